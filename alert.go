@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With -alert-webhook, failure bursts are reported to a Slack or Microsoft
+// Teams incoming webhook. Outcomes are counted over every -alert-interval;
+// at the end of an interval one message is posted if at least
+// alertMinFiles files finished and the share of failures reached
+// -alert-threshold, or if any file exhausted its retries. Nothing is posted
+// for quiet intervals, so there is at most one message per interval.
+const (
+	alertFormatSlack = "slack"
+	alertFormatTeams = "teams"
+
+	alertMinFiles = 10
+	// alertMaxListed bounds the files named in a message.
+	alertMaxListed = 10
+)
+
+var alerts struct {
+	mu        sync.Mutex
+	succeeded int
+	failed    int
+	exhausted []string
+}
+
+// countAlertOutcome counts the outcome of a file for the current interval.
+func countAlertOutcome(rec fileRecord, outcome string) {
+	if alertWebhook == "" {
+		return
+	}
+	alerts.mu.Lock()
+	defer alerts.mu.Unlock()
+	switch outcome {
+	case "success", "already_present":
+		alerts.succeeded++
+	case "failure", "conflict":
+		alerts.failed++
+		if rec.Retries >= retryLimit() {
+			alerts.exhausted = append(alerts.exhausted, fmt.Sprintf("%s/%s/%s", rec.Profile.Name, rec.Bucket, rec.Key))
+		}
+	}
+}
+
+func runAlerts() {
+	for range time.Tick(alertInterval) {
+		alerts.mu.Lock()
+		succeeded, failed, exhausted := alerts.succeeded, alerts.failed, alerts.exhausted
+		alerts.succeeded, alerts.failed, alerts.exhausted = 0, 0, nil
+		alerts.mu.Unlock()
+
+		total := succeeded + failed
+		burst := total >= alertMinFiles && float64(failed) >= alertThreshold*float64(total)
+		if !burst && len(exhausted) == 0 {
+			continue
+		}
+		if err := postAlert(alertMessage(succeeded, failed, exhausted)); err != nil {
+			log.Printf("Failed to post alert to %s: %v", alertWebhook, err)
+		}
+	}
+}
+
+func alertMessage(succeeded, failed int, exhausted []string) string {
+	total := succeeded + failed
+	var b strings.Builder
+	fmt.Fprintf(&b, "flood on %s: %d of %d file(s) failed in the last %s (%.0f%%, threshold %.0f%%).",
+		hostname, failed, total, alertInterval, 100*float64(failed)/float64(max(total, 1)), 100*alertThreshold)
+	if len(exhausted) > 0 {
+		fmt.Fprintf(&b, "\n%d file(s) exhausted their %d retries:", len(exhausted), retryLimit())
+		for _, name := range exhausted[:min(len(exhausted), alertMaxListed)] {
+			fmt.Fprintf(&b, "\n• %s", name)
+		}
+		if len(exhausted) > alertMaxListed {
+			fmt.Fprintf(&b, "\n… and %d more", len(exhausted)-alertMaxListed)
+		}
+	}
+	return b.String()
+}
+
+func postAlert(text string) error {
+	var payload any = map[string]string{"text": text}
+	if alertFormat == alertFormatTeams {
+		payload = map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  "flood failures on " + hostname,
+			// Teams renders the text as Markdown, which needs two spaces
+			// before a line break
+			"text": strings.ReplaceAll(text, "\n", "  \n"),
+		}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(alertWebhook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// With -admin-addr and a token (see auth.go), the admin listener also
+// serves a JSON API for ops tooling. Requests send the token as
+// "Authorization: Bearer <token>" or present a client certificate; GET
+// needs the read scope, the other methods the control scope.
+//
+//	GET  /api/v1/queues                        queues, uploads in flight and paused profiles
+//	GET  /api/v1/files?path=...|id=...         records of a file, by path or correlation ID
+//	POST /api/v1/files/retry?profile=..[&path=..]  move failed files back to processing
+//	POST /api/v1/records/{id}/retry            move the failed file of a record back
+//	POST /api/v1/files/cancel?profile=..&path=..|id=..  cancel a file or upload, see cancel.go
+//	POST /api/v1/profiles/{name}/pause         stop starting uploads for a profile
+//	POST /api/v1/profiles/{name}/resume
+//	POST /api/v1/pause                         pause the whole server, see pause.go
+//	POST /api/v1/resume
+//	POST /api/v1/reload                        re-read the credentials file
+//	GET|PATCH /api/v1/tuning                   global tuning parameters, see tuning.go
+//	GET  /api/v1/config                        flags and profiles, without secrets
+//
+// Errors are answered as {"error": "..."} with a 4xx or 5xx status. A token
+// bound to a tenant only sees the profiles of the tenant and gets 403 from
+// pause, resume, reload, tuning and config (see tenant.go). The same API is
+// served without a token on the control socket (see control.go).
+func registerAPI(mux *http.ServeMux) {
+	if !authConfigured("read") && !authConfigured("control") {
+		return
+	}
+	addAPIRoutes(mux, true)
+	log.Printf("Serving the admin API on %s/api/v1", adminAddr)
+}
+
+// addAPIRoutes adds the API to mux; without authenticate requests are not
+// checked.
+func addAPIRoutes(mux *http.ServeMux, authenticate bool) {
+	api := func(pattern string, handler func(w http.ResponseWriter, r *http.Request) (any, error)) {
+		scope := "control"
+		if strings.HasPrefix(pattern, "GET ") {
+			scope = "read"
+		}
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if authenticate {
+				tenant, err := authorize(r.Header.Get("Authorization"), r.TLS, scope)
+				if err != nil {
+					status := http.StatusForbidden
+					if errors.Is(err, errUnauthenticated) {
+						status = http.StatusUnauthorized
+					}
+					w.WriteHeader(status)
+					json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+					return
+				}
+				r = r.WithContext(withTenant(r.Context(), tenant))
+			}
+			result, err := handler(w, r)
+			if err != nil {
+				status := http.StatusInternalServerError
+				var apiErr apiError
+				if errors.As(err, &apiErr) {
+					status = apiErr.status
+				}
+				w.WriteHeader(status)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			json.NewEncoder(w).Encode(result)
+		})
+	}
+	api("GET /api/v1/queues", apiQueues)
+	api("GET /api/v1/files", apiFiles)
+	api("POST /api/v1/files/retry", apiRetry)
+	api("POST /api/v1/records/{id}/retry", apiRetryRecord)
+	api("POST /api/v1/files/cancel", apiCancel)
+	api("POST /api/v1/profiles/{name}/pause", func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return apiPause(r, true)
+	})
+	api("POST /api/v1/profiles/{name}/resume", func(w http.ResponseWriter, r *http.Request) (any, error) {
+		return apiPause(r, false)
+	})
+	api("POST /api/v1/pause", serverWide(func(w http.ResponseWriter, r *http.Request) (any, error) {
+		setPaused(true)
+		return map[string]any{"paused": true}, nil
+	}))
+	api("POST /api/v1/resume", serverWide(func(w http.ResponseWriter, r *http.Request) (any, error) {
+		setPaused(false)
+		return map[string]any{"paused": false}, nil
+	}))
+	api("POST /api/v1/reload", serverWide(apiReload))
+	api("GET /api/v1/tuning", serverWide(apiTuning))
+	api("PATCH /api/v1/tuning", serverWide(apiTuning))
+	api("GET /api/v1/config", serverWide(apiConfig))
+}
+
+// apiError is an error of the request rather than of flood.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e apiError) Error() string { return e.message }
+
+func badRequest(format string, args ...any) error {
+	return apiError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...any) error {
+	return apiError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+func apiQueues(w http.ResponseWriter, r *http.Request) (any, error) {
+	status := serverStatus{
+		PausedProfiles: pausedProfiles(),
+		Queues:         queueStatuses(),
+		Uploads:        inflightUploads(),
+	}
+	status.forTenant(contextTenant(r.Context()))
+	return map[string]any{
+		"paused":          isPaused(),
+		"paused_profiles": status.PausedProfiles,
+		"queues":          status.Queues,
+		"uploads":         status.Uploads,
+	}, nil
+}
+
+// pausedProfiles returns the names of the profiles paused through the API.
+func pausedProfiles() []string {
+	var names []string
+	for name, q := range queues {
+		if q.isPaused() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fileLookup is a record of a file with the last error of its attempts.
+type fileLookup struct {
+	statusRecord
+	FileID   string `json:"file_id,omitempty"`
+	ETag     string `json:"etag,omitempty"`
+	Version  string `json:"version_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Location string `json:"location,omitempty"` // state directory the file is in now
+}
+
+// apiFileRecords is the number of records returned per file, newest first.
+const apiFileRecords = 50
+
+func apiFiles(w http.ResponseWriter, r *http.Request) (any, error) {
+	return lookupFile(r.URL.Query().Get("path"), r.URL.Query().Get("id"), contextTenant(r.Context()))
+}
+
+// lookupFile returns the records of a file by path or correlation ID,
+// newest first, of the profiles the tenant may see. The newest has the
+// current location of the file.
+func lookupFile(path, id, tenant string) ([]fileLookup, error) {
+	var where, arg string
+	switch {
+	case path != "" && id == "":
+		where, arg = "filepath = ?", path
+	case id != "" && path == "":
+		where, arg = "correlation_id = ?", id
+	default:
+		return nil, badRequest("either path or id is required")
+	}
+	rows, err := db.Query(fmt.Sprintf(`SELECT id, profile, bucket, filepath, COALESCE(object_key, ''), COALESCE(operation, 'upload'), upload_outcome, retries, last_retry,
+		COALESCE(correlation_id, ''), COALESCE(etag, ''), COALESCE(version_id, ''),
+		(SELECT error_message FROM retry_attempts a WHERE a.record_id = r.id ORDER BY attempt DESC LIMIT 1)
+		FROM file_records r WHERE %s ORDER BY id DESC LIMIT %d`, where, apiFileRecords), arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []fileLookup
+	for rows.Next() {
+		var (
+			f       fileLookup
+			retries sql.NullInt64
+			at      sql.NullTime
+			message sql.NullString
+		)
+		if err := rows.Scan(&f.ID, &f.Profile, &f.Bucket, &f.Path, &f.Key, &f.Operation, &f.Outcome, &retries, &at, &f.FileID, &f.ETag, &f.Version, &message); err != nil {
+			return nil, err
+		}
+		f.Retries = int(retries.Int64)
+		if at.Valid {
+			f.Time = &at.Time
+		}
+		f.Error = message.String
+		if visible(tenant, f.Profile) {
+			records = append(records, f)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, notFound("no records of this file")
+	}
+	records[0].Location = fileLocation(records[0].Profile, records[0].Path)
+	return records, nil
+}
+
+// fileLocation returns the state directory a file of the processing
+// directory is in now, empty if it is in none.
+func fileLocation(profile, path string) string {
+	rel, err := filepath.Rel(filepath.Join(serverDir, "processing", profile), path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return ""
+	}
+	for _, dir := range mainDirs {
+		if _, err := os.Stat(filepath.Join(serverDir, dir, profile, rel)); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+func apiRetry(w http.ResponseWriter, r *http.Request) (any, error) {
+	name, path := r.URL.Query().Get("profile"), r.URL.Query().Get("path")
+	q, ok := queues[name]
+	if !ok || !visible(contextTenant(r.Context()), name) {
+		return nil, notFound("no profile %q", name)
+	}
+
+	var failed []string
+	if path != "" {
+		failed = append(failed, path)
+	} else {
+		filepath.Walk(filepath.Join(serverDir, "failed", name), func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && !isSidecar(path) {
+				failed = append(failed, path)
+			}
+			return nil
+		})
+	}
+
+	var retried []string
+	for _, path := range failed {
+		info, err := os.Stat(path)
+		if err != nil {
+			if len(failed) == 1 {
+				return nil, notFound("%s is not a failed file of profile %s", path, name)
+			}
+			continue
+		}
+		if err := redriveFile(q.currentProfile(), path, info, requestOrigin(r)); err != nil {
+			if len(failed) == 1 {
+				return nil, badRequest("%v", err)
+			}
+			continue
+		}
+		retried = append(retried, path)
+	}
+	if len(retried) > 0 {
+		q.notify()
+	}
+	return map[string]any{"retried": retried}, nil
+}
+
+// requestOrigin describes where an API request came from, for the attempt
+// history of manual re-drives.
+func requestOrigin(r *http.Request) string {
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return "the control socket"
+	}
+	return "the admin API from " + r.RemoteAddr
+}
+
+// apiRetryRecord re-drives the failed file of a file record, as listed by
+// flood status.
+func apiRetryRecord(w http.ResponseWriter, r *http.Request) (any, error) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return nil, badRequest("invalid record ID %q", r.PathValue("id"))
+	}
+	var name, path, outcome string
+	err = db.QueryRow("SELECT profile, filepath, upload_outcome FROM file_records WHERE id = ?", id).Scan(&name, &path, &outcome)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !visible(contextTenant(r.Context()), name) {
+		return nil, notFound("no record %d", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	q, ok := queues[name]
+	if !ok {
+		return nil, notFound("no profile %q", name)
+	}
+	rel, err := filepath.Rel(filepath.Join(serverDir, "processing", name), path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return nil, badRequest("record %d is not of a file of server mode", id)
+	}
+	failed := filepath.Join(serverDir, "failed", name, rel)
+	info, err := os.Stat(failed)
+	if err != nil {
+		return nil, badRequest("record %d has outcome %s and its file is not in failed", id, outcome)
+	}
+	if err := redriveFile(q.currentProfile(), failed, info, requestOrigin(r)); err != nil {
+		return nil, err
+	}
+	q.notify()
+	return map[string]any{"retried": []string{failed}}, nil
+}
+
+// apiCancel cancels the upload of a file of the processing directory, or
+// the upload in flight with a correlation ID.
+func apiCancel(w http.ResponseWriter, r *http.Request) (any, error) {
+	name, path, id := r.URL.Query().Get("profile"), r.URL.Query().Get("path"), r.URL.Query().Get("id")
+	switch {
+	case id != "" && path == "":
+		u := findUpload(id)
+		if u == nil || !visible(contextTenant(r.Context()), u.Profile) {
+			return nil, notFound("no upload in flight with ID %s", id)
+		}
+		u.cancelUpload()
+		return map[string]any{"path": u.Path, "state": "cancelling"}, nil
+	case path != "" && id == "":
+	default:
+		return nil, badRequest("either path or id is required")
+	}
+
+	q, ok := queues[name]
+	if !ok || !visible(contextTenant(r.Context()), name) {
+		return nil, notFound("no profile %q", name)
+	}
+	// The path may also be relative to the processing directory of the
+	// profile, as bucket/key.
+	root := filepath.Join(serverDir, "processing", name)
+	if strings.HasPrefix(path, root+string(os.PathSeparator)) {
+		path, _ = filepath.Rel(root, path)
+	}
+	if !filepath.IsLocal(path) {
+		return nil, badRequest("invalid path %q", path)
+	}
+	path = filepath.Join(root, path)
+	state, err := cancelFile(q, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, notFound("%s is not a file of profile %s waiting or being uploaded", path, name)
+	}
+	if err != nil {
+		return nil, badRequest("%v", err)
+	}
+	return map[string]any{"path": path, "state": state}, nil
+}
+
+func apiReload(w http.ResponseWriter, r *http.Request) (any, error) {
+	if err := reloadProfiles(); err != nil {
+		return nil, badRequest("%v", err)
+	}
+	return map[string]any{"reloaded": credFile}, nil
+}
+
+func apiPause(r *http.Request, paused bool) (any, error) {
+	name := r.PathValue("name")
+	q, ok := queues[name]
+	if !ok || !visible(contextTenant(r.Context()), name) {
+		return nil, notFound("no profile %q", name)
+	}
+	if err := q.setPaused(paused); err != nil {
+		return nil, err
+	}
+	return map[string]any{"profile": name, "paused": paused}, nil
+}
+
+// secretFlags are the flags whose values /api/v1/config leaves out: they
+// may contain credentials. Passwords and keys are only ever read from files
+// or the environment.
+var secretFlags = []string{"db", "alert-webhook", "heartbeat-url"}
+
+// profileConfig is the configuration of a profile shown by /api/v1/config.
+type profileConfig struct {
+	Name            string   `json:"name"`
+	Provider        string   `json:"provider,omitempty"`
+	Endpoint        string   `json:"endpoint,omitempty"`
+	Region          string   `json:"region,omitempty"`
+	PartSize        int64    `json:"part_size"`
+	PartConcurrency int      `json:"part_concurrency"`
+	Workers         int      `json:"workers"`
+	StorageClass    string   `json:"storage_class,omitempty"`
+	Buckets         []string `json:"buckets,omitempty"` // directories with overrides
+	FailoverProfile string   `json:"failover_profile,omitempty"`
+	RedriveInterval string   `json:"redrive_interval,omitempty"`
+	RedriveMax      int      `json:"redrive_max,omitempty"`
+	UploadWindows   int      `json:"upload_windows,omitempty"`
+	QuotaBytes      int64    `json:"quota_bytes_per_day,omitempty"`
+	QuotaObjects    int64    `json:"quota_objects_per_day,omitempty"`
+	Paused          bool     `json:"paused"`
+	Tenant          string   `json:"tenant,omitempty"`
+}
+
+func apiConfig(w http.ResponseWriter, r *http.Request) (any, error) {
+	flags := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		for _, secret := range secretFlags {
+			if f.Name == secret && value != "" {
+				value = "(redacted)"
+			}
+		}
+		flags[f.Name] = value
+	})
+
+	var configs []profileConfig
+	for _, p := range profiles {
+		c := profileConfig{
+			Name:            p.Name,
+			Provider:        p.Provider,
+			Endpoint:        p.Endpoint,
+			Region:          p.Region,
+			PartSize:        p.PartSize,
+			PartConcurrency: p.PartConcurrency,
+			Workers:         p.Workers,
+			StorageClass:    string(p.StorageClass),
+			FailoverProfile: p.FailoverProfile,
+			RedriveMax:      p.RedriveMax,
+			UploadWindows:   len(p.Windows),
+			QuotaBytes:      p.QuotaBytes,
+			QuotaObjects:    p.QuotaObjects,
+			Tenant:          p.tenantName(),
+		}
+		if p.RedriveInterval > 0 {
+			c.RedriveInterval = p.RedriveInterval.String()
+		}
+		for bucket := range p.Buckets {
+			c.Buckets = append(c.Buckets, bucket)
+		}
+		sort.Strings(c.Buckets)
+		if q, ok := queues[p.Name]; ok {
+			c.Paused = q.isPaused()
+		}
+		configs = append(configs, c)
+	}
+	sort.Slice(configs, func(i, j int) bool { return configs[i].Name < configs[j].Name })
+	return map[string]any{"flags": flags, "profiles": configs}, nil
+}
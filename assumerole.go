@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// A profile of an S3 compatible provider can assume an IAM role, as the
+// AWS CLI does:
+//
+//	role_arn = arn:aws:iam::123456789012:role/flood-uploads
+//	source_profile = base     ; profile whose credentials call STS; default the profile's own
+//	external_id = 7f3a...     ; if the trust policy of the role requires one
+//	role_session_name = nightly  ; default flood-<hostname>
+//	duration_seconds = 3600   ; lifetime of the credentials, 900 to 43200
+//
+// With a source_profile the profile needs no keys of its own; the source
+// profile may itself assume a role. STS is called in the region of the
+// profile. The temporary credentials are shared by every client of the
+// profile and renewed roleRefreshWindow before they expire. Since every
+// request is signed when it is sent, each part of a multipart upload gets
+// the current credentials, and transfers outlast them.
+const (
+	roleRefreshWindow  = 5 * time.Minute
+	roleDefaultSeconds = 3600
+)
+
+// roleSettings are the settings of parseRole.
+var roleSettings = []string{"role_arn", "source_profile", "external_id", "role_session_name", "duration_seconds"}
+
+// parseRole sets the role the profile assumes from its settings.
+func parseRole(profile *Profile, settings map[string]string) error {
+	profile.RoleARN = settings["role_arn"]
+	if profile.RoleARN == "" {
+		for _, key := range roleSettings {
+			if settings[key] != "" {
+				return fmt.Errorf("%s requires role_arn", key)
+			}
+		}
+		return nil
+	}
+	if !strings.HasPrefix(profile.RoleARN, "arn:") {
+		return fmt.Errorf("invalid role_arn %q", profile.RoleARN)
+	}
+	profile.SourceProfile = settings["source_profile"]
+	if profile.SourceProfile == profile.Name {
+		return fmt.Errorf("source_profile must not be the profile itself")
+	}
+	profile.ExternalID = settings["external_id"]
+
+	profile.RoleSessionName = settings["role_session_name"]
+	if profile.RoleSessionName == "" {
+		host, _ := os.Hostname()
+		profile.RoleSessionName = "flood-" + strings.Map(func(r rune) rune {
+			if strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_+=,.@-", r) {
+				return r
+			}
+			return '-'
+		}, host)
+		profile.RoleSessionName = profile.RoleSessionName[:min(len(profile.RoleSessionName), 64)]
+	}
+
+	seconds := roleDefaultSeconds
+	if value := settings["duration_seconds"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 900 || n > 43200 {
+			return fmt.Errorf("invalid duration_seconds %q: must be between 900 and 43200", value)
+		}
+		seconds = n
+	}
+	profile.RoleDuration = time.Duration(seconds) * time.Second
+	return nil
+}
+
+// resolveCredentials sets the credentials of the profiles that assume a
+// role, following their source profiles, and of the profiles that use the
+// default credential chain.
+func resolveCredentials(profiles map[string]Profile) error {
+	resolved := make(map[string]aws.CredentialsProvider)
+	var resolve func(name string, chain []string) (aws.CredentialsProvider, error)
+	resolve = func(name string, chain []string) (aws.CredentialsProvider, error) {
+		if provider, ok := resolved[name]; ok {
+			return provider, nil
+		}
+		if slices.Contains(chain, name) {
+			return nil, fmt.Errorf("source_profile loop %s", strings.Join(append(chain, name), " -> "))
+		}
+		profile, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown source_profile %s", name)
+		}
+		var provider aws.CredentialsProvider = credentials.NewStaticCredentialsProvider(profile.AccessKeyID, profile.SecretAccessKey, "")
+		if profile.usesDefaultCredentials() {
+			var err error
+			if provider, err = defaultCredentials(profile); err != nil {
+				return nil, err
+			}
+		} else if profile.AccessKeyID == "" && profile.RoleARN == "" {
+			return nil, fmt.Errorf("source_profile %s has no aws_access_key_id", name)
+		}
+		if profile.RoleARN != "" {
+			source := provider
+			if profile.SourceProfile != "" {
+				var err error
+				if source, err = resolve(profile.SourceProfile, append(chain, name)); err != nil {
+					return nil, err
+				}
+			}
+			var err error
+			if provider, err = assumeRoleProvider(profile, source); err != nil {
+				return nil, err
+			}
+		}
+		resolved[name] = provider
+		return provider, nil
+	}
+
+	for name, profile := range profiles {
+		if profile.RoleARN == "" && !profile.usesDefaultCredentials() {
+			continue
+		}
+		provider, err := resolve(name, nil)
+		if err != nil {
+			return fmt.Errorf("invalid profile %s: %w", name, err)
+		}
+		profile.Credentials = provider
+		profiles[name] = profile
+	}
+	return nil
+}
+
+// assumeRoleProvider returns cached credentials of the role of the profile,
+// obtained with the source credentials.
+func assumeRoleProvider(profile Profile, source aws.CredentialsProvider) (aws.CredentialsProvider, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(profile.Region),
+		config.WithCredentialsProvider(source),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration for STS: %w", err)
+	}
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), profile.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = profile.RoleSessionName
+		o.Duration = profile.RoleDuration
+		if profile.ExternalID != "" {
+			o.ExternalID = aws.String(profile.ExternalID)
+		}
+	})
+	logged := &roleProvider{inner: provider, profile: profile.Name, role: profile.RoleARN}
+	return aws.NewCredentialsCache(logged, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = roleRefreshWindow
+	}), nil
+}
+
+// usesDefaultCredentials reports whether the profile is an amazon profile
+// without keys of its own or a source profile.
+func (p Profile) usesDefaultCredentials() bool {
+	return p.Provider == "amazon" && p.AccessKeyID == "" && p.SourceProfile == ""
+}
+
+// defaultCredentials returns the default credential chain of the SDK for a
+// profile without keys. The chain caches what it finds, so the instance
+// metadata service or STS is only asked again when the credentials expire.
+func defaultCredentials(profile Profile) (aws.CredentialsProvider, error) {
+	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithRegion(profile.Region))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the default credential chain: %w", err)
+	}
+	log.Printf("Profile %s uses the default AWS credential chain", profile.Name)
+	return cfg.Credentials, nil
+}
+
+// roleProvider logs every time the credentials of a role are obtained.
+type roleProvider struct {
+	inner         aws.CredentialsProvider
+	profile, role string
+}
+
+func (p *roleProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	creds, err := p.inner.Retrieve(ctx)
+	if err != nil {
+		log.Printf("Failed to assume role %s for profile %s: %v", p.role, p.profile, err)
+		return creds, err
+	}
+	log.Printf("Assumed role %s for profile %s until %s", p.role, p.profile, creds.Expires.Local().Format("2006-01-02 15:04:05"))
+	return creds, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/smithy-go"
+)
+
+// attempt is a failed attempt to deliver a file. The attempts of a file
+// record are stored in the retry_attempts table along with the record. A
+// manual re-drive is recorded as an attempt of its own, see redrive.go.
+type attempt struct {
+	At        time.Time
+	Class     string // transient, permanent, conflict or manual
+	Message   string
+	Status    int    // HTTP status code, if any
+	RequestID string // request ID of the provider, if any
+	HostID    string // extended request ID of S3, if any
+	Code      string // error code of the provider, if any
+	Delay     time.Duration
+}
+
+// failedAttempt appends the failure of the current attempt to rec.Attempts.
+// delay is the time waited before the next attempt, zero if there is none.
+func (rec *fileRecord) failedAttempt(err error, transient bool, delay time.Duration) {
+	class := "permanent"
+	switch {
+	case errors.Is(err, errConflict):
+		class = "conflict"
+	case transient:
+		class = "transient"
+	}
+	a := attempt{
+		At:        time.Now(),
+		Class:     class,
+		Message:   err.Error(),
+		Status:    httpStatusCode(err),
+		RequestID: requestID(err),
+		HostID:    hostID(err),
+		Delay:     delay,
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		a.Code = apiErr.ErrorCode()
+	}
+	if a.RequestID != "" || a.Code != "" {
+		rec.loggerOf(retryLog).Info(fmt.Sprintf("Attempt %d failed", len(rec.Attempts)),
+			"attempt", len(rec.Attempts), "code", a.Code, "status", a.Status, "request_id", a.RequestID, "host_id", a.HostID)
+	}
+	rec.Attempts = append(rec.Attempts, a)
+}
+
+// requestID returns the request ID of an SDK error, or "" if the error did
+// not come with a response.
+func requestID(err error) string {
+	var re interface{ ServiceRequestID() string }
+	if errors.As(err, &re) {
+		return re.ServiceRequestID()
+	}
+	return ""
+}
+
+// hostID returns the extended request ID of an S3 error, or "".
+func hostID(err error) string {
+	var re interface{ ServiceHostID() string }
+	if errors.As(err, &re) {
+		return re.ServiceHostID()
+	}
+	return ""
+}
+
+func recordAttempts(recordID int64, attempts []attempt) {
+	for i, a := range attempts {
+		_, err := db.Exec(
+			"INSERT INTO retry_attempts(record_id, attempt, attempted, error_class, error_message, http_status, request_id, extended_request_id, error_code, delay_ms) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			recordID, i, a.At, a.Class, a.Message, a.Status, optionalString(a.RequestID), optionalString(a.HostID), optionalString(a.Code), a.Delay.Milliseconds(),
+		)
+		if err != nil {
+			log.Printf("Failed to record attempt %d of record %d: %v", i, recordID, err)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditItem is an object that flood uploaded and that verify checks.
+type auditItem struct {
+	profile string
+	bucket  string
+	path    string // local copy in the completed directory
+	key     string
+}
+
+// Verification statuses recorded in verify_results.
+const (
+	auditOK           = "ok"
+	auditDrift        = "drift"         // missing remotely or size/checksum differs
+	auditLocalMissing = "local_missing" // no local copy left to compare with
+	auditUnverifiable = "unverifiable"  // the destination cannot be queried
+	auditError        = "error"
+)
+
+// runVerifyMode confirms that uploaded objects still exist remotely with the
+// size and checksum of the local copy in the completed directory:
+//
+//	flood verify [-profile name] [-bucket name] [-dir] [-workers 4]
+//
+// By default the successful uploads in file_records are checked; with -dir
+// (which needs -server) the files in the completed directory are checked
+// instead, with their keys derived from the current key settings. Every
+// result is written to verify_results and anything other than "ok" is
+// printed, so the output is the reconciliation report. The exit status is 1
+// if drift was found.
+func runVerifyMode(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	onlyProfile := fs.String("profile", "", "Only verify uploads of this profile")
+	onlyBucket := fs.String("bucket", "", "Only verify uploads to this bucket")
+	fromDir := fs.Bool("dir", false, "Verify the files in the completed directory instead of the database records")
+	n := fs.Int("workers", 4, "Number of objects verified concurrently")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] verify [verify flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *n < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		items []auditItem
+		err   error
+	)
+	if *fromDir {
+		if serverDir == "" {
+			log.Fatal("verify -dir requires -server")
+		}
+		items, err = completedFiles(*onlyProfile, *onlyBucket)
+	} else {
+		items, err = completedRecords(*onlyProfile, *onlyBucket)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Verifying %d object(s)", len(items))
+
+	jobs := make(chan auditItem)
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		counts = make(map[string]int)
+	)
+	for i := 0; i < *n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				status, detail := verifyObject(item)
+				recordVerifyResult(item, status, detail)
+				mu.Lock()
+				counts[status]++
+				if status != auditOK {
+					fmt.Printf("%s\t%s/%s/%s\t%s\t%s\n", status, item.profile, item.bucket, item.key, item.path, detail)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, item := range items {
+		jobs <- item
+	}
+	close(jobs)
+	wg.Wait()
+
+	log.Printf("Verify finished: %d ok, %d drift, %d local copy missing, %d unverifiable, %d errors",
+		counts[auditOK], counts[auditDrift], counts[auditLocalMissing], counts[auditUnverifiable], counts[auditError])
+	if counts[auditDrift] > 0 {
+		os.Exit(1)
+	}
+}
+
+// completedRecords returns the files whose latest upload in file_records
+// succeeded, mapped to their location in the completed directory.
+func completedRecords(onlyProfile, onlyBucket string) ([]auditItem, error) {
+	rows, err := db.Query(`
+		SELECT profile, bucket, filepath, COALESCE(object_key, ''), COALESCE(original_path, '')
+		FROM file_records
+		WHERE id IN (
+			SELECT MAX(id) FROM file_records
+			WHERE COALESCE(operation, 'upload') = 'upload' AND upload_outcome != 'dry_run'
+			GROUP BY profile, bucket, filepath
+		) AND upload_outcome IN ('success', 'already_present')
+		ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []auditItem
+	for rows.Next() {
+		var item auditItem
+		var originalPath string
+		if err := rows.Scan(&item.profile, &item.bucket, &item.path, &item.key, &originalPath); err != nil {
+			return nil, err
+		}
+		if (onlyProfile != "" && item.profile != onlyProfile) || (onlyBucket != "" && item.bucket != onlyBucket) {
+			continue
+		}
+		if item.key == "" {
+			// Records written before object keys were stored.
+			item.key = originalPath
+		}
+		if item.key == "" {
+			continue
+		}
+		item.path = strings.Replace(item.path, "processing", "completed", 1)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// completedFiles walks the completed directory and derives the key of every
+// file from its profile's current key settings.
+func completedFiles(onlyProfile, onlyBucket string) ([]auditItem, error) {
+	var items []auditItem
+	for name, profile := range profiles {
+		if onlyProfile != "" && name != onlyProfile {
+			continue
+		}
+		root := filepath.Join(serverDir, "completed", name)
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() || isSidecar(path) {
+				return nil
+			}
+			rel, _ := filepath.Rel(root, path)
+			bucket, relativePath, ok := strings.Cut(rel, string(os.PathSeparator))
+			if !ok || (onlyBucket != "" && bucket != onlyBucket) {
+				return nil
+			}
+			key, err := objectKey(profile.forBucket(bucket), bucket, relativePath)
+			if err != nil {
+				log.Printf("Skipping %s: %v", path, err)
+				return nil
+			}
+			items = append(items, auditItem{profile: name, bucket: bucket, path: path, key: key})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return items, nil
+}
+
+// verifyObject compares a single object with its local copy.
+func verifyObject(item auditItem) (status, detail string) {
+	profile, ok := profiles[item.profile]
+	if !ok {
+		return auditError, fmt.Sprintf("unknown profile %s", item.profile)
+	}
+	if _, err := os.Stat(item.path); err != nil {
+		return auditLocalMissing, err.Error()
+	}
+
+	present, err := destinationFor(profile.forBucket(item.bucket)).matches(item.bucket, item.key, item.path)
+	switch {
+	case errors.Is(err, errNotImplemented):
+		return auditUnverifiable, err.Error()
+	case err != nil:
+		return auditError, err.Error()
+	case !present:
+		return auditDrift, "object is missing or its size or checksum differs"
+	}
+	return auditOK, ""
+}
+
+func recordVerifyResult(item auditItem, status, detail string) {
+	_, err := db.Exec("INSERT INTO verify_results(profile, bucket, filepath, object_key, status, detail, checked) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		item.profile, item.bucket, item.path, item.key, status, detail, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
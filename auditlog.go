@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/user"
+	"sort"
+	"sync"
+	"time"
+)
+
+// With -audit-log, every state change of a file is appended to a JSONL file
+// that is never rewritten: a file received in processing, and every
+// outcome recorded for it, with what triggered the change (the watcher, or
+// the operation such as upload, mirror or pull), the host, process and
+// user, and the identifiers the destination returned. Each entry carries
+// the hash of the previous one and its own SHA-256 over that hash and its
+// content, so removing, reordering or editing entries breaks the chain:
+//
+//	flood audit-log verify [-file path] [-open]
+//
+// checks the chain and reports the files that were received but have no
+// outcome yet. Entries are synced to disk before the change proceeds.
+type auditEntry struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Trigger    string    `json:"trigger"`
+	Actor      string    `json:"actor"`
+	FileID     string    `json:"file_id,omitempty"`
+	Profile    string    `json:"profile"`
+	Bucket     string    `json:"bucket"`
+	Path       string    `json:"path"`
+	Key        string    `json:"key,omitempty"`
+	Retries    int       `json:"retries"`
+	Checksum   string    `json:"checksum,omitempty"`
+	ETag       string    `json:"etag,omitempty"`
+	VersionID  string    `json:"version_id,omitempty"`
+	RequestIDs []string  `json:"request_ids,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Prev       string    `json:"prev"`
+	Hash       string    `json:"hash"`
+}
+
+// auditGenesis is the previous hash of the first entry.
+const auditGenesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+var auditLog struct {
+	mu    sync.Mutex
+	f     *os.File
+	seq   int64
+	prev  string
+	actor string
+}
+
+// openAuditLog opens -audit-log and continues its chain.
+func openAuditLog() {
+	if auditLogPath == "" {
+		return
+	}
+	f, err := os.OpenFile(auditLogPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	last, err := lastAuditEntry(f)
+	if err != nil {
+		log.Fatalf("Cannot continue audit log %s: %v", auditLogPath, err)
+	}
+	auditLog.f = f
+	auditLog.seq, auditLog.prev = 0, auditGenesis
+	if last != nil {
+		auditLog.seq, auditLog.prev = last.Seq, last.Hash
+	}
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	auditLog.actor = fmt.Sprintf("%s pid %d user %s", hostname, os.Getpid(), name)
+}
+
+// lastAuditEntry returns the last entry of the log, nil if it is empty.
+func lastAuditEntry(f *os.File) (*auditEntry, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return nil, err
+	}
+	// Entries are far shorter than this; read only the end of the file
+	const tail = 64 << 10
+	offset := max(0, info.Size()-tail)
+	data := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(data, offset); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if data[len(data)-1] != '\n' {
+		return nil, errors.New("the last entry is incomplete; check the log with flood audit-log verify")
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"))
+	var e auditEntry
+	if err := json.Unmarshal(lines[len(lines)-1], &e); err != nil {
+		return nil, fmt.Errorf("the last entry is invalid: %w", err)
+	}
+	return &e, nil
+}
+
+// auditFile appends a state change of rec to the audit log. A failure to
+// write it is fatal: the log must not miss a change.
+func auditFile(event, trigger string, rec fileRecord) {
+	if auditLog.f == nil {
+		return
+	}
+	e := auditEntry{
+		Time:      time.Now().UTC(),
+		Event:     event,
+		Trigger:   trigger,
+		FileID:    rec.ID,
+		Profile:   rec.Profile.Name,
+		Bucket:    rec.Bucket,
+		Path:      rec.Path,
+		Key:       rec.Key,
+		Retries:   rec.Retries,
+		Checksum:  rec.Checksum,
+		ETag:      rec.ETag,
+		VersionID: rec.VersionID,
+	}
+	for _, a := range rec.Attempts {
+		if a.RequestID != "" {
+			e.RequestIDs = append(e.RequestIDs, a.RequestID)
+		}
+	}
+	if n := len(rec.Attempts); n > 0 && event != "success" {
+		e.Error = rec.Attempts[n-1].Message
+	}
+
+	auditLog.mu.Lock()
+	defer auditLog.mu.Unlock()
+	e.Seq = auditLog.seq + 1
+	e.Actor = auditLog.actor
+	e.Prev = auditLog.prev
+	e.Hash = auditHash(e)
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = auditLog.f.Write(append(line, '\n'))
+	}
+	if err == nil {
+		err = auditLog.f.Sync()
+	}
+	if err != nil {
+		log.Fatalf("Failed to write audit log: %v", err)
+	}
+	auditLog.seq, auditLog.prev = e.Seq, e.Hash
+}
+
+// auditHash is the SHA-256 of the entry without its hash; the entry
+// includes the previous hash.
+func auditHash(e auditEntry) string {
+	e.Hash = ""
+	data, _ := json.Marshal(e)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func runAuditLogMode(args []string) {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "Usage: flood [flags] audit-log verify [-file path] [-open]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("audit-log verify", flag.ExitOnError)
+	path := fs.String("file", auditLogPath, "Audit log to verify (default -audit-log)")
+	listOpen := fs.Bool("open", false, "List the files that were received but have no outcome yet")
+	fs.Parse(args[1:])
+	if *path == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	var (
+		seq     int64
+		prev    = auditGenesis
+		open    = make(map[string]auditEntry) // received, keyed by path
+		scanner = bufio.NewScanner(f)
+	)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var e auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			log.Fatalf("Line %d: invalid entry: %v", line, err)
+		}
+		switch {
+		case e.Seq != seq+1:
+			log.Fatalf("Line %d: sequence %d follows %d; entries are missing or reordered", line, e.Seq, seq)
+		case e.Prev != prev:
+			log.Fatalf("Line %d: previous hash does not match entry %d", line, seq)
+		case e.Hash != auditHash(e):
+			log.Fatalf("Line %d: hash does not match the content; the entry was modified", line)
+		}
+		seq, prev = e.Seq, e.Hash
+
+		switch e.Event {
+		case "received":
+			open[e.Path] = e
+		case "success", "already_present", "failure", "conflict", "failed_over", "cancelled":
+			delete(open, e.Path)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Chain intact: %d entries, last hash %s\n", seq, prev)
+	fmt.Printf("%d received file(s) without an outcome\n", len(open))
+	if *listOpen {
+		var paths []string
+		for path := range open {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			e := open[path]
+			fmt.Printf("%s\t%s/%s\t%s\n", e.Time.Local().Format(time.DateTime), e.Profile, e.Bucket, path)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The admin, ingest and gRPC listeners authenticate clients with bearer
+// tokens or client certificates, each granted a set of scopes:
+//
+//	read     /status, the UI data and the GET endpoints of the admin API
+//	control  the other endpoints of the admin API
+//	ingest   uploads to -ingest-addr and Submit of the gRPC API
+//
+// -auth-tokens-file lists them, one per line:
+//
+//	# name    scopes         token or cert:<common name>  [tenant=<name>]
+//	noc       read           3f9c0e...
+//	ops       read,control   b71d44...
+//	producer  ingest         cert:producer.example.com
+//	acme      read,control,ingest  5d02aa...  tenant=acme
+//
+// A cert: entry matches a client certificate with that common name, which
+// must be signed by -tls-client-ca. An entry with a tenant is bound to the
+// profiles of that tenant, see tenant.go. The token of -admin-token-file (or
+// FLOOD_ADMIN_TOKEN) has the scopes read and control, that of
+// -ingest-token-file (or FLOOD_INGEST_TOKEN) the scope ingest.
+//
+// With -tls-cert and -tls-key the listeners serve TLS only; -tls-client-ca
+// additionally verifies the certificates clients present.
+type authIdentity struct {
+	name   string
+	scopes map[string]bool
+	tenant string // empty for every profile
+}
+
+var (
+	authTokens = make(map[string]authIdentity) // keyed by token
+	authCerts  = make(map[string]authIdentity) // keyed by common name
+)
+
+// loadAuth reads the tokens; it runs before any listener starts.
+func loadAuth() {
+	add := func(name, scopes, secret, tenant string) error {
+		if tenant != "" && !tenantExists(tenant) {
+			return fmt.Errorf("no profile of tenant %q for %s", tenant, name)
+		}
+		id := authIdentity{name: name, scopes: make(map[string]bool), tenant: tenant}
+		for _, scope := range strings.Split(scopes, ",") {
+			if scope != "read" && scope != "control" && scope != "ingest" {
+				return fmt.Errorf("unknown scope %q of %s", scope, name)
+			}
+			id.scopes[scope] = true
+		}
+		if cn, ok := strings.CutPrefix(secret, "cert:"); ok {
+			authCerts[cn] = id
+		} else {
+			authTokens[secret] = id
+		}
+		return nil
+	}
+
+	if token, err := readSecret(adminTokenFile, "FLOOD_ADMIN_TOKEN"); err != nil {
+		log.Fatalf("Failed to read admin token: %v", err)
+	} else if token != "" {
+		add("admin", "read,control", token, "")
+	}
+	if token, err := readSecret(ingestTokenFile, "FLOOD_INGEST_TOKEN"); err != nil {
+		log.Fatalf("Failed to read ingest token: %v", err)
+	} else if token != "" {
+		add("ingest", "ingest", token, "")
+	}
+	if authTokensFile == "" {
+		return
+	}
+	f, err := os.Open(authTokensFile)
+	if err != nil {
+		log.Fatalf("Failed to read -auth-tokens-file: %v", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var tenant string
+		if len(fields) == 4 {
+			var ok bool
+			if tenant, ok = strings.CutPrefix(fields[3], "tenant="); !ok || tenant == "" {
+				log.Fatalf("%s:%d: expected tenant=<name> after the token", authTokensFile, line)
+			}
+		} else if len(fields) != 3 {
+			log.Fatalf("%s:%d: expected a name, scopes and a token", authTokensFile, line)
+		}
+		if err := add(fields[0], fields[1], fields[2], tenant); err != nil {
+			log.Fatalf("%s:%d: %v", authTokensFile, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatalf("Failed to read -auth-tokens-file: %v", err)
+	}
+}
+
+// readSecret reads a secret from a file, or from the environment variable
+// if no file is given.
+func readSecret(path, env string) (string, error) {
+	if path == "" {
+		return os.Getenv(env), nil
+	}
+	data, err := os.ReadFile(path)
+	return strings.TrimSpace(string(data)), err
+}
+
+// authConfigured reports whether any client can authenticate with scope.
+func authConfigured(scope string) bool {
+	for _, id := range authTokens {
+		if id.scopes[scope] {
+			return true
+		}
+	}
+	for _, id := range authCerts {
+		if id.scopes[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+var errUnauthenticated = errors.New("missing or invalid credentials")
+
+// authorize checks that the bearer token or the verified client certificate
+// grants scope, and returns the tenant the client is bound to.
+func authorize(bearer string, state *tls.ConnectionState, scope string) (string, error) {
+	id, ok := identify(bearer, state)
+	if !ok {
+		return "", errUnauthenticated
+	}
+	if !id.scopes[scope] {
+		return "", fmt.Errorf("%s lacks the %s scope", id.name, scope)
+	}
+	return id.tenant, nil
+}
+
+func identify(bearer string, state *tls.ConnectionState) (authIdentity, bool) {
+	if token, ok := strings.CutPrefix(bearer, "Bearer "); ok {
+		// Compare with every token so the time taken reveals nothing
+		var match authIdentity
+		found := false
+		for t, id := range authTokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				match, found = id, true
+			}
+		}
+		return match, found
+	}
+	if state != nil && len(state.VerifiedChains) > 0 {
+		id, ok := authCerts[state.VerifiedChains[0][0].Subject.CommonName]
+		return id, ok
+	}
+	return authIdentity{}, false
+}
+
+// requireScope wraps h so that it only runs for clients granted scope. A
+// scope no client is granted is not checked; see authConfigured. The
+// tenant of the client is in the context of the request.
+func requireScope(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authConfigured(scope) {
+			tenant, err := authorize(r.Header.Get("Authorization"), r.TLS, scope)
+			if errors.Is(err, errUnauthenticated) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(withTenant(r.Context(), tenant))
+		}
+		h(w, r)
+	}
+}
+
+// serverTLS returns the TLS configuration of the listeners, nil without
+// -tls-cert.
+func serverTLS() *tls.Config {
+	if tlsCert == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(tlsCert, tlsKey)
+	if err != nil {
+		log.Fatalf("Failed to load -tls-cert and -tls-key: %v", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if tlsClientCA != "" {
+		data, err := os.ReadFile(tlsClientCA)
+		if err != nil {
+			log.Fatalf("Failed to read -tls-client-ca: %v", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(data) {
+			log.Fatalf("No certificates found in -tls-client-ca %s", tlsClientCA)
+		}
+		// Tokens keep working for clients without a certificate
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg
+}
+
+// listenAndServe serves h on addr, with TLS if configured.
+func listenAndServe(addr string, h http.Handler) error {
+	server := &http.Server{Addr: addr, Handler: h, TLSConfig: serverTLS()}
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// b2Destination uploads through the native Backblaze B2 API, which avoids
+// the quirks of B2's S3 compatible endpoint such as keys restricted to a
+// single bucket being unable to list buckets:
+//
+//	provider = backblaze-native
+//	b2_key_id = ...
+//	b2_application_key = ...
+//
+// Files larger than the part size are uploaded as B2 large files using the
+// profile's part_size and part_concurrency.
+type b2Destination struct {
+	keyID          string
+	applicationKey string
+
+	mu      sync.Mutex
+	auth    *b2Auth
+	buckets map[string]string // bucket name -> bucket ID
+}
+
+const b2AuthorizeURL = "https://api.backblazeb2.com/b2api/v2/b2_authorize_account"
+
+type b2Auth struct {
+	AccountID          string `json:"accountId"`
+	AuthorizationToken string `json:"authorizationToken"`
+	APIURL             string `json:"apiUrl"`
+	Allowed            struct {
+		BucketID   string `json:"bucketId"`
+		BucketName string `json:"bucketName"`
+	} `json:"allowed"`
+}
+
+type b2UploadURL struct {
+	UploadURL          string `json:"uploadUrl"`
+	AuthorizationToken string `json:"authorizationToken"`
+}
+
+type b2File struct {
+	FileID        string            `json:"fileId"`
+	FileName      string            `json:"fileName"`
+	ContentLength int64             `json:"contentLength"`
+	ContentSha1   string            `json:"contentSha1"`
+	FileInfo      map[string]string `json:"fileInfo"`
+}
+
+// b2Error is the error document returned by every B2 API call.
+type b2Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *b2Error) Error() string {
+	return fmt.Sprintf("B2 error %d (%s): %s", e.Status, e.Code, e.Message)
+}
+
+func (e *b2Error) HTTPStatusCode() int {
+	return e.Status
+}
+
+// b2TransientCodes are B2 error codes worth retrying. Expired tokens are
+// replaced with new ones on the next attempt.
+var b2TransientCodes = []string{"expired_auth_token", "bad_auth_token", "service_unavailable", "too_many_requests", "request_timeout"}
+
+func newB2Destination(name string, settings map[string]string) (destination, error) {
+	return &b2Destination{
+		keyID:          settings["b2_key_id"],
+		applicationKey: settings["b2_application_key"],
+		buckets:        make(map[string]string),
+	}, nil
+}
+
+// authorize returns the cached account authorization, authorizing the key
+// first if needed.
+func (d *b2Destination) authorize() (*b2Auth, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.auth != nil {
+		return d.auth, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, b2AuthorizeURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(d.keyID, d.applicationKey)
+	var auth b2Auth
+	if err := d.do(req, &auth); err != nil {
+		return nil, fmt.Errorf("failed to authorize B2 key %s: %w", d.keyID, err)
+	}
+	d.auth = &auth
+	return d.auth, nil
+}
+
+// call invokes a B2 API operation with a JSON request and response.
+func (d *b2Destination) call(operation string, request, response any) error {
+	auth, err := d.authorize()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, auth.APIURL+"/b2api/v2/"+operation, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", auth.AuthorizationToken)
+	if err := d.do(req, response); err != nil {
+		return fmt.Errorf("%s: %w", operation, err)
+	}
+	return nil
+}
+
+func (d *b2Destination) do(req *http.Request, response any) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := &b2Error{}
+		json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(apiErr)
+		if apiErr.Status == 0 {
+			apiErr.Status = resp.StatusCode
+		}
+		if apiErr.Code == "" {
+			apiErr.Code = strings.ToLower(strings.ReplaceAll(http.StatusText(resp.StatusCode), " ", "_"))
+		}
+		if apiErr.Code == "expired_auth_token" || apiErr.Code == "bad_auth_token" {
+			d.mu.Lock()
+			d.auth = nil
+			d.mu.Unlock()
+		}
+		return apiErr
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// bucketID looks up the ID of a bucket. Keys restricted to a bucket cannot
+// list buckets but are told their bucket when they are authorized.
+func (d *b2Destination) bucketID(bucket string) (string, error) {
+	auth, err := d.authorize()
+	if err != nil {
+		return "", err
+	}
+	if auth.Allowed.BucketName != "" {
+		if auth.Allowed.BucketName != bucket {
+			return "", fmt.Errorf("B2 key %s is restricted to bucket %s, not %s", d.keyID, auth.Allowed.BucketName, bucket)
+		}
+		return auth.Allowed.BucketID, nil
+	}
+
+	d.mu.Lock()
+	id, ok := d.buckets[bucket]
+	d.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	var list struct {
+		Buckets []struct {
+			BucketID   string `json:"bucketId"`
+			BucketName string `json:"bucketName"`
+		} `json:"buckets"`
+	}
+	request := map[string]string{"accountId": auth.AccountID, "bucketName": bucket}
+	if err := d.call("b2_list_buckets", request, &list); err != nil {
+		return "", err
+	}
+	for _, b := range list.Buckets {
+		if b.BucketName == bucket {
+			d.mu.Lock()
+			d.buckets[bucket] = b.BucketID
+			d.mu.Unlock()
+			return b.BucketID, nil
+		}
+	}
+	return "", fmt.Errorf("bucket %s does not exist on B2", bucket)
+}
+
+func (d *b2Destination) validate(bucket string) error {
+	_, err := d.bucketID(bucket)
+	return err
+}
+
+func (d *b2Destination) upload(rec fileRecord) (uploadResult, error) {
+	bucketID, err := d.bucketID(rec.Bucket)
+	if err != nil {
+		return uploadResult{}, err
+	}
+
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to open file %s: %w", rec.Path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to stat file %s: %w", rec.Path, err)
+	}
+	size := info.Size()
+
+	sum, err := sectionSHA1(f, 0, size)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to compute SHA-1 of %s: %w", rec.Path, err)
+	}
+
+	partSize := effectivePartSize(rec.Profile.PartSize, size)
+	if size <= partSize {
+		defer reserveUploadMemory(rec.Path, 1)()
+		var u b2UploadURL
+		if err := d.call("b2_get_upload_url", map[string]string{"bucketId": bucketID}, &u); err != nil {
+			return uploadResult{}, err
+		}
+		req, err := http.NewRequest(http.MethodPost, u.UploadURL, uploadBody(io.NewSectionReader(f, 0, size), rec.Profile))
+		if err != nil {
+			return uploadResult{}, err
+		}
+		req.ContentLength = size
+		req.Header.Set("Authorization", u.AuthorizationToken)
+		req.Header.Set("X-Bz-File-Name", escapeKey(rec.Key))
+		req.Header.Set("Content-Type", "b2/x-auto")
+		req.Header.Set("X-Bz-Content-Sha1", sum)
+		for k, v := range b2FileInfo(rec.Meta) {
+			req.Header.Set("X-Bz-Info-"+k, v)
+		}
+		var file b2File
+		if err := d.do(req, &file); err != nil {
+			return uploadResult{}, fmt.Errorf("failed to upload %s: %w", rec.Key, err)
+		}
+		return uploadResult{VersionID: file.FileID}, nil
+	}
+
+	partCount := int((size + partSize - 1) / partSize)
+	concurrency := min(rec.Profile.PartConcurrency, partCount)
+	defer reserveUploadMemory(rec.Path, concurrency)()
+
+	fileInfo := b2FileInfo(rec.Meta)
+	fileInfo["large_file_sha1"] = sum
+	var started b2File
+	request := map[string]any{"bucketId": bucketID, "fileName": rec.Key, "contentType": "b2/x-auto", "fileInfo": fileInfo}
+	if err := d.call("b2_start_large_file", request, &started); err != nil {
+		return uploadResult{}, err
+	}
+	log.Printf("Starting B2 large file upload of %s (%d parts of %d bytes, concurrency %d)", rec.Key, partCount, partSize, concurrency)
+
+	partSums := make([]string, partCount)
+	partNumbers := make(chan int)
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var u b2UploadURL
+			for partNumber := range partNumbers {
+				err := d.uploadPart(&u, started.FileID, f, partNumber, partSize, size, rec.Profile, partSums)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for partNumber := 1; partNumber <= partCount; partNumber++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		partNumbers <- partNumber
+	}
+	close(partNumbers)
+	wg.Wait()
+
+	var file b2File
+	if firstErr == nil {
+		firstErr = d.call("b2_finish_large_file", map[string]any{"fileId": started.FileID, "partSha1Array": partSums}, &file)
+	}
+	if firstErr != nil {
+		var cancelled b2File
+		if err := d.call("b2_cancel_large_file", map[string]string{"fileId": started.FileID}, &cancelled); err != nil {
+			log.Printf("Failed to cancel B2 large file %s of %s: %v", started.FileID, rec.Key, err)
+		}
+		return uploadResult{}, firstErr
+	}
+	return uploadResult{VersionID: file.FileID}, nil
+}
+
+// uploadPart uploads one part of a large file. u holds the worker's upload
+// URL, which is fetched on first use and again after it failed.
+func (d *b2Destination) uploadPart(u *b2UploadURL, fileID string, f *os.File, partNumber int, partSize, size int64, profile Profile, partSums []string) error {
+	offset := int64(partNumber-1) * partSize
+	length := min(partSize, size-offset)
+	sum, err := sectionSHA1(f, offset, length)
+	if err != nil {
+		return err
+	}
+
+	if u.UploadURL == "" {
+		if err := d.call("b2_get_upload_part_url", map[string]string{"fileId": fileID}, u); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, u.UploadURL, uploadBody(io.NewSectionReader(f, offset, length), profile))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	req.Header.Set("Authorization", u.AuthorizationToken)
+	req.Header.Set("X-Bz-Part-Number", strconv.Itoa(partNumber))
+	req.Header.Set("X-Bz-Content-Sha1", sum)
+	var part struct{}
+	if err := d.do(req, &part); err != nil {
+		// Upload URLs go bad when their pod is busy; get a new one
+		*u = b2UploadURL{}
+		return err
+	}
+	partSums[partNumber-1] = sum
+	return nil
+}
+
+// b2FileInfo maps sidecar metadata to B2 file info. B2 keeps the standard
+// headers in b2-* info keys.
+func b2FileInfo(meta *sidecarMetadata) map[string]string {
+	info := make(map[string]string)
+	if meta == nil {
+		return info
+	}
+	for k, v := range meta.Metadata {
+		info[k] = v
+	}
+	if meta.CacheControl != "" {
+		info["b2-cache-control"] = meta.CacheControl
+	}
+	if meta.ContentDisposition != "" {
+		info["b2-content-disposition"] = meta.ContentDisposition
+	}
+	if meta.ContentEncoding != "" {
+		info["b2-content-encoding"] = meta.ContentEncoding
+	}
+	return info
+}
+
+func sectionSHA1(f *os.File, offset, length int64) (string, error) {
+	h := sha1.New()
+	if _, err := io.CopyBuffer(h, io.NewSectionReader(f, offset, length), newCopyBuffer()); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// matches compares the size and the SHA-1 B2 keeps for the file. Large files
+// carry the SHA-1 of the whole file in their large_file_sha1 info.
+func (d *b2Destination) matches(bucket, key, path string) (bool, error) {
+	bucketID, err := d.bucketID(bucket)
+	if err != nil {
+		return false, err
+	}
+	var list struct {
+		Files []b2File `json:"files"`
+	}
+	request := map[string]any{"bucketId": bucketID, "startFileName": key, "maxFileCount": 1}
+	if err := d.call("b2_list_file_names", request, &list); err != nil {
+		return false, err
+	}
+	if len(list.Files) == 0 || list.Files[0].FileName != key {
+		return false, nil
+	}
+	remote := list.Files[0]
+
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if remote.ContentLength != info.Size() {
+		return false, nil
+	}
+
+	remoteSum := strings.TrimPrefix(remote.ContentSha1, "unverified:")
+	if remoteSum == "" || remoteSum == "none" {
+		remoteSum = remote.FileInfo["large_file_sha1"]
+	}
+	if remoteSum == "" {
+		log.Printf("Remote %s exists with matching size; it has no SHA-1 to compare", key)
+		return true, nil
+	}
+	sum, err := sectionSHA1(f, 0, info.Size())
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(sum, remoteSum), nil
+}
+
+func (d *b2Destination) transient(err error) bool {
+	var apiErr *b2Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Status >= 500 || apiErr.Status == http.StatusRequestTimeout ||
+			apiErr.Status == http.StatusTooManyRequests || slices.Contains(b2TransientCodes, apiErr.Code)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || isTransientError(err)
+}
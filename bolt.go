@@ -0,0 +1,727 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ncruces/go-sqlite3"
+	"github.com/ncruces/go-sqlite3/vfs"
+	bolt "go.etcd.io/bbolt"
+)
+
+// With -db-driver bolt the database is kept in a Bolt file (bbolt), a
+// pure-Go key-value store, for edge devices where neither cgo nor the
+// file system can be relied on. The queries and migrations stay those of
+// SQLite: a SQLite translated to Go runs on a VFS that stores the pages of
+// the database and its rollback journal as Bolt keys. The writes of a
+// SQLite transaction are committed to Bolt together, and Bolt's
+// copy-on-write pages keep the file consistent if the device loses power.
+// Bolt locks the file, so only one flood process at a time can open it,
+// and it cannot be opened with the other drivers.
+
+const (
+	boltVFSName = "flood-bolt"
+	// boltBlockSize is the size of the values the SQLite files are split
+	// into, the page size of SQLite.
+	boltBlockSize = 4096
+)
+
+var (
+	boltVFSOnce sync.Once
+
+	boltFilesMu sync.Mutex
+	boltFiles   = make(map[string]*boltFile)
+
+	// boltSizeKey holds the size of a SQLite file in its bucket; the keys
+	// of its blocks are 8 bytes long.
+	boltSizeKey = []byte("size")
+)
+
+// openBoltSQLite opens the SQLite database kept in the Bolt file at path.
+func openBoltSQLite(path string) (store, error) {
+	boltVFSOnce.Do(func() {
+		vfs.Register(boltVFSName, boltVFS{})
+	})
+	// Open the file here, so that a file that is not a Bolt file or is
+	// locked by another process fails now rather than on the first query.
+	file, err := openBoltFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(boltConnector{path: path})
+	if err := db.Ping(); err != nil {
+		file.release()
+		db.Close()
+		return nil, err
+	}
+	file.release()
+	s := &sqliteStore{db: db, writes: make(chan sqliteWrite)}
+	go s.writer()
+	return s, nil
+}
+
+// isBoltBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED of the
+// bolt driver.
+func isBoltBusy(err error) bool {
+	return errors.Is(err, sqlite3.BUSY) || errors.Is(err, sqlite3.LOCKED)
+}
+
+// boltFile is an open Bolt file with the SQLite database and its journal,
+// shared by all connections.
+type boltFile struct {
+	path string
+	db   *bolt.DB
+	refs int // guarded by boltFilesMu
+
+	mu       sync.Mutex
+	streams  map[string]*boltStream
+	shared   int
+	reserved bool
+	pending  bool
+}
+
+func openBoltFile(path string) (*boltFile, error) {
+	boltFilesMu.Lock()
+	defer boltFilesMu.Unlock()
+	if f, ok := boltFiles[path]; ok {
+		f.refs++
+		return f, nil
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("Bolt file %s is in use by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Bolt file %s: %w", path, err)
+	}
+	f := &boltFile{path: path, db: db, refs: 1, streams: make(map[string]*boltStream)}
+	boltFiles[path] = f
+	return f, nil
+}
+
+// release closes the Bolt file once no connection uses it.
+func (f *boltFile) release() error {
+	boltFilesMu.Lock()
+	defer boltFilesMu.Unlock()
+	if f.refs--; f.refs > 0 {
+		return nil
+	}
+	delete(boltFiles, f.path)
+	return f.db.Close()
+}
+
+// stream returns the SQLite file kept in the bucket name.
+func (f *boltFile) stream(name string) (*boltStream, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.streams[name]; ok {
+		return s, nil
+	}
+	s := &boltStream{file: f, bucket: []byte(name), dirty: make(map[int64][]byte)}
+	err := f.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket(s.bucket); b != nil {
+			s.exists = true
+			if v := b.Get(boltSizeKey); v != nil {
+				s.size = int64(binary.BigEndian.Uint64(v))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.synced = s.size
+	f.streams[name] = s
+	return s, nil
+}
+
+// boltStream is a SQLite file in a bucket of a Bolt file. Writes are kept
+// in memory until SQLite syncs the file or commits a batch of writes,
+// and are then written in a single Bolt transaction.
+type boltStream struct {
+	file   *boltFile
+	bucket []byte
+
+	mu     sync.Mutex
+	exists bool
+	size   int64
+	synced int64 // size in the Bolt file
+	dirty  map[int64][]byte
+}
+
+func boltBlockKey(n int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(n))
+}
+
+// block returns a copy of block n. Blocks that were never written are
+// zero.
+func (s *boltStream) block(tx *bolt.Tx, n int64) []byte {
+	if d, ok := s.dirty[n]; ok {
+		return d
+	}
+	block := make([]byte, boltBlockSize)
+	if b := tx.Bucket(s.bucket); b != nil {
+		copy(block, b.Get(boltBlockKey(n)))
+	}
+	return block
+}
+
+func (s *boltStream) ReadAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), s.size)
+	n := 0
+	err := s.file.db.View(func(tx *bolt.Tx) error {
+		for pos := off; pos < end; {
+			block := s.block(tx, pos/boltBlockSize)
+			c := copy(p[n:end-off], block[pos%boltBlockSize:])
+			n += c
+			pos += int64(c)
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (s *boltStream) WriteAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := off + int64(len(p))
+	n := 0
+	err := s.file.db.View(func(tx *bolt.Tx) error {
+		for pos := off; pos < end; {
+			i := pos / boltBlockSize
+			block := s.block(tx, i)
+			c := copy(block[pos%boltBlockSize:], p[n:])
+			s.dirty[i] = block
+			n += c
+			pos += int64(c)
+		}
+		return nil
+	})
+	if err != nil {
+		return n, err
+	}
+	s.exists = true
+	s.size = max(s.size, end)
+	return n, nil
+}
+
+func (s *boltStream) Truncate(size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if size >= s.size {
+		s.size = size
+		return nil
+	}
+	for i := range s.dirty {
+		if i*boltBlockSize >= size {
+			delete(s.dirty, i)
+		}
+	}
+	if size%boltBlockSize != 0 {
+		err := s.file.db.View(func(tx *bolt.Tx) error {
+			i := size / boltBlockSize
+			block := s.block(tx, i)
+			clear(block[size%boltBlockSize:])
+			s.dirty[i] = block
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.size = size
+	return nil
+}
+
+func (s *boltStream) Size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size, nil
+}
+
+// flush writes the blocks and the size of the file to Bolt.
+func (s *boltStream) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.exists || (len(s.dirty) == 0 && s.size == s.synced) {
+		return nil
+	}
+	err := s.file.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+		for i, block := range s.dirty {
+			if err := b.Put(boltBlockKey(i), block); err != nil {
+				return err
+			}
+		}
+		if s.size < s.synced {
+			// Delete the blocks past the end, which the cursor finds
+			// after the keys of the blocks before it.
+			c := b.Cursor()
+			for k, _ := c.Seek(boltBlockKey((s.size + boltBlockSize - 1) / boltBlockSize)); k != nil; k, _ = c.Next() {
+				if len(k) != 8 {
+					continue
+				}
+				if err := c.Delete(); err != nil {
+					return err
+				}
+			}
+		}
+		return b.Put(boltSizeKey, binary.BigEndian.AppendUint64(nil, uint64(s.size)))
+	})
+	if err != nil {
+		return err
+	}
+	clear(s.dirty)
+	s.synced = s.size
+	return nil
+}
+
+// discard drops the writes since the last flush.
+func (s *boltStream) discard() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.dirty)
+	s.size = s.synced
+}
+
+// remove deletes the file, as SQLite does with its journal at the end of
+// a transaction.
+func (s *boltStream) remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.file.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket(s.bucket) == nil {
+			return nil
+		}
+		return tx.DeleteBucket(s.bucket)
+	})
+	if err != nil {
+		return err
+	}
+	clear(s.dirty)
+	s.exists = false
+	s.size, s.synced = 0, 0
+	return nil
+}
+
+// boltVFS keeps the database and its rollback journal in the Bolt file at
+// the path of the database. Temporary files are left to the OS.
+type boltVFS struct{}
+
+const boltJournalSuffix = "-journal"
+
+func (boltVFS) Open(name string, flags vfs.OpenFlag) (vfs.File, vfs.OpenFlag, error) {
+	switch {
+	case flags&vfs.OPEN_MAIN_DB != 0:
+		file, err := openBoltFile(name)
+		if err != nil {
+			return nil, flags, err
+		}
+		s, err := file.stream("db")
+		if err != nil {
+			file.release()
+			return nil, flags, err
+		}
+		return &boltHandle{boltStream: s, main: true}, flags, nil
+	case flags&vfs.OPEN_MAIN_JOURNAL != 0:
+		s, err := journalStream(name)
+		if err != nil {
+			return nil, flags, err
+		}
+		if !s.exists && flags&vfs.OPEN_CREATE == 0 {
+			return nil, flags, sqlite3.CANTOPEN
+		}
+		s.mu.Lock()
+		s.exists = true
+		s.mu.Unlock()
+		return &boltHandle{boltStream: s}, flags, nil
+	case flags&vfs.OPEN_WAL != 0 || flags&vfs.OPEN_SUPER_JOURNAL != 0:
+		// Without shared memory there is no WAL, and with a single
+		// database no super journal.
+		return nil, flags, sqlite3.CANTOPEN
+	}
+	return vfs.Find("os").Open(name, flags)
+}
+
+// journalStream returns the journal of the database that is open in the
+// Bolt file at the path of name, without the journal suffix.
+func journalStream(name string) (*boltStream, error) {
+	boltFilesMu.Lock()
+	file, ok := boltFiles[strings.TrimSuffix(name, boltJournalSuffix)]
+	boltFilesMu.Unlock()
+	if !ok || !strings.HasSuffix(name, boltJournalSuffix) {
+		return nil, sqlite3.CANTOPEN
+	}
+	return file.stream("journal")
+}
+
+func (boltVFS) Delete(name string, syncDir bool) error {
+	if !strings.HasSuffix(name, boltJournalSuffix) {
+		return vfs.Find("os").Delete(name, syncDir)
+	}
+	s, err := journalStream(name)
+	if err != nil {
+		return sqlite3.IOERR_DELETE_NOENT
+	}
+	return s.remove()
+}
+
+func (boltVFS) Access(name string, flags vfs.AccessFlag) (bool, error) {
+	if !strings.HasSuffix(name, boltJournalSuffix) {
+		return vfs.Find("os").Access(name, flags)
+	}
+	s, err := journalStream(name)
+	if err != nil {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exists, nil
+}
+
+func (boltVFS) FullPathname(name string) (string, error) {
+	return name, nil
+}
+
+// boltHandle is a file of boltVFS opened by a connection. The database is
+// locked like a file by SQLite; its locks are shared by the connections
+// through the boltFile.
+type boltHandle struct {
+	*boltStream
+	main bool
+	lock vfs.LockLevel
+}
+
+var _ vfs.FileBatchAtomicWrite = &boltHandle{}
+
+func (h *boltHandle) Close() error {
+	err := h.flush()
+	if h.main {
+		h.Unlock(vfs.LOCK_NONE)
+		if releaseErr := h.file.release(); err == nil {
+			err = releaseErr
+		}
+	}
+	return err
+}
+
+func (h *boltHandle) Sync(flags vfs.SyncFlag) error {
+	return h.flush()
+}
+
+// BeginAtomicWrite, CommitAtomicWrite and RollbackAtomicWrite let SQLite
+// commit a transaction without its journal: the pages are kept in memory
+// anyway and written to Bolt in one transaction.
+func (h *boltHandle) BeginAtomicWrite() error    { return nil }
+func (h *boltHandle) CommitAtomicWrite() error   { return h.flush() }
+func (h *boltHandle) RollbackAtomicWrite() error { h.discard(); return nil }
+
+func (h *boltHandle) SectorSize() int {
+	return boltBlockSize
+}
+
+func (h *boltHandle) DeviceCharacteristics() vfs.DeviceCharacteristic {
+	return vfs.IOCAP_BATCH_ATOMIC |
+		vfs.IOCAP_SAFE_APPEND |
+		vfs.IOCAP_SEQUENTIAL |
+		vfs.IOCAP_POWERSAFE_OVERWRITE |
+		vfs.IOCAP_SUBPAGE_READ
+}
+
+// Lock and Unlock follow the locking states of a SQLite database file: any
+// number of connections share a SHARED lock, one of them may hold RESERVED
+// to prepare a write, and EXCLUSIVE, to write, waits for the others to
+// give up SHARED. SQLite retries on BUSY for the busy timeout.
+func (h *boltHandle) Lock(lock vfs.LockLevel) error {
+	if !h.main || h.lock >= lock {
+		return nil
+	}
+	f := h.file
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch lock {
+	case vfs.LOCK_SHARED:
+		if f.pending {
+			return sqlite3.BUSY
+		}
+		f.shared++
+	case vfs.LOCK_RESERVED:
+		if f.reserved {
+			return sqlite3.BUSY
+		}
+		f.reserved = true
+	case vfs.LOCK_EXCLUSIVE:
+		if h.lock == vfs.LOCK_RESERVED {
+			h.lock = vfs.LOCK_PENDING
+			f.pending = true
+		}
+		if f.shared > 1 {
+			return sqlite3.BUSY
+		}
+	}
+	h.lock = lock
+	return nil
+}
+
+func (h *boltHandle) Unlock(lock vfs.LockLevel) error {
+	if !h.main || h.lock <= lock {
+		return nil
+	}
+	f := h.file
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if h.lock >= vfs.LOCK_RESERVED {
+		f.reserved = false
+	}
+	if h.lock >= vfs.LOCK_PENDING {
+		f.pending = false
+	}
+	if lock < vfs.LOCK_SHARED {
+		f.shared--
+	}
+	h.lock = lock
+	return nil
+}
+
+func (h *boltHandle) CheckReservedLock() (bool, error) {
+	if h.lock >= vfs.LOCK_RESERVED {
+		return true, nil
+	}
+	h.file.mu.Lock()
+	defer h.file.mu.Unlock()
+	return h.file.reserved, nil
+}
+
+// boltConnector is a minimal database/sql driver for the SQLite of the
+// bolt driver. It converts arguments and columns as the cgo driver does,
+// so that timestamps are stored as text and scanned into time.Time.
+type boltConnector struct {
+	path string
+}
+
+var sqliteURIEscaper = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+
+func (c boltConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	uri := fmt.Sprintf("file:%s?vfs=%s&_pragma=busy_timeout(%d)&_pragma=synchronous(FULL)&_pragma=temp_store(MEMORY)",
+		sqliteURIEscaper.Replace(c.path), boltVFSName, sqliteBusyTimeout.Milliseconds())
+	conn, err := sqlite3.OpenContext(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return &boltConn{conn: conn}, nil
+}
+
+func (c boltConnector) Driver() driver.Driver {
+	return boltDriver{}
+}
+
+type boltDriver struct{}
+
+func (boltDriver) Open(name string) (driver.Conn, error) {
+	return boltConnector{path: name}.Connect(context.Background())
+}
+
+type boltConn struct {
+	conn *sqlite3.Conn
+}
+
+func (c *boltConn) Prepare(query string) (driver.Stmt, error) {
+	stmt, tail, err := c.conn.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(tail) != "" {
+		stmt.Close()
+		return nil, fmt.Errorf("statements with arguments must be run one at a time: %s", tail)
+	}
+	return &boltStmt{stmt: stmt, conn: c.conn}, nil
+}
+
+// ExecContext runs statements without arguments directly, so that a
+// migration file runs as a whole.
+func (c *boltConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	if err := c.conn.Exec(query); err != nil {
+		return nil, err
+	}
+	return boltResult{id: c.conn.LastInsertRowID(), changes: c.conn.Changes()}, nil
+}
+
+func (c *boltConn) Begin() (driver.Tx, error) {
+	if err := c.conn.Exec("BEGIN"); err != nil {
+		return nil, err
+	}
+	return boltTx{c.conn}, nil
+}
+
+func (c *boltConn) Close() error {
+	return c.conn.Close()
+}
+
+type boltTx struct {
+	conn *sqlite3.Conn
+}
+
+func (tx boltTx) Commit() error   { return tx.conn.Exec("COMMIT") }
+func (tx boltTx) Rollback() error { return tx.conn.Exec("ROLLBACK") }
+
+type boltResult struct {
+	id, changes int64
+}
+
+func (r boltResult) LastInsertId() (int64, error) { return r.id, nil }
+func (r boltResult) RowsAffected() (int64, error) { return r.changes, nil }
+
+type boltStmt struct {
+	stmt *sqlite3.Stmt
+	conn *sqlite3.Conn
+}
+
+func (s *boltStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *boltStmt) NumInput() int {
+	return s.stmt.BindCount()
+}
+
+// sqliteTimeFormat is how the cgo driver stores time.Time arguments.
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+func (s *boltStmt) bind(args []driver.Value) error {
+	if err := s.stmt.Reset(); err != nil {
+		return err
+	}
+	for i, arg := range args {
+		param := i + 1
+		var err error
+		switch v := arg.(type) {
+		case nil:
+			err = s.stmt.BindNull(param)
+		case int64:
+			err = s.stmt.BindInt64(param, v)
+		case float64:
+			err = s.stmt.BindFloat(param, v)
+		case bool:
+			err = s.stmt.BindBool(param, v)
+		case []byte:
+			err = s.stmt.BindBlob(param, v)
+		case string:
+			err = s.stmt.BindText(param, v)
+		case time.Time:
+			err = s.stmt.BindText(param, v.Format(sqliteTimeFormat))
+		default:
+			err = fmt.Errorf("unsupported argument type %T", arg)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *boltStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if err := s.bind(args); err != nil {
+		return nil, err
+	}
+	if err := s.stmt.Exec(); err != nil {
+		return nil, err
+	}
+	return boltResult{id: s.conn.LastInsertRowID(), changes: s.conn.Changes()}, nil
+}
+
+func (s *boltStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if err := s.bind(args); err != nil {
+		return nil, err
+	}
+	return &boltRows{stmt: s.stmt}, nil
+}
+
+type boltRows struct {
+	stmt *sqlite3.Stmt
+}
+
+func (r *boltRows) Columns() []string {
+	columns := make([]string, r.stmt.ColumnCount())
+	for i := range columns {
+		columns[i] = r.stmt.ColumnName(i)
+	}
+	return columns
+}
+
+func (r *boltRows) Close() error {
+	return r.stmt.Reset()
+}
+
+// sqliteTimeFormats are the formats of text in DATE, DATETIME and
+// TIMESTAMP columns that are scanned as time.Time, as by the cgo driver.
+var sqliteTimeFormats = []string{
+	sqliteTimeFormat,
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+func (r *boltRows) Next(dest []driver.Value) error {
+	if !r.stmt.Step() {
+		if err := r.stmt.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i := range dest {
+		switch r.stmt.ColumnType(i) {
+		case sqlite3.INTEGER:
+			dest[i] = r.stmt.ColumnInt64(i)
+		case sqlite3.FLOAT:
+			dest[i] = r.stmt.ColumnFloat(i)
+		case sqlite3.BLOB:
+			dest[i] = r.stmt.ColumnBlob(i, nil)
+		case sqlite3.TEXT:
+			text := r.stmt.ColumnText(i)
+			dest[i] = text
+			switch strings.ToUpper(r.stmt.ColumnDeclType(i)) {
+			case "DATE", "DATETIME", "TIMESTAMP":
+				text = strings.TrimSuffix(text, "Z")
+				for _, layout := range sqliteTimeFormats {
+					if t, err := time.ParseInLocation(layout, text, time.UTC); err == nil {
+						dest[i] = t
+						break
+					}
+				}
+			}
+		default:
+			dest[i] = nil
+		}
+	}
+	return nil
+}
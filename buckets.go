@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// bucketOverride layers bucket specific settings on top of a profile. They
+// are given in a section named after the profile and the bucket directory:
+//
+//	[cloudflare/eu-archive]
+//	aws_endpoint = https://<account>.eu.r2.cloudflarestorage.com
+//	aws_region = auto
+//	storage_class = STANDARD_IA
+type bucketOverride struct {
+	Endpoint     string
+	Region       string
+	StorageClass types.StorageClass
+}
+
+var bucketOverrideKeys = []string{"aws_endpoint", "aws_region", "storage_class"}
+
+// supportedStorageClasses lists the storage classes each provider accepts.
+var supportedStorageClasses = map[string][]types.StorageClass{
+	"amazon":     {"STANDARD", "STANDARD_IA", "ONEZONE_IA", "INTELLIGENT_TIERING", "GLACIER", "GLACIER_IR", "DEEP_ARCHIVE", "REDUCED_REDUNDANCY"},
+	"cloudflare": {"STANDARD", "STANDARD_IA"},
+}
+
+func parseStorageClass(provider, value string) (types.StorageClass, error) {
+	class := types.StorageClass(strings.ToUpper(value))
+	if !slices.Contains(supportedStorageClasses[provider], class) {
+		return "", fmt.Errorf("storage_class = %s is not supported by provider %s", value, provider)
+	}
+	return class, nil
+}
+
+func parseBucketOverride(profile Profile, settings map[string]string) (bucketOverride, error) {
+	if profile.Destination != nil {
+		return bucketOverride{}, fmt.Errorf("bucket overrides are not supported by provider %s", profile.Provider)
+	}
+	for key := range settings {
+		if !slices.Contains(bucketOverrideKeys, key) {
+			return bucketOverride{}, fmt.Errorf("%s cannot be overridden per bucket, only %s", key, strings.Join(bucketOverrideKeys, ", "))
+		}
+	}
+
+	override := bucketOverride{
+		Endpoint: settings["aws_endpoint"],
+		Region:   settings["aws_region"],
+	}
+	if pattern, ok := endpointPatterns[profile.Provider]; ok && override.Endpoint == "" && override.Region != "" {
+		override.Endpoint = fmt.Sprintf(pattern, override.Region)
+	}
+	if value := settings["storage_class"]; value != "" {
+		class, err := parseStorageClass(profile.Provider, value)
+		if err != nil {
+			return bucketOverride{}, err
+		}
+		override.StorageClass = class
+	}
+	return override, nil
+}
+
+// forBucket returns the profile with the overrides of the bucket applied.
+func (p Profile) forBucket(bucket string) Profile {
+	override, ok := p.Buckets[bucket]
+	if !ok {
+		return p
+	}
+	if override.Endpoint != "" {
+		p.Endpoint = override.Endpoint
+	}
+	if override.Region != "" {
+		p.Region = override.Region
+	}
+	if override.StorageClass != "" {
+		p.StorageClass = override.StorageClass
+	}
+	return p
+}
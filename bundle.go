@@ -0,0 +1,263 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Bundling groups the small files of a directory into tar archives that are
+// uploaded as a single object, which avoids paying the per-object overhead
+// for every file. It is enabled per profile:
+//
+//	bundle_size = 64MB        ; target size of a bundle
+//	bundle_format = tar.zst   ; tar (default) or tar.zst
+//	bundle_max_wait = 5m      ; flush a partial bundle after this long
+const defaultBundleMaxWait = 5 * time.Minute
+
+var bundleFormats = map[string]string{
+	"tar":     ".tar",
+	"tar.zst": ".tar.zst",
+}
+
+// planBundles groups files waiting in the processing directory by bucket and
+// directory and cuts each group into bundles of about the profile's bundle
+// size. A group that is too small for a full bundle is only bundled once its
+// oldest file has waited for BundleMaxWait, or right away with flush. Files
+// that are at least as large as a bundle are uploaded on their own.
+func planBundles(profile Profile, paths []string, flush bool) []uploadJob {
+	type member struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	type group struct {
+		bucket  string
+		dir     string
+		members []member
+	}
+
+	groups := make(map[string]*group)
+	var jobs []uploadJob
+	for _, p := range paths {
+		bucket, relativePath, ok := splitProcessingPath(profile, p)
+		if !ok {
+			log.Printf("Ignoring %s: files must be placed in a bucket directory", p)
+			continue
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if info.Size() >= profile.BundleSize {
+			jobs = append(jobs, uploadJob{bucket: bucket, path: p})
+			continue
+		}
+
+		dir := filepath.ToSlash(filepath.Dir(relativePath))
+		g, ok := groups[bucket+"/"+dir]
+		if !ok {
+			g = &group{bucket: bucket, dir: dir}
+			groups[bucket+"/"+dir] = g
+		}
+		g.members = append(g.members, member{path: p, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	for _, g := range groups {
+		sort.Slice(g.members, func(i, j int) bool { return g.members[i].path < g.members[j].path })
+
+		var (
+			current []string
+			size    int64
+			oldest  time.Time
+		)
+		for _, m := range g.members {
+			current = append(current, m.path)
+			size += m.size
+			if oldest.IsZero() || m.modTime.Before(oldest) {
+				oldest = m.modTime
+			}
+			if size >= profile.BundleSize {
+				jobs = append(jobs, uploadJob{bucket: g.bucket, dir: g.dir, members: current})
+				current, size, oldest = nil, 0, time.Time{}
+			}
+		}
+		if len(current) > 0 && (flush || time.Since(oldest) >= profile.BundleMaxWait) {
+			jobs = append(jobs, uploadJob{bucket: g.bucket, dir: g.dir, members: current})
+		}
+	}
+	return jobs
+}
+
+// processBundle archives the given files, uploads the archive and moves the
+// files to completed or failed depending on the outcome, which it returns.
+// The mapping of the bundle to its members is recorded in the bundles and
+// bundle_members tables.
+func processBundle(profile Profile, bucketName, dir string, members []string) (outcome string) {
+	profile = profile.forBucket(bucketName)
+	name := fmt.Sprintf("bundle-%s-%08x%s", time.Now().UTC().Format("20060102T150405Z"), rand.Uint32(), bundleFormats[profile.BundleFormat])
+	archivePath := filepath.Join(serverDir, "bundles", profile.Name, bucketName, name)
+	bucketDir := filepath.Join(serverDir, "processing", profile.Name, bucketName)
+
+	rec := fileRecord{Path: archivePath, Profile: profile, Bucket: bucketName, OriginalPath: path.Join(dir, name)}
+	outcome = "failure"
+	defer func() {
+		if outcome == "interrupted" {
+			// The members stay in processing for the drain to return
+			// them to incoming.
+			logRetry(rec, outcome)
+			os.Remove(archivePath)
+			return
+		}
+		for _, member := range members {
+			memberOutcome := outcome
+			switch {
+			case outcome == "success":
+				moveToCompleted(member)
+			case outcome == "failed_over" && failover(member, profile):
+			default:
+				moveToFailed(member)
+				if outcome == "failed_over" {
+					memberOutcome = "failure"
+				}
+			}
+			// The members were received under their own paths; the
+			// record of the bundle is under the path of the archive.
+			auditFile(memberOutcome, "bundle", fileRecord{Path: member, Profile: profile, Bucket: bucketName, Key: rec.Key, Retries: rec.Retries, Attempts: rec.Attempts})
+		}
+		logRetry(rec, outcome)
+		recordBundle(rec, profile.BundleFormat, bucketDir, members, outcome)
+		os.Remove(archivePath)
+	}()
+
+	log.Printf("Bundling %d file(s) from %s into %s", len(members), filepath.Join(bucketDir, dir), name)
+	if err := createBundle(archivePath, profile.BundleFormat, bucketDir, members); err != nil {
+		log.Printf("Failed to create bundle %s: %v", archivePath, err)
+		return
+	}
+
+	var err error
+	rec.Key, err = objectKey(profile, bucketName, rec.OriginalPath)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return
+	}
+
+	if err := uploadWithRetry(&rec); err != nil {
+		discardMultipartUpload(profile, archivePath)
+		switch {
+		case errors.Is(err, errCancelled) && drainInterrupted():
+			outcome = "interrupted"
+			return
+		case errors.Is(err, errConflict):
+			outcome = "conflict"
+		case profile.FailoverProfile != "":
+			outcome = "failed_over"
+		}
+		log.Printf("Bundle upload of %d file(s) failed (%s): %v", len(members), outcome, err)
+		return
+	}
+	outcome = "success"
+	return
+}
+
+// createBundle writes the members into a tar archive, optionally compressed
+// with zstd. Entries are named by their path relative to the bucket
+// directory. Files are streamed, so memory use does not depend on their size.
+func createBundle(archivePath, format, bucketDir string, members []string) error {
+	if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	var enc *zstd.Encoder
+	if format == "tar.zst" {
+		enc, err = zstd.NewWriter(f)
+		if err != nil {
+			return err
+		}
+		w = enc
+	}
+
+	tw := tar.NewWriter(w)
+	buf := newCopyBuffer()
+	for _, member := range members {
+		if err := addToBundle(tw, bucketDir, member, buf); err != nil {
+			return fmt.Errorf("failed to add %s: %w", member, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return err
+		}
+	}
+	return f.Close()
+}
+
+func addToBundle(tw *tar.Writer, bucketDir, path string, buf []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	name, err := filepath.Rel(bucketDir, path)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.CopyBuffer(tw, f, buf)
+	return err
+}
+
+func recordBundle(rec fileRecord, format, bucketDir string, members []string, outcome string) {
+	bundleID, err := db.insert(
+		"INSERT INTO bundles(profile, bucket, object_key, format, member_count, created, upload_outcome) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		rec.Profile.Name, rec.Bucket, rec.Key, format, len(members), time.Now(), outcome,
+	)
+	if err != nil {
+		log.Printf("Failed to record bundle %s: %v", rec.Key, err)
+		return
+	}
+
+	for _, member := range members {
+		relativePath, _ := filepath.Rel(bucketDir, member)
+		_, err := db.Exec(
+			"INSERT INTO bundle_members(bundle_id, filepath, original_path) VALUES (?, ?, ?)",
+			bundleID, member, filepath.ToSlash(relativePath),
+		)
+		if err != nil {
+			log.Printf("Failed to record member %s of bundle %s: %v", member, rec.Key, err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// A file of the processing directory can be cancelled through the admin
+// API or flood ctl cancel. An upload in flight is aborted: reads of its
+// body fail, a multipart upload is aborted and no retry follows. A file
+// still waiting is never uploaded. Either way the file moves to the
+// cancelled directory, from where it can be put back into incoming by
+// hand.
+
+// errCancelled is the error of an upload that was cancelled.
+var errCancelled = errors.New("upload cancelled")
+
+// cancelFile cancels the upload of a file of the profile's processing
+// directory. It returns "cancelling" if the upload or the worker that is
+// about to start it stops on its own, or "cancelled" if the file was
+// already moved.
+func cancelFile(q *profileQueue, path string) (string, error) {
+	profile := q.currentProfile()
+	if u := lookupUpload(profile.Name, path); u != nil {
+		u.cancelUpload()
+		return "cancelling", nil
+	}
+	bucket, _, ok := splitProcessingPath(profile, path)
+	if !ok {
+		return "", fmt.Errorf("%s is not in the processing directory of profile %s", path, profile.Name)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+
+	q.mu.Lock()
+	if q.pending[path] {
+		// A worker has it; see withoutCancelled and trackUpload
+		if q.cancelled == nil {
+			q.cancelled = make(map[string]bool)
+		}
+		q.cancelled[path] = true
+		q.mu.Unlock()
+		return "cancelling", nil
+	}
+	// Keep the scanner from queueing it meanwhile
+	q.pending[path] = true
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.pending, path)
+		q.mu.Unlock()
+	}()
+
+	if err := cancelWaiting(profile, bucket, path); err != nil {
+		return "", err
+	}
+	return "cancelled", nil
+}
+
+// cancelWaiting moves a file that is not being uploaded to the cancelled
+// directory.
+func cancelWaiting(profile Profile, bucket, path string) error {
+	release, ok := claimJob(uploadJob{bucket: bucket, path: path})
+	if !ok {
+		return fmt.Errorf("%s is being uploaded by another instance or is gone", path)
+	}
+	defer release()
+
+	profile = profile.forBucket(bucket)
+	rec := fileRecord{ID: newCorrelationID(), Path: path, Profile: profile, Bucket: bucket}
+	if rel, err := filepath.Rel(filepath.Join(serverDir, "processing", profile.Name, bucket), path); err == nil {
+		rec.OriginalPath = filepath.ToSlash(rel)
+		rec.Key, _ = objectKey(profile, bucket, rel)
+	}
+	discardMultipartUpload(profile, path)
+	settleCancelled(rec)
+	return nil
+}
+
+// settleCancelled moves the file of a cancelled upload to the cancelled
+// directory.
+func settleCancelled(rec fileRecord) {
+	rec.logger().Warn("Moving to cancelled directory", "state", "cancelled")
+	if settleFile(rec, "cancelled", strings.Replace(rec.Path, "processing", "cancelled", 1)) {
+		notifyFile("cancelled", rec, "cancelled", nil)
+	}
+}
+
+// withoutCancelled removes the files cancelled while queued from job and
+// moves them to the cancelled directory. It reports false if nothing of the
+// job is left to upload.
+func (q *profileQueue) withoutCancelled(job uploadJob) (uploadJob, bool) {
+	var cancelled []string
+	q.mu.Lock()
+	for _, path := range job.paths() {
+		if q.cancelled[path] {
+			delete(q.cancelled, path)
+			cancelled = append(cancelled, path)
+		}
+	}
+	q.mu.Unlock()
+	if len(cancelled) == 0 {
+		return job, true
+	}
+
+	for _, path := range cancelled {
+		if err := cancelWaiting(q.currentProfile(), job.bucket, path); err != nil {
+			log.Printf("Failed to cancel %s: %v", path, err)
+		}
+	}
+	if job.members == nil {
+		return job, false
+	}
+	job.members = slices.DeleteFunc(slices.Clone(job.members), func(path string) bool {
+		return slices.Contains(cancelled, path)
+	})
+	return job, len(job.members) > 0
+}
+
+// takeCancelRequest reports whether the file was cancelled while queued,
+// for an upload that started meanwhile.
+func (q *profileQueue) takeCancelRequest(path string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.cancelled[path] {
+		return false
+	}
+	delete(q.cancelled, path)
+	return true
+}
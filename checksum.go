@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// supportedChecksums lists the x-amz-checksum-* algorithms each provider
+// accepts. The SDK computes the checksum while streaming the body and sends
+// it as a trailer; the server rejects the upload if it does not match.
+var supportedChecksums = map[string][]types.ChecksumAlgorithm{
+	"amazon":     {types.ChecksumAlgorithmCrc32, types.ChecksumAlgorithmCrc32c, types.ChecksumAlgorithmSha1, types.ChecksumAlgorithmSha256},
+	"cloudflare": {types.ChecksumAlgorithmCrc32, types.ChecksumAlgorithmCrc32c, types.ChecksumAlgorithmSha1, types.ChecksumAlgorithmSha256},
+}
+
+// checksumAlgorithm returns the checksum to send with uploads of the
+// profile. Object Lock requires one, so CRC32 is used when none is set.
+func checksumAlgorithm(profile Profile) types.ChecksumAlgorithm {
+	if profile.ChecksumAlgorithm == "" && profile.ObjectLockMode != "" {
+		return types.ChecksumAlgorithmCrc32
+	}
+	return profile.ChecksumAlgorithm
+}
+
+// checksumValue picks the value of the given algorithm from the checksum
+// fields of a response.
+func checksumValue(algorithm types.ChecksumAlgorithm, crc32, crc32c, sha1, sha256 *string) string {
+	switch algorithm {
+	case types.ChecksumAlgorithmCrc32:
+		return aws.ToString(crc32)
+	case types.ChecksumAlgorithmCrc32c:
+		return aws.ToString(crc32c)
+	case types.ChecksumAlgorithmSha1:
+		return aws.ToString(sha1)
+	case types.ChecksumAlgorithmSha256:
+		return aws.ToString(sha256)
+	}
+	return ""
+}
+
+// completedPart builds the entry for a part in CompleteMultipartUpload,
+// which must repeat the checksum of every part.
+func completedPart(partNumber int32, etag string, algorithm types.ChecksumAlgorithm, checksum string) types.CompletedPart {
+	part := types.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int32(partNumber)}
+	value := optionalString(checksum)
+	switch algorithm {
+	case types.ChecksumAlgorithmCrc32:
+		part.ChecksumCRC32 = value
+	case types.ChecksumAlgorithmCrc32c:
+		part.ChecksumCRC32C = value
+	case types.ChecksumAlgorithmSha1:
+		part.ChecksumSHA1 = value
+	case types.ChecksumAlgorithmSha256:
+		part.ChecksumSHA256 = value
+	}
+	return part
+}
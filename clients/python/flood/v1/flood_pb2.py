@@ -0,0 +1,64 @@
+# -*- coding: utf-8 -*-
+# Generated by the protocol buffer compiler.  DO NOT EDIT!
+# NO CHECKED-IN PROTOBUF GENCODE
+# source: flood/v1/flood.proto
+# Protobuf Python Version: 5.29.0
+"""Generated protocol buffer code."""
+from google.protobuf import descriptor as _descriptor
+from google.protobuf import descriptor_pool as _descriptor_pool
+from google.protobuf import runtime_version as _runtime_version
+from google.protobuf import symbol_database as _symbol_database
+from google.protobuf.internal import builder as _builder
+_runtime_version.ValidateProtobufRuntimeVersion(
+    _runtime_version.Domain.PUBLIC,
+    5,
+    29,
+    0,
+    '',
+    'flood/v1/flood.proto'
+)
+# @@protoc_insertion_point(imports)
+
+_sym_db = _symbol_database.Default()
+
+
+from google.protobuf import timestamp_pb2 as google_dot_protobuf_dot_timestamp__pb2
+
+
+DESCRIPTOR = _descriptor_pool.Default().AddSerializedFile(b'\n\024flood/v1/flood.proto\022\010flood.v1\032\037google/protobuf/timestamp.proto\"\300\001\n\rSubmitRequest\022\017\n\007profile\030\001 \001(\t\022\016\n\006bucket\030\002 \001(\t\022\013\n\003key\030\003 \001(\t\022\014\n\004data\030\006 \001(\014\022/\n\004tags\030\007 \003(\0132!.flood.v1.SubmitRequest.TagsEntry\032+\n\tTagsEntry\022\013\n\003key\030\001 \001(\t\022\r\n\005value\030\002 \001(\t:\0028\001J\004\010\004\020\005J\004\010\005\020\006R\004pathR\003url\"\'\n\016SubmitResponse\022\025\n\rincoming_path\030\001 \001(\t\"7\n\020SubscribeRequest\022\021\n\tafter_seq\030\001 \001(\003\022\020\n\010profiles\030\002 \003(\t\"\234\002\n\005Event\022\013\n\003seq\030\001 \001(\003\022\r\n\005event\030\002 \001(\t\022\r\n\005state\030\003 \001(\t\022(\n\004time\030\004 \001(\0132\032.google.protobuf.Timestamp\022\020\n\010hostname\030\005 \001(\t\022\017\n\007file_id\030\006 \001(\t\022\017\n\007profile\030\007 \001(\t\022\016\n\006bucket\030\010 \001(\t\022\013\n\003key\030\t \001(\t\022\014\n\004path\030\n \001(\t\022\017\n\007retries\030\013 \001(\005\022\r\n\005bytes\030\014 \001(\003\022\014\n\004etag\030\r \001(\t\022\022\n\nversion_id\030\016 \001(\t\022\r\n\005error\030\017 \001(\t\022\016\n\006tenant\030\020 \001(\t\";\n\016GetFileRequest\022\016\n\004path\030\001 \001(\tH\000\022\021\n\007file_id\030\002 \001(\tH\000B\006\n\004file\"J\n\017GetFileResponse\022%\n\007records\030\001 \003(\0132\024.flood.v1.FileRecord\022\020\n\010location\030\002 \001(\t\"\365\001\n\nFileRecord\022\n\n\002id\030\001 \001(\003\022\017\n\007profile\030\002 \001(\t\022\016\n\006bucket\030\003 \001(\t\022\014\n\004path\030\004 \001(\t\022\013\n\003key\030\005 \001(\t\022\021\n\toperation\030\006 \001(\t\022\017\n\007outcome\030\007 \001(\t\022\017\n\007retries\030\010 \001(\005\022(\n\004time\030\t \001(\0132\032.google.protobuf.Timestamp\022\017\n\007file_id\030\n \001(\t\022\014\n\004etag\030\013 \001(\t\022\022\n\nversion_id\030\014 \001(\t\022\r\n\005error\030\r \001(\t\"\022\n\020GetQueuesRequest\"\200\001\n\021GetQueuesResponse\022\016\n\006paused\030\001 \001(\010\022\027\n\017paused_profiles\030\002 \003(\t\022\037\n\006queues\030\003 \003(\0132\017.flood.v1.Queue\022!\n\007uploads\030\004 \003(\0132\020.flood.v1.Upload\"I\n\005Queue\022\017\n\007profile\030\001 \001(\t\022\016\n\006bucket\030\002 \001(\t\022\017\n\007waiting\030\003 \001(\005\022\016\n\006queued\030\004 \001(\005\"\265\001\n\006Upload\022\017\n\007file_id\030\001 \001(\t\022\017\n\007profile\030\002 \001(\t\022\016\n\006bucket\030\003 \001(\t\022\013\n\003key\030\004 \001(\t\022\014\n\004size\030\005 \001(\003\022\014\n\004sent\030\006 \001(\003\022\022\n\nthroughput\030\007 \001(\003\022\017\n\007attempt\030\010 \001(\005\022+\n\007started\030\t \001(\0132\032.google.protobuf.Timestamp2\206\002\n\005Flood\022;\n\006Submit\022\027.flood.v1.SubmitRequest\032\030.flood.v1.SubmitResponse\022:\n\tSubscribe\022\032.flood.v1.SubscribeRequest\032\017.flood.v1.Event0\001\022>\n\007GetFile\022\030.flood.v1.GetFileRequest\032\031.flood.v1.GetFileResponse\022D\n\tGetQueues\022\032.flood.v1.GetQueuesRequest\032\033.flood.v1.GetQueuesResponseB$Z\"github.com/crowdwave/flood/floodpbb\006proto3')
+
+_globals = globals()
+_builder.BuildMessageAndEnumDescriptors(DESCRIPTOR, _globals)
+_builder.BuildTopDescriptorsAndMessages(DESCRIPTOR, 'flood.v1.flood_pb2', _globals)
+if not _descriptor._USE_C_DESCRIPTORS:
+  _globals['DESCRIPTOR']._loaded_options = None
+  _globals['DESCRIPTOR']._serialized_options = b'Z\"github.com/crowdwave/flood/floodpb'
+  _globals['_SUBMITREQUEST_TAGSENTRY']._loaded_options = None
+  _globals['_SUBMITREQUEST_TAGSENTRY']._serialized_options = b'8\001'
+  _globals['_SUBMITREQUEST']._serialized_start=68
+  _globals['_SUBMITREQUEST']._serialized_end=260
+  _globals['_SUBMITREQUEST_TAGSENTRY']._serialized_start=194
+  _globals['_SUBMITREQUEST_TAGSENTRY']._serialized_end=237
+  _globals['_SUBMITRESPONSE']._serialized_start=262
+  _globals['_SUBMITRESPONSE']._serialized_end=301
+  _globals['_SUBSCRIBEREQUEST']._serialized_start=303
+  _globals['_SUBSCRIBEREQUEST']._serialized_end=358
+  _globals['_EVENT']._serialized_start=361
+  _globals['_EVENT']._serialized_end=645
+  _globals['_GETFILEREQUEST']._serialized_start=647
+  _globals['_GETFILEREQUEST']._serialized_end=706
+  _globals['_GETFILERESPONSE']._serialized_start=708
+  _globals['_GETFILERESPONSE']._serialized_end=782
+  _globals['_FILERECORD']._serialized_start=785
+  _globals['_FILERECORD']._serialized_end=1030
+  _globals['_GETQUEUESREQUEST']._serialized_start=1032
+  _globals['_GETQUEUESREQUEST']._serialized_end=1050
+  _globals['_GETQUEUESRESPONSE']._serialized_start=1053
+  _globals['_GETQUEUESRESPONSE']._serialized_end=1181
+  _globals['_QUEUE']._serialized_start=1183
+  _globals['_QUEUE']._serialized_end=1256
+  _globals['_UPLOAD']._serialized_start=1259
+  _globals['_UPLOAD']._serialized_end=1440
+  _globals['_FLOOD']._serialized_start=1443
+  _globals['_FLOOD']._serialized_end=1705
+# @@protoc_insertion_point(module_scope)
@@ -0,0 +1,235 @@
+# Generated by the gRPC Python protocol compiler plugin. DO NOT EDIT!
+"""Client and server classes corresponding to protobuf-defined services."""
+import grpc
+import warnings
+
+from flood.v1 import flood_pb2 as flood_dot_v1_dot_flood__pb2
+
+GRPC_GENERATED_VERSION = '1.68.1'
+GRPC_VERSION = grpc.__version__
+_version_not_supported = False
+
+try:
+    from grpc._utilities import first_version_is_lower
+    _version_not_supported = first_version_is_lower(GRPC_VERSION, GRPC_GENERATED_VERSION)
+except ImportError:
+    _version_not_supported = True
+
+if _version_not_supported:
+    raise RuntimeError(
+        f'The grpc package installed is at version {GRPC_VERSION},'
+        + f' but the generated code in flood/v1/flood_pb2_grpc.py depends on'
+        + f' grpcio>={GRPC_GENERATED_VERSION}.'
+        + f' Please upgrade your grpc module to grpcio>={GRPC_GENERATED_VERSION}'
+        + f' or downgrade your generated code using grpcio-tools<={GRPC_VERSION}.'
+    )
+
+
+class FloodStub(object):
+    """Missing associated documentation comment in .proto file."""
+
+    def __init__(self, channel):
+        """Constructor.
+
+        Args:
+            channel: A grpc.Channel.
+        """
+        self.Submit = channel.unary_unary(
+                '/flood.v1.Flood/Submit',
+                request_serializer=flood_dot_v1_dot_flood__pb2.SubmitRequest.SerializeToString,
+                response_deserializer=flood_dot_v1_dot_flood__pb2.SubmitResponse.FromString,
+                _registered_method=True)
+        self.Subscribe = channel.unary_stream(
+                '/flood.v1.Flood/Subscribe',
+                request_serializer=flood_dot_v1_dot_flood__pb2.SubscribeRequest.SerializeToString,
+                response_deserializer=flood_dot_v1_dot_flood__pb2.Event.FromString,
+                _registered_method=True)
+        self.GetFile = channel.unary_unary(
+                '/flood.v1.Flood/GetFile',
+                request_serializer=flood_dot_v1_dot_flood__pb2.GetFileRequest.SerializeToString,
+                response_deserializer=flood_dot_v1_dot_flood__pb2.GetFileResponse.FromString,
+                _registered_method=True)
+        self.GetQueues = channel.unary_unary(
+                '/flood.v1.Flood/GetQueues',
+                request_serializer=flood_dot_v1_dot_flood__pb2.GetQueuesRequest.SerializeToString,
+                response_deserializer=flood_dot_v1_dot_flood__pb2.GetQueuesResponse.FromString,
+                _registered_method=True)
+
+
+class FloodServicer(object):
+    """Missing associated documentation comment in .proto file."""
+
+    def Submit(self, request, context):
+        """Submit places a file in the incoming directory of a profile and bucket,
+        from where it runs through the usual pipeline.
+        """
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def Subscribe(self, request, context):
+        """Subscribe streams file events as they happen. With -event-journal the
+        events after after_seq are replayed first, so a client that reconnects
+        with the seq of the last event it saw misses nothing. The stream ends
+        with RESOURCE_EXHAUSTED if the client falls too far behind.
+        """
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def GetFile(self, request, context):
+        """GetFile returns the records of a file, newest first.
+        """
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def GetQueues(self, request, context):
+        """GetQueues returns the files waiting per profile and bucket and the
+        uploads in flight.
+        """
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+
+def add_FloodServicer_to_server(servicer, server):
+    rpc_method_handlers = {
+            'Submit': grpc.unary_unary_rpc_method_handler(
+                    servicer.Submit,
+                    request_deserializer=flood_dot_v1_dot_flood__pb2.SubmitRequest.FromString,
+                    response_serializer=flood_dot_v1_dot_flood__pb2.SubmitResponse.SerializeToString,
+            ),
+            'Subscribe': grpc.unary_stream_rpc_method_handler(
+                    servicer.Subscribe,
+                    request_deserializer=flood_dot_v1_dot_flood__pb2.SubscribeRequest.FromString,
+                    response_serializer=flood_dot_v1_dot_flood__pb2.Event.SerializeToString,
+            ),
+            'GetFile': grpc.unary_unary_rpc_method_handler(
+                    servicer.GetFile,
+                    request_deserializer=flood_dot_v1_dot_flood__pb2.GetFileRequest.FromString,
+                    response_serializer=flood_dot_v1_dot_flood__pb2.GetFileResponse.SerializeToString,
+            ),
+            'GetQueues': grpc.unary_unary_rpc_method_handler(
+                    servicer.GetQueues,
+                    request_deserializer=flood_dot_v1_dot_flood__pb2.GetQueuesRequest.FromString,
+                    response_serializer=flood_dot_v1_dot_flood__pb2.GetQueuesResponse.SerializeToString,
+            ),
+    }
+    generic_handler = grpc.method_handlers_generic_handler(
+            'flood.v1.Flood', rpc_method_handlers)
+    server.add_generic_rpc_handlers((generic_handler,))
+    server.add_registered_method_handlers('flood.v1.Flood', rpc_method_handlers)
+
+
+ # This class is part of an EXPERIMENTAL API.
+class Flood(object):
+    """Missing associated documentation comment in .proto file."""
+
+    @staticmethod
+    def Submit(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_unary(
+            request,
+            target,
+            '/flood.v1.Flood/Submit',
+            flood_dot_v1_dot_flood__pb2.SubmitRequest.SerializeToString,
+            flood_dot_v1_dot_flood__pb2.SubmitResponse.FromString,
+            options,
+            channel_credentials,
+            insecure,
+            call_credentials,
+            compression,
+            wait_for_ready,
+            timeout,
+            metadata,
+            _registered_method=True)
+
+    @staticmethod
+    def Subscribe(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_stream(
+            request,
+            target,
+            '/flood.v1.Flood/Subscribe',
+            flood_dot_v1_dot_flood__pb2.SubscribeRequest.SerializeToString,
+            flood_dot_v1_dot_flood__pb2.Event.FromString,
+            options,
+            channel_credentials,
+            insecure,
+            call_credentials,
+            compression,
+            wait_for_ready,
+            timeout,
+            metadata,
+            _registered_method=True)
+
+    @staticmethod
+    def GetFile(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_unary(
+            request,
+            target,
+            '/flood.v1.Flood/GetFile',
+            flood_dot_v1_dot_flood__pb2.GetFileRequest.SerializeToString,
+            flood_dot_v1_dot_flood__pb2.GetFileResponse.FromString,
+            options,
+            channel_credentials,
+            insecure,
+            call_credentials,
+            compression,
+            wait_for_ready,
+            timeout,
+            metadata,
+            _registered_method=True)
+
+    @staticmethod
+    def GetQueues(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_unary(
+            request,
+            target,
+            '/flood.v1.Flood/GetQueues',
+            flood_dot_v1_dot_flood__pb2.GetQueuesRequest.SerializeToString,
+            flood_dot_v1_dot_flood__pb2.GetQueuesResponse.FromString,
+            options,
+            channel_credentials,
+            insecure,
+            call_credentials,
+            compression,
+            wait_for_ready,
+            timeout,
+            metadata,
+            _registered_method=True)
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Server mode listens on a Unix control socket, state/flood.sock in the
+// server directory unless -control-socket says otherwise, through which a
+// running server is managed from the same host:
+//
+//	flood ctl status                 queues, uploads in flight and failures
+//	flood ctl pause [profile]        pause the server or a single profile
+//	flood ctl resume [profile]
+//	flood ctl retry [-id] <record-id>  re-drive the failed file of a record
+//	flood ctl retry -all-failed -profile <profile>  re-drive every failed file
+//	flood ctl cancel <file-id>       abort the upload in flight with a correlation ID
+//	flood ctl cancel <profile> <path>  cancel a file of processing, see cancel.go
+//	flood ctl reload                 re-read the credentials file, as on SIGHUP
+//	flood ctl tune [name=value ...]  show or change tuning parameters
+//
+// The same commands work as floodctl <command> if flood is installed or
+// linked under that name. The socket serves the admin API (see api.go),
+// /status and /events (see sse.go) over HTTP; it needs no token, since
+// only the user the server runs as can connect to it.
+func controlSocketPath() string {
+	path := controlSocket
+	if path == "none" || !filepath.IsAbs(path) && serverDir == "" {
+		return ""
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(serverDir, path)
+	}
+	return path
+}
+
+func serveControl() {
+	path := controlSocketPath()
+	if path == "" {
+		return
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		log.Fatalf("Another flood server is listening on %s", path)
+	}
+	os.Remove(path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		log.Fatalf("Failed to create control socket directory: %v", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		log.Fatalf("Failed to listen on control socket: %v", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		log.Fatalf("Failed to restrict control socket: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("GET /events", handleEventStream)
+	addAPIRoutes(mux, false)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Fatalf("Control socket failed: %v", err)
+		}
+	}()
+}
+
+var reloadMu sync.Mutex
+
+// reloadProfiles re-reads the credentials file, on SIGHUP or the reload
+// command, e.g. after keys were rotated or a profile was added. New
+// profiles get their directories and workers; uploads in flight are not
+// interrupted; changed limits, such as workers, max_concurrency and
+// bandwidth, apply to uploads that start afterwards. Removing a profile
+// and changing its re-drive interval need a restart.
+func reloadProfiles() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	updated, err := readProfiles(credFile)
+	if err != nil {
+		return err
+	}
+	for name := range profiles {
+		if _, ok := updated[name]; !ok {
+			return fmt.Errorf("profile %s was removed; removing profiles needs a restart", name)
+		}
+	}
+
+	var added []*profileQueue
+	for name, profile := range updated {
+		if _, ok := profiles[name]; ok {
+			continue
+		}
+		for _, dir := range mainDirs {
+			if err := os.MkdirAll(filepath.Join(serverDir, dir, name), 0755); err != nil {
+				return fmt.Errorf("failed to create directories of profile %s: %w", name, err)
+			}
+		}
+		added = append(added, newProfileQueue(profile))
+	}
+
+	// Both maps are replaced, not changed, as they are read without a lock
+	profiles = updated
+	for name, q := range queues {
+		profile := updated[name]
+		q.mu.Lock()
+		q.profile = profile
+		q.mu.Unlock()
+		q.setWorkers(profile.Workers)
+		profileLimiter(profile).setLimit(profile.MaxConcurrency)
+	}
+	if len(added) > 0 {
+		grown := make(map[string]*profileQueue, len(queues)+len(added))
+		for name, q := range queues {
+			grown[name] = q
+		}
+		for _, q := range added {
+			grown[q.profile.Name] = q
+		}
+		queues = grown
+		for _, q := range added {
+			log.Printf("Added profile %s", q.profile.Name)
+			q.start()
+			if watcher != nil {
+				watchAndProcessDir(filepath.Join(serverDir, "incoming", q.profile.Name))
+			}
+			q.notify()
+		}
+	}
+	log.Printf("Reloaded %d profile(s) from %s", len(updated), credFile)
+	return nil
+}
+
+// handleReloadSignal reloads the profiles on SIGHUP.
+func handleReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			if err := reloadProfiles(); err != nil {
+				log.Printf("Reload failed, keeping the current profiles: %v", err)
+			}
+		}
+	}()
+}
+
+// runCtlMode runs a command of flood ctl against a running server.
+func runCtlMode(args []string) {
+	if len(args) == 0 {
+		ctlUsage()
+	}
+	path := controlSocketPath()
+	if path == "" {
+		log.Fatal("flood ctl needs -server or -control-socket")
+	}
+	client := &http.Client{
+		Timeout: time.Minute,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+
+	command, args := args[0], args[1:]
+	switch {
+	case command == "status" && len(args) == 0:
+		var status serverStatus
+		ctlCall(client, http.MethodGet, "/status", nil, &status)
+		printTop(status)
+	case (command == "pause" || command == "resume") && len(args) <= 1:
+		endpoint := "/api/v1/" + command
+		if len(args) == 1 {
+			endpoint = "/api/v1/profiles/" + args[0] + "/" + command
+		}
+		ctlCall(client, http.MethodPost, endpoint, nil, nil)
+		if len(args) == 1 {
+			fmt.Printf("Profile %s %sd\n", args[0], command)
+		} else {
+			fmt.Printf("Server %sd\n", command)
+		}
+	case command == "retry":
+		var result struct {
+			Retried []string `json:"retried"`
+		}
+		ctlCall(client, http.MethodPost, retryEndpoint(args), nil, &result)
+		for _, path := range result.Retried {
+			fmt.Printf("Re-driving %s\n", path)
+		}
+		if len(result.Retried) == 0 {
+			fmt.Println("No failed files to re-drive")
+		}
+	case command == "cancel" && (len(args) == 1 || len(args) == 2):
+		query := url.Values{"id": {args[0]}}
+		if len(args) == 2 {
+			query = url.Values{"profile": {args[0]}, "path": {args[1]}}
+		}
+		var result struct {
+			Path  string `json:"path"`
+			State string `json:"state"`
+		}
+		ctlCall(client, http.MethodPost, "/api/v1/files/cancel?"+query.Encode(), nil, &result)
+		if result.State == "cancelled" {
+			fmt.Printf("Moved %s to cancelled\n", result.Path)
+		} else {
+			fmt.Printf("Cancelling %s\n", result.Path)
+		}
+	case command == "tune":
+		method, changes := http.MethodGet, make(map[string]string)
+		for _, arg := range args {
+			name, value, ok := strings.Cut(arg, "=")
+			if !ok {
+				ctlUsage()
+			}
+			method, changes[name] = http.MethodPatch, value
+		}
+		var current map[string]string
+		ctlCall(client, method, "/api/v1/tuning", changes, &current)
+		names := make([]string, 0, len(current))
+		for name := range current {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s=%s\n", name, current[name])
+		}
+	case command == "reload" && len(args) == 0:
+		ctlCall(client, http.MethodPost, "/api/v1/reload", nil, nil)
+		fmt.Println("Reloaded the credentials file")
+	default:
+		ctlUsage()
+	}
+}
+
+// retryEndpoint returns the API endpoint for the arguments of flood ctl
+// retry: a record ID, -id, or -all-failed with -profile.
+func retryEndpoint(args []string) string {
+	fs := flag.NewFlagSet("ctl retry", flag.ExitOnError)
+	id := fs.Int64("id", 0, "Re-drive the failed file of this record, as listed by flood status")
+	allFailed := fs.Bool("all-failed", false, "Re-drive every failed file of -profile")
+	profile := fs.String("profile", "", "Profile of -all-failed")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood ctl retry <record-id> | -id <record-id> | -all-failed -profile <profile>")
+		fs.PrintDefaults()
+	}
+	if len(args) == 1 && !strings.HasPrefix(args[0], "-") {
+		return "/api/v1/records/" + url.PathEscape(args[0]) + "/retry"
+	}
+	fs.Parse(args)
+	switch {
+	case fs.NArg() != 0:
+	case *id != 0 && !*allFailed && *profile == "":
+		return fmt.Sprintf("/api/v1/records/%d/retry", *id)
+	case *allFailed && *id == 0 && *profile != "":
+		return "/api/v1/files/retry?" + url.Values{"profile": {*profile}}.Encode()
+	}
+	fs.Usage()
+	os.Exit(2)
+	return ""
+}
+
+func ctlUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: flood [-server dir | -control-socket path] ctl status|pause [profile]|resume [profile]|retry [-id] <record-id>|retry -all-failed -profile <profile>|cancel <file-id>|cancel <profile> <path>|reload|tune [name=value ...]")
+	os.Exit(2)
+}
+
+// ctlCall sends a request with body, if not nil, as JSON to the control
+// socket and decodes the response into result. Errors are fatal.
+func ctlCall(client *http.Client, method, endpoint string, body, result any) {
+	var reqBody bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&reqBody).Encode(body)
+	}
+	req, _ := http.NewRequest(method, "http://flood"+endpoint, &reqBody)
+	resp, err := client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			log.Fatalf("No flood server is listening on %s: %v", controlSocketPath(), err)
+		}
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var respBody bytes.Buffer
+	respBody.ReadFrom(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(respBody.Bytes(), &apiErr) == nil && apiErr.Error != "" {
+			log.Fatal(apiErr.Error)
+		}
+		log.Fatalf("Unexpected response %s", resp.Status)
+	}
+	if result != nil {
+		if err := json.Unmarshal(respBody.Bytes(), result); err != nil {
+			log.Fatalf("Invalid response: %v", err)
+		}
+	}
+}
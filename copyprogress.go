@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// copyProgress reports the progress of copy mode. On a terminal it draws a
+// bar for the current file and one for all files on stderr; at the end it
+// logs a summary of the files copied, skipped and failed. -quiet turns both
+// off; failures are logged regardless.
+type copyProgress struct {
+	tty     bool
+	started time.Time
+	drawn   time.Time
+
+	totalFiles int
+	totalBytes int64
+	doneBytes  int64 // of all files, including the current one
+
+	file        string
+	fileSize    int64
+	fileDone    int64
+	fileStarted time.Time
+
+	copied, skipped, failed int
+}
+
+// progressRedraw is how often the bars are redrawn at most.
+const progressRedraw = 100 * time.Millisecond
+
+func newCopyProgress(src string, recursive bool) *copyProgress {
+	p := &copyProgress{started: time.Now()}
+	if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		p.tty = !quiet
+	}
+	count := func(path string) {
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			p.totalFiles++
+			p.totalBytes += info.Size()
+		}
+	}
+	if !recursive {
+		count(src)
+		return p
+	}
+	filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			count(path)
+		}
+		return nil
+	})
+	return p
+}
+
+func (p *copyProgress) start(path string, size int64) {
+	p.file, p.fileSize, p.fileDone, p.fileStarted = path, size, 0, time.Now()
+	p.draw(true)
+}
+
+// add counts bytes written of the current file.
+func (p *copyProgress) add(n int64) {
+	p.fileDone += n
+	p.doneBytes += n
+	p.draw(false)
+}
+
+func (p *copyProgress) done() {
+	p.copied++
+	p.draw(true)
+}
+
+func (p *copyProgress) skip(path, reason string) {
+	p.skipped++
+	p.clear()
+	if !quiet {
+		log.Printf("Skipping %s: %s", path, reason)
+	}
+}
+
+func (p *copyProgress) fail(path string, err error) {
+	p.failed++
+	// The bytes of the failed file will not arrive
+	p.doneBytes -= p.fileDone
+	p.totalBytes -= p.fileSize
+	p.fileDone, p.fileSize = 0, 0
+	p.clear()
+	log.Printf("Failed to copy %s: %v", path, err)
+}
+
+// finish clears the bars and logs the summary.
+func (p *copyProgress) finish() {
+	p.clear()
+	if quiet {
+		return
+	}
+	elapsed := time.Since(p.started)
+	log.Printf("Copied %d file(s), %s in %s (%s/s); skipped %d, failed %d",
+		p.copied, formatBytes(p.doneBytes), elapsed.Round(time.Millisecond), formatBytes(rate(p.doneBytes, elapsed)), p.skipped, p.failed)
+}
+
+// draw redraws the bars, at most every progressRedraw unless forced.
+func (p *copyProgress) draw(force bool) {
+	if !p.tty || (!force && time.Since(p.drawn) < progressRedraw) {
+		return
+	}
+	p.drawn = time.Now()
+	fileLine := fmt.Sprintf("%s %s", progressBar(p.fileDone, p.fileSize), transferStats(p.fileDone, p.fileSize, time.Since(p.fileStarted)))
+	totalLine := fmt.Sprintf("%s %s  %d/%d file(s)", progressBar(p.doneBytes, p.totalBytes), transferStats(p.doneBytes, p.totalBytes, time.Since(p.started)), p.copied, p.totalFiles)
+	// Two lines, then back up to the first for the next redraw
+	fmt.Fprintf(os.Stderr, "\r\x1b[K%s  %s\n\x1b[K%s\x1b[1A\r", fileLine, filepath.Base(p.file), totalLine)
+}
+
+// clear removes the bars, so log lines do not mix with them.
+func (p *copyProgress) clear() {
+	if p.tty {
+		fmt.Fprint(os.Stderr, "\r\x1b[K\n\x1b[K\x1b[1A\r")
+	}
+}
+
+func progressBar(done, total int64) string {
+	const width = 30
+	filled := width
+	if total > 0 {
+		filled = int(min(done, total) * width / total)
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// transferStats formats progress, rate and the estimated time left.
+func transferStats(done, total int64, elapsed time.Duration) string {
+	r := rate(done, elapsed)
+	eta := "--"
+	if r > 0 && total >= done {
+		eta = time.Duration(float64(total-done) / float64(r) * float64(time.Second)).Round(time.Second).String()
+	}
+	return fmt.Sprintf("%s/%s %s/s ETA %s", formatBytes(done), formatBytes(total), formatBytes(r), eta)
+}
+
+func rate(bytes int64, elapsed time.Duration) int64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(bytes) / elapsed.Seconds())
+}
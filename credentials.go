@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// requiredKeys lists the settings each provider must supply in its profile.
+// An amazon profile without keys uses the default credential chain of the
+// SDK instead: environment, shared config, web identity token (IRSA), ECS
+// task role or EC2 instance profile.
+var requiredKeys = map[string][]string{
+	"amazon":           {"aws_region"},
+	"cloudflare":       {"aws_access_key_id", "aws_secret_access_key", "aws_region", "aws_endpoint"},
+	"backblaze":        {"aws_access_key_id", "aws_secret_access_key", "aws_region", "aws_endpoint"},
+	"wasabi":           {"aws_access_key_id", "aws_secret_access_key", "aws_region"},
+	"digitalocean":     {"aws_access_key_id", "aws_secret_access_key", "aws_region"},
+	"sftp":             {"sftp_host", "sftp_user"},
+	"http":             {"http_url"},
+	"local":            {"local_path"},
+	"backblaze-native": {"b2_key_id", "b2_application_key"},
+	"fanout":           {"destinations"},
+}
+
+// endpointPatterns gives the endpoint of providers whose endpoint follows
+// from the region. An explicit aws_endpoint still takes precedence.
+var endpointPatterns = map[string]string{
+	"wasabi":       "https://s3.%s.wasabisys.com",
+	"digitalocean": "https://%s.digitaloceanspaces.com",
+}
+
+// supportedSSE lists the server-side encryption modes each provider accepts.
+// Cloudflare R2 always encrypts at rest and rejects the SSE headers.
+var supportedSSE = map[string][]string{
+	"amazon":    {"AES256", "aws:kms"},
+	"backblaze": {"AES256"},
+}
+
+// supportedACLs lists the canned ACLs that can be applied to uploads.
+var supportedACLs = []types.ObjectCannedACL{
+	types.ObjectCannedACLPrivate,
+	types.ObjectCannedACLPublicRead,
+	types.ObjectCannedACLBucketOwnerFullControl,
+}
+
+// conditionalWriteProviders lists the providers that honour If-None-Match on
+// PutObject and CompleteMultipartUpload.
+var conditionalWriteProviders = []string{"amazon", "cloudflare"}
+
+// parseCredentialsFile reads an AWS style credentials file and returns the
+// settings of every profile keyed by profile name. Keys are lower-cased so
+// that AWS_REGION and aws_region are treated the same.
+func parseCredentialsFile(path string) (map[string]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sections := make(map[string]map[string]string)
+	var current map[string]string
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			name = strings.TrimSpace(strings.TrimPrefix(name, "profile "))
+			if name == "" {
+				return nil, fmt.Errorf("line %d: empty profile name", lineNo)
+			}
+			current = make(map[string]string)
+			sections[name] = current
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		if current == nil {
+			return nil, fmt.Errorf("line %d: setting outside of a profile", lineNo)
+		}
+		current[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return sections, nil
+}
+
+// newProfile validates the settings of a single profile and builds the
+// Profile used by the rest of the program.
+func newProfile(name string, settings map[string]string) (Profile, error) {
+	provider := settings["provider"]
+	if provider == "" {
+		return Profile{}, fmt.Errorf("missing provider")
+	}
+	required, ok := requiredKeys[provider]
+	if !ok {
+		return Profile{}, fmt.Errorf("unknown provider %q", provider)
+	}
+
+	var missing []string
+	for _, key := range required {
+		if settings["source_profile"] != "" && (key == "aws_access_key_id" || key == "aws_secret_access_key") {
+			// The keys of the source profile assume the role
+			continue
+		}
+		if settings[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return Profile{}, fmt.Errorf("missing required key(s) for provider %s: %s", provider, strings.Join(missing, ", "))
+	}
+	if (settings["aws_access_key_id"] == "") != (settings["aws_secret_access_key"] == "") {
+		return Profile{}, fmt.Errorf("aws_access_key_id and aws_secret_access_key must be given together")
+	}
+
+	profile := Profile{
+		Name:            name,
+		Provider:        provider,
+		AccessKeyID:     settings["aws_access_key_id"],
+		SecretAccessKey: settings["aws_secret_access_key"],
+		Endpoint:        settings["aws_endpoint"],
+		Region:          settings["aws_region"],
+		PartSize:        partSize,
+		PartConcurrency: partConcurrency,
+		Workers:         workers,
+		SkipExisting:    skipExisting,
+		Order:           uploadOrder,
+	}
+
+	if newDestination, ok := destinationProviders[provider]; ok {
+		unsupported := s3OnlySettings
+		if !slices.Contains(partProviders, provider) {
+			unsupported = append(slices.Clone(unsupported), partSettings...)
+		}
+		for _, key := range unsupported {
+			if settings[key] != "" {
+				return Profile{}, fmt.Errorf("%s is not supported by provider %s", key, provider)
+			}
+		}
+		dest, err := newDestination(name, settings)
+		if err != nil {
+			return Profile{}, err
+		}
+		profile.Destination = dest
+	}
+
+	if pattern, ok := endpointPatterns[provider]; ok && profile.Endpoint == "" {
+		profile.Endpoint = fmt.Sprintf(pattern, profile.Region)
+	}
+
+	if value := settings["part_size"]; value != "" {
+		size, err := parseByteSize(value)
+		if err != nil {
+			return Profile{}, fmt.Errorf("invalid part_size: %w", err)
+		}
+		if err := validatePartSize(size); err != nil {
+			return Profile{}, err
+		}
+		profile.PartSize = size
+	}
+
+	if value := settings["part_concurrency"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return Profile{}, fmt.Errorf("invalid part_concurrency %q: must be a positive integer", value)
+		}
+		profile.PartConcurrency = n
+	}
+
+	if value := settings["workers"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return Profile{}, fmt.Errorf("invalid workers %q: must be a positive integer", value)
+		}
+		profile.Workers = n
+	}
+
+	if value := settings["max_concurrency"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return Profile{}, fmt.Errorf("invalid max_concurrency %q: must be a positive integer", value)
+		}
+		profile.MaxConcurrency = n
+	}
+
+	if value := settings["bandwidth_limit"]; value != "" {
+		rate, err := parseBandwidth(value)
+		if err != nil {
+			return Profile{}, fmt.Errorf("invalid bandwidth_limit: %w", err)
+		}
+		profile.Bandwidth = newRateLimiter(rate)
+	}
+
+	if value := settings["tags"]; value != "" {
+		tags, err := url.ParseQuery(value)
+		if err != nil {
+			return Profile{}, fmt.Errorf("invalid tags %q: expected key1=value1&key2=value2", value)
+		}
+		profile.Tags = make(map[string]string)
+		for k := range tags {
+			profile.Tags[k] = tags.Get(k)
+		}
+		if err := validateTags(profile.Tags); err != nil {
+			return Profile{}, fmt.Errorf("invalid tags: %w", err)
+		}
+	}
+
+	if value := settings["sse"]; value != "" {
+		if !slices.Contains(supportedSSE[provider], value) {
+			return Profile{}, fmt.Errorf("sse = %s is not supported by provider %s", value, provider)
+		}
+		profile.SSE = types.ServerSideEncryption(value)
+	}
+	if value := settings["kms_key_id"]; value != "" {
+		if profile.SSE != types.ServerSideEncryptionAwsKms {
+			return Profile{}, fmt.Errorf("kms_key_id requires sse = aws:kms")
+		}
+		profile.KMSKeyID = value
+	}
+
+	if value := settings["acl"]; value != "" {
+		acl := types.ObjectCannedACL(value)
+		if !slices.Contains(supportedACLs, acl) {
+			return Profile{}, fmt.Errorf("invalid acl %q: must be private, public-read or bucket-owner-full-control", value)
+		}
+		profile.ACL = acl
+	}
+
+	if value := settings["key_prefix"]; value != "" {
+		tmpl, err := parseKeyPrefix(value)
+		if err != nil {
+			return Profile{}, fmt.Errorf("invalid key_prefix: %w", err)
+		}
+		profile.KeyPrefix = tmpl
+	}
+
+	if value := settings["bundle_size"]; value != "" {
+		size, err := parseByteSize(value)
+		if err != nil || size == 0 {
+			return Profile{}, fmt.Errorf("invalid bundle_size %q", value)
+		}
+		profile.BundleSize = size
+		profile.BundleFormat = "tar"
+		profile.BundleMaxWait = defaultBundleMaxWait
+	}
+	if value := settings["bundle_format"]; value != "" {
+		if _, ok := bundleFormats[value]; !ok {
+			return Profile{}, fmt.Errorf("invalid bundle_format %q: must be tar or tar.zst", value)
+		}
+		profile.BundleFormat = value
+	}
+	if value := settings["bundle_max_wait"]; value != "" {
+		wait, err := time.ParseDuration(value)
+		if err != nil || wait <= 0 {
+			return Profile{}, fmt.Errorf("invalid bundle_max_wait %q", value)
+		}
+		profile.BundleMaxWait = wait
+	}
+
+	if value := settings["skip_existing"]; value != "" {
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			return Profile{}, fmt.Errorf("invalid skip_existing %q: must be true or false", value)
+		}
+		profile.SkipExisting = skip
+	}
+
+	if value := settings["if_none_match"]; value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return Profile{}, fmt.Errorf("invalid if_none_match %q: must be true or false", value)
+		}
+		if enabled && !slices.Contains(conditionalWriteProviders, provider) {
+			return Profile{}, fmt.Errorf("if_none_match is not supported by provider %s", provider)
+		}
+		profile.IfNoneMatch = enabled
+	}
+
+	if value := settings["object_lock_mode"]; value != "" {
+		mode := types.ObjectLockMode(strings.ToUpper(value))
+		if mode != types.ObjectLockModeGovernance && mode != types.ObjectLockModeCompliance {
+			return Profile{}, fmt.Errorf("invalid object_lock_mode %q: must be GOVERNANCE or COMPLIANCE", value)
+		}
+		retention, err := parseDuration(settings["object_lock_retention"])
+		if err != nil || retention <= 0 {
+			return Profile{}, fmt.Errorf("object_lock_mode requires a positive object_lock_retention such as 30d")
+		}
+		profile.ObjectLockMode = mode
+		profile.ObjectLockFor = retention
+	} else if settings["object_lock_retention"] != "" {
+		return Profile{}, fmt.Errorf("object_lock_retention requires object_lock_mode")
+	}
+
+	if value := settings["order"]; value != "" {
+		if !slices.Contains(uploadOrders, value) {
+			return Profile{}, fmt.Errorf("invalid order %q: must be one of %s", value, strings.Join(uploadOrders, ", "))
+		}
+		profile.Order = value
+	}
+
+	if value := settings["checksum_algorithm"]; value != "" {
+		algorithm := types.ChecksumAlgorithm(strings.ToUpper(value))
+		if !slices.Contains(supportedChecksums[provider], algorithm) {
+			return Profile{}, fmt.Errorf("checksum_algorithm = %s is not supported by provider %s", value, provider)
+		}
+		profile.ChecksumAlgorithm = algorithm
+	}
+
+	if value := settings["addressing_style"]; value != "" {
+		if value != "path" && value != "virtual" {
+			return Profile{}, fmt.Errorf("invalid addressing_style %q: must be path or virtual", value)
+		}
+		profile.AddressingStyle = value
+	}
+
+	if value := settings["storage_class"]; value != "" {
+		class, err := parseStorageClass(provider, value)
+		if err != nil {
+			return Profile{}, err
+		}
+		profile.StorageClass = class
+	}
+
+	if value := settings["failover_profile"]; value != "" {
+		if value == name {
+			return Profile{}, fmt.Errorf("failover_profile must not be the profile itself")
+		}
+		profile.FailoverProfile = value
+	}
+
+	if value := settings["failed_retry_interval"]; value != "" {
+		interval, err := parseDuration(value)
+		if err != nil || interval <= 0 {
+			return Profile{}, fmt.Errorf("invalid failed_retry_interval %q", value)
+		}
+		profile.RedriveInterval = interval
+		profile.RedriveMax = defaultRedriveMax
+	}
+	if value := settings["failed_retry_max"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return Profile{}, fmt.Errorf("invalid failed_retry_max %q: must be a positive integer", value)
+		}
+		if profile.RedriveInterval == 0 {
+			return Profile{}, fmt.Errorf("failed_retry_max requires failed_retry_interval")
+		}
+		profile.RedriveMax = n
+	}
+
+	if value := settings["upload_window"]; value != "" {
+		windows, err := parseUploadWindows(value)
+		if err != nil {
+			return Profile{}, err
+		}
+		profile.Windows = windows
+	}
+
+	if err := parseRole(&profile, settings); err != nil {
+		return Profile{}, err
+	}
+
+	quotaBytes, quotaObjects, err := parseQuotas(settings)
+	if err != nil {
+		return Profile{}, err
+	}
+	profile.QuotaBytes, profile.QuotaObjects = quotaBytes, quotaObjects
+
+	if value := settings["propagate_deletes"]; value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return Profile{}, fmt.Errorf("invalid propagate_deletes %q: must be true or false", value)
+		}
+		profile.PropagateDeletes = enabled
+	}
+
+	hook, err := parseWebhook(settings)
+	if err != nil {
+		return Profile{}, err
+	}
+	profile.Webhook = hook
+
+	rules, err := parseKeyRules(settings)
+	if err != nil {
+		return Profile{}, err
+	}
+	profile.KeyRules = rules
+
+	return profile, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// tombstoneSuffix marks a request to delete a remote object. With
+//
+//	propagate_deletes = true
+//
+// in a profile, placing "report.csv.delete" in a bucket directory of
+// incoming deletes the object that "report.csv" was uploaded as. The key is
+// taken from the last successful upload of that path, or derived like the
+// key of a new upload if there is none. Deletions only happen with
+// -confirm-deletes; without it they are logged and recorded as
+// "unconfirmed". Every deletion is recorded in the deletions table and the
+// tombstone is moved to completed or failed.
+const tombstoneSuffix = ".delete"
+
+// remover is implemented by destinations that can delete objects.
+type remover interface {
+	remove(bucket, key string) error
+}
+
+func isTombstone(profile Profile, path string) bool {
+	return profile.PropagateDeletes && strings.HasSuffix(path, tombstoneSuffix)
+}
+
+func (d s3Destination) remove(bucket, key string) error {
+	_, err := newS3Client(d.profile).DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (d *localDestination) remove(bucket, key string) error {
+	err := os.Remove(d.target(bucket, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// processTombstone deletes the object a tombstone in the processing
+// directory refers to and returns the outcome.
+func processTombstone(path string, profile Profile, bucketName string) string {
+	profile = profile.forBucket(bucketName)
+	relativePath, err := filepath.Rel(filepath.Join(serverDir, "processing", profile.Name, bucketName), path)
+	if err != nil {
+		log.Printf("Invalid path for deletion: %s", path)
+		return "failure"
+	}
+	originalPath := strings.TrimSuffix(filepath.ToSlash(relativePath), tombstoneSuffix)
+
+	key, err := uploadedKey(profile.Name, bucketName, originalPath)
+	if err == nil && key == "" {
+		key, err = objectKey(profile, bucketName, filepath.FromSlash(originalPath))
+	}
+	if err != nil {
+		log.Printf("Error: %v", err)
+		moveToFailed(path)
+		recordDeletion(profile, bucketName, originalPath, "", 0, "failure")
+		return "failure"
+	}
+
+	if !confirmDeletes {
+		log.Printf("Not deleting %s/%s/%s for %s without -confirm-deletes", profile.Name, bucketName, key, path)
+		moveToFailed(path)
+		recordDeletion(profile, bucketName, originalPath, key, 0, "unconfirmed")
+		return "unconfirmed"
+	}
+
+	dest := destinationFor(profile)
+	r, ok := dest.(remover)
+	if !ok {
+		log.Printf("Cannot delete %s: not supported by provider %s", key, profile.Provider)
+		moveToFailed(path)
+		recordDeletion(profile, bucketName, originalPath, key, 0, "failure")
+		return "failure"
+	}
+
+	for retries := 0; ; retries++ {
+		release := acquireUploadSlot(profile)
+		err = r.remove(bucketName, key)
+		release()
+		if err == nil {
+			log.Printf("Deleted %s/%s/%s", profile.Name, bucketName, key)
+			moveToCompleted(path)
+			recordDeletion(profile, bucketName, originalPath, key, retries, "success")
+			return "success"
+		}
+		log.Printf("Error deleting %s: %v", key, err)
+		if !dest.transient(err) || retries >= retryLimit() {
+			moveToFailed(path)
+			recordDeletion(profile, bucketName, originalPath, key, retries, "failure")
+			return "failure"
+		}
+		time.Sleep(retryDelay(retries))
+	}
+}
+
+// uploadedKey returns the key of the last successful upload of a path, or
+// "" if there is none.
+func uploadedKey(profileName, bucket, originalPath string) (string, error) {
+	var key sql.NullString
+	err := db.QueryRow(`SELECT object_key FROM file_records
+		WHERE profile = ? AND bucket = ? AND original_path = ? AND upload_outcome IN ('success', 'already_present')
+		ORDER BY id DESC LIMIT 1`, profileName, bucket, originalPath).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up key of %s: %w", originalPath, err)
+	}
+	return key.String, nil
+}
+
+func recordDeletion(profile Profile, bucket, originalPath, key string, retries int, outcome string) {
+	_, err := db.Exec("INSERT INTO deletions(profile, bucket, original_path, object_key, retries, outcome, created) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		profile.Name, bucket, originalPath, key, retries, outcome, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
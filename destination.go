@@ -0,0 +1,77 @@
+package main
+
+// destination delivers files to a storage service. S3 compatible providers
+// are handled by s3Destination; other providers set Profile.Destination.
+type destination interface {
+	// validate checks that the bucket, or what the provider uses instead,
+	// exists and accepts uploads.
+	validate(bucket string) error
+	// upload delivers rec.Path as rec.Key.
+	upload(rec fileRecord) (uploadResult, error)
+	// matches reports whether key already holds the content of the local
+	// file. It returns errNotImplemented if that cannot be determined.
+	matches(bucket, key, path string) (bool, error)
+	// transient reports whether a failed upload may succeed on retry.
+	transient(err error) bool
+}
+
+// destinationProviders builds the destination of providers that do not speak
+// the S3 API from the settings of their profile.
+var destinationProviders = map[string]func(name string, settings map[string]string) (destination, error){
+	"sftp":             newSFTPDestination,
+	"http":             newHTTPDestination,
+	"local":            newLocalDestination,
+	"backblaze-native": newB2Destination,
+	"fanout":           newFanoutDestination,
+}
+
+// s3OnlySettings are profile settings that only apply to S3 compatible
+// providers.
+var s3OnlySettings = []string{
+	"tags", "sse", "kms_key_id", "acl", "storage_class", "addressing_style",
+	"if_none_match", "object_lock_mode", "object_lock_retention", "checksum_algorithm",
+	"role_arn", "source_profile", "external_id", "role_session_name", "duration_seconds",
+}
+
+// partSettings control uploads in parts, which besides S3 compatible
+// providers only partProviders do.
+var (
+	partSettings  = []string{"part_size", "part_concurrency"}
+	partProviders = []string{"backblaze-native"}
+)
+
+// uploadResult describes the object an upload created, as far as the
+// provider reports it.
+type uploadResult struct {
+	Checksum  string // value of the profile's checksum algorithm, if any
+	ETag      string
+	VersionID string // if the bucket keeps versions
+	Key       string // if the object went under another key than requested
+}
+
+func destinationFor(profile Profile) destination {
+	if profile.Destination != nil {
+		return profile.Destination
+	}
+	return s3Destination{profile: profile}
+}
+
+type s3Destination struct {
+	profile Profile
+}
+
+func (d s3Destination) validate(bucket string) error {
+	return validateBucketExists(d.profile, bucket)
+}
+
+func (d s3Destination) upload(rec fileRecord) (uploadResult, error) {
+	return uploadToS3(rec.Path, rec.Bucket, rec.Key, rec.Profile, rec.Meta)
+}
+
+func (d s3Destination) matches(bucket, key, path string) (bool, error) {
+	return remoteMatches(d.profile, bucket, key, path)
+}
+
+func (d s3Destination) transient(err error) bool {
+	return isTransientError(err)
+}
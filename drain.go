@@ -0,0 +1,184 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// With -drain-timeout, SIGTERM and SIGINT shut server mode down cleanly: no
+// new files are taken in and no new uploads start, uploads in flight get up
+// to the timeout to finish, and the files left in processing are returned to
+// incoming, where the next run picks them up. Uploads still running at the
+// timeout are cancelled and recorded with the outcome "interrupted"; their
+// multipart progress is kept and resumed on the next run. The files of
+// uploads that do not stop within drainCancelGrace stay in processing.
+var drainState struct {
+	mu          sync.Mutex
+	draining    bool
+	interrupted bool                 // the timeout passed and uploads are cancelled
+	active      map[string]uploadJob // keyed by the first path of the job
+	wg          sync.WaitGroup
+}
+
+// drainCancelGrace is how long cancelled uploads get to return.
+const drainCancelGrace = 30 * time.Second
+
+// handleShutdownSignals drains and exits on SIGTERM or SIGINT if a drain
+// timeout is set. The exit status is 0 if every upload in flight finished
+// and 1 otherwise.
+func handleShutdownSignals() {
+	if drainTimeout <= 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %s, draining for up to %s", sig, drainTimeout)
+		if drain() {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}()
+}
+
+func isDraining() bool {
+	drainState.mu.Lock()
+	defer drainState.mu.Unlock()
+	return drainState.draining
+}
+
+// drainInterrupted reports whether the uploads in flight were cancelled by
+// the drain timeout rather than by a client.
+func drainInterrupted() bool {
+	drainState.mu.Lock()
+	defer drainState.mu.Unlock()
+	return drainState.interrupted
+}
+
+// startJob registers a job as in flight. It returns false once draining has
+// begun, in which case the job must not be started.
+func startJob(job uploadJob) bool {
+	drainState.mu.Lock()
+	defer drainState.mu.Unlock()
+	if drainState.draining {
+		return false
+	}
+	if drainState.active == nil {
+		drainState.active = make(map[string]uploadJob)
+	}
+	drainState.active[job.paths()[0]] = job
+	drainState.wg.Add(1)
+	return true
+}
+
+func finishJob(job uploadJob) {
+	drainState.mu.Lock()
+	delete(drainState.active, job.paths()[0])
+	drainState.mu.Unlock()
+	drainState.wg.Done()
+}
+
+// drain stops intake, waits for the uploads in flight and returns the files
+// in processing to incoming. It reports whether all uploads finished in
+// time.
+func drain() bool {
+	drainState.mu.Lock()
+	drainState.draining = true
+	drainState.mu.Unlock()
+	if watcher != nil {
+		watcher.Close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		drainState.wg.Wait()
+		close(done)
+	}()
+	finished := true
+	select {
+	case <-done:
+		log.Printf("All uploads in flight finished")
+	case <-time.After(drainTimeout):
+		finished = false
+		drainState.mu.Lock()
+		drainState.interrupted = true
+		for _, job := range drainState.active {
+			log.Printf("Interrupting upload of %s", job.paths()[0])
+		}
+		drainState.mu.Unlock()
+		inflight.mu.Lock()
+		for _, u := range inflight.uploads {
+			u.cancelUpload()
+		}
+		inflight.mu.Unlock()
+
+		select {
+		case <-done:
+		case <-time.After(drainCancelGrace):
+			// The workers may still move the files and write records.
+			drainState.mu.Lock()
+			keep := make(map[string]bool)
+			for _, job := range drainState.active {
+				for _, path := range job.paths() {
+					keep[path] = true
+				}
+			}
+			drainState.mu.Unlock()
+			log.Printf("%d upload(s) did not stop; leaving their files in processing", len(keep))
+			returnToIncoming(keep)
+			return false
+		}
+	}
+
+	returnToIncoming(nil)
+	if err := db.Close(); err != nil {
+		log.Printf("Failed to close database: %v", err)
+	}
+	return finished
+}
+
+// processingLocation finds the profile, bucket and path below the bucket
+// directory of a file in the processing directory.
+func processingLocation(path string) (Profile, string, string, bool) {
+	rel, err := filepath.Rel(filepath.Join(serverDir, "processing"), path)
+	if err != nil {
+		return Profile{}, "", "", false
+	}
+	name, rest, _ := strings.Cut(rel, string(os.PathSeparator))
+	profile, ok := profiles[name]
+	if !ok {
+		return Profile{}, "", "", false
+	}
+	bucket, relativePath, ok := strings.Cut(rest, string(os.PathSeparator))
+	return profile, bucket, relativePath, ok
+}
+
+// returnToIncoming moves everything left in processing back to incoming,
+// except the files to keep and those another instance is uploading.
+func returnToIncoming(keep map[string]bool) {
+	processingRoot := filepath.Join(serverDir, "processing")
+	var moved int
+	filepath.Walk(processingRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || isSidecar(path) || keep[path] || leasedElsewhere(path) {
+			return nil
+		}
+		rel, _ := filepath.Rel(processingRoot, path)
+		incomingPath := filepath.Join(serverDir, "incoming", rel)
+		if err := moveFile(path, incomingPath); err != nil {
+			log.Printf("Failed to return %s to incoming: %v", path, err)
+			return nil
+		}
+		moved++
+		return nil
+	})
+	if moved > 0 {
+		log.Printf("Returned %d file(s) from processing to incoming", moved)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runDryRun plans what server or copy mode would do without copying, moving
+// or uploading anything. Profiles and buckets are validated with read-only
+// requests, and every planned upload is printed, logged and recorded in
+// file_records with the outcome "dry_run".
+func runDryRun() {
+	p := &dryRunPlan{validated: make(map[string]error)}
+	if serverDir != "" {
+		p.planServer()
+	} else if sourceFile != "" && destURI != "" {
+		p.planCopy()
+	} else {
+		log.Fatal("Invalid mode. Specify either server directory or source file and destination URI.")
+	}
+	log.Printf("Dry run: %d file(s) would be uploaded, %d skipped, %d would fail; nothing was copied, moved or uploaded", p.uploads, p.skipped, p.failures)
+}
+
+type dryRunPlan struct {
+	validated                  map[string]error // profile/bucket -> validation result
+	uploads, skipped, failures int
+}
+
+func (p *dryRunPlan) planServer() {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		profile := profiles[name]
+		for _, stage := range []string{"processing", "incoming"} {
+			root := filepath.Join(serverDir, stage, name)
+			filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+				if err != nil || info.IsDir() || isSidecar(path) || filepath.Base(path) == priorityFile {
+					return nil
+				}
+				relativePath, _ := filepath.Rel(root, path)
+				bucket, rest, ok := strings.Cut(relativePath, string(os.PathSeparator))
+				if !ok {
+					p.report(fmt.Sprintf("ignore %s: not in a bucket directory", path))
+					return nil
+				}
+				action := "upload"
+				if stage == "incoming" {
+					action = "move to processing and upload"
+				}
+				p.planFile(path, profile, bucket, rest, action)
+				return nil
+			})
+		}
+	}
+}
+
+func (p *dryRunPlan) planCopy() {
+	parts := strings.SplitN(strings.TrimPrefix(destURI, "s3://"), "/", 3)
+	if len(parts) < 3 {
+		log.Fatal("Invalid S3 URI")
+	}
+	profileName, bucketName, objectKey := parts[0], parts[1], parts[2]
+	profile, ok := profiles[profileName]
+	if !ok {
+		log.Fatalf("Unknown profile: %s", profileName)
+	}
+
+	if recursiveFlag && isDirectory(sourceFile) {
+		filepath.Walk(sourceFile, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			relPath, _ := filepath.Rel(sourceFile, path)
+			p.planFile(path, profile, bucketName, filepath.Join(objectKey, relPath), "copy and upload")
+			return nil
+		})
+		return
+	}
+	p.planFile(sourceFile, profile, bucketName, objectKey, "copy and upload")
+}
+
+// planFile reports what would happen to a single file.
+func (p *dryRunPlan) planFile(path string, profile Profile, bucket, relativePath, action string) {
+	profile = profile.forBucket(bucket)
+	if isTombstone(profile, path) {
+		p.report(fmt.Sprintf("delete the remote object of %s", strings.TrimSuffix(path, tombstoneSuffix)))
+		return
+	}
+	rec := fileRecord{Path: path, Profile: profile, Bucket: bucket, OriginalPath: filepath.ToSlash(relativePath)}
+	defer func() { logRetry(rec, "dry_run") }()
+
+	target := profile.Name + "/" + bucket
+	err, ok := p.validated[target]
+	if !ok {
+		err = destinationFor(profile).validate(bucket)
+		p.validated[target] = err
+	}
+	if err != nil {
+		p.failures++
+		p.report(fmt.Sprintf("fail %s: %v", path, err))
+		return
+	}
+
+	rec.Meta, err = loadSidecar(path)
+	if err == nil {
+		rec.Key, err = objectKey(profile, bucket, relativePath)
+	}
+	if err != nil {
+		p.failures++
+		p.report(fmt.Sprintf("fail %s: %v", path, err))
+		return
+	}
+
+	if profile.SkipExisting {
+		if present, err := alreadyPresent(profile, bucket, rec.Key, path); err == nil && present {
+			p.skipped++
+			p.report(fmt.Sprintf("skip %s: already present as %s", path, rec.Key))
+			return
+		}
+	}
+	if profile.BundleSize > 0 {
+		if info, err := os.Stat(path); err == nil && info.Size() < profile.BundleSize {
+			action += " in a bundle"
+		}
+	}
+	p.uploads++
+	p.report(fmt.Sprintf("%s %s -> %s/%s/%s", action, path, profile.Name, bucket, rec.Key))
+}
+
+func (p *dryRunPlan) report(line string) {
+	fmt.Println(line)
+	log.Printf("Dry run: %s", line)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// With -alert-email, a digest is mailed every -email-interval if files were
+// moved to the failed directory since the last one, or if a profile with
+// files queued for upload had no successful upload for -alert-no-success.
+// A stalled profile is reported once until it uploads again. The mail goes
+// through -smtp-addr; port 465 uses TLS from the start, other ports
+// STARTTLS when the server offers it. With -smtp-user the password is read
+// from -smtp-password-file or FLOOD_SMTP_PASSWORD.
+const emailMaxListed = 50
+
+var emailDigest struct {
+	mu          sync.Mutex
+	failed      []string
+	lastSuccess map[string]time.Time // keyed by profile
+	reported    map[string]bool      // stalled profiles already reported
+}
+
+// recordEmailOutcome notes files that failed and profiles that uploaded.
+func recordEmailOutcome(rec fileRecord, outcome string) {
+	if alertEmail == "" {
+		return
+	}
+	emailDigest.mu.Lock()
+	defer emailDigest.mu.Unlock()
+	switch outcome {
+	case "success", "already_present":
+		emailDigest.lastSuccess[rec.Profile.Name] = time.Now()
+		delete(emailDigest.reported, rec.Profile.Name)
+	case "failure", "conflict":
+		line := fmt.Sprintf("%s/%s/%s (%s after %d retries)", rec.Profile.Name, rec.Bucket, rec.Key, outcome, rec.Retries)
+		if n := len(rec.Attempts); n > 0 {
+			line += ": " + rec.Attempts[n-1].Message
+		}
+		emailDigest.failed = append(emailDigest.failed, line)
+	}
+}
+
+func init() {
+	emailDigest.lastSuccess = make(map[string]time.Time)
+	emailDigest.reported = make(map[string]bool)
+}
+
+func runEmailDigests() {
+	started := time.Now()
+	for range time.Tick(emailInterval) {
+		emailDigest.mu.Lock()
+		failed := emailDigest.failed
+		emailDigest.failed = nil
+		var stalled []string
+		for name, q := range queues {
+			q.mu.Lock()
+			queued := len(q.pending)
+			q.mu.Unlock()
+			last, ok := emailDigest.lastSuccess[name]
+			if !ok {
+				last = started
+			}
+			if queued > 0 && time.Since(last) >= alertNoSuccess && !emailDigest.reported[name] {
+				stalled = append(stalled, fmt.Sprintf("%s: %d file(s) queued, no successful upload since %s", name, queued, last.Format(time.RFC3339)))
+				emailDigest.reported[name] = true
+			}
+		}
+		emailDigest.mu.Unlock()
+
+		if len(failed) == 0 && len(stalled) == 0 {
+			continue
+		}
+		sort.Strings(stalled)
+		if err := sendEmail(emailSubject(failed, stalled), emailBody(failed, stalled)); err != nil {
+			log.Printf("Failed to send alert email: %v", err)
+		}
+	}
+}
+
+func emailSubject(failed, stalled []string) string {
+	var parts []string
+	if len(failed) > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed file(s)", len(failed)))
+	}
+	if len(stalled) > 0 {
+		parts = append(parts, fmt.Sprintf("%d stalled profile(s)", len(stalled)))
+	}
+	return fmt.Sprintf("[flood] %s on %s", strings.Join(parts, ", "), hostname)
+}
+
+func emailBody(failed, stalled []string) string {
+	var b strings.Builder
+	if len(stalled) > 0 {
+		b.WriteString("Profiles without successful uploads despite queued files:\r\n\r\n")
+		for _, line := range stalled {
+			fmt.Fprintf(&b, "  %s\r\n", line)
+		}
+		b.WriteString("\r\n")
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(&b, "Files moved to the failed directory in the last %s:\r\n\r\n", emailInterval)
+		for _, line := range failed[:min(len(failed), emailMaxListed)] {
+			fmt.Fprintf(&b, "  %s\r\n", line)
+		}
+		if len(failed) > emailMaxListed {
+			fmt.Fprintf(&b, "  ... and %d more\r\n", len(failed)-emailMaxListed)
+		}
+	}
+	return b.String()
+}
+
+func sendEmail(subject, body string) error {
+	recipients := strings.Split(alertEmail, ",")
+	for i := range recipients {
+		recipients[i] = strings.TrimSpace(recipients[i])
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		smtpFrom, strings.Join(recipients, ", "), subject, time.Now().Format(time.RFC1123Z), body)
+
+	host, port, err := net.SplitHostPort(smtpAddr)
+	if err != nil {
+		return fmt.Errorf("invalid -smtp-addr %q: %w", smtpAddr, err)
+	}
+	var auth smtp.Auth
+	if smtpUser != "" {
+		password, err := smtpPassword()
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", smtpUser, password, host)
+	}
+	if port != "465" {
+		return smtp.SendMail(smtpAddr, auth, smtpFrom, recipients, []byte(msg))
+	}
+
+	conn, err := tls.Dial("tcp", smtpAddr, &tls.Config{ServerName: host})
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if auth != nil {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(smtpFrom); err != nil {
+		return err
+	}
+	for _, to := range recipients {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func smtpPassword() (string, error) {
+	if smtpPasswordFile == "" {
+		return os.Getenv("FLOOD_SMTP_PASSWORD"), nil
+	}
+	data, err := os.ReadFile(smtpPasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read -smtp-password-file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Every flag can also be set through the environment, which suits
+// containers better than templated command lines: -workers is
+// FLOOD_WORKERS, -log-level FLOOD_LOG_LEVEL and so on, with dashes as
+// underscores. A few settings have names of their own that read better:
+//
+//	FLOOD_SERVER_DIR   -server
+//	FLOOD_CREDENTIALS  -cred
+//	FLOOD_CONCURRENCY  -workers
+//
+// Flags on the command line take precedence over the environment. Secrets
+// are read from variables of their own, such as FLOOD_ADMIN_TOKEN, and
+// never through a flag.
+var envAliases = map[string]string{
+	"FLOOD_SERVER_DIR":  "server",
+	"FLOOD_CREDENTIALS": "cred",
+	"FLOOD_CONCURRENCY": "workers",
+}
+
+// envName returns the environment variable of a flag.
+func envName(flagName string) string {
+	return "FLOOD_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvironment sets the flags given in the environment. It runs before
+// flag.Parse, so that the command line overrides them.
+func applyEnvironment() {
+	set := func(variable, name string) {
+		value, ok := os.LookupEnv(variable)
+		if !ok {
+			return
+		}
+		if err := flag.Set(name, value); err != nil {
+			log.Fatalf("Invalid %s: %v", variable, err)
+		}
+	}
+	for variable, name := range envAliases {
+		set(variable, name)
+	}
+	// The names derived from the flags come last and win over an alias
+	flag.VisitAll(func(f *flag.Flag) {
+		set(envName(f.Name), f.Name)
+	})
+
+	usage := flag.Usage
+	flag.Usage = func() {
+		usage()
+		fmt.Fprintln(flag.CommandLine.Output(), "\nEvery flag can also be set as FLOOD_<FLAG> in the environment, e.g. FLOOD_LOG_LEVEL=debug.")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// Every state transition of a file can be published as a JSON message to
+// event sinks: an SNS topic or SQS queue (-events-target, see
+// awsEventSender) and a Kafka topic (-kafka-brokers, see kafka.go).
+//
+//	{"schema_version": 1, "event": "file.success", "state": "success", "profile": "backup", "bucket": "logs", "key": "2024/app.log", ...}
+//
+// The states are processing (moved from incoming), uploading (an upload
+// attempt starts) and the outcomes a file is settled with, such as success,
+// already_present, failure, conflict and failed_over. schema_version is
+// raised whenever a field changes meaning or is removed; new fields do not
+// raise it. Every sink sends from a queue of its own and retries a few
+// times; if its queue is full, events are dropped.
+type lifecycleEvent struct {
+	SchemaVersion int       `json:"schema_version"`
+	Seq           int64     `json:"seq,omitempty"` // with -event-journal, see journal.go
+	Event         string    `json:"event"`
+	State         string    `json:"state"`
+	Time          time.Time `json:"time"`
+	Hostname      string    `json:"hostname"`
+	FileID        string    `json:"file_id,omitempty"`
+	Profile       string    `json:"profile"`
+	Bucket        string    `json:"bucket"`
+	Key           string    `json:"key,omitempty"`
+	Path          string    `json:"path"`
+	Retries       int       `json:"retries"`
+	Bytes         int64     `json:"bytes,omitempty"`
+	ETag          string    `json:"etag,omitempty"`
+	VersionID     string    `json:"version_id,omitempty"`
+	Error         string    `json:"error,omitempty"`
+	Tenant        string    `json:"tenant,omitempty"` // see tenant.go
+}
+
+const (
+	eventSchemaVersion = 1
+	eventQueueSize     = 1024
+	eventMaxAttempts   = 3
+)
+
+// eventSink delivers events to one destination.
+type eventSink struct {
+	target string
+	queue  chan lifecycleEvent
+	send   func(e lifecycleEvent, body []byte) error
+}
+
+var eventSinks []*eventSink
+
+// startEventSinks starts the sinks that are configured. It runs before
+// any file is processed, so eventSinks does not change afterwards.
+func startEventSinks() {
+	if eventsTarget != "" {
+		addEventSink(eventsTarget, awsEventSender())
+	}
+	if kafkaBrokers != "" {
+		addEventSink("Kafka topic "+kafkaTopic, kafkaEventSender())
+	}
+}
+
+func addEventSink(target string, send func(e lifecycleEvent, body []byte) error) {
+	s := &eventSink{target: target, queue: make(chan lifecycleEvent, eventQueueSize), send: send}
+	eventSinks = append(eventSinks, s)
+	log.Printf("Publishing file events to %s", target)
+	go s.run()
+}
+
+// publishEvent queues an event of rec for every sink.
+func publishEvent(state string, rec fileRecord) {
+	subscribers.mu.Lock()
+	subscribed := len(subscribers.chans) > 0
+	subscribers.mu.Unlock()
+	if len(eventSinks) == 0 && !journalEvents && !subscribed {
+		return
+	}
+	e := lifecycleEvent{
+		SchemaVersion: eventSchemaVersion,
+		Event:         "file." + state,
+		State:         state,
+		Time:          time.Now(),
+		Hostname:      hostname,
+		FileID:        rec.ID,
+		Profile:       rec.Profile.Name,
+		Bucket:        rec.Bucket,
+		Key:           rec.Key,
+		Path:          rec.Path,
+		Retries:       rec.Retries,
+		Bytes:         rec.Bytes,
+		ETag:          rec.ETag,
+		VersionID:     rec.VersionID,
+		Tenant:        rec.Profile.tenantName(),
+	}
+	if n := len(rec.Attempts); n > 0 && state != "success" {
+		e.Error = rec.Attempts[n-1].Message
+	}
+	if journalEvents {
+		journalEvent(&e)
+	}
+	if subscribed {
+		notifySubscribers(e)
+	}
+	for _, s := range eventSinks {
+		select {
+		case s.queue <- e:
+		default:
+			log.Printf("Event queue of %s is full, dropping %s event for %s", s.target, e.Event, rec.Path)
+		}
+	}
+}
+
+func (s *eventSink) run() {
+	for e := range s.queue {
+		body, err := json.Marshal(e)
+		if err != nil {
+			log.Printf("Failed to encode %s event for %s: %v", e.Event, e.Path, err)
+			continue
+		}
+		for attempt := 0; ; attempt++ {
+			err := s.send(e, body)
+			if err == nil {
+				break
+			}
+			if attempt+1 >= eventMaxAttempts {
+				log.Printf("Failed to publish %s event for %s to %s, dropping it: %v", e.Event, e.Path, s.target, err)
+				break
+			}
+			time.Sleep(retryDelay(attempt))
+		}
+	}
+}
+
+// subscribers receive the events in-process, for the gRPC API.
+var subscribers struct {
+	mu    sync.Mutex
+	chans map[chan lifecycleEvent]bool
+}
+
+// subscribeEvents returns a channel that receives every event published
+// from now on, and a function that ends the subscription. The channel is
+// closed early if the subscriber falls behind by eventQueueSize events.
+func subscribeEvents() (<-chan lifecycleEvent, func()) {
+	ch := make(chan lifecycleEvent, eventQueueSize)
+	subscribers.mu.Lock()
+	if subscribers.chans == nil {
+		subscribers.chans = make(map[chan lifecycleEvent]bool)
+	}
+	subscribers.chans[ch] = true
+	subscribers.mu.Unlock()
+	return ch, func() {
+		subscribers.mu.Lock()
+		defer subscribers.mu.Unlock()
+		if subscribers.chans[ch] {
+			delete(subscribers.chans, ch)
+			close(ch)
+		}
+	}
+}
+
+func notifySubscribers(e lifecycleEvent) {
+	subscribers.mu.Lock()
+	defer subscribers.mu.Unlock()
+	for ch := range subscribers.chans {
+		select {
+		case ch <- e:
+		default:
+			delete(subscribers.chans, ch)
+			close(ch)
+		}
+	}
+}
+
+// eventPartitionKey keeps the events of a file in order: it is the FIFO
+// message group and the Kafka message key.
+func eventPartitionKey(e lifecycleEvent) string {
+	return e.Profile + "/" + e.Bucket + "/" + e.Key
+}
+
+// awsEventSender sends events to the SNS topic (an arn:aws:sns: ARN) or SQS
+// queue (a queue URL) of -events-target, using the credentials and region
+// of -events-profile. The message attribute "event" carries the event name
+// for SNS filter policies; on FIFO topics and queues the events of a file
+// share a message group.
+func awsEventSender() func(e lifecycleEvent, body []byte) error {
+	profile, ok := profiles[eventsProfile]
+	if !ok {
+		log.Fatalf("Unknown -events-profile: %s", eventsProfile)
+	}
+	cfg := getAWSConfig(profile)
+	fifo := strings.HasSuffix(eventsTarget, ".fifo")
+
+	if strings.HasPrefix(eventsTarget, "arn:aws:sns:") {
+		client := sns.NewFromConfig(cfg)
+		return func(e lifecycleEvent, body []byte) error {
+			in := &sns.PublishInput{
+				TopicArn: aws.String(eventsTarget),
+				Message:  aws.String(string(body)),
+				MessageAttributes: map[string]snstypes.MessageAttributeValue{
+					"event": {DataType: aws.String("String"), StringValue: aws.String(e.Event)},
+				},
+			}
+			if fifo {
+				in.MessageGroupId = aws.String(eventPartitionKey(e))
+				in.MessageDeduplicationId = aws.String(eventDeduplicationID(e))
+			}
+			_, err := client.Publish(context.TODO(), in)
+			return err
+		}
+	}
+
+	client := sqs.NewFromConfig(cfg)
+	return func(e lifecycleEvent, body []byte) error {
+		in := &sqs.SendMessageInput{
+			QueueUrl:    aws.String(eventsTarget),
+			MessageBody: aws.String(string(body)),
+			MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+				"event": {DataType: aws.String("String"), StringValue: aws.String(e.Event)},
+			},
+		}
+		if fifo {
+			in.MessageGroupId = aws.String(eventPartitionKey(e))
+			in.MessageDeduplicationId = aws.String(eventDeduplicationID(e))
+		}
+		_, err := client.SendMessage(context.TODO(), in)
+		return err
+	}
+}
+
+func eventDeduplicationID(e lifecycleEvent) string {
+	return fmt.Sprintf("%s-%s-%d", e.FileID, e.State, e.Time.UnixNano())
+}
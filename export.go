@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportRecord is a file record as exported. Every attempt to deliver a file
+// is a record of its own, so the export holds the full attempt history.
+type exportRecord struct {
+	ID                int64      `json:"id" parquet:"id"`
+	Profile           string     `json:"profile" parquet:"profile"`
+	Bucket            string     `json:"bucket" parquet:"bucket"`
+	Path              string     `json:"path" parquet:"path"`
+	OriginalPath      string     `json:"original_path" parquet:"original_path"`
+	Key               string     `json:"key" parquet:"key"`
+	Operation         string     `json:"operation" parquet:"operation"`
+	Outcome           string     `json:"outcome" parquet:"outcome"`
+	Retries           int64      `json:"retries" parquet:"retries"`
+	Time              *time.Time `json:"time" parquet:"time,optional,timestamp(millisecond)"`
+	PartSize          int64      `json:"part_size" parquet:"part_size"`
+	PartConcurrency   int64      `json:"part_concurrency" parquet:"part_concurrency"`
+	Metadata          string     `json:"metadata" parquet:"metadata"`
+	ChecksumAlgorithm string     `json:"checksum_algorithm" parquet:"checksum_algorithm"`
+	Checksum          string     `json:"checksum" parquet:"checksum"`
+	AcceptedBy        string     `json:"accepted_by" parquet:"accepted_by"`
+	Bytes             int64      `json:"bytes" parquet:"bytes"`
+	DurationMS        int64      `json:"duration_ms" parquet:"duration_ms"`
+	Throughput        int64      `json:"throughput" parquet:"throughput"`
+	PartCount         int64      `json:"part_count" parquet:"part_count"`
+	Attempts          int64      `json:"attempts" parquet:"attempts"`
+	ETag              string     `json:"etag" parquet:"etag"`
+	VersionID         string     `json:"version_id" parquet:"version_id"`
+}
+
+var exportColumns = []string{
+	"id", "profile", "bucket", "path", "original_path", "key", "operation", "outcome", "retries",
+	"time", "part_size", "part_concurrency", "metadata", "checksum_algorithm", "checksum", "accepted_by",
+	"bytes", "duration_ms", "throughput", "part_count", "attempts", "etag", "version_id",
+}
+
+func (r exportRecord) csv() []string {
+	var at string
+	if r.Time != nil {
+		at = r.Time.UTC().Format(time.RFC3339Nano)
+	}
+	return []string{
+		strconv.FormatInt(r.ID, 10), r.Profile, r.Bucket, r.Path, r.OriginalPath, r.Key, r.Operation, r.Outcome,
+		strconv.FormatInt(r.Retries, 10), at, strconv.FormatInt(r.PartSize, 10), strconv.FormatInt(r.PartConcurrency, 10),
+		r.Metadata, r.ChecksumAlgorithm, r.Checksum, r.AcceptedBy,
+		strconv.FormatInt(r.Bytes, 10), strconv.FormatInt(r.DurationMS, 10), strconv.FormatInt(r.Throughput, 10),
+		strconv.FormatInt(r.PartCount, 10), strconv.FormatInt(r.Attempts, 10), r.ETag, r.VersionID,
+	}
+}
+
+// runExportMode writes the file records to stdout or a file:
+//
+//	flood export [-format jsonl|csv|parquet] [-since 2024-01-01|30d] [-o file]
+//
+// Records are read and written one at a time, so databases of any size can
+// be exported without holding them in memory.
+func runExportMode(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "Output format: jsonl, csv or parquet")
+	since := fs.String("since", "", "Only export records from this date (2024-01-01) or period (30d) on")
+	output := fs.String("o", "", "Write to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] export [export flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *format != "jsonl" && *format != "csv" && *format != "parquet" {
+		log.Fatalf("Invalid -format %q: must be jsonl, csv or parquet", *format)
+	}
+
+	var from time.Time
+	if *since != "" {
+		if t, err := time.ParseInLocation(time.DateOnly, *since, time.Local); err == nil {
+			from = t
+		} else if t, err := time.Parse(time.RFC3339, *since); err == nil {
+			from = t
+		} else if period, err := parseDuration(*since); err == nil && period > 0 {
+			from = time.Now().Add(-period)
+		} else {
+			log.Fatalf("Invalid -since %q: expected a date such as 2024-01-01 or a period such as 30d", *since)
+		}
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		out = f
+	}
+	n, err := exportRecords(out, *format, from)
+	if err == nil && out != os.Stdout {
+		err = out.Close()
+	}
+	if err != nil {
+		log.Fatalf("Export failed after %d record(s): %v", n, err)
+	}
+	log.Printf("Exported %d record(s)", n)
+}
+
+func exportRecords(out io.Writer, format string, from time.Time) (int, error) {
+	rows, err := db.Query(`
+		SELECT id, profile, bucket, filepath, COALESCE(original_path, ''), COALESCE(object_key, ''),
+			COALESCE(operation, 'upload'), upload_outcome, retries, last_retry, part_size, part_concurrency,
+			COALESCE(metadata, ''), COALESCE(checksum_algorithm, ''), COALESCE(checksum_value, ''), COALESCE(accepted_by, ''),
+			COALESCE(bytes, 0), COALESCE(duration_ms, 0), COALESCE(throughput, 0), COALESCE(part_count, 0), COALESCE(attempts, 0),
+			COALESCE(etag, ''), COALESCE(version_id, '')
+		FROM file_records WHERE last_retry >= ? ORDER BY id`, from)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	buffered := bufio.NewWriter(out)
+	var (
+		write func(exportRecord) error
+		flush func() error
+	)
+	switch format {
+	case "csv":
+		w := csv.NewWriter(buffered)
+		w.Write(exportColumns)
+		write = func(r exportRecord) error { return w.Write(r.csv()) }
+		flush = func() error { w.Flush(); return w.Error() }
+	case "parquet":
+		w := parquet.NewGenericWriter[exportRecord](buffered)
+		write = func(r exportRecord) error {
+			_, err := w.Write([]exportRecord{r})
+			return err
+		}
+		flush = w.Close
+	default:
+		enc := json.NewEncoder(buffered)
+		write = func(r exportRecord) error { return enc.Encode(r) }
+		flush = func() error { return nil }
+	}
+
+	n := 0
+	for rows.Next() {
+		var (
+			r                          exportRecord
+			retries, size, concurrency sql.NullInt64
+			at                         sql.NullTime
+		)
+		err := rows.Scan(&r.ID, &r.Profile, &r.Bucket, &r.Path, &r.OriginalPath, &r.Key, &r.Operation, &r.Outcome,
+			&retries, &at, &size, &concurrency, &r.Metadata, &r.ChecksumAlgorithm, &r.Checksum, &r.AcceptedBy,
+			&r.Bytes, &r.DurationMS, &r.Throughput, &r.PartCount, &r.Attempts, &r.ETag, &r.VersionID)
+		if err != nil {
+			return n, err
+		}
+		r.Retries, r.PartSize, r.PartConcurrency = retries.Int64, size.Int64, concurrency.Int64
+		if at.Valid {
+			r.Time = &at.Time
+		}
+		if err := write(r); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if err := flush(); err != nil {
+		return n, err
+	}
+	return n, buffered.Flush()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// failover hands a file its profile gave up on to the profile's
+// failover_profile by moving it into that profile's processing directory,
+// where it is queued like any other file. It reports whether the file was
+// handed over.
+func failover(path string, profile Profile) bool {
+	failoverPath, ok := failoverTarget(path, profile)
+	if !ok {
+		return false
+	}
+	if err := moveFile(path, failoverPath); err != nil {
+		log.Printf("Failed to fail over %s to profile %s: %v", path, profile.FailoverProfile, err)
+		return false
+	}
+
+	log.Printf("Failing over %s from profile %s to profile %s", path, profile.Name, profile.FailoverProfile)
+	queues[profile.FailoverProfile].notify()
+	return true
+}
+
+// failoverTarget returns where a file of the profile goes in the processing
+// directory of its failover profile, if it has one.
+func failoverTarget(path string, profile Profile) (string, bool) {
+	if profile.FailoverProfile == "" {
+		return "", false
+	}
+	relativePath, err := filepath.Rel(filepath.Join(serverDir, "processing", profile.Name), path)
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(serverDir, "processing", profile.FailoverProfile, relativePath), true
+}
+
+// validateFailover checks that a profile's failover chain only refers to
+// existing profiles and does not loop.
+func validateFailover(profile Profile, profiles map[string]Profile) error {
+	seen := map[string]bool{profile.Name: true}
+	for next := profile.FailoverProfile; next != ""; {
+		target, ok := profiles[next]
+		if !ok {
+			return fmt.Errorf("unknown failover_profile %s", next)
+		}
+		if seen[next] {
+			return fmt.Errorf("failover_profile chain loops back to %s", next)
+		}
+		seen[next] = true
+		next = target.FailoverProfile
+	}
+	return nil
+}
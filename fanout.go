@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fanoutDestination uploads every file to several other profiles:
+//
+//	[everywhere]
+//	provider = fanout
+//	destinations = r2, s3-archive
+//
+// A file is completed only once all destinations accepted it. Each
+// destination retries on its own and applies its own key prefix and rewrite
+// rules on top of the key of the fanout profile. The outcome for every
+// destination is recorded in the destination_uploads table, and a retry or
+// re-drive skips the destinations that already accepted the file as it is.
+// The destination profiles are looked up for every upload, so a reload
+// takes effect right away.
+type fanoutDestination struct {
+	names []string
+}
+
+func newFanoutDestination(name string, settings map[string]string) (destination, error) {
+	d := &fanoutDestination{}
+	for _, target := range strings.Split(settings["destinations"], ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		if target == name {
+			return nil, fmt.Errorf("destinations must not include the profile itself")
+		}
+		d.names = append(d.names, target)
+	}
+	if len(d.names) == 0 {
+		return nil, fmt.Errorf("destinations lists no profiles")
+	}
+	return d, nil
+}
+
+// resolve checks the destination profiles once all profiles are loaded.
+func (d *fanoutDestination) resolve(profiles map[string]Profile) error {
+	for _, name := range d.names {
+		target, ok := profiles[name]
+		if !ok {
+			return fmt.Errorf("unknown destination profile %s", name)
+		}
+		if _, nested := target.Destination.(*fanoutDestination); nested {
+			return fmt.Errorf("destination profile %s is itself a fanout profile", name)
+		}
+	}
+	return nil
+}
+
+// targets returns the destination profiles as currently loaded.
+func (d *fanoutDestination) targets() ([]Profile, error) {
+	var targets []Profile
+	for _, name := range d.names {
+		target, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown destination profile %s", name)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+func (d *fanoutDestination) validate(bucket string) error {
+	targets, err := d.targets()
+	if err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if err := destinationFor(target.forBucket(bucket)).validate(bucket); err != nil {
+			return fmt.Errorf("destination %s: %w", target.Name, err)
+		}
+	}
+	return nil
+}
+
+func (d *fanoutDestination) upload(rec fileRecord) (uploadResult, error) {
+	return uploadResult{}, d.deliver(&rec)
+}
+
+// deliver uploads the file to all destinations concurrently, each with its
+// own retries and upload slots. rec.Retries is set to the most retries any
+// destination needed, and rec.Attempts collects the attempts of all.
+func (d *fanoutDestination) deliver(rec *fileRecord) error {
+	targets, err := d.targets()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(rec.Path)
+	if err != nil {
+		return err
+	}
+	mtime := info.ModTime().UnixNano()
+
+	var (
+		mu     sync.Mutex
+		failed []error
+		wg     sync.WaitGroup
+	)
+	for _, target := range targets {
+		if delivered(*rec, target.Name, mtime) {
+			log.Printf("Skipping destination %s of %s: already uploaded", target.Name, rec.Path)
+			continue
+		}
+		wg.Add(1)
+		go func(target Profile) {
+			defer wg.Done()
+			targetRec := *rec
+			targetRec.Profile = target.forBucket(rec.Bucket)
+			targetRec.Retries = 0
+
+			var err error
+			targetRec.Key, err = objectKey(target, rec.Bucket, rec.Key)
+			if err == nil {
+				err = uploadWithRetry(&targetRec)
+			}
+			outcome := "success"
+			if err != nil {
+				log.Printf("Upload of %s to destination %s failed: %v", rec.Path, target.Name, err)
+				discardMultipartUpload(target, rec.Path)
+				outcome = "failure"
+				if errors.Is(err, errConflict) {
+					outcome = "conflict"
+				}
+			}
+			recordDestinationUpload(*rec, targetRec, outcome, mtime)
+
+			mu.Lock()
+			defer mu.Unlock()
+			rec.Retries = max(rec.Retries, targetRec.Retries)
+			for _, a := range targetRec.Attempts {
+				a.Message = "destination " + target.Name + ": " + a.Message
+				rec.Attempts = append(rec.Attempts, a)
+			}
+			if err != nil {
+				failed = append(failed, fmt.Errorf("destination %s: %w", target.Name, err))
+			}
+		}(target)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d destination(s) failed: %w", len(failed), len(targets), errors.Join(failed...))
+	}
+	return nil
+}
+
+// matches reports whether every destination already has the file.
+func (d *fanoutDestination) matches(bucket, key, path string) (bool, error) {
+	targets, err := d.targets()
+	if err != nil {
+		return false, err
+	}
+	for _, target := range targets {
+		targetKey, err := objectKey(target, bucket, key)
+		if err != nil {
+			return false, err
+		}
+		present, err := destinationFor(target.forBucket(bucket)).matches(bucket, targetKey, path)
+		if err != nil || !present {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// transient is false: the destinations have already retried on their own.
+func (d *fanoutDestination) transient(err error) bool {
+	return false
+}
+
+// delivered reports whether the destination accepted the file with the
+// modification time before.
+func delivered(rec fileRecord, destination string, mtime int64) bool {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM destination_uploads WHERE profile = ? AND destination = ? AND bucket = ? AND filepath = ? AND mtime = ? AND upload_outcome = 'success'",
+		rec.Profile.Name, destination, rec.Bucket, rec.Path, mtime).Scan(&n)
+	if err != nil {
+		log.Printf("Failed to look up uploads of %s to destination %s, uploading again: %v", rec.Path, destination, err)
+		return false
+	}
+	return n > 0
+}
+
+func recordDestinationUpload(rec, targetRec fileRecord, outcome string, mtime int64) {
+	_, err := db.Exec(
+		"INSERT INTO destination_uploads(profile, destination, bucket, filepath, object_key, retries, upload_outcome, created, etag, version_id, mtime) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		rec.Profile.Name, targetRec.Profile.Name, rec.Bucket, rec.Path, targetRec.Key, targetRec.Retries, outcome, time.Now(), optionalString(targetRec.ETag), optionalString(targetRec.VersionID), mtime,
+	)
+	if err != nil {
+		log.Printf("Failed to record upload of %s to destination %s: %v", rec.Path, targetRec.Profile.Name, err)
+	}
+}
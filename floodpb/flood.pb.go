@@ -0,0 +1,1093 @@
+// The gRPC control and event API of flood server mode, served on
+// -grpc-addr by builds with the grpc tag. See grpc.go.
+//
+// Go clients import github.com/crowdwave/flood/floodpb; Python clients are
+// generated into clients/python (see floodpb/generate.go).
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: flood/v1/flood.proto
+
+package floodpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type SubmitRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Profile       string                 `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`
+	Bucket        string                 `protobuf:"bytes,2,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	Key           string                 `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`                                                                             // path below the bucket directory
+	Data          []byte                 `protobuf:"bytes,6,opt,name=data,proto3" json:"data,omitempty"`                                                                           // the content of the file
+	Tags          map[string]string      `protobuf:"bytes,7,rep,name=tags,proto3" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // object tags, as in a .floodmeta sidecar
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitRequest) Reset() {
+	*x = SubmitRequest{}
+	mi := &file_flood_v1_flood_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitRequest) ProtoMessage() {}
+
+func (x *SubmitRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitRequest.ProtoReflect.Descriptor instead.
+func (*SubmitRequest) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *SubmitRequest) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *SubmitRequest) GetBucket() string {
+	if x != nil {
+		return x.Bucket
+	}
+	return ""
+}
+
+func (x *SubmitRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *SubmitRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *SubmitRequest) GetTags() map[string]string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+type SubmitResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	IncomingPath  string                 `protobuf:"bytes,1,opt,name=incoming_path,json=incomingPath,proto3" json:"incoming_path,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubmitResponse) Reset() {
+	*x = SubmitResponse{}
+	mi := &file_flood_v1_flood_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubmitResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubmitResponse) ProtoMessage() {}
+
+func (x *SubmitResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubmitResponse.ProtoReflect.Descriptor instead.
+func (*SubmitResponse) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *SubmitResponse) GetIncomingPath() string {
+	if x != nil {
+		return x.IncomingPath
+	}
+	return ""
+}
+
+type SubscribeRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	AfterSeq int64                  `protobuf:"varint,1,opt,name=after_seq,json=afterSeq,proto3" json:"after_seq,omitempty"`
+	// Only events of these profiles; all when empty.
+	Profiles      []string `protobuf:"bytes,2,rep,name=profiles,proto3" json:"profiles,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_flood_v1_flood_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SubscribeRequest) GetAfterSeq() int64 {
+	if x != nil {
+		return x.AfterSeq
+	}
+	return 0
+}
+
+func (x *SubscribeRequest) GetProfiles() []string {
+	if x != nil {
+		return x.Profiles
+	}
+	return nil
+}
+
+// Event is a lifecycle event of a file; see lifecycleEvent in events.go.
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           int64                  `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"` // zero without -event-journal
+	Event         string                 `protobuf:"bytes,2,opt,name=event,proto3" json:"event,omitempty"`
+	State         string                 `protobuf:"bytes,3,opt,name=state,proto3" json:"state,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=time,proto3" json:"time,omitempty"`
+	Hostname      string                 `protobuf:"bytes,5,opt,name=hostname,proto3" json:"hostname,omitempty"`
+	FileId        string                 `protobuf:"bytes,6,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"`
+	Profile       string                 `protobuf:"bytes,7,opt,name=profile,proto3" json:"profile,omitempty"`
+	Bucket        string                 `protobuf:"bytes,8,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	Key           string                 `protobuf:"bytes,9,opt,name=key,proto3" json:"key,omitempty"`
+	Path          string                 `protobuf:"bytes,10,opt,name=path,proto3" json:"path,omitempty"`
+	Retries       int32                  `protobuf:"varint,11,opt,name=retries,proto3" json:"retries,omitempty"`
+	Bytes         int64                  `protobuf:"varint,12,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	Etag          string                 `protobuf:"bytes,13,opt,name=etag,proto3" json:"etag,omitempty"`
+	VersionId     string                 `protobuf:"bytes,14,opt,name=version_id,json=versionId,proto3" json:"version_id,omitempty"`
+	Error         string                 `protobuf:"bytes,15,opt,name=error,proto3" json:"error,omitempty"`
+	Tenant        string                 `protobuf:"bytes,16,opt,name=tenant,proto3" json:"tenant,omitempty"` // empty unless the profile belongs to a tenant
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_flood_v1_flood_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Event) GetSeq() int64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Event) GetEvent() string {
+	if x != nil {
+		return x.Event
+	}
+	return ""
+}
+
+func (x *Event) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetHostname() string {
+	if x != nil {
+		return x.Hostname
+	}
+	return ""
+}
+
+func (x *Event) GetFileId() string {
+	if x != nil {
+		return x.FileId
+	}
+	return ""
+}
+
+func (x *Event) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *Event) GetBucket() string {
+	if x != nil {
+		return x.Bucket
+	}
+	return ""
+}
+
+func (x *Event) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Event) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Event) GetRetries() int32 {
+	if x != nil {
+		return x.Retries
+	}
+	return 0
+}
+
+func (x *Event) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *Event) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+func (x *Event) GetVersionId() string {
+	if x != nil {
+		return x.VersionId
+	}
+	return ""
+}
+
+func (x *Event) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Event) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+type GetFileRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to File:
+	//
+	//	*GetFileRequest_Path
+	//	*GetFileRequest_FileId
+	File          isGetFileRequest_File `protobuf_oneof:"file"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetFileRequest) Reset() {
+	*x = GetFileRequest{}
+	mi := &file_flood_v1_flood_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetFileRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetFileRequest) ProtoMessage() {}
+
+func (x *GetFileRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetFileRequest.ProtoReflect.Descriptor instead.
+func (*GetFileRequest) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetFileRequest) GetFile() isGetFileRequest_File {
+	if x != nil {
+		return x.File
+	}
+	return nil
+}
+
+func (x *GetFileRequest) GetPath() string {
+	if x != nil {
+		if x, ok := x.File.(*GetFileRequest_Path); ok {
+			return x.Path
+		}
+	}
+	return ""
+}
+
+func (x *GetFileRequest) GetFileId() string {
+	if x != nil {
+		if x, ok := x.File.(*GetFileRequest_FileId); ok {
+			return x.FileId
+		}
+	}
+	return ""
+}
+
+type isGetFileRequest_File interface {
+	isGetFileRequest_File()
+}
+
+type GetFileRequest_Path struct {
+	Path string `protobuf:"bytes,1,opt,name=path,proto3,oneof"`
+}
+
+type GetFileRequest_FileId struct {
+	FileId string `protobuf:"bytes,2,opt,name=file_id,json=fileId,proto3,oneof"` // correlation ID
+}
+
+func (*GetFileRequest_Path) isGetFileRequest_File() {}
+
+func (*GetFileRequest_FileId) isGetFileRequest_File() {}
+
+type GetFileResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Records []*FileRecord          `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	// State directory the file is in now (incoming, processing, completed
+	// or failed), empty if it is in none.
+	Location      string `protobuf:"bytes,2,opt,name=location,proto3" json:"location,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetFileResponse) Reset() {
+	*x = GetFileResponse{}
+	mi := &file_flood_v1_flood_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetFileResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetFileResponse) ProtoMessage() {}
+
+func (x *GetFileResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetFileResponse.ProtoReflect.Descriptor instead.
+func (*GetFileResponse) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetFileResponse) GetRecords() []*FileRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *GetFileResponse) GetLocation() string {
+	if x != nil {
+		return x.Location
+	}
+	return ""
+}
+
+type FileRecord struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Profile       string                 `protobuf:"bytes,2,opt,name=profile,proto3" json:"profile,omitempty"`
+	Bucket        string                 `protobuf:"bytes,3,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	Path          string                 `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`
+	Key           string                 `protobuf:"bytes,5,opt,name=key,proto3" json:"key,omitempty"`
+	Operation     string                 `protobuf:"bytes,6,opt,name=operation,proto3" json:"operation,omitempty"`
+	Outcome       string                 `protobuf:"bytes,7,opt,name=outcome,proto3" json:"outcome,omitempty"`
+	Retries       int32                  `protobuf:"varint,8,opt,name=retries,proto3" json:"retries,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=time,proto3" json:"time,omitempty"`
+	FileId        string                 `protobuf:"bytes,10,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"`
+	Etag          string                 `protobuf:"bytes,11,opt,name=etag,proto3" json:"etag,omitempty"`
+	VersionId     string                 `protobuf:"bytes,12,opt,name=version_id,json=versionId,proto3" json:"version_id,omitempty"`
+	Error         string                 `protobuf:"bytes,13,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *FileRecord) Reset() {
+	*x = FileRecord{}
+	mi := &file_flood_v1_flood_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *FileRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FileRecord) ProtoMessage() {}
+
+func (x *FileRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FileRecord.ProtoReflect.Descriptor instead.
+func (*FileRecord) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FileRecord) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *FileRecord) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *FileRecord) GetBucket() string {
+	if x != nil {
+		return x.Bucket
+	}
+	return ""
+}
+
+func (x *FileRecord) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *FileRecord) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *FileRecord) GetOperation() string {
+	if x != nil {
+		return x.Operation
+	}
+	return ""
+}
+
+func (x *FileRecord) GetOutcome() string {
+	if x != nil {
+		return x.Outcome
+	}
+	return ""
+}
+
+func (x *FileRecord) GetRetries() int32 {
+	if x != nil {
+		return x.Retries
+	}
+	return 0
+}
+
+func (x *FileRecord) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *FileRecord) GetFileId() string {
+	if x != nil {
+		return x.FileId
+	}
+	return ""
+}
+
+func (x *FileRecord) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+func (x *FileRecord) GetVersionId() string {
+	if x != nil {
+		return x.VersionId
+	}
+	return ""
+}
+
+func (x *FileRecord) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type GetQueuesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetQueuesRequest) Reset() {
+	*x = GetQueuesRequest{}
+	mi := &file_flood_v1_flood_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetQueuesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetQueuesRequest) ProtoMessage() {}
+
+func (x *GetQueuesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetQueuesRequest.ProtoReflect.Descriptor instead.
+func (*GetQueuesRequest) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{7}
+}
+
+type GetQueuesResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Paused         bool                   `protobuf:"varint,1,opt,name=paused,proto3" json:"paused,omitempty"`
+	PausedProfiles []string               `protobuf:"bytes,2,rep,name=paused_profiles,json=pausedProfiles,proto3" json:"paused_profiles,omitempty"`
+	Queues         []*Queue               `protobuf:"bytes,3,rep,name=queues,proto3" json:"queues,omitempty"`
+	Uploads        []*Upload              `protobuf:"bytes,4,rep,name=uploads,proto3" json:"uploads,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GetQueuesResponse) Reset() {
+	*x = GetQueuesResponse{}
+	mi := &file_flood_v1_flood_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetQueuesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetQueuesResponse) ProtoMessage() {}
+
+func (x *GetQueuesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetQueuesResponse.ProtoReflect.Descriptor instead.
+func (*GetQueuesResponse) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GetQueuesResponse) GetPaused() bool {
+	if x != nil {
+		return x.Paused
+	}
+	return false
+}
+
+func (x *GetQueuesResponse) GetPausedProfiles() []string {
+	if x != nil {
+		return x.PausedProfiles
+	}
+	return nil
+}
+
+func (x *GetQueuesResponse) GetQueues() []*Queue {
+	if x != nil {
+		return x.Queues
+	}
+	return nil
+}
+
+func (x *GetQueuesResponse) GetUploads() []*Upload {
+	if x != nil {
+		return x.Uploads
+	}
+	return nil
+}
+
+type Queue struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Profile       string                 `protobuf:"bytes,1,opt,name=profile,proto3" json:"profile,omitempty"`
+	Bucket        string                 `protobuf:"bytes,2,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	Waiting       int32                  `protobuf:"varint,3,opt,name=waiting,proto3" json:"waiting,omitempty"` // files in processing
+	Queued        int32                  `protobuf:"varint,4,opt,name=queued,proto3" json:"queued,omitempty"`   // handed to the upload workers
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Queue) Reset() {
+	*x = Queue{}
+	mi := &file_flood_v1_flood_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Queue) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Queue) ProtoMessage() {}
+
+func (x *Queue) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Queue.ProtoReflect.Descriptor instead.
+func (*Queue) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Queue) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *Queue) GetBucket() string {
+	if x != nil {
+		return x.Bucket
+	}
+	return ""
+}
+
+func (x *Queue) GetWaiting() int32 {
+	if x != nil {
+		return x.Waiting
+	}
+	return 0
+}
+
+func (x *Queue) GetQueued() int32 {
+	if x != nil {
+		return x.Queued
+	}
+	return 0
+}
+
+type Upload struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	FileId        string                 `protobuf:"bytes,1,opt,name=file_id,json=fileId,proto3" json:"file_id,omitempty"`
+	Profile       string                 `protobuf:"bytes,2,opt,name=profile,proto3" json:"profile,omitempty"`
+	Bucket        string                 `protobuf:"bytes,3,opt,name=bucket,proto3" json:"bucket,omitempty"`
+	Key           string                 `protobuf:"bytes,4,opt,name=key,proto3" json:"key,omitempty"`
+	Size          int64                  `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	Sent          int64                  `protobuf:"varint,6,opt,name=sent,proto3" json:"sent,omitempty"`
+	Throughput    int64                  `protobuf:"varint,7,opt,name=throughput,proto3" json:"throughput,omitempty"` // bytes per second in the current attempt
+	Attempt       int32                  `protobuf:"varint,8,opt,name=attempt,proto3" json:"attempt,omitempty"`
+	Started       *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=started,proto3" json:"started,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Upload) Reset() {
+	*x = Upload{}
+	mi := &file_flood_v1_flood_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Upload) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Upload) ProtoMessage() {}
+
+func (x *Upload) ProtoReflect() protoreflect.Message {
+	mi := &file_flood_v1_flood_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Upload.ProtoReflect.Descriptor instead.
+func (*Upload) Descriptor() ([]byte, []int) {
+	return file_flood_v1_flood_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Upload) GetFileId() string {
+	if x != nil {
+		return x.FileId
+	}
+	return ""
+}
+
+func (x *Upload) GetProfile() string {
+	if x != nil {
+		return x.Profile
+	}
+	return ""
+}
+
+func (x *Upload) GetBucket() string {
+	if x != nil {
+		return x.Bucket
+	}
+	return ""
+}
+
+func (x *Upload) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *Upload) GetSize() int64 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *Upload) GetSent() int64 {
+	if x != nil {
+		return x.Sent
+	}
+	return 0
+}
+
+func (x *Upload) GetThroughput() int64 {
+	if x != nil {
+		return x.Throughput
+	}
+	return 0
+}
+
+func (x *Upload) GetAttempt() int32 {
+	if x != nil {
+		return x.Attempt
+	}
+	return 0
+}
+
+func (x *Upload) GetStarted() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Started
+	}
+	return nil
+}
+
+var File_flood_v1_flood_proto protoreflect.FileDescriptor
+
+const file_flood_v1_flood_proto_rawDesc = "" +
+	"\n" +
+	"\x14flood/v1/flood.proto\x12\bflood.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xee\x01\n" +
+	"\rSubmitRequest\x12\x18\n" +
+	"\aprofile\x18\x01 \x01(\tR\aprofile\x12\x16\n" +
+	"\x06bucket\x18\x02 \x01(\tR\x06bucket\x12\x10\n" +
+	"\x03key\x18\x03 \x01(\tR\x03key\x12\x12\n" +
+	"\x04data\x18\x06 \x01(\fR\x04data\x125\n" +
+	"\x04tags\x18\a \x03(\v2!.flood.v1.SubmitRequest.TagsEntryR\x04tags\x1a7\n" +
+	"\tTagsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01J\x04\b\x04\x10\x05J\x04\b\x05\x10\x06R\x04pathR\x03url\"5\n" +
+	"\x0eSubmitResponse\x12#\n" +
+	"\rincoming_path\x18\x01 \x01(\tR\fincomingPath\"K\n" +
+	"\x10SubscribeRequest\x12\x1b\n" +
+	"\tafter_seq\x18\x01 \x01(\x03R\bafterSeq\x12\x1a\n" +
+	"\bprofiles\x18\x02 \x03(\tR\bprofiles\"\x93\x03\n" +
+	"\x05Event\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x03R\x03seq\x12\x14\n" +
+	"\x05event\x18\x02 \x01(\tR\x05event\x12\x14\n" +
+	"\x05state\x18\x03 \x01(\tR\x05state\x12.\n" +
+	"\x04time\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1a\n" +
+	"\bhostname\x18\x05 \x01(\tR\bhostname\x12\x17\n" +
+	"\afile_id\x18\x06 \x01(\tR\x06fileId\x12\x18\n" +
+	"\aprofile\x18\a \x01(\tR\aprofile\x12\x16\n" +
+	"\x06bucket\x18\b \x01(\tR\x06bucket\x12\x10\n" +
+	"\x03key\x18\t \x01(\tR\x03key\x12\x12\n" +
+	"\x04path\x18\n" +
+	" \x01(\tR\x04path\x12\x18\n" +
+	"\aretries\x18\v \x01(\x05R\aretries\x12\x14\n" +
+	"\x05bytes\x18\f \x01(\x03R\x05bytes\x12\x12\n" +
+	"\x04etag\x18\r \x01(\tR\x04etag\x12\x1d\n" +
+	"\n" +
+	"version_id\x18\x0e \x01(\tR\tversionId\x12\x14\n" +
+	"\x05error\x18\x0f \x01(\tR\x05error\x12\x16\n" +
+	"\x06tenant\x18\x10 \x01(\tR\x06tenant\"I\n" +
+	"\x0eGetFileRequest\x12\x14\n" +
+	"\x04path\x18\x01 \x01(\tH\x00R\x04path\x12\x19\n" +
+	"\afile_id\x18\x02 \x01(\tH\x00R\x06fileIdB\x06\n" +
+	"\x04file\"]\n" +
+	"\x0fGetFileResponse\x12.\n" +
+	"\arecords\x18\x01 \x03(\v2\x14.flood.v1.FileRecordR\arecords\x12\x1a\n" +
+	"\blocation\x18\x02 \x01(\tR\blocation\"\xd8\x02\n" +
+	"\n" +
+	"FileRecord\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x18\n" +
+	"\aprofile\x18\x02 \x01(\tR\aprofile\x12\x16\n" +
+	"\x06bucket\x18\x03 \x01(\tR\x06bucket\x12\x12\n" +
+	"\x04path\x18\x04 \x01(\tR\x04path\x12\x10\n" +
+	"\x03key\x18\x05 \x01(\tR\x03key\x12\x1c\n" +
+	"\toperation\x18\x06 \x01(\tR\toperation\x12\x18\n" +
+	"\aoutcome\x18\a \x01(\tR\aoutcome\x12\x18\n" +
+	"\aretries\x18\b \x01(\x05R\aretries\x12.\n" +
+	"\x04time\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x17\n" +
+	"\afile_id\x18\n" +
+	" \x01(\tR\x06fileId\x12\x12\n" +
+	"\x04etag\x18\v \x01(\tR\x04etag\x12\x1d\n" +
+	"\n" +
+	"version_id\x18\f \x01(\tR\tversionId\x12\x14\n" +
+	"\x05error\x18\r \x01(\tR\x05error\"\x12\n" +
+	"\x10GetQueuesRequest\"\xa9\x01\n" +
+	"\x11GetQueuesResponse\x12\x16\n" +
+	"\x06paused\x18\x01 \x01(\bR\x06paused\x12'\n" +
+	"\x0fpaused_profiles\x18\x02 \x03(\tR\x0epausedProfiles\x12'\n" +
+	"\x06queues\x18\x03 \x03(\v2\x0f.flood.v1.QueueR\x06queues\x12*\n" +
+	"\auploads\x18\x04 \x03(\v2\x10.flood.v1.UploadR\auploads\"k\n" +
+	"\x05Queue\x12\x18\n" +
+	"\aprofile\x18\x01 \x01(\tR\aprofile\x12\x16\n" +
+	"\x06bucket\x18\x02 \x01(\tR\x06bucket\x12\x18\n" +
+	"\awaiting\x18\x03 \x01(\x05R\awaiting\x12\x16\n" +
+	"\x06queued\x18\x04 \x01(\x05R\x06queued\"\xfd\x01\n" +
+	"\x06Upload\x12\x17\n" +
+	"\afile_id\x18\x01 \x01(\tR\x06fileId\x12\x18\n" +
+	"\aprofile\x18\x02 \x01(\tR\aprofile\x12\x16\n" +
+	"\x06bucket\x18\x03 \x01(\tR\x06bucket\x12\x10\n" +
+	"\x03key\x18\x04 \x01(\tR\x03key\x12\x12\n" +
+	"\x04size\x18\x05 \x01(\x03R\x04size\x12\x12\n" +
+	"\x04sent\x18\x06 \x01(\x03R\x04sent\x12\x1e\n" +
+	"\n" +
+	"throughput\x18\a \x01(\x03R\n" +
+	"throughput\x12\x18\n" +
+	"\aattempt\x18\b \x01(\x05R\aattempt\x124\n" +
+	"\astarted\x18\t \x01(\v2\x1a.google.protobuf.TimestampR\astarted2\x86\x02\n" +
+	"\x05Flood\x12;\n" +
+	"\x06Submit\x12\x17.flood.v1.SubmitRequest\x1a\x18.flood.v1.SubmitResponse\x12:\n" +
+	"\tSubscribe\x12\x1a.flood.v1.SubscribeRequest\x1a\x0f.flood.v1.Event0\x01\x12>\n" +
+	"\aGetFile\x12\x18.flood.v1.GetFileRequest\x1a\x19.flood.v1.GetFileResponse\x12D\n" +
+	"\tGetQueues\x12\x1a.flood.v1.GetQueuesRequest\x1a\x1b.flood.v1.GetQueuesResponseB$Z\"github.com/crowdwave/flood/floodpbb\x06proto3"
+
+var (
+	file_flood_v1_flood_proto_rawDescOnce sync.Once
+	file_flood_v1_flood_proto_rawDescData []byte
+)
+
+func file_flood_v1_flood_proto_rawDescGZIP() []byte {
+	file_flood_v1_flood_proto_rawDescOnce.Do(func() {
+		file_flood_v1_flood_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_flood_v1_flood_proto_rawDesc), len(file_flood_v1_flood_proto_rawDesc)))
+	})
+	return file_flood_v1_flood_proto_rawDescData
+}
+
+var file_flood_v1_flood_proto_msgTypes = make([]protoimpl.MessageInfo, 12)
+var file_flood_v1_flood_proto_goTypes = []any{
+	(*SubmitRequest)(nil),         // 0: flood.v1.SubmitRequest
+	(*SubmitResponse)(nil),        // 1: flood.v1.SubmitResponse
+	(*SubscribeRequest)(nil),      // 2: flood.v1.SubscribeRequest
+	(*Event)(nil),                 // 3: flood.v1.Event
+	(*GetFileRequest)(nil),        // 4: flood.v1.GetFileRequest
+	(*GetFileResponse)(nil),       // 5: flood.v1.GetFileResponse
+	(*FileRecord)(nil),            // 6: flood.v1.FileRecord
+	(*GetQueuesRequest)(nil),      // 7: flood.v1.GetQueuesRequest
+	(*GetQueuesResponse)(nil),     // 8: flood.v1.GetQueuesResponse
+	(*Queue)(nil),                 // 9: flood.v1.Queue
+	(*Upload)(nil),                // 10: flood.v1.Upload
+	nil,                           // 11: flood.v1.SubmitRequest.TagsEntry
+	(*timestamppb.Timestamp)(nil), // 12: google.protobuf.Timestamp
+}
+var file_flood_v1_flood_proto_depIdxs = []int32{
+	11, // 0: flood.v1.SubmitRequest.tags:type_name -> flood.v1.SubmitRequest.TagsEntry
+	12, // 1: flood.v1.Event.time:type_name -> google.protobuf.Timestamp
+	6,  // 2: flood.v1.GetFileResponse.records:type_name -> flood.v1.FileRecord
+	12, // 3: flood.v1.FileRecord.time:type_name -> google.protobuf.Timestamp
+	9,  // 4: flood.v1.GetQueuesResponse.queues:type_name -> flood.v1.Queue
+	10, // 5: flood.v1.GetQueuesResponse.uploads:type_name -> flood.v1.Upload
+	12, // 6: flood.v1.Upload.started:type_name -> google.protobuf.Timestamp
+	0,  // 7: flood.v1.Flood.Submit:input_type -> flood.v1.SubmitRequest
+	2,  // 8: flood.v1.Flood.Subscribe:input_type -> flood.v1.SubscribeRequest
+	4,  // 9: flood.v1.Flood.GetFile:input_type -> flood.v1.GetFileRequest
+	7,  // 10: flood.v1.Flood.GetQueues:input_type -> flood.v1.GetQueuesRequest
+	1,  // 11: flood.v1.Flood.Submit:output_type -> flood.v1.SubmitResponse
+	3,  // 12: flood.v1.Flood.Subscribe:output_type -> flood.v1.Event
+	5,  // 13: flood.v1.Flood.GetFile:output_type -> flood.v1.GetFileResponse
+	8,  // 14: flood.v1.Flood.GetQueues:output_type -> flood.v1.GetQueuesResponse
+	11, // [11:15] is the sub-list for method output_type
+	7,  // [7:11] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_flood_v1_flood_proto_init() }
+func file_flood_v1_flood_proto_init() {
+	if File_flood_v1_flood_proto != nil {
+		return
+	}
+	file_flood_v1_flood_proto_msgTypes[4].OneofWrappers = []any{
+		(*GetFileRequest_Path)(nil),
+		(*GetFileRequest_FileId)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_flood_v1_flood_proto_rawDesc), len(file_flood_v1_flood_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   12,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_flood_v1_flood_proto_goTypes,
+		DependencyIndexes: file_flood_v1_flood_proto_depIdxs,
+		MessageInfos:      file_flood_v1_flood_proto_msgTypes,
+	}.Build()
+	File_flood_v1_flood_proto = out.File
+	file_flood_v1_flood_proto_goTypes = nil
+	file_flood_v1_flood_proto_depIdxs = nil
+}
@@ -0,0 +1,263 @@
+// The gRPC control and event API of flood server mode, served on
+// -grpc-addr by builds with the grpc tag. See grpc.go.
+//
+// Go clients import github.com/crowdwave/flood/floodpb; Python clients are
+// generated into clients/python (see floodpb/generate.go).
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: flood/v1/flood.proto
+
+package floodpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Flood_Submit_FullMethodName    = "/flood.v1.Flood/Submit"
+	Flood_Subscribe_FullMethodName = "/flood.v1.Flood/Subscribe"
+	Flood_GetFile_FullMethodName   = "/flood.v1.Flood/GetFile"
+	Flood_GetQueues_FullMethodName = "/flood.v1.Flood/GetQueues"
+)
+
+// FloodClient is the client API for Flood service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FloodClient interface {
+	// Submit places a file in the incoming directory of a profile and bucket,
+	// from where it runs through the usual pipeline.
+	Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error)
+	// Subscribe streams file events as they happen. With -event-journal the
+	// events after after_seq are replayed first, so a client that reconnects
+	// with the seq of the last event it saw misses nothing. The stream ends
+	// with RESOURCE_EXHAUSTED if the client falls too far behind.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error)
+	// GetFile returns the records of a file, newest first.
+	GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (*GetFileResponse, error)
+	// GetQueues returns the files waiting per profile and bucket and the
+	// uploads in flight.
+	GetQueues(ctx context.Context, in *GetQueuesRequest, opts ...grpc.CallOption) (*GetQueuesResponse, error)
+}
+
+type floodClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFloodClient(cc grpc.ClientConnInterface) FloodClient {
+	return &floodClient{cc}
+}
+
+func (c *floodClient) Submit(ctx context.Context, in *SubmitRequest, opts ...grpc.CallOption) (*SubmitResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SubmitResponse)
+	err := c.cc.Invoke(ctx, Flood_Submit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *floodClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Event], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Flood_ServiceDesc.Streams[0], Flood_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, Event]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Flood_SubscribeClient = grpc.ServerStreamingClient[Event]
+
+func (c *floodClient) GetFile(ctx context.Context, in *GetFileRequest, opts ...grpc.CallOption) (*GetFileResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetFileResponse)
+	err := c.cc.Invoke(ctx, Flood_GetFile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *floodClient) GetQueues(ctx context.Context, in *GetQueuesRequest, opts ...grpc.CallOption) (*GetQueuesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetQueuesResponse)
+	err := c.cc.Invoke(ctx, Flood_GetQueues_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FloodServer is the server API for Flood service.
+// All implementations must embed UnimplementedFloodServer
+// for forward compatibility.
+type FloodServer interface {
+	// Submit places a file in the incoming directory of a profile and bucket,
+	// from where it runs through the usual pipeline.
+	Submit(context.Context, *SubmitRequest) (*SubmitResponse, error)
+	// Subscribe streams file events as they happen. With -event-journal the
+	// events after after_seq are replayed first, so a client that reconnects
+	// with the seq of the last event it saw misses nothing. The stream ends
+	// with RESOURCE_EXHAUSTED if the client falls too far behind.
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Event]) error
+	// GetFile returns the records of a file, newest first.
+	GetFile(context.Context, *GetFileRequest) (*GetFileResponse, error)
+	// GetQueues returns the files waiting per profile and bucket and the
+	// uploads in flight.
+	GetQueues(context.Context, *GetQueuesRequest) (*GetQueuesResponse, error)
+	mustEmbedUnimplementedFloodServer()
+}
+
+// UnimplementedFloodServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedFloodServer struct{}
+
+func (UnimplementedFloodServer) Submit(context.Context, *SubmitRequest) (*SubmitResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Submit not implemented")
+}
+func (UnimplementedFloodServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[Event]) error {
+	return status.Error(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedFloodServer) GetFile(context.Context, *GetFileRequest) (*GetFileResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetFile not implemented")
+}
+func (UnimplementedFloodServer) GetQueues(context.Context, *GetQueuesRequest) (*GetQueuesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetQueues not implemented")
+}
+func (UnimplementedFloodServer) mustEmbedUnimplementedFloodServer() {}
+func (UnimplementedFloodServer) testEmbeddedByValue()               {}
+
+// UnsafeFloodServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FloodServer will
+// result in compilation errors.
+type UnsafeFloodServer interface {
+	mustEmbedUnimplementedFloodServer()
+}
+
+func RegisterFloodServer(s grpc.ServiceRegistrar, srv FloodServer) {
+	// If the following call panics, it indicates UnimplementedFloodServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Flood_ServiceDesc, srv)
+}
+
+func _Flood_Submit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SubmitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FloodServer).Submit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flood_Submit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FloodServer).Submit(ctx, req.(*SubmitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Flood_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(FloodServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, Event]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Flood_SubscribeServer = grpc.ServerStreamingServer[Event]
+
+func _Flood_GetFile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFileRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FloodServer).GetFile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flood_GetFile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FloodServer).GetFile(ctx, req.(*GetFileRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Flood_GetQueues_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetQueuesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FloodServer).GetQueues(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Flood_GetQueues_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FloodServer).GetQueues(ctx, req.(*GetQueuesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Flood_ServiceDesc is the grpc.ServiceDesc for Flood service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Flood_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "flood.v1.Flood",
+	HandlerType: (*FloodServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Submit",
+			Handler:    _Flood_Submit_Handler,
+		},
+		{
+			MethodName: "GetFile",
+			Handler:    _Flood_GetFile_Handler,
+		},
+		{
+			MethodName: "GetQueues",
+			Handler:    _Flood_GetQueues_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Flood_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "flood/v1/flood.proto",
+}
@@ -0,0 +1,13 @@
+// Package floodpb holds the generated code of the gRPC API in
+// proto/flood/v1/flood.proto. Regenerate it, and the Python clients in
+// clients/python, after changing the proto file:
+//
+//	go generate ./floodpb
+//
+// This needs protoc with protoc-gen-go and protoc-gen-go-grpc on the PATH,
+// and Python with grpcio-tools.
+package floodpb
+
+//go:generate protoc -I ../proto --go_out=. --go_opt=module=github.com/crowdwave/flood/floodpb --go-grpc_out=. --go-grpc_opt=module=github.com/crowdwave/flood/floodpb flood/v1/flood.proto
+//go:generate mkdir -p ../clients/python
+//go:generate python3 -m grpc_tools.protoc -I ../proto --python_out=../clients/python --grpc_python_out=../clients/python flood/v1/flood.proto
@@ -0,0 +1,298 @@
+//go:build grpc
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/crowdwave/flood/floodpb"
+)
+
+// With -grpc-addr, server mode serves the gRPC API of
+// proto/flood/v1/flood.proto: submitting files, subscribing to file events
+// and querying state. It needs a build with the grpc tag:
+//
+//	go build -tags grpc
+//
+// Calls authenticate with a token in the "authorization" metadata as
+// "Bearer <token>" or a client certificate (see auth.go). Submit needs the
+// ingest scope, the other calls the read scope. A token bound to a tenant
+// only reaches the profiles of the tenant. Without any token the API is
+// not served.
+func startGRPC() {
+	if !authConfigured("read") && !authConfigured("ingest") {
+		log.Fatal("-grpc-addr needs a token with the read or ingest scope, see -auth-tokens-file")
+	}
+	listener, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		log.Fatalf("gRPC listener failed: %v", err)
+	}
+	options := []grpc.ServerOption{
+		grpc.UnaryInterceptor(grpcUnaryAuth),
+		grpc.StreamInterceptor(grpcStreamAuth),
+	}
+	if cfg := serverTLS(); cfg != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+	server := grpc.NewServer(options...)
+	floodpb.RegisterFloodServer(server, &grpcServer{})
+	log.Printf("Serving the gRPC API on %s", grpcAddr)
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Fatalf("gRPC listener failed: %v", err)
+		}
+	}()
+}
+
+// grpcAuthorize checks the credentials of a call of method and returns
+// the context of the call with the tenant of the client.
+func grpcAuthorize(ctx context.Context, method string) (context.Context, error) {
+	scope := "read"
+	if strings.HasSuffix(method, "/Submit") {
+		scope = "ingest"
+	}
+	var bearer string
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("authorization"); len(values) > 0 {
+		bearer = values[0]
+	}
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	tenant, err := authorize(bearer, state, scope)
+	if errors.Is(err, errUnauthenticated) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return withTenant(ctx, tenant), nil
+}
+
+func grpcUnaryAuth(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := grpcAuthorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func grpcStreamAuth(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := grpcAuthorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, tenantStream{ss, ctx})
+}
+
+// tenantStream is a server stream with the context of grpcAuthorize.
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s tenantStream) Context() context.Context { return s.ctx }
+
+type grpcServer struct {
+	floodpb.UnimplementedFloodServer
+}
+
+// grpcError converts the errors of the admin API to gRPC status errors.
+func grpcError(err error) error {
+	var apiErr apiError
+	if errors.As(err, &apiErr) {
+		switch apiErr.status {
+		case 400:
+			return status.Error(codes.InvalidArgument, apiErr.message)
+		case 404:
+			return status.Error(codes.NotFound, apiErr.message)
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+func (s *grpcServer) Submit(ctx context.Context, req *floodpb.SubmitRequest) (*floodpb.SubmitResponse, error) {
+	m := ingestMessage{
+		Profile: req.GetProfile(),
+		Bucket:  req.GetBucket(),
+		Key:     req.GetKey(),
+		tenant:  contextTenant(ctx),
+	}
+	if len(req.GetTags()) > 0 {
+		m.Sidecar = &sidecarMetadata{Tags: req.GetTags()}
+	}
+	if err := m.validateTarget(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(serverDir, "incoming_tmp"), "grpc-")
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	defer os.Remove(tmpPath)
+	if err := os.WriteFile(tmpPath, req.GetData(), 0644); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	dst, err := placeIncoming(m, tmpPath)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	log.Printf("Ingested %s through the gRPC API", dst)
+	return &floodpb.SubmitResponse{IncomingPath: dst}, nil
+}
+
+func (s *grpcServer) Subscribe(req *floodpb.SubscribeRequest, stream floodpb.Flood_SubscribeServer) error {
+	// Subscribe before replaying, so that no event falls in between.
+	live, cancel := subscribeEvents()
+	defer cancel()
+
+	tenant := contextTenant(stream.Context())
+	wanted := func(e lifecycleEvent) bool {
+		return (tenant == "" || e.Tenant == tenant) && (len(req.GetProfiles()) == 0 || slices.Contains(req.GetProfiles(), e.Profile))
+	}
+	// Events that are replayed may also arrive live; they are sent once.
+	replayed := make(map[int64]bool)
+	if last := req.GetAfterSeq(); last > 0 {
+		if !journalEvents {
+			return status.Error(codes.FailedPrecondition, "after_seq needs -event-journal")
+		}
+		for {
+			events, err := readJournal(last, 1000)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if len(events) == 0 {
+				break
+			}
+			for _, e := range events {
+				if wanted(e) {
+					if err := stream.Send(eventMessage(e)); err != nil {
+						return err
+					}
+				}
+				replayed[e.Seq] = true
+				last = e.Seq
+			}
+		}
+	}
+
+	for {
+		select {
+		case e, ok := <-live:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "subscriber fell behind; resubscribe with after_seq")
+			}
+			if replayed[e.Seq] || !wanted(e) {
+				continue
+			}
+			if err := stream.Send(eventMessage(e)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func eventMessage(e lifecycleEvent) *floodpb.Event {
+	return &floodpb.Event{
+		Seq:       e.Seq,
+		Event:     e.Event,
+		State:     e.State,
+		Time:      timestamppb.New(e.Time),
+		Hostname:  e.Hostname,
+		FileId:    e.FileID,
+		Profile:   e.Profile,
+		Bucket:    e.Bucket,
+		Key:       e.Key,
+		Path:      e.Path,
+		Retries:   int32(e.Retries),
+		Bytes:     e.Bytes,
+		Etag:      e.ETag,
+		VersionId: e.VersionID,
+		Error:     e.Error,
+		Tenant:    e.Tenant,
+	}
+}
+
+func (s *grpcServer) GetFile(ctx context.Context, req *floodpb.GetFileRequest) (*floodpb.GetFileResponse, error) {
+	records, err := lookupFile(req.GetPath(), req.GetFileId(), contextTenant(ctx))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &floodpb.GetFileResponse{Location: records[0].Location}
+	for _, r := range records {
+		record := &floodpb.FileRecord{
+			Id:        r.ID,
+			Profile:   r.Profile,
+			Bucket:    r.Bucket,
+			Path:      r.Path,
+			Key:       r.Key,
+			Operation: r.Operation,
+			Outcome:   r.Outcome,
+			Retries:   int32(r.Retries),
+			FileId:    r.FileID,
+			Etag:      r.ETag,
+			VersionId: r.Version,
+			Error:     r.Error,
+		}
+		if r.Time != nil {
+			record.Time = timestamppb.New(*r.Time)
+		}
+		resp.Records = append(resp.Records, record)
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) GetQueues(ctx context.Context, req *floodpb.GetQueuesRequest) (*floodpb.GetQueuesResponse, error) {
+	state := serverStatus{
+		PausedProfiles: pausedProfiles(),
+		Queues:         queueStatuses(),
+		Uploads:        inflightUploads(),
+	}
+	state.forTenant(contextTenant(ctx))
+	resp := &floodpb.GetQueuesResponse{Paused: isPaused(), PausedProfiles: state.PausedProfiles}
+	for _, q := range state.Queues {
+		resp.Queues = append(resp.Queues, &floodpb.Queue{
+			Profile: q.Profile,
+			Bucket:  q.Bucket,
+			Waiting: int32(q.Waiting),
+			Queued:  int32(q.Queued),
+		})
+	}
+	for _, u := range state.Uploads {
+		resp.Uploads = append(resp.Uploads, &floodpb.Upload{
+			FileId:     u.ID,
+			Profile:    u.Profile,
+			Bucket:     u.Bucket,
+			Key:        u.Key,
+			Size:       u.Size,
+			Sent:       u.Sent,
+			Throughput: u.Throughput,
+			Attempt:    int32(u.Attempt),
+			Started:    timestamppb.New(u.Started),
+		})
+	}
+	return resp, nil
+}
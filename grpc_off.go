@@ -0,0 +1,11 @@
+//go:build !grpc
+
+package main
+
+import "log"
+
+// startGRPC is replaced by the gRPC API in builds with the grpc tag; see
+// grpc.go.
+func startGRPC() {
+	log.Fatal("-grpc-addr needs flood built with the gRPC API: go build -tags grpc")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// With -admin-addr, server mode serves probes for Kubernetes and the like:
+//
+//	GET /readyz   200 once credentials, directories, database and watcher are fine
+//	GET /healthz  200 unless the pipeline stalled
+//	GET /status   queues, uploads in flight and recent failures for flood top
+//	GET /events   state transitions of files as server-sent events (see sse.go)
+//	/api/v1/...   admin API, with a token (see api.go)
+//	/ui/          web UI (see ui.go)
+//
+// The probes answer with a JSON object of their checks, and 503 if one failed.
+// The pipeline counts as stalled when files are queued for upload but no
+// upload has read data from disk and no file was settled for -stall-timeout.
+// A paused instance is never stalled.
+var (
+	lastProgress   atomic.Int64 // Unix nanoseconds
+	watcherRunning atomic.Bool
+)
+
+// markProgress records that the pipeline moved.
+func markProgress() {
+	lastProgress.Store(time.Now().UnixNano())
+}
+
+func serveAdmin() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
+	mux.HandleFunc("/status", requireScope("read", handleStatus))
+	mux.HandleFunc("GET /events", requireScope("read", handleEventStream))
+	registerAPI(mux)
+	registerUI(mux)
+	log.Printf("Serving /healthz, /readyz, /status, /events and /ui/ on %s", adminAddr)
+	if err := listenAndServe(adminAddr, mux); err != nil {
+		log.Fatalf("Admin listener failed: %v", err)
+	}
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{
+		"credentials": "ok",
+		"directories": "ok",
+		"database":    "ok",
+		"watcher":     "ok",
+	}
+	if len(profiles) == 0 {
+		checks["credentials"] = "no profiles loaded"
+	}
+	for _, dir := range mainDirs {
+		for name := range profiles {
+			path := filepath.Join(serverDir, dir, name)
+			if info, err := os.Stat(path); err != nil || !info.IsDir() {
+				checks["directories"] = path + " is missing"
+			}
+		}
+	}
+	_, err := db.Exec(
+		`INSERT INTO health_checks(host, pid, checked) VALUES (?, ?, ?)
+		ON CONFLICT(host, pid) DO UPDATE SET checked = excluded.checked`,
+		leaseHost, os.Getpid(), time.Now(),
+	)
+	if err != nil {
+		checks["database"] = err.Error()
+	}
+	if !watcherRunning.Load() {
+		checks["watcher"] = "not running"
+	}
+	writeProbe(w, checks)
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{"pipeline": "ok"}
+	if stall := pipelineStall(); stall != "" {
+		checks["pipeline"] = stall
+	}
+	writeProbe(w, checks)
+}
+
+// pipelineStall describes the stall of the pipeline, empty if there is none.
+func pipelineStall() string {
+	queued := 0
+	for _, q := range queues {
+		q.mu.Lock()
+		queued += len(q.pending)
+		q.mu.Unlock()
+	}
+	if queued == 0 || isPaused() {
+		// Idle time is not a stall
+		markProgress()
+	}
+	since := time.Since(time.Unix(0, lastProgress.Load())).Round(time.Second)
+	if since > stallTimeout {
+		return fmt.Sprintf("stalled: %d file(s) queued, no progress for %s", queued, since)
+	}
+	return ""
+}
+
+func writeProbe(w http.ResponseWriter, checks map[string]string) {
+	status := http.StatusOK
+	for _, result := range checks {
+		if result != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"ok": status == http.StatusOK, "checks": checks})
+}
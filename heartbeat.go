@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// With -heartbeat-file or -heartbeat-url, server mode acts as a dead man's
+// switch for external monitoring: every -heartbeat-interval it touches the
+// file and requests the URL, but only while the watcher runs and the
+// pipeline is not stalled (see -stall-timeout). A hung daemon, even one
+// whose process is still alive, stops beating; the monitor alerts when the
+// file grows old or the URL is not requested in time.
+func runHeartbeat() {
+	healthy := true
+	for ; ; time.Sleep(heartbeatInterval) {
+		problem := pipelineStall()
+		if !watcherRunning.Load() {
+			problem = "watcher is not running"
+		}
+		if problem != "" {
+			if healthy {
+				log.Printf("Heartbeat stopped: %s", problem)
+			}
+			healthy = false
+			continue
+		}
+		if !healthy {
+			log.Printf("Heartbeat resumed")
+		}
+		healthy = true
+		beat()
+	}
+}
+
+func beat() {
+	if heartbeatFile != "" {
+		now := time.Now()
+		err := os.Chtimes(heartbeatFile, now, now)
+		if os.IsNotExist(err) {
+			var f *os.File
+			if f, err = os.Create(heartbeatFile); err == nil {
+				err = f.Close()
+			}
+		}
+		if err != nil {
+			log.Printf("Failed to touch heartbeat file %s: %v", heartbeatFile, err)
+		}
+	}
+	if heartbeatURL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, heartbeatURL, nil)
+		if err != nil {
+			log.Printf("Invalid heartbeat URL: %v", err)
+			return
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("Heartbeat request failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("Heartbeat request failed: %s", resp.Status)
+		}
+	}
+}
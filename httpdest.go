@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// httpDestination delivers files to an HTTP endpoint, one request per file
+// with the file as the raw request body:
+//
+//	provider = http
+//	http_url = https://ingest.example/v1/{{.Bucket}}/{{.Key}}
+//	http_method = PUT               ; PUT (default) or POST
+//	http_bearer_token = ...         ; or http_username and http_password
+//	http_header_x-source = flood    ; extra request headers
+//
+// The URL template has the fields Profile, Bucket, Key (path escaped, with
+// slashes kept) and Hostname. 5xx, 408 and 429 responses are retried; other
+// 4xx responses fail the file.
+type httpDestination struct {
+	profile  string
+	url      *template.Template
+	method   string
+	username string
+	password string
+	token    string
+	headers  http.Header
+}
+
+type httpURLData struct {
+	Profile  string
+	Bucket   string
+	Key      string
+	Hostname string
+}
+
+// httpStatusError is returned for responses other than 2xx.
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *httpStatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected response %s", e.Status)
+	}
+	return fmt.Sprintf("unexpected response %s: %s", e.Status, e.Body)
+}
+
+func (e *httpStatusError) HTTPStatusCode() int {
+	return e.StatusCode
+}
+
+var httpClient = &http.Client{}
+
+func newHTTPDestination(name string, settings map[string]string) (destination, error) {
+	tmpl, err := template.New("http_url").Option("missingkey=error").Parse(settings["http_url"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid http_url: %w", err)
+	}
+	d := &httpDestination{
+		profile:  name,
+		url:      tmpl,
+		method:   http.MethodPut,
+		username: settings["http_username"],
+		password: settings["http_password"],
+		token:    settings["http_bearer_token"],
+		headers:  make(http.Header),
+	}
+	if _, err := d.requestURL("bucket", "key"); err != nil {
+		return nil, fmt.Errorf("invalid http_url: %w", err)
+	}
+
+	if value := settings["http_method"]; value != "" {
+		d.method = strings.ToUpper(value)
+		if d.method != http.MethodPut && d.method != http.MethodPost {
+			return nil, fmt.Errorf("invalid http_method %q: must be PUT or POST", value)
+		}
+	}
+	if d.token != "" && d.username != "" {
+		return nil, fmt.Errorf("http_bearer_token and http_username are mutually exclusive")
+	}
+	for key, value := range settings {
+		if name, ok := strings.CutPrefix(key, "http_header_"); ok {
+			d.headers.Set(name, value)
+		}
+	}
+	return d, nil
+}
+
+func (d *httpDestination) requestURL(bucket, key string) (string, error) {
+	var b strings.Builder
+	data := httpURLData{Profile: d.profile, Bucket: bucket, Key: escapeKey(key), Hostname: hostname}
+	if err := d.url.Execute(&b, data); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(b.String())
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%s is not an http or https URL", b.String())
+	}
+	return u.String(), nil
+}
+
+// escapeKey percent-encodes the segments of a key, keeping the slashes.
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// validate has nothing to check: an HTTP endpoint has no notion of buckets
+// beyond the URL.
+func (d *httpDestination) validate(bucket string) error {
+	return nil
+}
+
+func (d *httpDestination) upload(rec fileRecord) (uploadResult, error) {
+	target, err := d.requestURL(rec.Bucket, rec.Key)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to build URL for %s: %w", rec.Key, err)
+	}
+
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to open file %s: %w", rec.Path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to stat file %s: %w", rec.Path, err)
+	}
+	defer reserveUploadMemory(rec.Path, 1)()
+
+	req, err := http.NewRequest(d.method, target, uploadBody(f, rec.Profile))
+	if err != nil {
+		return uploadResult{}, err
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	for name, values := range d.headers {
+		req.Header[name] = values
+	}
+	rec.Meta.applyToRequest(req)
+	switch {
+	case d.token != "":
+		req.Header.Set("Authorization", "Bearer "+d.token)
+	case d.username != "":
+		req.SetBasicAuth(d.username, d.password)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to %s %s: %w", d.method, target, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return uploadResult{}, &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status, Body: strings.TrimSpace(string(body))}
+	}
+	io.Copy(io.Discard, resp.Body)
+	log.Printf("Delivered %s to %s (%s)", rec.Path, target, resp.Status)
+	return uploadResult{}, nil
+}
+
+func (d *httpDestination) matches(bucket, key, path string) (bool, error) {
+	return false, errNotImplemented
+}
+
+// transient retries server errors, timeouts, rate limiting and network
+// failures; any other response is final.
+func (d *httpDestination) transient(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		code := statusErr.StatusCode
+		return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || isTransientError(err)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// inflightUpload is an upload that uploadWithRetry is running, for the
+// /status endpoint that flood top shows. sent counts the bytes read from
+// disk in the current attempt; a body that the SDK rewinds counts down
+// again.
+type inflightUpload struct {
+	ID      string
+	Profile string
+	Bucket  string
+	Key     string
+	Path    string
+	Size    int64
+	Started time.Time
+
+	sent           atomic.Int64
+	attempt        atomic.Int32
+	attemptStarted atomic.Int64 // Unix nanoseconds
+	retryAt        atomic.Int64 // Unix nanoseconds of the next attempt while waiting, else 0
+
+	cancel     chan struct{} // closed when the upload is cancelled, see cancel.go
+	cancelOnce sync.Once
+}
+
+// cancelUpload aborts the upload: reads of its body fail and a retry it is
+// waiting for does not happen.
+func (u *inflightUpload) cancelUpload() {
+	u.cancelOnce.Do(func() { close(u.cancel) })
+}
+
+func (u *inflightUpload) cancelled() bool {
+	select {
+	case <-u.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// startAttempt resets the progress for a new attempt.
+func (u *inflightUpload) startAttempt(attempt int) {
+	u.sent.Store(0)
+	u.attempt.Store(int32(attempt))
+	u.attemptStarted.Store(time.Now().UnixNano())
+	u.retryAt.Store(0)
+}
+
+// inflightStatus is an inflightUpload in the /status response.
+type inflightStatus struct {
+	ID         string     `json:"id"`
+	Profile    string     `json:"profile"`
+	Bucket     string     `json:"bucket"`
+	Key        string     `json:"key"`
+	Size       int64      `json:"size"`
+	Sent       int64      `json:"sent"`
+	Throughput int64      `json:"throughput"` // bytes per second in the current attempt
+	Attempt    int        `json:"attempt"`
+	Started    time.Time  `json:"started"`
+	RetryAt    *time.Time `json:"retry_at,omitempty"`
+}
+
+var inflight struct {
+	mu      sync.Mutex
+	uploads map[string]*inflightUpload // keyed by inflightKey
+}
+
+func inflightKey(profile, path string) string {
+	return profile + "\x00" + path
+}
+
+// trackUpload registers the upload of rec until the returned function is
+// called.
+func trackUpload(rec *fileRecord) (*inflightUpload, func()) {
+	u := &inflightUpload{ID: rec.ID, Profile: rec.Profile.Name, Bucket: rec.Bucket, Key: rec.Key, Path: rec.Path, Started: time.Now(), cancel: make(chan struct{})}
+	u.startAttempt(rec.Retries)
+	if info, err := os.Stat(rec.Path); err == nil {
+		u.Size = info.Size()
+	}
+	key := inflightKey(rec.Profile.Name, rec.Path)
+	inflight.mu.Lock()
+	if inflight.uploads == nil {
+		inflight.uploads = make(map[string]*inflightUpload)
+	}
+	inflight.uploads[key] = u
+	inflight.mu.Unlock()
+	if q, ok := queues[rec.Profile.Name]; ok && q.takeCancelRequest(rec.Path) {
+		// Cancelled after its worker picked it up
+		u.cancelUpload()
+	}
+	if drainInterrupted() {
+		u.cancelUpload()
+	}
+	return u, func() {
+		inflight.mu.Lock()
+		defer inflight.mu.Unlock()
+		if inflight.uploads[key] == u {
+			delete(inflight.uploads, key)
+		}
+	}
+}
+
+// uploadProgress returns the upload that reads r, if r is a file or a
+// section of a file being uploaded by the profile.
+func uploadProgress(r io.ReadSeeker, profile Profile) *inflightUpload {
+	if s, ok := r.(*io.SectionReader); ok {
+		outer, _, _ := s.Outer()
+		if f, ok := outer.(*os.File); ok {
+			r = f
+		}
+	}
+	f, ok := r.(*os.File)
+	if !ok {
+		return nil
+	}
+	inflight.mu.Lock()
+	defer inflight.mu.Unlock()
+	return inflight.uploads[inflightKey(profile.Name, f.Name())]
+}
+
+// findUpload returns the upload in flight with the correlation ID, or nil.
+func findUpload(id string) *inflightUpload {
+	inflight.mu.Lock()
+	defer inflight.mu.Unlock()
+	for _, u := range inflight.uploads {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// lookupUpload returns the upload in flight of the file of the profile, or
+// nil.
+func lookupUpload(profile, path string) *inflightUpload {
+	inflight.mu.Lock()
+	defer inflight.mu.Unlock()
+	return inflight.uploads[inflightKey(profile, path)]
+}
+
+func inflightUploads() []inflightStatus {
+	inflight.mu.Lock()
+	defer inflight.mu.Unlock()
+	var uploads []inflightStatus
+	for _, u := range inflight.uploads {
+		s := inflightStatus{
+			ID:      u.ID,
+			Profile: u.Profile,
+			Bucket:  u.Bucket,
+			Key:     u.Key,
+			Size:    u.Size,
+			Sent:    u.sent.Load(),
+			Attempt: int(u.attempt.Load()),
+			Started: u.Started,
+		}
+		if elapsed := time.Since(time.Unix(0, u.attemptStarted.Load())).Seconds(); elapsed > 0 {
+			s.Throughput = int64(float64(s.Sent) / elapsed)
+		}
+		if at := u.retryAt.Load(); at != 0 {
+			t := time.Unix(0, at)
+			s.RetryAt = &t
+		}
+		uploads = append(uploads, s)
+	}
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Started.Before(uploads[j].Started) })
+	return uploads
+}
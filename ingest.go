@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// With -ingest-addr, server mode accepts files over HTTP from producers
+// that share no file system with it:
+//
+//	curl -T app.log -H "Authorization: Bearer $TOKEN" http://flood:8082/ingest/backup/logs/2024/app.log
+//
+// PUT and POST write the body to incoming_tmp and move it to
+// incoming/<profile>/<bucket>/<key> once complete, from where the usual
+// pipeline takes over; the response is 201 with the incoming path. As with
+// S3, Cache-Control, Content-Disposition and Content-Encoding headers and
+// X-Flood-Meta-* headers become object metadata, and X-Flood-Tagging
+// (key1=value1&key2=value2) the object tags. Clients need the ingest scope
+// (see auth.go); if nobody is granted it, anybody who can reach the
+// listener can submit files.
+func serveIngest() {
+	if !authConfigured("ingest") {
+		log.Printf("Warning: the ingest listener on %s accepts files without authentication", ingestAddr)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest/{profile}/{bucket}/{key...}", requireScope("ingest", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, "only PUT and POST are supported", http.StatusMethodNotAllowed)
+			return
+		}
+		handleIngest(w, r)
+	}))
+	log.Printf("Accepting files on %s/ingest", ingestAddr)
+	if err := listenAndServe(ingestAddr, mux); err != nil {
+		log.Fatalf("Ingest listener failed: %v", err)
+	}
+}
+
+func handleIngest(w http.ResponseWriter, r *http.Request) {
+	if isDraining() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	m := ingestMessage{
+		Profile: r.PathValue("profile"),
+		Bucket:  r.PathValue("bucket"),
+		Key:     r.PathValue("key"),
+		tenant:  contextTenant(r.Context()),
+	}
+	sidecar, err := ingestSidecar(r.Header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.Sidecar = sidecar
+	if err := m.validateTarget(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(serverDir, "incoming_tmp"), "http-")
+	if err != nil {
+		log.Printf("Failed to ingest %s/%s/%s: %v", m.Profile, m.Bucket, m.Key, err)
+		http.Error(w, "failed to store the file", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Mostly a client that went away mid-upload
+		log.Printf("Failed to receive %s/%s/%s after %d bytes: %v", m.Profile, m.Bucket, m.Key, n, err)
+		http.Error(w, "failed to receive the file", http.StatusBadRequest)
+		return
+	}
+	dst, err := placeIncoming(m, tmp.Name())
+	if err != nil {
+		log.Printf("Failed to ingest %s/%s/%s: %v", m.Profile, m.Bucket, m.Key, err)
+		http.Error(w, "failed to store the file", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Ingested %s (%s) from %s", dst, formatBytes(n), r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"incoming_path": dst, "bytes": n})
+}
+
+// ingestSidecar returns the sidecar described by the request headers, nil
+// if there is none.
+func ingestSidecar(header http.Header) (*sidecarMetadata, error) {
+	s := &sidecarMetadata{
+		CacheControl:       header.Get("Cache-Control"),
+		ContentDisposition: header.Get("Content-Disposition"),
+		ContentEncoding:    header.Get("Content-Encoding"),
+	}
+	for name, values := range header {
+		if key, ok := strings.CutPrefix(name, "X-Flood-Meta-"); ok && key != "" {
+			if s.Metadata == nil {
+				s.Metadata = make(map[string]string)
+			}
+			s.Metadata[strings.ToLower(key)] = values[0]
+		}
+	}
+	if tagging := header.Get("X-Flood-Tagging"); tagging != "" {
+		values, err := url.ParseQuery(tagging)
+		if err != nil {
+			return nil, fmt.Errorf("invalid X-Flood-Tagging: %w", err)
+		}
+		s.Tags = make(map[string]string)
+		for key, v := range values {
+			s.Tags[key] = v[0]
+		}
+	}
+	if s.CacheControl == "" && s.ContentDisposition == "" && s.ContentEncoding == "" && s.Metadata == nil && s.Tags == nil {
+		return nil, nil
+	}
+	return s, nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// inventoryBatch is the number of rows inserted per statement.
+const inventoryBatch = 500
+
+// runImportInventoryMode seeds the database with objects uploaded before
+// flood was used:
+//
+//	flood import-inventory -profile name [-bucket name] inventory.csv
+//	flood import-inventory -profile name manifest.json
+//
+// A CSV file needs a header row naming its columns: key, and optionally
+// bucket, size, etag and last_modified. An S3 Inventory manifest.json is
+// read with the profile's credentials from its destination bucket, using
+// the columns of its fileSchema. Every object becomes a successful record
+// with operation "import"; with skip_existing, a file whose key, size and
+// ETag match an imported object is not uploaded again, without asking the
+// provider.
+func runImportInventoryMode(args []string) {
+	fs := flag.NewFlagSet("import-inventory", flag.ExitOnError)
+	profileName := fs.String("profile", "", "Profile the objects were uploaded with")
+	bucket := fs.String("bucket", "", "Bucket of the objects, if the inventory has no bucket column")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] import-inventory [import flags] inventory.csv|manifest.json")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *profileName == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	profile, ok := profiles[*profileName]
+	if !ok {
+		log.Fatalf("Unknown profile: %s", *profileName)
+	}
+
+	imp := &inventoryImport{profile: profile, bucket: *bucket}
+	var err error
+	if strings.HasSuffix(fs.Arg(0), ".json") {
+		err = imp.manifest(fs.Arg(0))
+	} else {
+		err = imp.file(fs.Arg(0))
+	}
+	if err == nil {
+		err = imp.flush()
+	}
+	if err != nil {
+		log.Fatalf("Import failed after %d object(s): %v", imp.imported, err)
+	}
+	log.Printf("Imported %d object(s) for profile %s, skipped %d row(s)", imp.imported, profile.Name, imp.skipped)
+}
+
+type inventoryImport struct {
+	profile  Profile
+	bucket   string
+	pending  []any // arguments of the rows not inserted yet
+	imported int
+	skipped  int
+}
+
+// s3Manifest is the part of an S3 Inventory manifest.json that is used.
+type s3Manifest struct {
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+func (imp *inventoryImport) manifest(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var m s3Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	if !strings.EqualFold(m.FileFormat, "CSV") {
+		return fmt.Errorf("unsupported inventory format %s: only CSV can be imported", m.FileFormat)
+	}
+	var columns []string
+	for _, column := range strings.Split(m.FileSchema, ",") {
+		columns = append(columns, strings.TrimSpace(column))
+	}
+	bucket := m.DestinationBucket[strings.LastIndex(m.DestinationBucket, ":")+1:]
+
+	client := newS3Client(imp.profile)
+	for _, file := range m.Files {
+		log.Printf("Importing s3://%s/%s/%s", imp.profile.Name, bucket, file.Key)
+		out, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(file.Key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get inventory file %s: %w", file.Key, err)
+		}
+		err = imp.read(out.Body, columns, true)
+		out.Body.Close()
+		if err != nil {
+			return fmt.Errorf("inventory file %s: %w", file.Key, err)
+		}
+	}
+	return nil
+}
+
+func (imp *inventoryImport) file(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return imp.read(f, nil, false)
+}
+
+// read imports the rows of a CSV inventory, optionally gzip compressed.
+// Without columns they are taken from the header row. Keys in S3 Inventory
+// files are URL encoded.
+func (imp *inventoryImport) read(r io.Reader, columns []string, escapedKeys bool) error {
+	buffered, err := gzipOrPlain(r)
+	if err != nil {
+		return err
+	}
+	cr := csv.NewReader(buffered)
+	cr.FieldsPerRecord = -1
+	if columns == nil {
+		if columns, err = cr.Read(); err != nil {
+			return fmt.Errorf("failed to read header: %w", err)
+		}
+	}
+	index := make(map[string]int)
+	for i, column := range columns {
+		name := strings.ToLower(strings.NewReplacer("_", "", " ", "").Replace(column))
+		index[name] = i
+	}
+	field := func(row []string, names ...string) string {
+		for _, name := range names {
+			if i, ok := index[name]; ok && i < len(row) {
+				return row[i]
+			}
+		}
+		return ""
+	}
+	if _, ok := index["key"]; !ok {
+		return fmt.Errorf("inventory has no key column")
+	}
+
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if field(row, "isdeletemarker") == "true" || field(row, "islatest") == "false" {
+			imp.skipped++
+			continue
+		}
+		key := field(row, "key")
+		if escapedKeys {
+			if key, err = url.QueryUnescape(key); err != nil {
+				imp.skipped++
+				continue
+			}
+		}
+		bucket := field(row, "bucket")
+		if imp.bucket != "" {
+			bucket = imp.bucket
+		}
+		if key == "" || bucket == "" {
+			imp.skipped++
+			continue
+		}
+		var size sql.NullInt64
+		if value := field(row, "size"); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			size = sql.NullInt64{Int64: n, Valid: err == nil}
+		}
+		modified := time.Now()
+		if t, err := time.Parse(time.RFC3339, field(row, "lastmodified", "lastmodifieddate")); err == nil {
+			modified = t
+		}
+
+		imp.pending = append(imp.pending, imp.profile.Name, bucket, modified, key, key, imp.profile.Name, size, optionalString(field(row, "etag")), optionalString(imp.profile.tenantName()))
+		if len(imp.pending) >= inventoryBatch*9 {
+			if err := imp.flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// flush inserts the pending rows.
+func (imp *inventoryImport) flush() error {
+	rows := len(imp.pending) / 9
+	if rows == 0 {
+		return nil
+	}
+	values := strings.TrimSuffix(strings.Repeat("(?, ?, '', 0, ?, 'success', ?, ?, ?, 'import', ?, 0, ?, ?), ", rows), ", ")
+	_, err := db.Exec("INSERT INTO file_records(profile, bucket, filepath, retries, last_retry, upload_outcome, original_path, object_key, accepted_by, operation, bytes, attempts, etag, tenant) VALUES "+values, imp.pending...)
+	if err != nil {
+		return err
+	}
+	imp.imported += rows
+	imp.pending = imp.pending[:0]
+	return nil
+}
+
+// gzipOrPlain decompresses r if it starts with the gzip magic number.
+func gzipOrPlain(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// alreadyPresent reports whether the object at key matches the local file,
+// according to an imported inventory or else the destination.
+func alreadyPresent(profile Profile, bucket, key, path string) (bool, error) {
+	var (
+		size sql.NullInt64
+		etag string
+	)
+	err := db.QueryRow(
+		"SELECT bytes, COALESCE(etag, '') FROM file_records WHERE profile = ? AND bucket = ? AND object_key = ? AND operation = 'import' ORDER BY id DESC LIMIT 1",
+		profile.Name, bucket, key,
+	).Scan(&size, &etag)
+	switch {
+	case err == nil && size.Valid:
+		present, err := contentMatches(profile, key, path, size.Int64, etag)
+		if err == nil && present {
+			return true, nil
+		}
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		log.Printf("Failed to look up %s in the imported inventory: %v", key, err)
+	}
+	return destinationFor(profile).matches(bucket, key, path)
+}
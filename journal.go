@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With -event-journal, every file event (see lifecycleEvent) is also stored
+// in the event_journal table before it is published, under a sequence
+// number that increases with every event. The event carries it as "seq",
+// in the sinks too. Other processes read the journal from a cursor:
+//
+//	flood events [-after 1234 | -cursor-file events.cursor] [-follow]
+//
+// prints the events after the cursor as JSON lines. With -cursor-file the
+// sequence number of the last event printed is saved, so a consumer that
+// restarts continues where it stopped and misses nothing, whether or not
+// flood was running meanwhile. Rows are pruned with the other history
+// (see -db-retention).
+
+// journalGapWait is how long a reader waits for a missing sequence number.
+// PostgreSQL and MySQL hand out numbers before the insert commits, so a
+// later event may be visible before an earlier one; a number that is still
+// missing after this long belongs to an insert that was rolled back.
+const journalGapWait = 10 * time.Second
+
+// journalEvent stores e and sets its sequence number.
+func journalEvent(e *lifecycleEvent) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Fatalf("Failed to encode %s event for %s: %v", e.Event, e.Path, err)
+	}
+	seq, err := db.insert("INSERT INTO event_journal(recorded, event, body) VALUES (?, ?, ?)", e.Time, e.Event, string(body))
+	if err != nil {
+		log.Fatalf("Failed to record %s event for %s in the journal: %v", e.Event, e.Path, err)
+	}
+	e.Seq = seq
+}
+
+func runEventsMode(args []string) {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	after := fs.Int64("after", 0, "Print the events with a sequence number greater than this")
+	cursorFile := fs.String("cursor-file", "", "File that holds the sequence number of the last event printed; read at start and updated as events are printed")
+	follow := fs.Bool("follow", false, "Keep printing new events as they are recorded")
+	interval := fs.Duration("interval", time.Second, "How often to look for new events with -follow")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] events [events flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *interval <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cursor := *after
+	if *cursorFile != "" {
+		data, err := os.ReadFile(*cursorFile)
+		switch {
+		case err == nil:
+			if cursor, err = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err != nil {
+				log.Fatalf("Invalid cursor in %s: %v", *cursorFile, err)
+			}
+		case !os.IsNotExist(err):
+			log.Fatal(err)
+		}
+	}
+
+	for {
+		next, err := printJournal(cursor, *follow)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if next != cursor && *cursorFile != "" {
+			if err := os.WriteFile(*cursorFile, []byte(strconv.FormatInt(next, 10)+"\n"), 0644); err != nil {
+				log.Fatal(err)
+			}
+		}
+		caughtUp := next == cursor
+		cursor = next
+		if caughtUp {
+			if !*follow {
+				return
+			}
+			time.Sleep(*interval)
+		}
+	}
+}
+
+// printJournal prints the events after cursor and returns the sequence
+// number of the last one printed. With waitForGaps it stops before a
+// missing sequence number that may still be committed.
+func printJournal(cursor int64, waitForGaps bool) (int64, error) {
+	events, err := readJournal(cursor, 1000)
+	if err != nil {
+		return cursor, err
+	}
+	for _, e := range events {
+		if waitForGaps && e.Seq != cursor+1 && time.Since(e.Time) < journalGapWait {
+			break
+		}
+		line, _ := json.Marshal(e)
+		if _, err := fmt.Printf("%s\n", line); err != nil {
+			return cursor, err
+		}
+		cursor = e.Seq
+	}
+	return cursor, nil
+}
+
+// readJournal returns up to limit events after cursor.
+func readJournal(cursor int64, limit int) ([]lifecycleEvent, error) {
+	rows, err := db.Query(fmt.Sprintf("SELECT id, body FROM event_journal WHERE id > ? ORDER BY id LIMIT %d", limit), cursor)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []lifecycleEvent
+	for rows.Next() {
+		var (
+			seq  int64
+			body string
+		)
+		if err := rows.Scan(&seq, &body); err != nil {
+			return nil, err
+		}
+		var e lifecycleEvent
+		if err := json.Unmarshal([]byte(body), &e); err != nil {
+			return nil, fmt.Errorf("invalid event %d: %w", seq, err)
+		}
+		e.Seq = seq
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
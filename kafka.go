@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// kafkaEventSender produces events to -kafka-topic on -kafka-brokers. The
+// message key is profile/bucket/key, so the events of a file land in the
+// same partition and stay in order; the headers event and schema_version
+// let consumers route messages without decoding them. With -kafka-sasl
+// the password is read from -kafka-password-file or FLOOD_KAFKA_PASSWORD.
+func kafkaEventSender() func(e lifecycleEvent, body []byte) error {
+	transport := &kafka.Transport{}
+	if kafkaTLS {
+		transport.TLS = &tls.Config{}
+	}
+	if kafkaSASL != "" {
+		mechanism, err := kafkaMechanism()
+		if err != nil {
+			log.Fatalf("Invalid Kafka SASL settings: %v", err)
+		}
+		transport.SASL = mechanism
+	}
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(kafkaBrokers, ",")...),
+		Topic:        kafkaTopic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	return func(e lifecycleEvent, body []byte) error {
+		return w.WriteMessages(context.TODO(), kafka.Message{
+			Key:   []byte(eventPartitionKey(e)),
+			Value: body,
+			Time:  e.Time,
+			Headers: []kafka.Header{
+				{Key: "event", Value: []byte(e.Event)},
+				{Key: "schema_version", Value: []byte(strconv.Itoa(e.SchemaVersion))},
+			},
+		})
+	}
+}
+
+func kafkaMechanism() (sasl.Mechanism, error) {
+	password := os.Getenv("FLOOD_KAFKA_PASSWORD")
+	if kafkaPasswordFile != "" {
+		data, err := os.ReadFile(kafkaPasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read -kafka-password-file: %w", err)
+		}
+		password = strings.TrimSpace(string(data))
+	}
+	switch kafkaSASL {
+	case "plain":
+		return plain.Mechanism{Username: kafkaUser, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, kafkaUser, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, kafkaUser, password)
+	}
+	return nil, fmt.Errorf("unknown -kafka-sasl %q: must be plain, scram-sha-256 or scram-sha-512", kafkaSASL)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// keyTemplateData is the data available to key prefix templates, e.g.
+// key_prefix = {{.Hostname}}/{{.Date "2006/01/02"}}/
+type keyTemplateData struct {
+	Hostname string
+	Profile  string
+	Bucket   string
+	Time     time.Time
+}
+
+// Date formats the upload time (UTC) with a Go time layout.
+func (d keyTemplateData) Date(layout string) string {
+	return d.Time.Format(layout)
+}
+
+var hostname = func() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return name
+}()
+
+func parseKeyPrefix(value string) (*template.Template, error) {
+	tmpl, err := template.New("key_prefix").Option("missingkey=error").Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	// Render once so that mistakes such as unknown fields abort at startup
+	// rather than failing every upload.
+	if err := tmpl.Execute(&strings.Builder{}, keyTemplateData{Time: time.Now()}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// keyRule is a single key rewrite rule. Rules are configured as numbered
+// profile keys and applied in order:
+//
+//	key_rewrite_1 = lowercase
+//	key_rewrite_2 = strip_prefix exports/
+//	key_rewrite_3 = replace ^(\d{4})-(\d{2})-(.*)$ $1/$2/$3
+type keyRule struct {
+	action  string
+	arg     string
+	pattern *regexp.Regexp
+}
+
+func parseKeyRule(value string) (keyRule, error) {
+	action, arg, _ := strings.Cut(strings.TrimSpace(value), " ")
+	arg = strings.TrimSpace(arg)
+	switch action {
+	case "lowercase":
+		return keyRule{action: action}, nil
+	case "strip_prefix":
+		if arg == "" {
+			return keyRule{}, fmt.Errorf("strip_prefix requires a prefix")
+		}
+		return keyRule{action: action, arg: arg}, nil
+	case "replace":
+		expr, replacement, ok := strings.Cut(arg, " ")
+		if !ok {
+			return keyRule{}, fmt.Errorf("replace requires a pattern and a replacement")
+		}
+		pattern, err := regexp.Compile(expr)
+		if err != nil {
+			return keyRule{}, err
+		}
+		return keyRule{action: action, arg: strings.TrimSpace(replacement), pattern: pattern}, nil
+	}
+	return keyRule{}, fmt.Errorf("unknown rule %q", action)
+}
+
+// parseKeyRules collects the key_rewrite_N settings of a profile in order.
+func parseKeyRules(settings map[string]string) ([]keyRule, error) {
+	values := make(map[int]string)
+	var numbers []int
+	for name, value := range settings {
+		suffix, ok := strings.CutPrefix(name, "key_rewrite_")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(suffix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rule name %s: expected key_rewrite_<number>", name)
+		}
+		values[n] = value
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	var rules []keyRule
+	for _, n := range numbers {
+		rule, err := parseKeyRule(values[n])
+		if err != nil {
+			return nil, fmt.Errorf("key_rewrite_%d: %w", n, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (r keyRule) apply(key string) string {
+	switch r.action {
+	case "lowercase":
+		return strings.ToLower(key)
+	case "strip_prefix":
+		return strings.TrimPrefix(key, r.arg)
+	case "replace":
+		return r.pattern.ReplaceAllString(key, r.arg)
+	}
+	return key
+}
+
+// objectKey maps the path of a file relative to its bucket directory to the
+// object key: the profile's rewrite rules are applied to the path and the
+// rendered key prefix is prepended.
+func objectKey(profile Profile, bucket, relativePath string) (string, error) {
+	key := strings.ReplaceAll(relativePath, string(os.PathSeparator), "/")
+	for _, rule := range profile.KeyRules {
+		key = rule.apply(key)
+	}
+	key = strings.TrimPrefix(key, "/")
+	if key == "" {
+		return "", fmt.Errorf("key rewrite rules produced an empty key for %s", relativePath)
+	}
+	if profile.KeyPrefix == nil {
+		return key, nil
+	}
+
+	var prefix strings.Builder
+	data := keyTemplateData{
+		Hostname: hostname,
+		Profile:  profile.Name,
+		Bucket:   bucket,
+		Time:     time.Now().UTC(),
+	}
+	if err := profile.KeyPrefix.Execute(&prefix, data); err != nil {
+		return "", fmt.Errorf("failed to render key prefix: %w", err)
+	}
+	// The key is kept as it is; only the slashes around the prefix go.
+	p := strings.Trim(prefix.String(), "/")
+	if p == "" {
+		return key, nil
+	}
+	return p + "/" + key, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestObjectKey(t *testing.T) {
+	rules, err := parseKeyRules(map[string]string{
+		"key_rewrite_1": "strip_prefix exports/",
+		"key_rewrite_2": "lowercase",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := func(value string) Profile {
+		tmpl, err := parseKeyPrefix(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Profile{Name: "logs", KeyPrefix: tmpl}
+	}
+	tests := []struct {
+		name    string
+		profile Profile
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"plain", Profile{}, "a/b.txt", "a/b.txt", false},
+		{"rules", Profile{KeyRules: rules}, "exports/A/B.TXT", "a/b.txt", false},
+		{"empty key", Profile{KeyRules: rules}, "exports/", "", true},
+		{"prefix", prefix("archive/{{.Profile}}/"), "a/b.txt", "archive/logs/a/b.txt", false},
+		{"prefix without slash", prefix("archive"), "a/b.txt", "archive/a/b.txt", false},
+		{"empty prefix", prefix("{{if false}}x{{end}}"), "a/b.txt", "a/b.txt", false},
+		{"key kept as is", prefix("archive/"), "a/../b//c.txt", "archive/a/../b//c.txt", false},
+	}
+	for _, tt := range tests {
+		got, err := objectKey(tt.profile, "bucket", tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: objectKey(%q) error = %v, want error %t", tt.name, tt.path, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: objectKey(%q) = %q, want %q", tt.name, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"time"
+)
+
+// Several flood instances may share a server directory and database, by
+// accident or for availability. Before uploading a file an instance claims
+// it with a row in file_leases naming its host and pid; the insert fails if
+// another instance holds the file. The lease is extended while the upload
+// runs and deleted afterwards. A lease that was not extended within
+// -lease-ttl belongs to an instance that died and is taken over. Leases are
+// keyed by leaseKey and expire at Unix nanoseconds.
+
+var leaseHost, _ = os.Hostname()
+
+// leaseKey is the key of the lease of a file.
+func leaseKey(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:])
+}
+
+// claimJob leases the files of a job. It returns false if another instance
+// holds one of them or one is gone, and otherwise a function that releases
+// the leases.
+func claimJob(job uploadJob) (func(), bool) {
+	var claimed []string
+	release := func() {
+		for _, path := range claimed {
+			if _, err := db.Exec("DELETE FROM file_leases WHERE path_hash = ? AND host = ? AND pid = ?", leaseKey(path), leaseHost, os.Getpid()); err != nil {
+				log.Printf("Failed to release lease of %s: %v", path, err)
+			}
+		}
+	}
+	for _, path := range job.paths() {
+		if !claimFile(path) {
+			release()
+			return nil, false
+		}
+		claimed = append(claimed, path)
+	}
+
+	// Another instance may have finished the files before the claim.
+	for _, path := range claimed {
+		if _, err := os.Stat(path); err != nil {
+			release()
+			return nil, false
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(leaseTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				for _, path := range claimed {
+					_, err := db.Exec("UPDATE file_leases SET expires = ? WHERE path_hash = ? AND host = ? AND pid = ?", time.Now().Add(leaseTTL).UnixNano(), leaseKey(path), leaseHost, os.Getpid())
+					if err != nil {
+						log.Printf("Failed to extend lease of %s: %v", path, err)
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		release()
+	}, true
+}
+
+// leasedElsewhere reports whether another live instance holds the lease of
+// the file.
+func leasedElsewhere(path string) bool {
+	var n int
+	err := db.QueryRow("SELECT COUNT(*) FROM file_leases WHERE path_hash = ? AND expires >= ? AND NOT (host = ? AND pid = ?)", leaseKey(path), time.Now().UnixNano(), leaseHost, os.Getpid()).Scan(&n)
+	if err != nil {
+		// Leave the file alone rather than take it from its instance
+		log.Printf("Failed to look up the lease of %s: %v", path, err)
+		return true
+	}
+	return n > 0
+}
+
+func claimFile(path string) bool {
+	now := time.Now()
+	result, err := db.Exec("DELETE FROM file_leases WHERE path_hash = ? AND expires < ?", leaseKey(path), now.UnixNano())
+	if err != nil {
+		log.Printf("Failed to claim %s: %v", path, err)
+		return false
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Taking over the expired lease of %s", path)
+	}
+
+	_, err = db.Exec("INSERT INTO file_leases(path_hash, filepath, host, pid, expires) VALUES (?, ?, ?, ?, ?)", leaseKey(path), path, leaseHost, os.Getpid(), now.Add(leaseTTL).UnixNano())
+	if err == nil {
+		return true
+	}
+	var (
+		host    string
+		pid     int
+		expires int64
+	)
+	lookup := db.QueryRow("SELECT host, pid, expires FROM file_leases WHERE path_hash = ?", leaseKey(path)).Scan(&host, &pid, &expires)
+	switch {
+	case lookup == nil:
+		log.Printf("Skipping %s: claimed by %s pid %d until %s", path, host, pid, time.Unix(0, expires).Local().Format(time.TimeOnly))
+	case errors.Is(lookup, sql.ErrNoRows):
+		log.Printf("Failed to claim %s: %v", path, err)
+	default:
+		log.Printf("Failed to claim %s: %v", path, lookup)
+	}
+	return false
+}
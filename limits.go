@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// uploadLimiter is a counting semaphore that caps the number of uploads in
+// flight. A limit of zero means unlimited; the in-flight count is still kept
+// so it can be reported.
+type uploadLimiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	inFlight int
+}
+
+var (
+	globalUploads = newUploadLimiter(0)
+
+	profileUploadsMu sync.Mutex
+	profileUploads   = make(map[string]*uploadLimiter)
+)
+
+func newUploadLimiter(limit int) *uploadLimiter {
+	l := &uploadLimiter{limit: limit}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *uploadLimiter) acquire() {
+	l.mu.Lock()
+	for l.limit > 0 && l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+	l.mu.Unlock()
+}
+
+func (l *uploadLimiter) release() {
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+	l.cond.Signal()
+}
+
+// setLimit changes the limit; uploads in flight beyond a lower limit finish.
+func (l *uploadLimiter) setLimit(limit int) {
+	l.mu.Lock()
+	l.limit = limit
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+func (l *uploadLimiter) count() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight
+}
+
+// acquireUploadSlot blocks until both the profile and the global limit allow
+// another upload to start and returns the function that gives the slot back.
+// The profile slot is taken first so that a profile at its own limit never
+// holds on to global slots other profiles could use.
+func acquireUploadSlot(profile Profile) func() {
+	uploads := profileLimiter(profile)
+	uploads.acquire()
+	globalUploads.acquire()
+	uploaderLog.Debug(fmt.Sprintf("Upload slot acquired for profile %s (in flight: %d for profile, %d total)", profile.Name, uploads.count(), globalUploads.count()))
+
+	return func() {
+		globalUploads.release()
+		uploads.release()
+		uploaderLog.Debug(fmt.Sprintf("Upload slot released for profile %s (in flight: %d for profile, %d total)", profile.Name, uploads.count(), globalUploads.count()))
+	}
+}
+
+// profileLimiter returns the limiter for the profile's max_concurrency.
+func profileLimiter(profile Profile) *uploadLimiter {
+	profileUploadsMu.Lock()
+	defer profileUploadsMu.Unlock()
+	l, ok := profileUploads[profile.Name]
+	if !ok {
+		l = newUploadLimiter(profile.MaxConcurrency)
+		profileUploads[profile.Name] = l
+	}
+	return l
+}
+
+// memoryBudget caps the bytes buffered by all uploads together. Uploads
+// reserve their buffers before they start and wait while the budget is
+// exhausted, so a burst of large files queues up instead of exhausting
+// memory. A limit of zero means unlimited.
+type memoryBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64
+	used  int64
+}
+
+var globalMemory = newMemoryBudget(0)
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	b := &memoryBudget{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// reserve blocks until n bytes fit within the budget. A reservation larger
+// than the whole budget is capped to it, so it can still run on its own.
+func (b *memoryBudget) reserve(n int64) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit == 0 {
+		b.used += n
+		return n
+	}
+	n = min(n, b.limit)
+	for b.used+n > b.limit {
+		b.cond.Wait()
+	}
+	b.used += n
+	return n
+}
+
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	// Waiters need different amounts, so wake them all
+	b.cond.Broadcast()
+}
+
+// reserveUploadMemory reserves the buffers of an upload with the given
+// number of concurrent streams and returns the function that frees them.
+func reserveUploadMemory(file string, streams int) func() {
+	n := globalMemory.reserve(int64(streams) * maxBufferPerUpload)
+	if globalMemory.limit > 0 {
+		uploaderLog.Debug(fmt.Sprintf("Reserved %d bytes of memory for %s (%d of %d in use)", n, file, globalMemory.inUse(), globalMemory.limit))
+	}
+	return func() { globalMemory.release(n) }
+}
+
+func (b *memoryBudget) inUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
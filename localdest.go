@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// localDestination copies files to a directory such as an NFS mount. Buckets
+// are directories below local_path that must exist:
+//
+//	provider = local
+//	local_path = /mnt/landing
+//
+// Files are written under a temporary name in the target directory, synced
+// and renamed into place, so readers never see a partial file.
+type localDestination struct {
+	root string
+}
+
+func newLocalDestination(name string, settings map[string]string) (destination, error) {
+	root := filepath.Clean(settings["local_path"])
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local_path: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("invalid local_path: %s is not a directory", root)
+	}
+	return &localDestination{root: root}, nil
+}
+
+func (d *localDestination) target(bucket, key string) string {
+	return filepath.Join(d.root, bucket, filepath.FromSlash(key))
+}
+
+func (d *localDestination) validate(bucket string) error {
+	dir := filepath.Join(d.root, bucket)
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("directory %s does not exist", dir)
+	}
+	return nil
+}
+
+func (d *localDestination) upload(rec fileRecord) (uploadResult, error) {
+	src, err := os.Open(rec.Path)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to open file %s: %w", rec.Path, err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to stat file %s: %w", rec.Path, err)
+	}
+	if rec.Meta != nil {
+		log.Printf("Sidecar metadata of %s is not supported by the local provider and is ignored", rec.Path)
+	}
+	defer reserveUploadMemory(rec.Path, 1)()
+
+	target := d.target(rec.Bucket, rec.Key)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return uploadResult{}, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.flood-tmp")
+	if err != nil {
+		return uploadResult{}, err
+	}
+	_, err = io.CopyBuffer(tmp, uploadBody(src, rec.Profile), newCopyBuffer())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), target)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return uploadResult{}, fmt.Errorf("failed to copy %s to %s: %w", rec.Path, target, err)
+	}
+	log.Printf("Copied %s to %s", rec.Path, target)
+	return uploadResult{}, nil
+}
+
+// matches compares the size and the MD5 of both files.
+func (d *localDestination) matches(bucket, key, path string) (bool, error) {
+	local, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	target := d.target(bucket, key)
+	remote, err := os.Stat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if local.Size() != remote.Size() {
+		return false, nil
+	}
+
+	localSum, err := fileMD5(path)
+	if err != nil {
+		return false, err
+	}
+	remoteSum, err := fileMD5(target)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(localSum, remoteSum), nil
+}
+
+func fileMD5(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := md5.New()
+	if _, err := io.CopyBuffer(h, f, newCopyBuffer()); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// transient retries the errors a network file system reports while the
+// server is unreachable or busy.
+func (d *localDestination) transient(err error) bool {
+	return errors.Is(err, syscall.EIO) ||
+		errors.Is(err, syscall.ESTALE) ||
+		errors.Is(err, syscall.ETIMEDOUT) ||
+		errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EBUSY) ||
+		isTransientError(err)
+}
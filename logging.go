@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Log formats of -log-format. Both carry the same fields: text is the
+// classic log line followed by key=value pairs, json one object per line
+// for log pipelines. Log lines about a file carry its profile, bucket, key
+// and a correlation ID that is also stored in its file record as
+// correlation_id, plus the state and attempt where they apply.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Destinations of -log-output. -log-format only applies to stderr: syslog
+// messages carry the text line and journald entries one field per
+// attribute.
+const (
+	logOutputStderr   = "stderr"
+	logOutputSyslog   = "syslog"
+	logOutputJournald = "journald"
+)
+
+// logSubsystems are the subsystems whose level -log-levels can set apart
+// from -log-level: watcher for file system events, uploader for uploads
+// and their parts, db for the database and retry for failed attempts.
+// Everything else, including the log package, logs at -log-level.
+var logSubsystems = []string{"watcher", "uploader", "db", "retry"}
+
+func setupLogging() {
+	level, err := parseLogLevel(logLevel)
+	if err != nil {
+		log.Fatalf("Invalid -log-level: %v", err)
+	}
+	levels, err := parseLogLevels(logLevels, level)
+	if err != nil {
+		log.Fatalf("Invalid -log-levels: %v", err)
+	}
+
+	var h slog.Handler
+	switch logOutput {
+	case logOutputStderr:
+		h = &sinkHandler{sink: &textSink{w: os.Stderr}}
+		if logFormat == logFormatJSON {
+			h = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		}
+	case logOutputSyslog:
+		sink, err := newSyslogSink(syslogAddr, syslogFacility)
+		if err != nil {
+			log.Fatalf("Failed to connect to syslog: %v", err)
+		}
+		h = &sinkHandler{sink: sink}
+	case logOutputJournald:
+		sink, err := newJournaldSink()
+		if err != nil {
+			log.Fatalf("Failed to connect to journald: %v", err)
+		}
+		h = &sinkHandler{sink: sink}
+	default:
+		log.Fatalf("Invalid -log-output %q: must be stderr, syslog or journald", logOutput)
+	}
+	slog.SetDefault(slog.New(&levelHandler{level: level, next: h}))
+
+	subsystem := func(name string) *slog.Logger {
+		return slog.New(&levelHandler{level: levels[name], next: h.WithAttrs([]slog.Attr{slog.String("subsystem", name)})})
+	}
+	watcherLog = subsystem("watcher")
+	uploaderLog = subsystem("uploader")
+	dbLog = subsystem("db")
+	retryLog = subsystem("retry")
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown level %q: must be debug, info, warn or error", s)
+	}
+	return level, nil
+}
+
+// parseLogLevels parses subsystem=level pairs such as
+// "watcher=debug,uploader=warn". Subsystems not listed log at level.
+func parseLogLevels(s string, level slog.Level) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, name := range logSubsystems {
+		levels[name] = level
+	}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not subsystem=level", pair)
+		}
+		name = strings.TrimSpace(name)
+		if !slices.Contains(logSubsystems, name) {
+			return nil, fmt.Errorf("unknown subsystem %q: must be one of %s", name, strings.Join(logSubsystems, ", "))
+		}
+		l, err := parseLogLevel(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		levels[name] = l
+	}
+	return levels, nil
+}
+
+// levelHandler drops records below level before they reach next.
+type levelHandler struct {
+	level slog.Level
+	next  slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.next.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.next.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, next: h.next.WithGroup(name)}
+}
+
+// sinkHandler passes records with their attributes to a logSink. Groups
+// are flattened into the keys of their attributes, as in "group.key".
+type sinkHandler struct {
+	sink   logSink
+	prefix string      // groups of the keys that follow
+	attrs  []slog.Attr // attributes of WithAttrs
+}
+
+// logSink writes a record with the attributes of the logger and the record.
+type logSink interface {
+	write(r slog.Record, attrs []slog.Attr) error
+}
+
+func (h *sinkHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *sinkHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := slices.Clip(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = flattenAttr(attrs, h.prefix, a)
+		return true
+	})
+	return h.sink.write(r, attrs)
+}
+
+func (h *sinkHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = slices.Clip(h.attrs)
+	for _, a := range attrs {
+		h2.attrs = flattenAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *sinkHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.prefix += name + "."
+	return &h2
+}
+
+func flattenAttr(attrs []slog.Attr, prefix string, a slog.Attr) []slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return attrs
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, sub := range a.Value.Group() {
+			attrs = flattenAttr(attrs, prefix, sub)
+		}
+		return attrs
+	}
+	a.Key = prefix + a.Key
+	return append(attrs, a)
+}
+
+// textSink writes the classic log line, followed by key=value pairs.
+// Levels other than INFO precede the message, so lines of the log package
+// look as they always did.
+type textSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *textSink) write(r slog.Record, attrs []slog.Attr) error {
+	buf := []byte(r.Time.Format("2006/01/02 15:04:05 "))
+	if r.Level != slog.LevelInfo {
+		buf = append(buf, r.Level.String()...)
+		buf = append(buf, ' ')
+	}
+	buf = appendMessage(buf, r.Message, attrs)
+	buf = append(buf, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.w.Write(buf)
+	return err
+}
+
+// appendMessage appends the message followed by key=value pairs.
+func appendMessage(buf []byte, msg string, attrs []slog.Attr) []byte {
+	buf = append(buf, msg...)
+	for _, a := range attrs {
+		v := a.Value.String()
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		buf = fmt.Appendf(buf, " %s=%s", a.Key, v)
+	}
+	return buf
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logger returns a logger of the uploader subsystem with the fields of the
+// file.
+func (rec *fileRecord) logger() *slog.Logger {
+	return rec.loggerOf(uploaderLog)
+}
+
+// loggerOf returns l with the fields of the file.
+func (rec *fileRecord) loggerOf(l *slog.Logger) *slog.Logger {
+	if rec.ID == "" {
+		rec.ID = newCorrelationID()
+	}
+	l = l.With("file_id", rec.ID, "profile", rec.Profile.Name, "bucket", rec.Bucket, "key", rec.Key, "path", rec.Path)
+	if tenant := rec.Profile.tenantName(); tenant != "" {
+		l = l.With("tenant", tenant)
+	}
+	return l
+}
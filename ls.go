@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// lsEntry is a line of ls output in JSON form.
+type lsEntry struct {
+	Type         string     `json:"type"` // bucket, prefix or object
+	Name         string     `json:"name"`
+	Size         *int64     `json:"size,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	StorageClass string     `json:"storage_class,omitempty"`
+}
+
+// runListMode lists the buckets of a profile or the objects below a prefix:
+//
+//	flood ls [-r] [-json] s3://profile[/bucket[/prefix]]
+//
+// Without -r only the level below the prefix is listed, with deeper keys
+// shown as prefixes. Objects are printed page by page as they are listed;
+// with -json every entry is a JSON object on a line of its own.
+func runListMode(args []string) {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	recursive := fs.Bool("r", false, "List all objects below the prefix instead of one level")
+	asJSON := fs.Bool("json", false, "Print one JSON object per entry")
+	pageSize := fs.Int("page-size", 1000, "Number of keys requested per page")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] ls [ls flags] s3://profile[/bucket[/prefix]]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *pageSize < 1 || *pageSize > 1000 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	uri := fs.Arg(0)
+	name, rest, _ := strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+	if !strings.HasPrefix(uri, "s3://") || name == "" {
+		log.Fatalf("Invalid S3 URI %s: expected s3://profile[/bucket[/prefix]]", uri)
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		log.Fatalf("Unknown profile: %s", name)
+	}
+	if profile.Destination != nil {
+		log.Fatalf("ls is not supported by provider %s", profile.Provider)
+	}
+
+	show := func(e lsEntry) {
+		if *asJSON {
+			data, _ := json.Marshal(e)
+			fmt.Println(string(data))
+			return
+		}
+		switch e.Type {
+		case "bucket":
+			fmt.Printf("%s  %s\n", aws.ToTime(e.LastModified).Local().Format("2006-01-02 15:04:05"), e.Name)
+		case "prefix":
+			fmt.Printf("%30s %s\n", "PRE", e.Name)
+		default:
+			fmt.Printf("%s %10d %s\n", aws.ToTime(e.LastModified).Local().Format("2006-01-02 15:04:05"), aws.ToInt64(e.Size), e.Name)
+		}
+	}
+
+	if rest == "" {
+		out, err := newS3Client(profile).ListBuckets(context.TODO(), &s3.ListBucketsInput{})
+		if err != nil {
+			log.Fatalf("Failed to list buckets of profile %s: %v", name, err)
+		}
+		for _, b := range out.Buckets {
+			show(lsEntry{Type: "bucket", Name: aws.ToString(b.Name), LastModified: b.CreationDate})
+		}
+		return
+	}
+
+	profile, bucket, prefix, err := parseS3URI(uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  optionalString(prefix),
+		MaxKeys: aws.Int32(int32(*pageSize)),
+	}
+	if !*recursive {
+		input.Delimiter = aws.String("/")
+	}
+	paginator := s3.NewListObjectsV2Paginator(newS3Client(profile), input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Fatalf("Failed to list %s: %v", uri, err)
+		}
+		for _, p := range page.CommonPrefixes {
+			show(lsEntry{Type: "prefix", Name: aws.ToString(p.Prefix)})
+		}
+		for _, object := range page.Contents {
+			show(lsEntry{
+				Type:         "object",
+				Name:         aws.ToString(object.Key),
+				Size:         object.Size,
+				LastModified: object.LastModified,
+				ETag:         strings.Trim(aws.ToString(object.ETag), `"`),
+				StorageClass: string(object.StorageClass),
+			})
+		}
+	}
+}
@@ -2,61 +2,269 @@ package main
 
 import (
 	"context"
-	"crypto/md5"
 	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math"
 	"math/rand"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"text/template"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
-
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/fsnotify/fsnotify"
 )
 
 type Profile struct {
-	Name     string
-	Endpoint string
-	Region   string
+	Name              string
+	Provider          string
+	AccessKeyID       string
+	SecretAccessKey   string
+	Endpoint          string
+	Region            string
+	PartSize          int64
+	PartConcurrency   int
+	Workers           int
+	MaxConcurrency    int
+	Bandwidth         *rateLimiter // nil when the profile has no own limit
+	Tags              map[string]string
+	SSE               types.ServerSideEncryption
+	KMSKeyID          string
+	ACL               types.ObjectCannedACL
+	KeyPrefix         *template.Template
+	KeyRules          []keyRule
+	BundleSize        int64 // zero disables bundling
+	BundleFormat      string
+	BundleMaxWait     time.Duration
+	SkipExisting      bool
+	IfNoneMatch       bool // never overwrite existing objects
+	ObjectLockMode    types.ObjectLockMode
+	ObjectLockFor     time.Duration // retention period from the time of upload
+	Order             string        // upload order within the same priority
+	ChecksumAlgorithm types.ChecksumAlgorithm
+	Destination       destination // nil for S3 compatible providers
+	FailoverProfile   string      // takes over files this profile gave up on
+	StorageClass      types.StorageClass
+	Buckets           map[string]bucketOverride // keyed by bucket directory name
+	AddressingStyle   string                    // "path", "virtual" or empty for the SDK default
+	RedriveInterval   time.Duration             // zero disables re-driving failed files
+	RedriveMax        int
+	Windows           []uploadWindow // daily upload windows; none means always
+	QuotaBytes        int64          // bytes per day, zero for no quota; see quota.go
+	QuotaObjects      int64          // objects per day, zero for no quota
+	Tenant            *tenant        // nil unless the service is shared, see tenant.go
+	PropagateDeletes  bool           // delete objects on tombstone files
+	Webhook           *webhook       // nil when the profile sends no notifications
+
+	// Role assumed with STS, see assumerole.go
+	RoleARN         string
+	SourceProfile   string
+	ExternalID      string
+	RoleSessionName string
+	RoleDuration    time.Duration
+	Credentials     aws.CredentialsProvider // of the role; nil for the static keys
 }
 
 var (
-	credFile        string
-	serverDir       string
-	sourceFile      string
-	destURI         string
-	recursiveFlag   bool
-	profiles        map[string]Profile
-	mainDirs        = []string{"incoming_tmp", "incoming", "processing", "failed", "completed"}
-	watcher         *fsnotify.Watcher
-	processingLock  sync.Mutex
-	maxRetries      = 10
-	initialBackoff  = 30 * time.Second
-	errNotImplemented = errors.New("HEAD request not supported")
-	db              *sql.DB
+	credFile             string
+	serverDir            string
+	sourceFile           string
+	destURI              string
+	recursiveFlag        bool
+	quiet                bool
+	partSizeArg          string
+	bufferSizeArg        string
+	memoryLimitArg       string
+	partSize             int64 = 8 << 20
+	partConcurrency            = 4
+	workers                    = 4
+	maxConcurrentUploads int
+	bandwidthLimit       string
+	skipExisting         bool
+	uploadOrder          string
+	dryRun               bool
+	once                 bool
+	sqsQueueURL          string
+	sqsProfile           string
+	drainTimeout         time.Duration
+	watchMode            string
+	pollInterval         time.Duration
+	confirmDeletes       bool
+	databaseDSN          string
+	databasePath         string
+	databaseDriver       string
+	logFormat            string
+	logLevel             string
+	logLevels            string
+	logOutput            string
+	syslogAddr           string
+	syslogFacility       string
+	adminAddr            string
+	stallTimeout         time.Duration
+	adminTokenFile       string
+	authTokensFile       string
+	tlsCert              string
+	tlsKey               string
+	tlsClientCA          string
+	grpcAddr             string
+	controlSocket        string
+	ingestAddr           string
+	ingestTokenFile      string
+	heartbeatFile        string
+	heartbeatURL         string
+	heartbeatInterval    time.Duration
+	summaryInterval      time.Duration
+	metricsMaxBuckets    int
+	statsdAddr           string
+	journalEvents        bool
+	statsdPrefix         string
+	statsdTags           string
+	auditLogPath         string
+	alertWebhook         string
+	alertFormat          string
+	alertThreshold       float64
+	alertInterval        time.Duration
+	alertEmail           string
+	alertNoSuccess       time.Duration
+	emailInterval        time.Duration
+	smtpAddr             string
+	smtpUser             string
+	smtpPasswordFile     string
+	smtpFrom             string
+	eventsTarget         string
+	eventsProfile        string
+	kafkaBrokers         string
+	kafkaTopic           string
+	kafkaSASL            string
+	kafkaUser            string
+	kafkaPasswordFile    string
+	kafkaTLS             bool
+	watcherLog           *slog.Logger
+	uploaderLog          *slog.Logger
+	dbLog                *slog.Logger
+	retryLog             *slog.Logger
+	databaseKeyFile      string
+	dbRetentionArg       string
+	dbRetention          time.Duration
+	dbMaintenanceAt      string
+	dbMaintenanceMinute  int
+	leaseTTL             time.Duration
+	profiles             map[string]Profile
+	mainDirs             = []string{"incoming_tmp", "incoming", "processing", "failed", "completed", "cancelled"}
+	watcher              *fsnotify.Watcher
+	processingLock       sync.Mutex
+	maxRetriesArg        int
+	retryBackoffArg      time.Duration
+	errNotImplemented    = errors.New("HEAD request not supported")
+	errConflict          = errors.New("object already exists")
+	db                   store
 )
 
 func main() {
 	parseFlags()
+	// flood ctl talks to a running server and needs nothing else
+	if filepath.Base(os.Args[0]) == "floodctl" {
+		runCtlMode(flag.Args())
+		return
+	}
+	if flag.Arg(0) == "ctl" {
+		runCtlMode(flag.Args()[1:])
+		return
+	}
 	loadCredentials()
 	setupDirectories()
 	setupDatabase()
+	if flag.Arg(0) != "audit-log" {
+		openAuditLog()
+	}
+	if alertWebhook != "" {
+		go runAlerts()
+	}
+	if alertEmail != "" {
+		go runEmailDigests()
+	}
+	startEventSinks()
+	startStatsD()
+
+	if dryRun {
+		if flag.NArg() > 0 {
+			log.Fatal("-dry-run is only supported in server and copy mode")
+		}
+		runDryRun()
+		return
+	}
+
+	switch flag.Arg(0) {
+	case "pull":
+		runPullMode(flag.Args()[1:])
+		return
+	case "mirror":
+		runMirrorMode(flag.Args()[1:])
+		return
+	case "transfer":
+		runTransferMode(flag.Args()[1:])
+		return
+	case "verify":
+		runVerifyMode(flag.Args()[1:])
+		return
+	case "put":
+		runPutMode(flag.Args()[1:])
+		return
+	case "ls":
+		runListMode(flag.Args()[1:])
+		return
+	case "presign":
+		runPresignMode(flag.Args()[1:])
+		return
+	case "restore":
+		runRestoreMode(flag.Args()[1:])
+		return
+	case "prune":
+		runPruneMode(flag.Args()[1:])
+		return
+	case "status":
+		runStatusMode(flag.Args()[1:])
+		return
+	case "export":
+		runExportMode(flag.Args()[1:])
+		return
+	case "db":
+		runDBMode(flag.Args()[1:])
+		return
+	case "import-inventory":
+		runImportInventoryMode(flag.Args()[1:])
+		return
+	case "top":
+		runTopMode(flag.Args()[1:])
+		return
+	case "audit-log":
+		runAuditLogMode(flag.Args()[1:])
+		return
+	case "events":
+		runEventsMode(flag.Args()[1:])
+		return
+	}
 
 	if serverDir != "" {
 		runServerMode()
 	} else if sourceFile != "" && destURI != "" {
 		runCopyMode()
 	} else {
-		log.Fatal("Invalid mode. Specify either server directory or source file and destination URI.")
+		log.Fatal("Invalid mode. Specify either server directory, source file and destination URI, or a command (pull, mirror, transfer, verify, put, ls, presign, restore, prune, status, export, db, import-inventory, top, audit-log, events, ctl).")
 	}
 }
 
@@ -66,7 +274,188 @@ func parseFlags() {
 	flag.StringVar(&sourceFile, "source", "", "Source file or directory")
 	flag.StringVar(&destURI, "dest", "", "Destination S3 URI")
 	flag.BoolVar(&recursiveFlag, "r", false, "Recursive copy")
+	flag.BoolVar(&quiet, "quiet", false, "In copy mode, show no progress bars and no summary")
+	flag.StringVar(&partSizeArg, "part-size", "8MB", "Multipart upload part size (e.g. 16MB)")
+	flag.StringVar(&bufferSizeArg, "max-buffer-per-upload", "1MB", "Read buffer per upload stream; files are streamed from disk, never loaded whole (e.g. 4MB)")
+	flag.StringVar(&memoryLimitArg, "memory-limit", "", "Maximum upload buffer memory across all uploads; uploads wait when it is used up (e.g. 512MB)")
+	flag.IntVar(&partConcurrency, "part-concurrency", partConcurrency, "Number of parts of a single file uploaded concurrently")
+	flag.IntVar(&workers, "workers", workers, "Number of files uploaded concurrently per profile")
+	flag.IntVar(&maxConcurrentUploads, "max-concurrent-uploads", 0, "Maximum number of uploads in flight across all profiles (0 = unlimited)")
+	flag.StringVar(&bandwidthLimit, "bandwidth-limit", "", "Maximum upload rate across all uploads (e.g. 50MB/s)")
+	flag.IntVar(&maxRetriesArg, "max-retries", 10, "How often a failed transfer is retried before the file is given up on")
+	flag.DurationVar(&retryBackoffArg, "retry-backoff", 30*time.Second, "Delay before the first retry; it doubles with every further retry")
+	flag.BoolVar(&skipExisting, "skip-existing", false, "Skip files whose object already exists with the same size and checksum")
+	flag.StringVar(&uploadOrder, "order", "arrival", "Upload order within the same priority: arrival, smallest-first or largest-first")
+	flag.BoolVar(&once, "once", false, "Process everything in incoming and processing, then exit instead of watching for new files")
+	flag.StringVar(&sqsQueueURL, "sqs-queue", "", "URL of an SQS queue with messages describing files to ingest in server mode")
+	flag.StringVar(&sqsProfile, "sqs-profile", "", "Profile whose credentials and region are used for -sqs-queue")
+	flag.DurationVar(&drainTimeout, "drain-timeout", 0, "On SIGTERM or SIGINT, stop taking in files and wait this long for uploads in flight before exiting (0 = exit immediately)")
+	flag.StringVar(&watchMode, "watch-mode", watchModeNotify, "How to detect new files in incoming: notify (inotify) or poll (for NFS/CIFS mounts)")
+	flag.DurationVar(&pollInterval, "poll-interval", 10*time.Second, "Rescan interval of -watch-mode poll")
+	flag.BoolVar(&confirmDeletes, "confirm-deletes", false, "Actually delete remote objects for tombstone files of profiles with propagate_deletes")
+	flag.StringVar(&databaseDSN, "db", "", "Tracking database: a SQLite file, a postgres:// URL or a mysql:// DSN (default $FLOOD_DB or -db-path)")
+	flag.StringVar(&databasePath, "db-path", "", "SQLite database file (default $FLOOD_DB_PATH, or state/flood.db in the server directory)")
+	defaultDriver := sqliteDriverCgo
+	if !cgoSQLite {
+		defaultDriver = sqliteDriverPureGo
+	}
+	flag.StringVar(&databaseDriver, "db-driver", defaultDriver, "SQLite driver: cgo, purego for builds without cgo, or bolt to keep the database in a Bolt file")
+	flag.StringVar(&databaseKeyFile, "db-key-file", "", "File holding the key of an encrypted SQLite database (default $FLOOD_DB_KEY); requires a SQLCipher build")
+	flag.StringVar(&dbRetentionArg, "db-retention", "", "Delete database history older than this once a day in server mode, and the default of prune (e.g. 90d)")
+	flag.StringVar(&dbMaintenanceAt, "db-maintenance", "", "Time of day (e.g. 03:30) at which server mode checks, compacts and analyzes the database")
+	flag.DurationVar(&leaseTTL, "lease-ttl", 2*time.Minute, "How long the claim of a file by an instance that stopped renewing it lasts before another instance takes it over")
+	flag.BoolVar(&dryRun, "dry-run", false, "Validate profiles and buckets and print what would be uploaded without copying, moving or uploading anything")
+	flag.StringVar(&logFormat, "log-format", logFormatText, "Log format: text, or json for one object per line")
+	flag.StringVar(&logLevel, "log-level", "info", "Minimum level of log lines: debug, info, warn or error")
+	flag.StringVar(&logLevels, "log-levels", "", "Levels of subsystems that differ from -log-level, e.g. watcher=debug,uploader=warn (subsystems: watcher, uploader, db, retry)")
+	flag.StringVar(&logOutput, "log-output", logOutputStderr, "Where logs go: stderr, syslog or journald")
+	flag.StringVar(&syslogAddr, "syslog-addr", "", "Remote syslog server for -log-output syslog, as udp://host:port or tcp://host:port (default the local syslog socket)")
+	flag.StringVar(&syslogFacility, "syslog-facility", "daemon", "Syslog facility: user, daemon or local0 to local7")
+	flag.StringVar(&adminAddr, "admin-addr", "", "Address (e.g. :8081) on which server mode serves /healthz, /readyz and /status for flood top")
+	flag.DurationVar(&stallTimeout, "stall-timeout", 15*time.Minute, "How long files may be queued without any upload progress before /healthz reports a stall")
+	flag.StringVar(&adminTokenFile, "admin-token-file", "", "File holding the bearer token of the admin API on -admin-addr (default $FLOOD_ADMIN_TOKEN); without a token the API is off")
+	flag.StringVar(&authTokensFile, "auth-tokens-file", "", "File listing the tokens and client certificates of the admin, ingest and gRPC listeners with their scopes")
+	flag.StringVar(&tlsCert, "tls-cert", "", "Certificate (PEM) with which the admin, ingest and gRPC listeners serve TLS")
+	flag.StringVar(&tlsKey, "tls-key", "", "Private key (PEM) of -tls-cert")
+	flag.StringVar(&tlsClientCA, "tls-client-ca", "", "CA certificates (PEM) that client certificates of the listeners are verified against")
+	flag.StringVar(&controlSocket, "control-socket", "state/flood.sock", "Unix socket for flood ctl, relative to the server directory, or none")
+	flag.StringVar(&ingestAddr, "ingest-addr", "", "Address (e.g. :8082) on which server mode accepts files PUT or POSTed to /ingest/<profile>/<bucket>/<key>")
+	flag.StringVar(&ingestTokenFile, "ingest-token-file", "", "File holding the bearer token clients of -ingest-addr must send (default $FLOOD_INGEST_TOKEN)")
+	flag.StringVar(&grpcAddr, "grpc-addr", "", "Address (e.g. :9090) on which server mode serves the gRPC API; needs a build with -tags grpc")
+	flag.StringVar(&heartbeatFile, "heartbeat-file", "", "File whose modification time server mode updates while it is healthy")
+	flag.StringVar(&heartbeatURL, "heartbeat-url", "", "URL (e.g. a healthchecks.io check) server mode requests while it is healthy")
+	flag.DurationVar(&heartbeatInterval, "heartbeat-interval", time.Minute, "How often server mode updates -heartbeat-file and requests -heartbeat-url")
+	flag.StringVar(&alertWebhook, "alert-webhook", "", "Slack or Teams incoming webhook URL to alert on failure bursts and files that exhausted their retries")
+	flag.StringVar(&alertFormat, "alert-format", alertFormatSlack, "Message format of -alert-webhook: slack or teams")
+	flag.Float64Var(&alertThreshold, "alert-threshold", 0.25, "Share of failed files (0 to 1) within an -alert-interval that triggers an alert")
+	flag.DurationVar(&alertInterval, "alert-interval", 5*time.Minute, "Interval over which failures are aggregated; at most one alert is posted per interval")
+	flag.StringVar(&alertEmail, "alert-email", "", "Comma separated recipients of digests of failed files and stalled profiles")
+	flag.DurationVar(&alertNoSuccess, "alert-no-success", 30*time.Minute, "How long a profile with queued files may go without a successful upload before -alert-email reports it")
+	flag.DurationVar(&emailInterval, "email-interval", 15*time.Minute, "Interval of -alert-email digests; quiet intervals send nothing")
+	flag.StringVar(&smtpAddr, "smtp-addr", "localhost:25", "SMTP server for -alert-email as host:port")
+	flag.StringVar(&smtpUser, "smtp-user", "", "SMTP user name, if the server requires authentication")
+	flag.StringVar(&smtpPasswordFile, "smtp-password-file", "", "File holding the SMTP password (default $FLOOD_SMTP_PASSWORD)")
+	flag.StringVar(&smtpFrom, "smtp-from", "flood@localhost", "Sender address of -alert-email digests")
+	flag.StringVar(&eventsTarget, "events-target", "", "SNS topic ARN or SQS queue URL that receives a JSON message on every state transition of a file")
+	flag.StringVar(&eventsProfile, "events-profile", "", "Profile whose credentials and region are used for -events-target")
+	flag.StringVar(&kafkaBrokers, "kafka-brokers", "", "Comma separated Kafka brokers (host:port) that receive an event on every state transition of a file")
+	flag.StringVar(&kafkaTopic, "kafka-topic", "flood.file-events", "Kafka topic of the events")
+	flag.StringVar(&kafkaSASL, "kafka-sasl", "", "Kafka SASL mechanism: plain, scram-sha-256 or scram-sha-512")
+	flag.StringVar(&kafkaUser, "kafka-user", "", "Kafka SASL user name")
+	flag.StringVar(&kafkaPasswordFile, "kafka-password-file", "", "File holding the Kafka SASL password (default $FLOOD_KAFKA_PASSWORD)")
+	flag.BoolVar(&kafkaTLS, "kafka-tls", false, "Connect to the Kafka brokers with TLS")
+	flag.DurationVar(&summaryInterval, "summary-interval", 15*time.Minute, "How often server mode logs a summary of throughput, backlog and failures (0 to disable)")
+	flag.IntVar(&metricsMaxBuckets, "metrics-max-buckets", 20, "Buckets per profile that get summary lines of their own; the others are added up as bucket _other")
+	flag.StringVar(&auditLogPath, "audit-log", "", "Append-only, hash-chained JSONL file recording every state change of a file")
+	flag.BoolVar(&journalEvents, "event-journal", false, "Record every file event in the event_journal table, for consumers of flood events")
+	flag.StringVar(&statsdAddr, "statsd-addr", "", "host:port of a StatsD server or Datadog agent to send metrics to")
+	flag.StringVar(&statsdPrefix, "statsd-prefix", "flood.", "Prefix of the StatsD metric names")
+	flag.StringVar(&statsdTags, "statsd-tags", "", "Tags added to every StatsD metric (e.g. env:prod,team:data), or none to send no tags at all")
+	applyEnvironment()
 	flag.Parse()
+	if logFormat != logFormatText && logFormat != logFormatJSON {
+		log.Fatalf("Invalid -log-format %q: must be text or json", logFormat)
+	}
+	setupLogging()
+	if alertFormat != alertFormatSlack && alertFormat != alertFormatTeams {
+		log.Fatalf("Invalid -alert-format %q: must be slack or teams", alertFormat)
+	}
+	if alertThreshold < 0 || alertThreshold > 1 || alertInterval <= 0 {
+		log.Fatal("-alert-threshold must be between 0 and 1 and -alert-interval positive")
+	}
+	if metricsMaxBuckets < 0 {
+		log.Fatal("-metrics-max-buckets must not be negative")
+	}
+	if emailInterval <= 0 {
+		log.Fatal("-email-interval must be positive")
+	}
+
+	size, err := parseByteSize(partSizeArg)
+	if err != nil {
+		log.Fatalf("Invalid -part-size: %v", err)
+	}
+	if err := validatePartSize(size); err != nil {
+		log.Fatalf("Invalid -part-size: %v", err)
+	}
+	partSize = size
+	size, err = parseByteSize(bufferSizeArg)
+	if err != nil {
+		log.Fatalf("Invalid -max-buffer-per-upload: %v", err)
+	}
+	if err := validateUploadBuffer(size); err != nil {
+		log.Fatalf("Invalid -max-buffer-per-upload: %v", err)
+	}
+	maxBufferPerUpload = size
+	if memoryLimitArg != "" {
+		limit, err := parseByteSize(memoryLimitArg)
+		if err != nil || limit < maxBufferPerUpload {
+			log.Fatalf("Invalid -memory-limit %q: must be at least the -max-buffer-per-upload of %d bytes", memoryLimitArg, maxBufferPerUpload)
+		}
+		globalMemory = newMemoryBudget(limit)
+	}
+	if partConcurrency < 1 {
+		log.Fatal("Invalid -part-concurrency: must be at least 1")
+	}
+	if workers < 1 {
+		log.Fatal("Invalid -workers: must be at least 1")
+	}
+	if !slices.Contains(uploadOrders, uploadOrder) {
+		log.Fatalf("Invalid -order %q: must be one of %s", uploadOrder, strings.Join(uploadOrders, ", "))
+	}
+	if maxConcurrentUploads < 0 {
+		log.Fatal("Invalid -max-concurrent-uploads: must not be negative")
+	}
+	globalUploads = newUploadLimiter(maxConcurrentUploads)
+	if dbRetentionArg != "" {
+		retention, err := parseDuration(dbRetentionArg)
+		if err != nil || retention <= 0 {
+			log.Fatalf("Invalid -db-retention %q: must be a positive duration such as 90d", dbRetentionArg)
+		}
+		dbRetention = retention
+	}
+	if dbMaintenanceAt != "" {
+		minute, err := parseClock(dbMaintenanceAt)
+		if err != nil {
+			log.Fatalf("Invalid -db-maintenance: %v", err)
+		}
+		dbMaintenanceMinute = minute
+	}
+	if watchMode != watchModeNotify && watchMode != watchModePoll {
+		log.Fatalf("Invalid -watch-mode %q: must be notify or poll", watchMode)
+	}
+	if databaseDriver != sqliteDriverCgo && databaseDriver != sqliteDriverPureGo && databaseDriver != sqliteDriverBolt {
+		log.Fatalf("Invalid -db-driver %q: must be %s, %s or %s", databaseDriver, sqliteDriverCgo, sqliteDriverPureGo, sqliteDriverBolt)
+	}
+	if databaseDriver == sqliteDriverCgo && !cgoSQLite {
+		log.Fatalf("Invalid -db-driver %s: flood was built without cgo", sqliteDriverCgo)
+	}
+	if leaseTTL < 3*time.Second {
+		log.Fatal("Invalid -lease-ttl: must be at least 3s")
+	}
+	if pollInterval <= 0 {
+		log.Fatal("Invalid -poll-interval: must be positive")
+	}
+	if eventsTarget != "" && eventsProfile == "" {
+		log.Fatal("-events-target requires -events-profile")
+	}
+	if sqsQueueURL != "" && (sqsProfile == "" || serverDir == "" || once) {
+		log.Fatal("-sqs-queue requires -sqs-profile and -server and cannot be combined with -once")
+	}
+	if bandwidthLimit != "" {
+		rate, err := parseBandwidth(bandwidthLimit)
+		if err != nil {
+			log.Fatalf("Invalid -bandwidth-limit: %v", err)
+		}
+		globalBandwidth.Store(newRateLimiter(rate))
+	}
+	if maxRetriesArg < 0 || retryBackoffArg <= 0 {
+		log.Fatal("Invalid -max-retries or -retry-backoff: retries must not be negative and the backoff must be positive")
+	}
+	if (tlsCert == "") != (tlsKey == "") || tlsClientCA != "" && tlsCert == "" {
+		log.Fatal("-tls-cert and -tls-key must be given together, and -tls-client-ca needs them")
+	}
+	maxRetries.Store(int64(maxRetriesArg))
+	initialBackoff.Store(int64(retryBackoffArg))
 
 	if credFile == "" {
 		credFile = findCredentials()
@@ -79,24 +468,89 @@ func findCredentials() string {
 }
 
 func loadCredentials() {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	var err error
+	profiles, err = readProfiles(credFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// readProfiles reads and validates the profiles of a credentials file.
+func readProfiles(path string) (map[string]Profile, error) {
+	sections, err := parseCredentialsFile(path)
 	if err != nil {
-		log.Fatalf("Unable to load AWS SDK config: %v", err)
+		return nil, fmt.Errorf("unable to read credentials file %s: %w", path, err)
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("no profiles found in credentials file %s", path)
+	}
+
+	tenants, err := parseTenants(sections)
+	if err != nil {
+		return nil, err
+	}
+	profiles := make(map[string]Profile)
+	for profileName, settings := range sections {
+		if strings.Contains(profileName, "/") || strings.HasPrefix(profileName, tenantSectionPrefix) {
+			continue
+		}
+		profile, err := newProfile(profileName, settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid profile %s: %w", profileName, err)
+		}
+		if name := settings["tenant"]; name != "" {
+			if profile.Tenant = tenants[name]; profile.Tenant == nil {
+				return nil, fmt.Errorf("invalid profile %s: no [tenant %s] section", profileName, name)
+			}
+		}
+		profiles[profileName] = profile
 	}
 
-	profiles = make(map[string]Profile)
-	for profileName, profileCfg := range cfg.SharedConfigProfiles {
-		profiles[profileName] = Profile{
-			Name:     profileName,
-			Endpoint: profileCfg.Endpoint,
-			Region:   profileCfg.Region,
+	// Sections named profile/bucket override settings of a single bucket
+	for sectionName, settings := range sections {
+		profileName, bucketName, ok := strings.Cut(sectionName, "/")
+		if !ok {
+			continue
+		}
+		profile, exists := profiles[profileName]
+		if !exists || bucketName == "" {
+			return nil, fmt.Errorf("invalid bucket section %s: expected an existing profile and a bucket name", sectionName)
 		}
+		override, err := parseBucketOverride(profile, settings)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket section %s: %w", sectionName, err)
+		}
+		if profile.Buckets == nil {
+			profile.Buckets = make(map[string]bucketOverride)
+		}
+		profile.Buckets[bucketName] = override
+		profiles[profileName] = profile
 	}
+
+	for profileName, profile := range profiles {
+		if fanout, ok := profile.Destination.(*fanoutDestination); ok {
+			if err := fanout.resolve(profiles); err != nil {
+				return nil, fmt.Errorf("invalid profile %s: %w", profileName, err)
+			}
+		}
+		if err := validateFailover(profile, profiles); err != nil {
+			return nil, fmt.Errorf("invalid profile %s: %w", profileName, err)
+		}
+		if err := validateTenancy(profile, profiles); err != nil {
+			return nil, fmt.Errorf("invalid profile %s: %w", profileName, err)
+		}
+	}
+	if err := resolveCredentials(profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
 }
 
 func setupDirectories() {
-	if serverDir != "" {
+	if serverDir != "" && !dryRun {
 		os.RemoveAll(filepath.Join(serverDir, "incoming_tmp"))
+		// Bundle archives are rebuilt from the files left in processing
+		os.RemoveAll(filepath.Join(serverDir, "bundles"))
 		for _, dir := range mainDirs {
 			for profile := range profiles {
 				os.MkdirAll(filepath.Join(serverDir, dir, profile), 0755)
@@ -107,56 +561,149 @@ func setupDirectories() {
 
 func setupDatabase() {
 	var err error
-	db, err = sql.Open("sqlite3", "flood.db")
+	db, err = openStore(databaseDSN)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := migrate(); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	createTable := `
-		CREATE TABLE IF NOT EXISTS file_records (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			profile TEXT,
-			bucket TEXT,
-			filepath TEXT,
-			retries INTEGER,
-			last_retry TIMESTAMP,
-			upload_outcome TEXT
-		);
-	`
-	_, err = db.Exec(createTable)
+// ensureColumn adds a column to an existing table if it is not there yet.
+func ensureColumn(tx storeTx, table, column, columnType string) {
+	exists, err := tx.hasColumn(table, column)
 	if err != nil {
 		log.Fatal(err)
 	}
-}
+	if exists {
+		return
+	}
 
-func logRetry(filePath, profileName, bucketName string, retries int, outcome string) {
-	stmt, err := db.Prepare("INSERT INTO file_records(profile, bucket, filepath, retries, last_retry, upload_outcome) VALUES (?, ?, ?, ?, ?, ?)")
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, columnType))
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer stmt.Close()
+}
+
+// fileRecord describes an upload attempt as stored in file_records.
+type fileRecord struct {
+	ID           string  // correlation ID of the log lines about the file
+	Path         string  // local path of the file
+	Profile      Profile `json:"-"`
+	Bucket       string
+	OriginalPath string // path relative to the bucket directory
+	Key          string // object key after prefix and rewrite rules
+	Retries      int
+	Meta         *sidecarMetadata `json:"-"`
+	Checksum     string           // value of the profile's checksum algorithm, if any
+	ETag         string
+	VersionID    string
+	Operation    string // "upload" when empty
+	Attempts     []attempt
+
+	// Metrics of the successful attempt
+	Bytes    int64
+	Duration time.Duration
+	Parts    int
+
+	Transition int64 // file_transitions row the record confirms, if any
+}
 
-	_, err = stmt.Exec(profileName, bucketName, filePath, retries, time.Now(), outcome)
+func logRetry(rec fileRecord, outcome string) {
+	markProgress()
+	// The profile that ends up with the file; after a failover this is
+	// the failover profile's record.
+	operation := rec.Operation
+	if operation == "" {
+		operation = "upload"
+	}
+	var acceptedBy string
+	if outcome == "success" || outcome == "already_present" {
+		acceptedBy = rec.Profile.Name
+	}
+	attempts := len(rec.Attempts)
+	if outcome == "success" {
+		attempts++
+	}
+	var throughput int64
+	if rec.Duration > 0 {
+		throughput = int64(float64(rec.Bytes) / rec.Duration.Seconds())
+	}
+	now := time.Now()
+	id, err := db.insert("INSERT INTO file_records(profile, bucket, filepath, retries, last_retry, upload_outcome, part_size, part_concurrency, metadata, original_path, object_key, checksum_algorithm, checksum_value, accepted_by, operation, bytes, duration_ms, throughput, part_count, attempts, etag, version_id, transition_id, correlation_id, tenant) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		rec.Profile.Name, rec.Bucket, rec.Path, rec.Retries, now, outcome, rec.Profile.PartSize, rec.Profile.PartConcurrency, rec.Meta.String(), rec.OriginalPath, rec.Key, string(checksumAlgorithm(rec.Profile)), rec.Checksum, acceptedBy, operation,
+		rec.Bytes, rec.Duration.Milliseconds(), throughput, rec.Parts, attempts, optionalString(rec.ETag), optionalString(rec.VersionID), sql.NullInt64{Int64: rec.Transition, Valid: rec.Transition != 0}, optionalString(rec.ID), optionalString(rec.Profile.tenantName()))
 	if err != nil {
 		log.Fatal(err)
 	}
+	recordAttempts(id, rec.Attempts)
+	recordDailyStats(rec, outcome, now)
+	countAlertOutcome(rec, outcome)
+	recordEmailOutcome(rec, outcome)
+	countSummaryOutcome(rec, outcome)
+	countStatsDOutcome(rec, operation, outcome)
+	if outcome != "dry_run" {
+		auditFile(outcome, operation, rec)
+	}
 }
 
 func runServerMode() {
+	reconcileTransitions()
+	if once {
+		runOnce()
+		return
+	}
+
+	handlePauseSignals()
+	handleShutdownSignals()
+	markProgress()
+	startQueues()
 	processExistingFiles()
-	setupWatcher()
-	processIncomingFiles()
+	if watchMode == watchModePoll {
+		go pollIncoming()
+	} else {
+		setupWatcher()
+		processIncomingFiles()
+	}
+	handleReloadSignal()
+	if sqsQueueURL != "" {
+		go consumeSQS()
+	}
+	if dbRetention > 0 {
+		go schedulePrune()
+	}
+	if dbMaintenanceAt != "" {
+		go scheduleMaintenance()
+	}
+	loadAuth()
+	serveControl()
+	if adminAddr != "" {
+		go serveAdmin()
+	}
+	if grpcAddr != "" {
+		startGRPC()
+	}
+	if ingestAddr != "" {
+		go serveIngest()
+	}
+	if summaryInterval > 0 {
+		go logSummaries()
+	}
+	if statsd != nil {
+		go reportQueueDepth()
+	}
+	if heartbeatFile != "" || heartbeatURL != "" {
+		go runHeartbeat()
+	}
+
+	// Uploads happen on the queue workers; keep the process alive.
+	select {}
 }
 
 func processExistingFiles() {
-	for _, profile := range profiles {
-		processDir := filepath.Join(serverDir, "processing", profile.Name)
-		filepath.Walk(processDir, func(path string, info os.FileInfo, err error) error {
-			if !info.IsDir() {
-				processFile(path, profile)
-			}
-			return nil
-		})
+	for _, q := range queues {
+		q.notify()
 	}
 }
 
@@ -168,21 +715,24 @@ func setupWatcher() {
 	}
 
 	go func() {
+		watcherRunning.Store(true)
+		defer watcherRunning.Store(false)
 		for {
 			select {
 			case event, ok := <-watcher.Events:
 				if !ok {
 					return
 				}
-				if event.Op&fsnotify.CloseWrite == fsnotify.CloseWrite ||
+				watcherLog.Debug("File system event", "op", event.Op.String(), "path", event.Name)
+				if event.Op&fsnotify.Write == fsnotify.Write ||
 					event.Op&fsnotify.Create == fsnotify.Create {
-					handleFileEvent(event.Name)
+					handleWriteEvent(event.Name)
 				}
 			case err, ok := <-watcher.Errors:
 				if !ok {
 					return
 				}
-				log.Println("error:", err)
+				watcherLog.Error("Watcher error", "error", err)
 			}
 		}
 	}()
@@ -201,91 +751,315 @@ func setupWatcher() {
 	}
 }
 
+// writeQuietPeriod is how long a file in incoming must go without writes
+// before it is taken in. fsnotify has no portable close event, so the end
+// of a write is taken from its Write events stopping; a file moved in has
+// a single Create event.
+const writeQuietPeriod = time.Second
+
+var (
+	pendingWritesMu sync.Mutex
+	pendingWrites   = make(map[string]*time.Timer)
+)
+
+// handleWriteEvent passes path to handleFileEvent once it has not been
+// written to for writeQuietPeriod. Directories are passed on at once.
+func handleWriteEvent(path string) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		handleFileEvent(path)
+		return
+	}
+	pendingWritesMu.Lock()
+	defer pendingWritesMu.Unlock()
+	if t, ok := pendingWrites[path]; ok && t.Stop() {
+		t.Reset(writeQuietPeriod)
+		return
+	}
+	var t *time.Timer
+	t = time.AfterFunc(writeQuietPeriod, func() {
+		pendingWritesMu.Lock()
+		if pendingWrites[path] == t {
+			delete(pendingWrites, path)
+		}
+		pendingWritesMu.Unlock()
+		handleFileEvent(path)
+	})
+	pendingWrites[path] = t
+}
+
 func handleFileEvent(path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		// New bucket or sub directory: watch it and pick up anything
+		// that was moved in together with it.
+		watchAndProcessDir(path)
+		return
+	}
+
+	if isPaused() || isDraining() {
+		// Picked up again on resume or on the next run
+		return
+	}
+
 	processingLock.Lock()
 	defer processingLock.Unlock()
 
+	if filepath.Base(path) == priorityFile {
+		// Priority files configure the bucket and stay in incoming
+		return
+	}
+
 	relativePath, _ := filepath.Rel(filepath.Join(serverDir, "incoming"), path)
 	parts := strings.SplitN(relativePath, string(os.PathSeparator), 3)
-	if len(parts) < 2 {
+	if len(parts) < 3 {
+		watcherLog.Warn(fmt.Sprintf("Ignoring %s: files must be placed in a bucket directory", path))
 		return
 	}
 
 	profileName := parts[0] // Profile
 	bucketName := parts[1]  // Bucket
 
-	profile, ok := profiles[profileName]
-	if !ok {
+	if _, ok := profiles[profileName]; !ok {
 		log.Printf("Unknown profile: %s", profileName)
 		return
 	}
 
-	// Create processing directory for the file
-	processingPath := filepath.Join(serverDir, "processing", profileName, bucketName)
-	os.MkdirAll(processingPath, 0755)
-	os.Rename(path, processingPath)
+	// Move the file into the processing directory, keeping its path
+	processingPath := filepath.Join(serverDir, "processing", profileName, bucketName, parts[2])
+	os.MkdirAll(filepath.Dir(processingPath), 0755)
+	if err := os.Rename(path, processingPath); err != nil {
+		log.Printf("Failed to move %s to processing: %v", path, err)
+		return
+	}
+	log.Printf("Moved %s to %s", path, processingPath)
+	received := fileRecord{Path: processingPath, Profile: profiles[profileName], Bucket: bucketName}
+	auditFile("received", "incoming", received)
+	publishEvent("processing", received)
 
-	// Process the file (upload to S3 etc.)
-	processFileWithRetry(processingPath, profile, bucketName, 0)
+	// Hand the file to the profile's upload workers; with -once there are
+	// none and the processing directory is scanned afterwards.
+	if q, ok := queues[profileName]; ok {
+		q.notify()
+	}
 }
 
-func processFileWithRetry(path string, profile Profile, bucketName string, retryCount int) {
-	if retryCount > maxRetries {
-		log.Printf("Max retries reached for %s. Moving to failed directory.", path)
-		moveToFailed(path)
-		logRetry(path, profile.Name, bucketName, retryCount, "failure")
-		return
+func watchAndProcessDir(dir string) {
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() {
+			if err := watcher.Add(path); err != nil {
+				log.Printf("Failed to watch %s: %v", path, err)
+			}
+		} else {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	for _, path := range files {
+		handleFileEvent(path)
 	}
+}
 
-	log.Printf("Uploading %s to S3 for profile %s and bucket %s. Retry attempt: %d\n", path, profile.Name, bucketName, retryCount)
+// processFile uploads a file from the processing directory and moves it to
+// completed or failed depending on the outcome, which it returns.
+func processFile(path string, profile Profile, bucketName string) string {
+	profile = profile.forBucket(bucketName)
+	rec := fileRecord{ID: newCorrelationID(), Path: path, Profile: profile, Bucket: bucketName}
 
-	err := validateBucketExists(profile, bucketName)
+	// Object key is derived from the path of the file below the bucket directory
+	relativePath, err := filepath.Rel(filepath.Join(serverDir, "processing", profile.Name, bucketName), path)
 	if err != nil {
-		log.Printf("Error: %v", err)
-		moveToFailed(path)
-		logRetry(path, profile.Name, bucketName, retryCount, "failure")
-		return
+		log.Printf("Invalid path for S3 upload: %s", path)
+		return "failure"
 	}
+	rec.OriginalPath = filepath.ToSlash(relativePath)
 
-	// Simulate S3 upload
-	parts := strings.SplitN(path, string(os.PathSeparator), 4)
-	if len(parts) < 4 {
-		log.Println("Invalid path for S3 upload")
-		return
+	rec.Meta, err = loadSidecar(path)
+	if err != nil {
+		rec.logger().Error("Invalid sidecar", "error", err)
+		failFile(rec, "failure")
+		return "failure"
+	}
+	if rec.Meta != nil {
+		rec.logger().Info(fmt.Sprintf("Using sidecar metadata: %s", rec.Meta))
 	}
 
-	key := parts[3] // Object key (rest of the path after profile/bucket)
-	err = uploadToS3(path, bucketName, key, profile)
+	rec.Key, err = objectKey(profile, bucketName, relativePath)
 	if err != nil {
-		log.Printf("Error uploading to S3: %v\n", err)
-		if isTransientError(err) {
-			// Retry with exponential backoff and jitter
-			backoffDuration := initialBackoff * time.Duration(1<<retryCount)
-			jitter := time.Duration(rand.Intn(1000)) * time.Millisecond
-			time.Sleep(backoffDuration + jitter)
-			processFileWithRetry(path, profile, bucketName, retryCount+1)
-		} else {
-			moveToFailed(path)
-			logRetry(path, profile.Name, bucketName, retryCount, "failure")
+		rec.logger().Error("Invalid object key", "error", err)
+		failFile(rec, "failure")
+		return "failure"
+	}
+	if rec.Key != rec.OriginalPath {
+		rec.logger().Info(fmt.Sprintf("Mapped %s to key %s", rec.OriginalPath, rec.Key))
+	}
+
+	if profile.SkipExisting {
+		present, err := alreadyPresent(profile, bucketName, rec.Key, path)
+		switch {
+		case errors.Is(err, errNotImplemented):
+			rec.logger().Info("Cannot check whether the object already exists (informational)", "error", err)
+		case err != nil:
+			rec.logger().Warn("Failed to check whether the object already exists, uploading anyway", "error", err)
+		case present:
+			rec.logger().Info("Skipping: already present", "state", "already_present")
+			completeFile(rec, "already_present")
+			return "already_present"
 		}
-		return
 	}
 
-	// Move to completed directory
-	completedPath := strings.Replace(path, "processing", "completed", 1)
-	os.MkdirAll(filepath.Dir(completedPath), 0755)
-	os.Rename(path, completedPath)
-	logRetry(path, profile.Name, bucketName, retryCount, "success")
+	if err := uploadWithRetry(&rec); err != nil {
+		if errors.Is(err, errCancelled) && drainInterrupted() {
+			// The file stays in processing for the drain to return it
+			// to incoming, with its multipart progress.
+			logRetry(rec, "interrupted")
+			return "interrupted"
+		}
+		discardMultipartUpload(profile, path)
+		if errors.Is(err, errCancelled) {
+			settleCancelled(rec)
+			return "cancelled"
+		}
+		if target, ok := failoverTarget(path, profile); ok && !errors.Is(err, errConflict) {
+			rec.logger().Warn("Failing over to profile "+profile.FailoverProfile, "state", "failed_over", "error", err)
+			if settleFile(rec, "failed_over", target) {
+				queues[profile.FailoverProfile].notify()
+			}
+			return "failed_over"
+		}
+		rec.logger().Error("Moving to failed directory", "state", "failure", "error", err, "attempt", rec.Retries)
+		if errors.Is(err, errConflict) {
+			failFile(rec, "conflict")
+			return "conflict"
+		}
+		failFile(rec, "failure")
+		return "failure"
+	}
+
+	completeFile(rec, "success")
+	return "success"
+}
+
+// uploadWithRetry uploads rec.Path to rec.Key, retrying transient errors
+// with exponential backoff and jitter up to -max-retries times. rec.Retries is
+// updated with the number of retries used. The returned error is the one
+// that ended the attempts, or nil once the upload succeeded.
+func uploadWithRetry(rec *fileRecord) error {
+	dest := destinationFor(rec.Profile)
+	if fanout, ok := dest.(*fanoutDestination); ok {
+		// Every destination retries and takes upload slots on its own
+		start := time.Now()
+		err := fanout.deliver(rec)
+		if err == nil {
+			rec.measure(start)
+		}
+		return err
+	}
+	progress, done := trackUpload(rec)
+	defer done()
+	for {
+		if progress.cancelled() {
+			return errCancelled
+		}
+		progress.startAttempt(rec.Retries)
+		rec.logger().Info(fmt.Sprintf("Uploading %s. Retry attempt: %d", rec.Path, rec.Retries), "state", "uploading", "attempt", rec.Retries)
+		publishEvent("uploading", *rec)
+
+		err := dest.validate(rec.Bucket)
+		if err != nil {
+			notifyFile("bucket_invalid", *rec, "", err)
+			return err
+		}
+
+		release := acquireUploadSlot(rec.Profile)
+		start := time.Now()
+		result, err := dest.upload(*rec)
+		release()
+		rec.Checksum, rec.ETag, rec.VersionID = result.Checksum, strings.Trim(result.ETag, `"`), result.VersionID
+		if result.Key != "" {
+			rec.Key = result.Key
+		}
+		if err == nil {
+			rec.measure(start)
+			return nil
+		}
+		if progress.cancelled() {
+			rec.logger().Info("Upload cancelled", "state", "uploading", "attempt", rec.Retries)
+			return errCancelled
+		}
+
+		rec.loggerOf(retryLog).Warn("Error uploading", "state", "uploading", "attempt", rec.Retries, "error", err)
+		if !dest.transient(err) {
+			rec.failedAttempt(err, false, 0)
+			return err
+		}
+		if rec.Retries >= retryLimit() {
+			rec.loggerOf(retryLog).Warn("Max retries reached", "attempt", rec.Retries)
+			rec.failedAttempt(err, true, 0)
+			return err
+		}
+
+		delay := retryDelay(rec.Retries)
+		rec.failedAttempt(err, true, delay)
+		countStatsDRetry(*rec)
+		progress.retryAt.Store(time.Now().Add(delay).UnixNano())
+		select {
+		case <-time.After(delay):
+		case <-progress.cancel:
+		}
+		rec.Retries++
+	}
+}
+
+// measure sets the transfer metrics of rec after an upload that began at
+// start succeeded.
+func (rec *fileRecord) measure(start time.Time) {
+	rec.Duration = time.Since(start)
+	rec.Parts = 1
+	if info, err := os.Stat(rec.Path); err == nil {
+		rec.Bytes = info.Size()
+		rec.Parts = partCount(rec.Profile, rec.Bytes)
+	}
+}
+
+// partCount is the number of parts a file of the given size is uploaded in.
+func partCount(profile Profile, size int64) int {
+	if profile.Destination != nil && !slices.Contains(partProviders, profile.Provider) {
+		return 1
+	}
+	partSize := effectivePartSize(profile.PartSize, size)
+	if size <= partSize {
+		return 1
+	}
+	return int((size + partSize - 1) / partSize)
+}
+
+// retryDelay is the exponential backoff with jitter before the given retry.
+func retryDelay(retries int) time.Duration {
+	backoffDuration := retryBackoff() * time.Duration(1<<retries)
+	jitter := time.Duration(rand.Intn(1000)) * time.Millisecond
+	return backoffDuration + jitter
 }
 
 func isTransientError(err error) bool {
-	return strings.Contains(err.Error(), "timeout") ||
+	return errors.Is(err, errETagMismatch) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		strings.Contains(err.Error(), "timeout") ||
 		strings.Contains(err.Error(), "connection reset") ||
-		strings.Contains(err.Error(), "DNS error")
+		strings.Contains(err.Error(), "DNS error") ||
+		// Credentials of a role that expired are renewed on the next attempt
+		strings.Contains(err.Error(), "ExpiredToken")
 }
 
 func validateBucketExists(profile Profile, bucketName string) error {
-	client := s3.NewFromConfig(getAWSConfig(profile))
+	client := newS3Client(profile)
 
 	resp, err := client.ListBuckets(context.TODO(), &s3.ListBucketsInput{})
 	if err != nil {
@@ -294,12 +1068,30 @@ func validateBucketExists(profile Profile, bucketName string) error {
 
 	for _, bucket := range resp.Buckets {
 		if *bucket.Name == bucketName {
+			if profile.ObjectLockMode != "" {
+				return validateObjectLock(client, bucketName)
+			}
 			return nil
 		}
 	}
 	return fmt.Errorf("bucket %s does not exist on S3 server", bucketName)
 }
 
+// validateObjectLock makes sure retention can be applied to objects in the
+// bucket; S3 rejects retention headers for buckets without Object Lock.
+func validateObjectLock(client *s3.Client, bucketName string) error {
+	resp, err := client.GetObjectLockConfiguration(context.TODO(), &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get Object Lock configuration of bucket %s: %w", bucketName, err)
+	}
+	if resp.ObjectLockConfiguration == nil || resp.ObjectLockConfiguration.ObjectLockEnabled != types.ObjectLockEnabledEnabled {
+		return fmt.Errorf("bucket %s does not have Object Lock enabled", bucketName)
+	}
+	return nil
+}
+
 func processIncomingFiles() {
 	for _, profile := range profiles {
 		incomingDir := filepath.Join(serverDir, "incoming", profile.Name)
@@ -325,8 +1117,8 @@ func runCopyMode() {
 		log.Fatalf("Unknown profile: %s", profileName)
 	}
 
-	// Ensure bucket exists on S3 server
-	err := validateBucketExists(profile, bucketName)
+	// Ensure bucket exists on the destination
+	err := destinationFor(profile.forBucket(bucketName)).validate(bucketName)
 	if err != nil {
 		log.Fatalf("Error: %v", err)
 	}
@@ -336,14 +1128,20 @@ func runCopyMode() {
 	os.MkdirAll(tmpDir, 0755)
 
 	// Copy the source file or directory to incoming_tmp
-	if recursiveFlag && isDirectory(sourceFile) {
-		copyDirectory(sourceFile, filepath.Join(tmpDir, objectKey))
+	recursive := recursiveFlag && isDirectory(sourceFile)
+	progress := newCopyProgress(sourceFile, recursive)
+	if recursive {
+		copyDirectory(sourceFile, filepath.Join(tmpDir, objectKey), progress)
 	} else {
-		copyFile(sourceFile, filepath.Join(tmpDir, objectKey))
+		copyFile(sourceFile, filepath.Join(tmpDir, objectKey), progress)
 	}
+	progress.finish()
 
 	// Move files from incoming_tmp to incoming (bucket structure must also exist here)
 	moveToIncoming(tmpDir, profileName, bucketName)
+	if progress.failed > 0 {
+		os.Exit(1)
+	}
 }
 
 func isDirectory(path string) bool {
@@ -354,10 +1152,11 @@ func isDirectory(path string) bool {
 	return info.IsDir()
 }
 
-func copyDirectory(src, dst string) {
+func copyDirectory(src, dst string, progress *copyProgress) {
 	filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return err
+			progress.fail(path, err)
+			return nil
 		}
 		relPath, _ := filepath.Rel(src, path)
 		dstPath := filepath.Join(dst, relPath)
@@ -365,27 +1164,32 @@ func copyDirectory(src, dst string) {
 		if info.IsDir() {
 			os.MkdirAll(dstPath, info.Mode())
 		} else {
-			copyFile(path, dstPath)
+			copyFile(path, dstPath, progress)
 		}
 		return nil
 	})
 }
 
-func copyFile(src, dst string) {
-	input, err := os.ReadFile(src)
+// copyFile copies a regular file, or what a symbolic link points to. Other
+// files, such as devices and named pipes, are skipped. A failed copy is
+// removed so that only complete files move on to incoming.
+func copyFile(src, dst string, progress *copyProgress) {
+	info, err := os.Stat(src)
 	if err != nil {
-		log.Fatal(err)
+		progress.fail(src, err)
+		return
 	}
-
-	err = os.MkdirAll(filepath.Dir(dst), 0755)
-	if err != nil {
-		log.Fatal(err)
+	if !info.Mode().IsRegular() {
+		progress.skip(src, "not a regular file")
+		return
 	}
-
-	err = os.WriteFile(dst, input, 0644)
-	if err != nil {
-		log.Fatal(err)
+	progress.start(src, info.Size())
+	if err := streamFile(src, dst, progress.add); err != nil {
+		os.Remove(dst)
+		progress.fail(src, err)
+		return
 	}
+	progress.done()
 }
 
 func moveToIncoming(tmpDir, profileName, bucketName string) {
@@ -411,38 +1215,122 @@ func moveToIncoming(tmpDir, profileName, bucketName string) {
 }
 
 func moveToFailed(path string) {
-	failedPath := strings.Replace(path, "processing", "failed", 1)
-	os.MkdirAll(filepath.Dir(failedPath), 0755)
-	os.Rename(path, failedPath)
+	if err := moveFile(path, strings.Replace(path, "processing", "failed", 1)); err != nil {
+		log.Printf("Failed to move %s to failed: %v", path, err)
+	}
+}
+
+func moveToCompleted(path string) {
+	if err := moveFile(path, strings.Replace(path, "processing", "completed", 1)); err != nil {
+		log.Printf("Failed to move %s to completed: %v", path, err)
+	}
 }
 
-func uploadToS3(file, bucket, key string, profile Profile) error {
-	client := s3.NewFromConfig(getAWSConfig(profile))
+// uploadToS3 uploads a file and returns its ETag, its version if the bucket
+// keeps versions, and its checksum if the profile uses a checksum algorithm.
+func uploadToS3(file, bucket, key string, profile Profile, meta *sidecarMetadata) (uploadResult, error) {
+	client := newS3Client(profile)
 
 	f, err := os.Open(file)
 	if err != nil {
-		return fmt.Errorf("failed to open file %s: %w", file, err)
+		return uploadResult{}, fmt.Errorf("failed to open file %s: %w", file, err)
 	}
 	defer f.Close()
 
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
-		Body:   f,
-	})
+	info, err := f.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to upload file: %w", err)
+		return uploadResult{}, fmt.Errorf("failed to stat file %s: %w", file, err)
 	}
 
-	return nil
+	size := info.Size()
+	partSize := effectivePartSize(profile.PartSize, size)
+	if size > partSize {
+		streams := min(profile.PartConcurrency, int((size+partSize-1)/partSize))
+		defer reserveUploadMemory(file, streams)()
+		result, err := uploadMultipart(client, f, info, profile, bucket, key, partSize, profile.PartConcurrency, meta)
+		if err != nil {
+			return uploadResult{}, err
+		}
+		return result, verifyETag(f, size, partSize, true, result.ETag, profile)
+	}
+
+	defer reserveUploadMemory(file, 1)()
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		Body:          uploadBody(f, profile),
+		ContentLength: aws.Int64(size),
+	}
+	applyPutObjectOptions(input, profile, meta)
+	out, err := client.PutObject(context.TODO(), input)
+	if err != nil {
+		if httpStatusCode(err) == http.StatusPreconditionFailed {
+			return uploadResult{}, fmt.Errorf("%w: %s", errConflict, key)
+		}
+		return uploadResult{}, fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	result := uploadResult{
+		Checksum:  checksumValue(input.ChecksumAlgorithm, out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256),
+		ETag:      aws.ToString(out.ETag),
+		VersionID: aws.ToString(out.VersionId),
+	}
+	return result, verifyETag(f, size, partSize, false, result.ETag, profile)
+}
+
+// newS3Client creates a client for the profile, applying its addressing
+// style. Virtual-hosted addressing puts the bucket in the host name, which
+// breaks TLS for bucket names with dots and is unsupported by some
+// appliances.
+func newS3Client(profile Profile) *s3.Client {
+	return s3.NewFromConfig(getAWSConfig(profile), func(o *s3.Options) {
+		switch profile.AddressingStyle {
+		case "path":
+			o.UsePathStyle = true
+		case "virtual":
+			o.UsePathStyle = false
+		}
+	})
+}
+
+// applyPutObjectOptions sets the metadata and the profile's object options
+// on a PutObject request.
+func applyPutObjectOptions(input *s3.PutObjectInput, profile Profile, meta *sidecarMetadata) {
+	meta.applyToPutObject(input)
+	input.Tagging = optionalString(objectTagging(profile, meta))
+	input.ServerSideEncryption = profile.SSE
+	input.SSEKMSKeyId = optionalString(profile.KMSKeyID)
+	input.ACL = profile.ACL
+	if profile.IfNoneMatch {
+		input.IfNoneMatch = aws.String("*")
+	}
+	if profile.ObjectLockMode != "" {
+		input.ObjectLockMode = profile.ObjectLockMode
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(profile.ObjectLockFor))
+	}
+	input.ChecksumAlgorithm = checksumAlgorithm(profile)
+	input.StorageClass = profile.StorageClass
+}
+
+// credentialsOf returns the credentials provider of the profile.
+func credentialsOf(profile Profile) aws.CredentialsProvider {
+	if profile.Credentials != nil {
+		return profile.Credentials
+	}
+	return credentials.NewStaticCredentialsProvider(profile.AccessKeyID, profile.SecretAccessKey, "")
 }
 
 func getAWSConfig(profile Profile) aws.Config {
 	cfg, err := config.LoadDefaultConfig(context.TODO(),
 		config.WithRegion(profile.Region),
+		config.WithCredentialsProvider(credentialsOf(profile)),
 		config.WithEndpointResolverWithOptions(
 			aws.EndpointResolverWithOptionsFunc(
 				func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+					if profile.Endpoint == "" {
+						// Fall back to the SDK's default endpoint (e.g. Amazon S3)
+						return aws.Endpoint{}, &aws.EndpointNotFoundError{}
+					}
 					return aws.Endpoint{URL: profile.Endpoint}, nil
 				},
 			),
@@ -453,3 +1341,44 @@ func getAWSConfig(profile Profile) aws.Config {
 	}
 	return cfg
 }
+
+// parseDuration parses Go durations such as "90m" and additionally accepts a
+// number of days such as "30d".
+func parseDuration(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// parseByteSize parses sizes such as "8MB", "64MiB" or "1048576". Decimal and
+// binary suffixes are both treated as powers of 1024, as most users expect.
+func parseByteSize(value string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+		{"KB", 1 << 10}, {"MB", 1 << 20}, {"GB", 1 << 30}, {"TB", 1 << 40},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40}, {"B", 1},
+	} {
+		if strings.HasSuffix(s, unit.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// runDBMode runs database administration commands:
+//
+//	flood db maintain [-no-vacuum]
+//
+// maintain checks the integrity of the database, compacts it and updates
+// the statistics of the query planner. It holds the write lock while it
+// compacts, so uploads of a running server wait; -db-maintenance runs it
+// daily at an off-peak time instead.
+func runDBMode(args []string) {
+	if len(args) == 0 || args[0] != "maintain" {
+		fmt.Fprintln(os.Stderr, "Usage: flood [flags] db maintain [-no-vacuum]")
+		os.Exit(2)
+	}
+	fs := flag.NewFlagSet("db maintain", flag.ExitOnError)
+	noVacuum := fs.Bool("no-vacuum", false, "Only check integrity and update statistics, do not compact")
+	fs.Parse(args[1:])
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err := maintainDatabase(!*noVacuum); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func maintainDatabase(vacuum bool) error {
+	start := time.Now()
+	log.Printf("Starting database maintenance")
+	if err := db.maintain(vacuum); err != nil {
+		return fmt.Errorf("database maintenance failed: %w", err)
+	}
+	log.Printf("Finished database maintenance in %s", time.Since(start).Round(time.Second))
+	return nil
+}
+
+// scheduleMaintenance maintains the database every day at -db-maintenance.
+func scheduleMaintenance() {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day(), 0, dbMaintenanceMinute, 0, 0, now.Location())
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+		time.Sleep(time.Until(next))
+		if err := maintainDatabase(true); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// maintain runs integrity_check and stops if it finds problems, since
+// compacting a damaged database can lose more data; then VACUUM and ANALYZE.
+func (s *sqliteStore) maintain(vacuum bool) error {
+	rows, err := s.db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return err
+	}
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	log.Printf("Database integrity check passed")
+
+	if vacuum {
+		if _, err := s.Exec("VACUUM"); err != nil {
+			return fmt.Errorf("VACUUM failed: %w", err)
+		}
+		log.Printf("Database compacted")
+	}
+	if _, err := s.Exec("ANALYZE"); err != nil {
+		return fmt.Errorf("ANALYZE failed: %w", err)
+	}
+	return nil
+}
+
+// maintain relies on PostgreSQL for integrity and runs VACUUM ANALYZE, or
+// only ANALYZE.
+func (s *postgresStore) maintain(vacuum bool) error {
+	statement := "ANALYZE"
+	if vacuum {
+		statement = "VACUUM ANALYZE"
+	}
+	_, err := s.db.Exec(statement)
+	return err
+}
+
+// maintain runs CHECK TABLE and then OPTIMIZE TABLE, or ANALYZE TABLE, on
+// every table of the database.
+func (s *mysqlStore) maintain(vacuum bool) error {
+	rows, err := s.db.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()")
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			rows.Close()
+			return err
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, table := range tables {
+		if err := s.adminTable("CHECK TABLE", table); err != nil {
+			return err
+		}
+	}
+	log.Printf("Database integrity check passed")
+	statement := "ANALYZE TABLE"
+	if vacuum {
+		statement = "OPTIMIZE TABLE"
+	}
+	for _, table := range tables {
+		if err := s.adminTable(statement, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adminTable runs a table maintenance statement and fails on the errors it
+// reports in its result rows.
+func (s *mysqlStore) adminTable(statement, table string) error {
+	rows, err := s.db.Query(fmt.Sprintf("%s `%s`", statement, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, op, msgType, msgText string
+		if err := rows.Scan(&name, &op, &msgType, &msgText); err != nil {
+			return err
+		}
+		if strings.EqualFold(msgType, "error") {
+			return fmt.Errorf("%s %s: %s", statement, table, msgText)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations. A migration is a file named
+// NNNN_description.sql with statements in the SQLite dialect, which the
+// store translates for other databases. Migrations are never edited once
+// released; schema changes go into a new file with the next number.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrate applies the migrations newer than the version recorded in
+// schema_version, in order, and records each one in the same transaction
+// (see storeTx).
+func migrate() error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	applied TIMESTAMP
+);`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_version: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_version").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		base := strings.TrimPrefix(name, "migrations/")
+		prefix, _, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return fmt.Errorf("invalid migration name %s", base)
+		}
+		if version <= current {
+			continue
+		}
+
+		data, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		tx, err := db.begin()
+		if err != nil {
+			return fmt.Errorf("failed to start migration %s: %w", base, err)
+		}
+		if err := tx.script(string(data)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %s failed: %w", base, err)
+		}
+		switch version {
+		case 1:
+			upgradeLegacySchema(tx)
+		case 8:
+			backfillDailyStats(tx)
+		case 13:
+			backfillTenants(tx)
+		case 14:
+			backfillUploads(tx)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_version(version, applied) VALUES (?, ?)", version, time.Now()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %s: %w", base, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %s: %w", base, err)
+		}
+		dbLog.Info("Applied database migration " + base)
+	}
+	return nil
+}
+
+// upgradeLegacySchema adds the columns that databases created before
+// versioned migrations may lack; the initial migration only creates tables
+// that do not exist yet.
+func upgradeLegacySchema(tx storeTx) {
+	ensureColumn(tx, "file_records", "part_size", "INTEGER")
+	ensureColumn(tx, "file_records", "part_concurrency", "INTEGER")
+	ensureColumn(tx, "file_records", "metadata", "TEXT")
+	ensureColumn(tx, "file_records", "original_path", "TEXT")
+	ensureColumn(tx, "file_records", "object_key", "TEXT")
+	ensureColumn(tx, "file_records", "checksum_algorithm", "TEXT")
+	ensureColumn(tx, "file_records", "checksum_value", "TEXT")
+	ensureColumn(tx, "file_records", "accepted_by", "TEXT")
+	ensureColumn(tx, "file_records", "operation", "TEXT")
+	ensureColumn(tx, "multipart_parts", "checksum", "TEXT")
+}
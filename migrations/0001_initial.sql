@@ -0,0 +1,124 @@
+-- The schema at the time versioned migrations were introduced. Databases
+-- created before that are brought up to it by upgradeLegacySchema.
+
+CREATE TABLE IF NOT EXISTS file_records (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	profile TEXT,
+	bucket TEXT,
+	filepath TEXT,
+	retries INTEGER,
+	last_retry TIMESTAMP,
+	upload_outcome TEXT,
+	part_size INTEGER,
+	part_concurrency INTEGER,
+	metadata TEXT,
+	original_path TEXT,
+	object_key TEXT,
+	checksum_algorithm TEXT,
+	checksum_value TEXT,
+	accepted_by TEXT,
+	operation TEXT
+);
+
+CREATE TABLE IF NOT EXISTS multipart_uploads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	profile TEXT,
+	bucket TEXT,
+	object_key TEXT,
+	filepath TEXT,
+	file_size INTEGER,
+	file_mtime INTEGER,
+	part_size INTEGER,
+	upload_id TEXT UNIQUE,
+	created TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS multipart_parts (
+	upload_id TEXT,
+	part_number INTEGER,
+	etag TEXT,
+	checksum TEXT,
+	PRIMARY KEY (upload_id, part_number)
+);
+
+CREATE TABLE IF NOT EXISTS bundles (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	profile TEXT,
+	bucket TEXT,
+	object_key TEXT,
+	format TEXT,
+	member_count INTEGER,
+	created TIMESTAMP,
+	upload_outcome TEXT
+);
+CREATE TABLE IF NOT EXISTS bundle_members (
+	bundle_id INTEGER,
+	filepath TEXT,
+	original_path TEXT
+);
+
+CREATE TABLE IF NOT EXISTS destination_uploads (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	profile TEXT,
+	destination TEXT,
+	bucket TEXT,
+	filepath TEXT,
+	object_key TEXT,
+	retries INTEGER,
+	upload_outcome TEXT,
+	created TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS mirror_files (
+	profile TEXT,
+	bucket TEXT,
+	local_path TEXT,
+	object_key TEXT,
+	size INTEGER,
+	mtime INTEGER,
+	md5 TEXT,
+	uploaded TIMESTAMP,
+	PRIMARY KEY (profile, bucket, local_path)
+);
+
+CREATE TABLE IF NOT EXISTS verify_results (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	profile TEXT,
+	bucket TEXT,
+	filepath TEXT,
+	object_key TEXT,
+	status TEXT,
+	detail TEXT,
+	checked TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS failed_redrives (
+	profile TEXT,
+	filepath TEXT,
+	mtime INTEGER,
+	cycles INTEGER,
+	last_redrive TIMESTAMP,
+	PRIMARY KEY (profile, filepath)
+);
+
+CREATE TABLE IF NOT EXISTS restores (
+	profile TEXT,
+	bucket TEXT,
+	object_key TEXT,
+	tier TEXT,
+	days INTEGER,
+	status TEXT,
+	requested TIMESTAMP,
+	restored TIMESTAMP,
+	PRIMARY KEY (profile, bucket, object_key)
+);
+
+CREATE TABLE IF NOT EXISTS deletions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	profile TEXT,
+	bucket TEXT,
+	original_path TEXT,
+	object_key TEXT,
+	retries INTEGER,
+	outcome TEXT,
+	created TIMESTAMP
+);
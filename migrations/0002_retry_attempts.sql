@@ -0,0 +1,13 @@
+-- Every failed attempt of a file record, for disputes with providers.
+
+CREATE TABLE IF NOT EXISTS retry_attempts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	record_id INTEGER,
+	attempt INTEGER,
+	attempted TIMESTAMP,
+	error_class TEXT,
+	error_message TEXT,
+	http_status INTEGER,
+	request_id TEXT,
+	delay_ms INTEGER
+);
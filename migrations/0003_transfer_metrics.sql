@@ -0,0 +1,7 @@
+-- Transfer metrics of file records. throughput is in bytes per second.
+
+ALTER TABLE file_records ADD COLUMN bytes INTEGER;
+ALTER TABLE file_records ADD COLUMN duration_ms INTEGER;
+ALTER TABLE file_records ADD COLUMN throughput INTEGER;
+ALTER TABLE file_records ADD COLUMN part_count INTEGER;
+ALTER TABLE file_records ADD COLUMN attempts INTEGER;
@@ -0,0 +1,6 @@
+-- ETag and version of the object an upload created.
+
+ALTER TABLE file_records ADD COLUMN etag TEXT;
+ALTER TABLE file_records ADD COLUMN version_id TEXT;
+ALTER TABLE destination_uploads ADD COLUMN etag TEXT;
+ALTER TABLE destination_uploads ADD COLUMN version_id TEXT;
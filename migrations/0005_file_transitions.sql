@@ -0,0 +1,12 @@
+-- Intent log of moves out of processing; see transition.go.
+
+CREATE TABLE IF NOT EXISTS file_transitions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	profile TEXT,
+	source TEXT,
+	target TEXT,
+	outcome TEXT,
+	record TEXT,
+	created TIMESTAMP
+);
+ALTER TABLE file_records ADD COLUMN transition_id INTEGER;
@@ -0,0 +1,9 @@
+-- Claims of files in processing by flood instances; see lease.go.
+
+CREATE TABLE IF NOT EXISTS file_leases (
+	filepath TEXT,
+	host TEXT,
+	pid INTEGER,
+	expires TIMESTAMP,
+	PRIMARY KEY (filepath)
+);
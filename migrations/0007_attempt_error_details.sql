@@ -0,0 +1,4 @@
+-- Provider error details of failed attempts, for support cases.
+
+ALTER TABLE retry_attempts ADD COLUMN extended_request_id TEXT;
+ALTER TABLE retry_attempts ADD COLUMN error_code TEXT;
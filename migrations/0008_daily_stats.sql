@@ -0,0 +1,24 @@
+-- Daily delivery statistics per profile and bucket, kept up to date as
+-- records are written and not pruned with them; see stats.go.
+
+CREATE TABLE IF NOT EXISTS daily_stats (
+	day TEXT,
+	profile TEXT,
+	bucket TEXT,
+	files INTEGER,
+	succeeded INTEGER,
+	failed INTEGER,
+	conflicts INTEGER,
+	failed_over INTEGER,
+	retries INTEGER,
+	bytes INTEGER,
+	duration_ms INTEGER,
+	PRIMARY KEY (day, profile, bucket)
+);
+
+CREATE VIEW daily_delivery_health AS
+SELECT day, profile, bucket, files, succeeded, failed, conflicts, failed_over, bytes,
+	(failed + conflicts) * 1.0 / files AS failure_rate,
+	retries * 1.0 / files AS mean_retries,
+	CASE WHEN duration_ms > 0 THEN bytes * 1000 / duration_ms END AS throughput
+FROM daily_stats;
@@ -0,0 +1,3 @@
+-- Correlation ID of the log lines about a record's file.
+
+ALTER TABLE file_records ADD COLUMN correlation_id TEXT;
@@ -0,0 +1,9 @@
+-- Last readiness check of each flood instance; the check writes here to
+-- prove the database accepts writes. See health.go.
+
+CREATE TABLE IF NOT EXISTS health_checks (
+	host TEXT,
+	pid INTEGER,
+	checked TIMESTAMP,
+	PRIMARY KEY (host, pid)
+);
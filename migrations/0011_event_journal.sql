@@ -0,0 +1,9 @@
+-- Every file event in the order it happened, for consumers that tail it
+-- with flood events. See journal.go.
+
+CREATE TABLE IF NOT EXISTS event_journal (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recorded TIMESTAMP,
+	event TEXT,
+	body TEXT
+);
@@ -0,0 +1,8 @@
+-- Profiles paused through the admin API or flood ctl; they stay paused
+-- across restarts until resumed. See queue.go.
+
+CREATE TABLE IF NOT EXISTS paused_profiles (
+	profile TEXT,
+	paused TIMESTAMP,
+	PRIMARY KEY (profile)
+);
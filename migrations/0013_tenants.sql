@@ -0,0 +1,5 @@
+-- Tenant of the profile of a file record and of daily statistics, for a
+-- service shared by tenants. See tenant.go.
+
+ALTER TABLE file_records ADD COLUMN tenant TEXT;
+ALTER TABLE daily_stats ADD COLUMN tenant TEXT;
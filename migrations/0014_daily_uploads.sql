@@ -0,0 +1,5 @@
+-- Files and bytes actually transferred per day, which quotas count; files
+-- that were already present are succeeded but not uploaded. See quota.go.
+
+ALTER TABLE daily_stats ADD COLUMN uploaded INTEGER DEFAULT 0;
+ALTER TABLE daily_stats ADD COLUMN uploaded_bytes INTEGER DEFAULT 0;
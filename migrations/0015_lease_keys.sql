@@ -0,0 +1,14 @@
+-- Leases are keyed by the SHA-256 of the path, since MySQL only indexes a
+-- prefix of a TEXT key, and expire at Unix nanoseconds, which compare the
+-- same in every time zone. Leases only last as long as an upload, so the
+-- old ones are dropped. See lease.go.
+
+DROP TABLE IF EXISTS file_leases;
+CREATE TABLE IF NOT EXISTS file_leases (
+	path_hash TEXT,
+	filepath TEXT,
+	host TEXT,
+	pid INTEGER,
+	expires INTEGER,
+	PRIMARY KEY (path_hash)
+);
@@ -0,0 +1,5 @@
+-- Modification time of the file uploaded to a fan-out destination, so that
+-- a retry skips the destinations that already have this version of the
+-- file. See fanout.go.
+
+ALTER TABLE destination_uploads ADD COLUMN mtime INTEGER;
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// mirror keeps a local directory continuously uploaded to a bucket prefix,
+// without the incoming/processing/completed directories of server mode:
+//
+//	flood mirror [-interval 1m] [-settle 5s] [-hash] localdir s3://profile/bucket/prefix
+//
+// New and changed files are uploaded; files are never moved or deleted, and
+// deleting a local file leaves its object in place. A file counts as changed
+// when its size or modification time differs from the last upload. With
+// -hash, a file whose modification time changed but whose size did not is
+// only uploaded again if its MD5 differs. What was uploaded is kept in the
+// mirror_files table.
+type mirror struct {
+	root    string
+	profile Profile
+	bucket  string
+	prefix  string
+	settle  time.Duration
+	useHash bool
+
+	kick    chan struct{}
+	jobs    chan string
+	mu      sync.Mutex
+	pending map[string]bool
+}
+
+func runMirrorMode(args []string) {
+	fs := flag.NewFlagSet("mirror", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Minute, "Interval of full rescans in addition to file system events")
+	settle := fs.Duration("settle", 5*time.Second, "How long a file must be unchanged before it is uploaded")
+	useHash := fs.Bool("hash", false, "Compare MD5 hashes to skip files whose modification time changed but content did not")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] mirror [mirror flags] localdir s3://profile/bucket/prefix")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	profile, bucket, prefix, err := parseS3URI(fs.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := destinationFor(profile).validate(bucket); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	root := filepath.Clean(fs.Arg(0))
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		log.Fatalf("Invalid mirror directory %s", root)
+	}
+
+	m := &mirror{
+		root:    root,
+		profile: profile,
+		bucket:  bucket,
+		prefix:  prefix,
+		settle:  *settle,
+		useHash: *useHash,
+		kick:    make(chan struct{}, 1),
+		jobs:    make(chan string, queueDepth),
+		pending: make(map[string]bool),
+	}
+	for i := 0; i < profile.Workers; i++ {
+		go m.worker()
+	}
+	m.watch()
+
+	log.Printf("Mirroring %s to s3://%s/%s/%s", root, profile.Name, bucket, prefix)
+	ticker := time.NewTicker(*interval)
+	m.notify()
+	for {
+		select {
+		case <-m.kick:
+		case <-ticker.C:
+		}
+		m.scan()
+	}
+}
+
+func (m *mirror) notify() {
+	select {
+	case m.kick <- struct{}{}:
+	default:
+	}
+}
+
+// watch triggers a scan whenever something changes below the root.
+func (m *mirror) watch() {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	addDirs := func(dir string) {
+		filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				if err := w.Add(p); err != nil {
+					log.Printf("Failed to watch %s: %v", p, err)
+				}
+			}
+			return nil
+		})
+	}
+	addDirs(m.root)
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if event.Op&fsnotify.Create == fsnotify.Create {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						addDirs(event.Name)
+					}
+				}
+				watcherLog.Debug("File system event", "op", event.Op.String(), "path", event.Name)
+				m.notify()
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				watcherLog.Error("Watcher error", "error", err)
+			}
+		}
+	}()
+}
+
+// scan queues every file that changed since its last upload. Files that are
+// still being written are picked up by another scan once they settled.
+func (m *mirror) scan() {
+	settling := false
+	filepath.Walk(m.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || isSidecar(p) {
+			return nil
+		}
+		if time.Since(info.ModTime()) < m.settle {
+			settling = true
+			return nil
+		}
+
+		m.mu.Lock()
+		queued := m.pending[p]
+		m.mu.Unlock()
+		if queued || !m.changed(p, info) {
+			return nil
+		}
+
+		m.mu.Lock()
+		m.pending[p] = true
+		m.mu.Unlock()
+		m.jobs <- p
+		return nil
+	})
+	if settling {
+		time.AfterFunc(m.settle, m.notify)
+	}
+}
+
+func (m *mirror) objectKey(p string) (string, string, error) {
+	relativePath, err := filepath.Rel(m.root, p)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := objectKey(m.profile, m.bucket, relativePath)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.ToSlash(relativePath), path.Join(m.prefix, key), nil
+}
+
+// changed compares a file with the state of its last upload.
+func (m *mirror) changed(p string, info os.FileInfo) bool {
+	var (
+		size, mtime int64
+		sum         sql.NullString
+	)
+	err := db.QueryRow(
+		"SELECT size, mtime, md5 FROM mirror_files WHERE profile = ? AND bucket = ? AND local_path = ?",
+		m.profile.Name, m.bucket, p,
+	).Scan(&size, &mtime, &sum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true
+	}
+	if err != nil {
+		log.Printf("Failed to look up mirror state of %s: %v", p, err)
+		return true
+	}
+	if size != info.Size() {
+		return true
+	}
+	if mtime == info.ModTime().UnixNano() {
+		return false
+	}
+	if !m.useHash || !sum.Valid {
+		return true
+	}
+
+	current, err := fileMD5(p)
+	if err != nil || hex.EncodeToString(current) != sum.String {
+		return true
+	}
+	// Same content, only touched: remember the new time
+	db.Exec("UPDATE mirror_files SET mtime = ? WHERE profile = ? AND bucket = ? AND local_path = ?", info.ModTime().UnixNano(), m.profile.Name, m.bucket, p)
+	return false
+}
+
+func (m *mirror) worker() {
+	for p := range m.jobs {
+		m.upload(p)
+		m.mu.Lock()
+		delete(m.pending, p)
+		m.mu.Unlock()
+	}
+}
+
+func (m *mirror) upload(p string) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return
+	}
+	rec := fileRecord{Path: p, Profile: m.profile, Bucket: m.bucket, Operation: "mirror"}
+	rec.OriginalPath, rec.Key, err = m.objectKey(p)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		logRetry(rec, "failure")
+		return
+	}
+	rec.Meta, err = loadSidecar(p)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		logRetry(rec, "failure")
+		return
+	}
+
+	var sum string
+	if m.useHash {
+		if digest, err := fileMD5(p); err == nil {
+			sum = hex.EncodeToString(digest)
+		}
+	}
+
+	if err := uploadWithRetry(&rec); err != nil {
+		log.Printf("Failed to mirror %s: %v", p, err)
+		discardMultipartUpload(m.profile, p)
+		logRetry(rec, "failure")
+		return
+	}
+	logRetry(rec, "success")
+
+	_, err = db.Exec(
+		`INSERT INTO mirror_files(profile, bucket, local_path, object_key, size, mtime, md5, uploaded) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(profile, bucket, local_path) DO UPDATE SET object_key = excluded.object_key, size = excluded.size, mtime = excluded.mtime, md5 = excluded.md5, uploaded = excluded.uploaded`,
+		m.profile.Name, m.bucket, p, rec.Key, info.Size(), info.ModTime().UnixNano(), optionalString(sum), time.Now(),
+	)
+	if err != nil {
+		log.Printf("Failed to record mirror state of %s: %v", p, err)
+	}
+}
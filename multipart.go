@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	minPartSize = 5 << 20 // S3 minimum for every part except the last
+	maxPartSize = 5 << 30 // S3 maximum size of a single part
+	maxParts    = 10000   // S3 maximum number of parts per upload
+)
+
+func validatePartSize(size int64) error {
+	if size < minPartSize || size > maxPartSize {
+		return fmt.Errorf("part size %d must be between %d and %d bytes", size, int64(minPartSize), int64(maxPartSize))
+	}
+	return nil
+}
+
+// effectivePartSize grows the configured part size when a file is too large
+// to fit within the S3 limit of 10,000 parts.
+func effectivePartSize(configured, fileSize int64) int64 {
+	size := configured
+	for fileSize > size*maxParts {
+		size *= 2
+	}
+	if size != configured {
+		uploaderLog.Warn(fmt.Sprintf("Part size %d is too small for %d bytes, using %d", configured, fileSize, size))
+	}
+	return size
+}
+
+// multipartState is the persisted progress of a multipart upload, used to
+// resume it after the process restarts.
+type multipartState struct {
+	Bucket    string
+	Key       string
+	UploadID  string
+	FileSize  int64
+	ModTime   int64
+	PartSize  int64
+	Parts     map[int32]string // part number -> ETag
+	Checksums map[int32]string // part number -> checksum, if any
+}
+
+// uploadMultipart uploads f in parts of partSize bytes, with up to
+// concurrency parts in flight at once. Progress is persisted in the database
+// after every part, so an upload interrupted by a failure or a restart
+// resumes from the last completed part instead of starting over. It returns
+// the ETag, version and checksum, if any, of the completed object, and its
+// key, which is that of the interrupted upload when one is resumed.
+func uploadMultipart(client *s3.Client, f *os.File, info os.FileInfo, profile Profile, bucket, key string, partSize int64, concurrency int, meta *sidecarMetadata) (uploadResult, error) {
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	path := f.Name()
+	size := info.Size()
+	algorithm := checksumAlgorithm(profile)
+
+	state, err := loadMultipartState(profile.Name, path)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to load multipart state: %w", err)
+	}
+	if state != nil && (state.FileSize != size || state.ModTime != info.ModTime().UnixNano() || state.PartSize != partSize) {
+		log.Printf("File %s changed since multipart upload %s started, starting over", path, state.UploadID)
+		discardMultipartUpload(profile, path)
+		state = nil
+	}
+	if state != nil && state.Key != key {
+		// The key prefix may depend on the date; keep the key the upload
+		// was started with rather than discarding the uploaded parts.
+		log.Printf("Resuming %s under its original key %s", path, state.Key)
+		key = state.Key
+	}
+
+	if state == nil {
+		input := &s3.CreateMultipartUploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		applyMultipartUploadOptions(input, profile, meta)
+		created, err := client.CreateMultipartUpload(ctx, input)
+		if err != nil {
+			return uploadResult{}, fmt.Errorf("failed to create multipart upload: %w", err)
+		}
+		state = &multipartState{
+			Bucket:    bucket,
+			Key:       key,
+			UploadID:  aws.ToString(created.UploadId),
+			FileSize:  size,
+			ModTime:   info.ModTime().UnixNano(),
+			PartSize:  partSize,
+			Parts:     make(map[int32]string),
+			Checksums: make(map[int32]string),
+		}
+		if err := saveMultipartState(profile.Name, path, state); err != nil {
+			abortMultipart(client, bucket, key, created.UploadId)
+			return uploadResult{}, fmt.Errorf("failed to save multipart state: %w", err)
+		}
+	} else {
+		log.Printf("Resuming multipart upload %s of %s with %d part(s) already uploaded", state.UploadID, key, len(state.Parts))
+	}
+	uploadID := aws.String(state.UploadID)
+
+	partCount := int((size + partSize - 1) / partSize)
+	uploaderLog.Info(fmt.Sprintf("Starting multipart upload of %s (%d parts of %d bytes, concurrency %d)", key, partCount, partSize, concurrency))
+
+	partNumbers := make(chan int32)
+	var (
+		mu        sync.Mutex
+		completed []types.CompletedPart
+		firstErr  error
+		wg        sync.WaitGroup
+	)
+	for partNumber, etag := range state.Parts {
+		completed = append(completed, completedPart(partNumber, etag, algorithm, state.Checksums[partNumber]))
+	}
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range partNumbers {
+				offset := int64(partNumber-1) * partSize
+				length := partSize
+				if offset+length > size {
+					length = size - offset
+				}
+
+				out, err := client.UploadPart(ctx, &s3.UploadPartInput{
+					Bucket:            aws.String(bucket),
+					Key:               aws.String(key),
+					UploadId:          uploadID,
+					PartNumber:        aws.Int32(partNumber),
+					Body:              uploadBody(io.NewSectionReader(f, offset, length), profile),
+					ContentLength:     aws.Int64(length),
+					ChecksumAlgorithm: algorithm,
+				})
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to upload part %d: %w", partNumber, err)
+						cancel()
+					}
+				} else {
+					checksum := checksumValue(algorithm, out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256)
+					completed = append(completed, completedPart(partNumber, aws.ToString(out.ETag), algorithm, checksum))
+					if err := saveMultipartPart(state.UploadID, partNumber, aws.ToString(out.ETag), checksum); err != nil {
+						log.Printf("Failed to record part %d of upload %s: %v", partNumber, state.UploadID, err)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for partNumber := int32(1); int(partNumber) <= partCount; partNumber++ {
+		if _, done := state.Parts[partNumber]; done {
+			continue
+		}
+		select {
+		case partNumbers <- partNumber:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(partNumbers)
+	wg.Wait()
+
+	if firstErr != nil {
+		// Leave the upload open so that the next attempt can resume it.
+		// Uploads that are given up on are aborted by discardMultipartUpload.
+		if isNoSuchUpload(firstErr) {
+			deleteMultipartState(state.UploadID)
+		}
+		return uploadResult{}, firstErr
+	}
+
+	sort.Slice(completed, func(i, j int) bool {
+		return *completed[i].PartNumber < *completed[j].PartNumber
+	})
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String(key),
+		UploadId:        uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}
+	if profile.IfNoneMatch {
+		input.IfNoneMatch = aws.String("*")
+	}
+	out, err := client.CompleteMultipartUpload(context.TODO(), input)
+	if err != nil {
+		if httpStatusCode(err) == http.StatusPreconditionFailed {
+			// The parts are useless now; discardMultipartUpload aborts the
+			// upload when the file is moved to failed.
+			return uploadResult{}, fmt.Errorf("%w: %s", errConflict, key)
+		}
+		if isNoSuchUpload(err) {
+			deleteMultipartState(state.UploadID)
+		}
+		return uploadResult{}, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	deleteMultipartState(state.UploadID)
+	return uploadResult{
+		Checksum:  checksumValue(algorithm, out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256),
+		ETag:      aws.ToString(out.ETag),
+		VersionID: aws.ToString(out.VersionId),
+		Key:       key,
+	}, nil
+}
+
+// applyMultipartUploadOptions sets the metadata and the profile's object
+// options on a CreateMultipartUpload request.
+func applyMultipartUploadOptions(input *s3.CreateMultipartUploadInput, profile Profile, meta *sidecarMetadata) {
+	meta.applyToMultipartUpload(input)
+	input.Tagging = optionalString(objectTagging(profile, meta))
+	input.ServerSideEncryption = profile.SSE
+	input.SSEKMSKeyId = optionalString(profile.KMSKeyID)
+	input.ACL = profile.ACL
+	if profile.ObjectLockMode != "" {
+		input.ObjectLockMode = profile.ObjectLockMode
+		input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(profile.ObjectLockFor))
+	}
+	input.ChecksumAlgorithm = checksumAlgorithm(profile)
+	input.StorageClass = profile.StorageClass
+}
+
+// isNoSuchUpload reports whether the server no longer knows the upload, e.g.
+// because a lifecycle rule aborted it while the process was down.
+func isNoSuchUpload(err error) bool {
+	var noSuchUpload *types.NoSuchUpload
+	return errors.As(err, &noSuchUpload)
+}
+
+// discardMultipartUpload aborts any unfinished multipart upload of the given
+// file and forgets its state. It is called when a file is given up on.
+func discardMultipartUpload(profile Profile, path string) {
+	rows, err := db.Query("SELECT bucket, object_key, upload_id FROM multipart_uploads WHERE profile = ? AND filepath = ?", profile.Name, path)
+	if err != nil {
+		log.Printf("Failed to look up multipart uploads for %s: %v", path, err)
+		return
+	}
+
+	type pending struct{ bucket, key, uploadID string }
+	var uploads []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.bucket, &p.key, &p.uploadID); err != nil {
+			log.Printf("Failed to read multipart upload for %s: %v", path, err)
+			continue
+		}
+		uploads = append(uploads, p)
+	}
+	rows.Close()
+
+	if len(uploads) == 0 {
+		return
+	}
+	client := newS3Client(profile)
+	for _, p := range uploads {
+		log.Printf("Aborting multipart upload %s of %s", p.uploadID, p.key)
+		abortMultipart(client, p.bucket, p.key, aws.String(p.uploadID))
+		deleteMultipartState(p.uploadID)
+	}
+}
+
+func loadMultipartState(profileName, path string) (*multipartState, error) {
+	state := &multipartState{Parts: make(map[int32]string), Checksums: make(map[int32]string)}
+	err := db.QueryRow(
+		"SELECT bucket, object_key, upload_id, file_size, file_mtime, part_size FROM multipart_uploads WHERE profile = ? AND filepath = ?",
+		profileName, path,
+	).Scan(&state.Bucket, &state.Key, &state.UploadID, &state.FileSize, &state.ModTime, &state.PartSize)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT part_number, etag, checksum FROM multipart_parts WHERE upload_id = ?", state.UploadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			partNumber int32
+			etag       string
+			checksum   sql.NullString
+		)
+		if err := rows.Scan(&partNumber, &etag, &checksum); err != nil {
+			return nil, err
+		}
+		state.Parts[partNumber] = etag
+		state.Checksums[partNumber] = checksum.String
+	}
+	return state, rows.Err()
+}
+
+func saveMultipartState(profileName, path string, state *multipartState) error {
+	_, err := db.Exec(
+		"INSERT INTO multipart_uploads(profile, bucket, object_key, filepath, file_size, file_mtime, part_size, upload_id, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		profileName, state.Bucket, state.Key, path, state.FileSize, state.ModTime, state.PartSize, state.UploadID, time.Now(),
+	)
+	return err
+}
+
+func saveMultipartPart(uploadID string, partNumber int32, etag, checksum string) error {
+	_, err := db.Exec(`INSERT INTO multipart_parts(upload_id, part_number, etag, checksum) VALUES (?, ?, ?, ?)
+		ON CONFLICT(upload_id, part_number) DO UPDATE SET etag = excluded.etag, checksum = excluded.checksum`, uploadID, partNumber, etag, checksum)
+	return err
+}
+
+func deleteMultipartState(uploadID string) {
+	if _, err := db.Exec("DELETE FROM multipart_parts WHERE upload_id = ?", uploadID); err != nil {
+		log.Printf("Failed to delete parts of multipart upload %s: %v", uploadID, err)
+	}
+	if _, err := db.Exec("DELETE FROM multipart_uploads WHERE upload_id = ?", uploadID); err != nil {
+		log.Printf("Failed to delete multipart upload %s: %v", uploadID, err)
+	}
+}
+
+func abortMultipart(client *s3.Client, bucket, key string, uploadID *string) {
+	_, err := client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.Printf("Failed to abort multipart upload %s for %s: %v", aws.ToString(uploadID), key, err)
+	}
+}
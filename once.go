@@ -0,0 +1,84 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+)
+
+// Exit statuses of -once. Setup errors exit with 1 through log.Fatal.
+const (
+	exitOnceFailures = 3 // one or more files failed or conflicted
+)
+
+// runOnce moves everything in the incoming directory to processing, uploads
+// everything in processing and exits. Each profile uploads with its own
+// workers as in server mode, and partial bundles are flushed right away.
+// Files handed to a failover profile are uploaded in a further pass. The
+// exit status is 0 if every file was uploaded or already present and
+// exitOnceFailures otherwise.
+func runOnce() {
+	processIncomingFiles()
+
+	var (
+		mu        sync.Mutex
+		attempted = make(map[string]bool)
+		counts    = make(map[string]int)
+	)
+	for pass := 1; ; pass++ {
+		var (
+			wg    sync.WaitGroup
+			found bool
+		)
+		for _, profile := range profiles {
+			jobs := collectJobs(profile, true, func(path string) bool {
+				mu.Lock()
+				defer mu.Unlock()
+				return attempted[path]
+			})
+			if len(jobs) == 0 {
+				continue
+			}
+			found = true
+			log.Printf("Pass %d: %d upload(s) for profile %s", pass, len(jobs), profile.Name)
+
+			mu.Lock()
+			for _, job := range jobs {
+				for _, path := range job.paths() {
+					attempted[path] = true
+				}
+			}
+			mu.Unlock()
+
+			ch := make(chan uploadJob)
+			for i := 0; i < profile.Workers; i++ {
+				wg.Add(1)
+				go func(profile Profile) {
+					defer wg.Done()
+					for job := range ch {
+						outcome := runJob(profile, job)
+						mu.Lock()
+						counts[outcome] += len(job.paths())
+						mu.Unlock()
+					}
+				}(profile)
+			}
+			go func() {
+				for _, job := range jobs {
+					ch <- job
+				}
+				close(ch)
+			}()
+		}
+		wg.Wait()
+		if !found {
+			break
+		}
+	}
+
+	log.Printf("Finished: %d uploaded, %d already present, %d failed over, %d conflicts, %d failed, %d held by other instances",
+		counts["success"], counts["already_present"], counts["failed_over"], counts["conflict"], counts["failure"], counts["leased"])
+	if counts["conflict"] > 0 || counts["failure"] > 0 {
+		os.Exit(exitOnceFailures)
+	}
+}
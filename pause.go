@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Server mode can be paused at runtime, e.g. during a provider's
+// maintenance window:
+//
+//	kill -USR1 <pid>   # pause
+//	kill -USR2 <pid>   # resume
+//
+// While paused, files stay in incoming and no upload is started; uploads
+// that are in flight finish normally. On resume the incoming directory is
+// processed again.
+var pause struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	paused bool
+}
+
+func init() {
+	pause.cond = sync.NewCond(&pause.mu)
+}
+
+// handlePauseSignals pauses and resumes processing on SIGUSR1 and SIGUSR2.
+func handlePauseSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			setPaused(sig == syscall.SIGUSR1)
+		}
+	}()
+}
+
+func setPaused(paused bool) {
+	pause.mu.Lock()
+	changed := pause.paused != paused
+	pause.paused = paused
+	pause.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	if paused {
+		log.Printf("Paused: no new files are taken in or uploaded until resumed")
+		return
+	}
+	log.Printf("Resumed")
+	pause.cond.Broadcast()
+	if watchMode == watchModeNotify {
+		// The poller picks the files up by itself once they are stable.
+		processIncomingFiles()
+	}
+}
+
+func isPaused() bool {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	return pause.paused
+}
+
+// waitWhilePaused blocks until processing is not paused.
+func waitWhilePaused() {
+	pause.mu.Lock()
+	defer pause.mu.Unlock()
+	for pause.paused {
+		pause.cond.Wait()
+	}
+}
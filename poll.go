@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Watch modes of the incoming directory. inotify does not see changes made
+// on other hosts, so for NFS or CIFS mounts the directory can be polled
+// instead.
+const (
+	watchModeNotify = "notify"
+	watchModePoll   = "poll"
+)
+
+// fileState is what the poller remembers about a file in incoming.
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// pollIncoming rescans the incoming directory every pollInterval. Without
+// close events to tell when a file is complete, a file is only taken in
+// once its size and modification time are unchanged between two scans.
+func pollIncoming() {
+	log.Printf("Polling %s every %s", filepath.Join(serverDir, "incoming"), pollInterval)
+	watcherRunning.Store(true)
+	defer watcherRunning.Store(false)
+	seen := make(map[string]fileState)
+	for !isDraining() {
+		current := make(map[string]fileState)
+		filepath.Walk(filepath.Join(serverDir, "incoming"), func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			state := fileState{size: info.Size(), modTime: info.ModTime()}
+			if previous, ok := seen[path]; ok && previous == state {
+				handleFileEvent(path)
+				return nil
+			}
+			current[path] = state
+			return nil
+		})
+		seen = current
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// maxPresignExpiry is the longest validity SigV4 allows for a presigned URL.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// runPresignMode prints a presigned URL for an object:
+//
+//	flood presign s3://profile/bucket/key -expires 1h
+//	flood presign -method PUT s3://profile/bucket/key
+//
+// Flags may come before or after the URI. Headers that the holder of a PUT
+// URL has to send are printed after the URL, one per line.
+func runPresignMode(args []string) {
+	fs := flag.NewFlagSet("presign", flag.ExitOnError)
+	expires := fs.Duration("expires", time.Hour, "How long the URL is valid, at most 168h")
+	method := fs.String("method", http.MethodGet, "HTTP method the URL is for: GET or PUT")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] presign [presign flags] s3://profile/bucket/key [presign flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	uri := fs.Arg(0)
+	if fs.NArg() > 0 {
+		fs.Parse(fs.Args()[1:])
+	}
+	if uri == "" || fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if *expires <= 0 || *expires > maxPresignExpiry {
+		log.Fatalf("Invalid -expires %s: must be positive and at most %s", *expires, maxPresignExpiry)
+	}
+
+	profile, bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if key == "" {
+		log.Fatalf("Invalid S3 URI %s: missing key", uri)
+	}
+	if profile.Destination != nil {
+		log.Fatalf("presign is not supported by provider %s", profile.Provider)
+	}
+
+	client := s3.NewPresignClient(newS3Client(profile))
+	var req *v4.PresignedHTTPRequest
+	switch strings.ToUpper(*method) {
+	case http.MethodGet:
+		req, err = client.PresignGetObject(context.TODO(), &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}, s3.WithPresignExpires(*expires))
+	case http.MethodPut:
+		req, err = client.PresignPutObject(context.TODO(), &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}, s3.WithPresignExpires(*expires))
+	default:
+		log.Fatalf("Invalid -method %q: must be GET or PUT", *method)
+	}
+	if err != nil {
+		log.Fatalf("Failed to presign %s: %v", uri, err)
+	}
+
+	fmt.Println(req.URL)
+	for name, values := range req.SignedHeader {
+		if strings.EqualFold(name, "Host") {
+			continue
+		}
+		for _, value := range values {
+			fmt.Printf("%s: %s\n", name, value)
+		}
+	}
+	log.Printf("Presigned %s %s, valid until %s", strings.ToUpper(*method), uri, time.Now().Add(*expires).Format(time.RFC3339))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// priorityFile may be placed in a bucket directory under incoming to give the
+// files of that bucket a priority. It holds a single integer; buckets with a
+// higher number are uploaded first and the default is 0.
+const priorityFile = ".priority"
+
+// Upload orders within the same priority.
+var uploadOrders = []string{"arrival", "smallest-first", "largest-first"}
+
+// bucketPriority reads the priority of a bucket directory.
+func bucketPriority(profile Profile, bucket string) int {
+	data, err := os.ReadFile(filepath.Join(serverDir, "incoming", profile.Name, bucket, priorityFile))
+	if err != nil {
+		return 0
+	}
+	priority, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		log.Printf("Ignoring invalid priority file in bucket %s of profile %s: %v", bucket, profile.Name, err)
+		return 0
+	}
+	return priority
+}
+
+// prioritize sorts jobs by bucket priority and then by the profile's upload
+// order: arrival (oldest first), smallest-first or largest-first.
+func prioritize(profile Profile, jobs []uploadJob) []uploadJob {
+	type ranked struct {
+		job      uploadJob
+		priority int
+		size     int64
+		arrived  time.Time
+	}
+
+	priorities := make(map[string]int)
+	rankedJobs := make([]ranked, 0, len(jobs))
+	for _, job := range jobs {
+		priority, ok := priorities[job.bucket]
+		if !ok {
+			priority = bucketPriority(profile, job.bucket)
+			priorities[job.bucket] = priority
+		}
+
+		r := ranked{job: job, priority: priority}
+		for _, path := range job.paths() {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			r.size += info.Size()
+			if r.arrived.IsZero() || info.ModTime().Before(r.arrived) {
+				r.arrived = info.ModTime()
+			}
+		}
+		rankedJobs = append(rankedJobs, r)
+	}
+
+	sort.SliceStable(rankedJobs, func(i, j int) bool {
+		a, b := rankedJobs[i], rankedJobs[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		switch profile.Order {
+		case "smallest-first":
+			return a.size < b.size
+		case "largest-first":
+			return a.size > b.size
+		}
+		return a.arrived.Before(b.arrived)
+	})
+
+	for i, r := range rankedJobs {
+		jobs[i] = r.job
+	}
+	return jobs
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// pruneTables lists the history tables that are pruned and the column that
+// dates their rows. Tables holding state that is still needed, such as
+// multipart uploads in progress or the mirror state, are never pruned.
+var pruneTables = []struct {
+	table  string
+	column string
+	where  string // additional condition
+}{
+	{"file_records", "last_retry", ""},
+	{"retry_attempts", "attempted", ""},
+	{"destination_uploads", "created", ""},
+	{"verify_results", "checked", ""},
+	{"deletions", "created", ""},
+	{"restores", "restored", "status = 'restored'"},
+	{"bundles", "created", ""},
+	{"event_journal", "recorded", ""},
+}
+
+// runPruneMode deletes history older than the retention period:
+//
+//	flood prune [-retention 90d] [-archive old-records.jsonl]
+//
+// The retention defaults to -db-retention. With -archive the rows are
+// appended to the given file as JSON lines before they are deleted.
+func runPruneMode(args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	retentionArg := fs.String("retention", dbRetentionArg, "Delete records older than this (e.g. 90d)")
+	archivePath := fs.String("archive", "", "Append the pruned records to this file as JSON lines")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] prune [prune flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	retention, err := parseDuration(*retentionArg)
+	if err != nil || retention <= 0 {
+		log.Fatalf("Invalid -retention %q: a positive duration such as 90d is required", *retentionArg)
+	}
+
+	var archive io.Writer
+	if *archivePath != "" {
+		f, err := os.OpenFile(*archivePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		archive = f
+	}
+	if _, err := pruneRecords(time.Now().Add(-retention), archive); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// schedulePrune prunes the history every day in server mode.
+func schedulePrune() {
+	for {
+		if _, err := pruneRecords(time.Now().Add(-dbRetention), nil); err != nil {
+			log.Printf("Failed to prune database: %v", err)
+		}
+		time.Sleep(24 * time.Hour)
+	}
+}
+
+// pruneRecords deletes the rows of the history tables dated before cutoff,
+// writing them to archive first if it is not nil, and returns the number of
+// rows deleted.
+func pruneRecords(cutoff time.Time, archive io.Writer) (int64, error) {
+	var total int64
+	for _, t := range pruneTables {
+		where := fmt.Sprintf("%s < ?", t.column)
+		if t.where != "" {
+			where += " AND " + t.where
+		}
+		if archive != nil {
+			if err := archiveRows(archive, t.table, where, cutoff); err != nil {
+				return total, fmt.Errorf("failed to archive %s: %w", t.table, err)
+			}
+		}
+		result, err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s", t.table, where), cutoff)
+		if err != nil {
+			return total, fmt.Errorf("failed to prune %s: %w", t.table, err)
+		}
+		n, _ := result.RowsAffected()
+		if n > 0 {
+			log.Printf("Pruned %d row(s) from %s older than %s", n, t.table, cutoff.Format(time.RFC3339))
+		}
+		total += n
+	}
+
+	// Members of pruned bundles
+	result, err := db.Exec("DELETE FROM bundle_members WHERE bundle_id NOT IN (SELECT id FROM bundles)")
+	if err != nil {
+		return total, fmt.Errorf("failed to prune bundle_members: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	return total + n, nil
+}
+
+// archiveRows writes the matching rows of a table as JSON lines, each with
+// the table name under "table".
+func archiveRows(w io.Writer, table, where string, args ...any) error {
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM %s WHERE %s", table, where), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		row := map[string]any{"table": table}
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				row[column] = string(b)
+			} else {
+				row[column] = values[i]
+			}
+		}
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
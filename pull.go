@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// parseS3URI splits s3://profile/bucket/key into its parts. The key may be
+// empty.
+func parseS3URI(uri string) (profile Profile, bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(uri, "s3://")
+	if !ok {
+		return Profile{}, "", "", fmt.Errorf("invalid S3 URI %s: expected s3://profile/bucket/key", uri)
+	}
+	parts := strings.SplitN(rest, "/", 3)
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Profile{}, "", "", fmt.Errorf("invalid S3 URI %s: expected s3://profile/bucket/key", uri)
+	}
+	profile, ok = profiles[parts[0]]
+	if !ok {
+		return Profile{}, "", "", fmt.Errorf("unknown profile: %s", parts[0])
+	}
+	if len(parts) == 3 {
+		key = parts[2]
+	}
+	return profile.forBucket(parts[1]), parts[1], key, nil
+}
+
+// runPullMode downloads every object below a prefix into a local directory:
+//
+//	flood pull s3://profile/bucket/prefix localdir
+//
+// Objects whose local copy already has the same size and modification time
+// are skipped, so an interrupted pull can simply be run again. Downloads use
+// the profile's workers, bandwidth limit and retry policy and are recorded
+// in file_records with operation "download".
+func runPullMode(args []string) {
+	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] pull s3://profile/bucket/prefix localdir")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	profile, bucket, prefix, err := parseS3URI(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if profile.Destination != nil {
+		log.Fatalf("pull is not supported by provider %s", profile.Provider)
+	}
+	localDir := fs.Arg(1)
+
+	client := newS3Client(profile)
+	objects := make(chan types.Object)
+	var (
+		wg              sync.WaitGroup
+		mu              sync.Mutex
+		pulled, skipped int
+		failed          int
+	)
+	for i := 0; i < profile.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range objects {
+				outcome := pullObject(client, profile, bucket, prefix, localDir, object)
+				mu.Lock()
+				switch outcome {
+				case "success":
+					pulled++
+				case "already_present":
+					skipped++
+				default:
+					failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: optionalString(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Printf("Failed to list s3://%s/%s/%s: %v", profile.Name, bucket, prefix, err)
+			mu.Lock()
+			failed++
+			mu.Unlock()
+			break
+		}
+		for _, object := range page.Contents {
+			if strings.HasSuffix(aws.ToString(object.Key), "/") {
+				continue // directory marker
+			}
+			objects <- object
+		}
+	}
+	close(objects)
+	wg.Wait()
+
+	log.Printf("Pull finished: %d downloaded, %d already present, %d failed", pulled, skipped, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// pullObject downloads a single object with retries and returns the outcome
+// recorded for it.
+func pullObject(client *s3.Client, profile Profile, bucket, prefix, localDir string, object types.Object) string {
+	key := aws.ToString(object.Key)
+	relativePath := strings.TrimPrefix(strings.TrimPrefix(key, prefix), "/")
+	if relativePath == "" {
+		relativePath = path.Base(key)
+	}
+	localPath := filepath.Join(localDir, filepath.FromSlash(relativePath))
+	rec := fileRecord{Path: localPath, Profile: profile, Bucket: bucket, OriginalPath: relativePath, Key: key, Operation: "download"}
+
+	if info, err := os.Stat(localPath); err == nil && info.Size() == aws.ToInt64(object.Size) && info.ModTime().Equal(aws.ToTime(object.LastModified)) {
+		logRetry(rec, "already_present")
+		return "already_present"
+	}
+
+	for {
+		log.Printf("Downloading s3://%s/%s/%s to %s. Retry attempt: %d", profile.Name, bucket, key, localPath, rec.Retries)
+		start := time.Now()
+		err := downloadObject(client, profile, bucket, key, localPath)
+		if err == nil {
+			rec.Bytes, rec.Duration, rec.Parts = aws.ToInt64(object.Size), time.Since(start), 1
+			logRetry(rec, "success")
+			return "success"
+		}
+
+		log.Printf("Error downloading %s: %v", key, err)
+		if !isTransientError(err) || rec.Retries >= retryLimit() {
+			rec.failedAttempt(err, isTransientError(err), 0)
+			logRetry(rec, "failure")
+			return "failure"
+		}
+		delay := retryDelay(rec.Retries)
+		rec.failedAttempt(err, true, delay)
+		time.Sleep(delay)
+		rec.Retries++
+	}
+}
+
+// downloadObject streams an object into a temporary file next to localPath
+// and renames it into place once it is complete and verified.
+func downloadObject(client *s3.Client, profile Profile, bucket, key, localPath string) error {
+	out, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get object: %w", err)
+	}
+	defer out.Body.Close()
+	defer reserveUploadMemory(localPath, 1)()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".*.flood-tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.CopyBuffer(tmp, throttleReader(out.Body, profile), newCopyBuffer())
+	if err == nil && out.ContentLength != nil && size != *out.ContentLength {
+		err = fmt.Errorf("%w: got %d of %d bytes", io.ErrUnexpectedEOF, size, *out.ContentLength)
+	}
+	if err == nil {
+		err = verifyDownload(tmp, size, aws.ToString(out.ETag), profile)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if out.LastModified != nil {
+		os.Chtimes(tmp.Name(), *out.LastModified, *out.LastModified)
+	}
+	return os.Rename(tmp.Name(), localPath)
+}
+
+// verifyDownload compares a single-part ETag with the downloaded data. The
+// part size of multipart objects is unknown, so their ETags are not checked.
+func verifyDownload(f *os.File, size int64, etag string, profile Profile) error {
+	if strings.Contains(etag, "-") {
+		return nil
+	}
+	return verifyETag(f, size, size, false, etag, profile)
+}
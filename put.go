@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// runPutMode uploads standard input to a single object without staging it
+// on disk:
+//
+//	pg_dump mydb | flood put - s3://profile/bucket/backups/mydb.sql
+//
+// Input up to the profile's part size is uploaded with a single PutObject,
+// anything larger as a multipart upload with up to PartConcurrency parts
+// buffered in memory. As standard input cannot be read twice, retries
+// happen per part rather than per object. Since the size is not known in
+// advance, the part size is not scaled up for large streams; the stream
+// fails once it exceeds 10000 parts, so set part_size accordingly. The
+// upload is recorded in file_records with operation "stream".
+func runPutMode(args []string) {
+	fs := flag.NewFlagSet("put", flag.ExitOnError)
+	contentType := fs.String("content-type", "", "Content-Type of the object")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] put [put flags] - s3://profile/bucket/key")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 || fs.Arg(0) != "-" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	profile, bucket, key, err := parseS3URI(fs.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if key == "" {
+		log.Fatalf("Invalid S3 URI %s: missing key", fs.Arg(1))
+	}
+	if profile.Destination != nil {
+		log.Fatalf("put is not supported by provider %s", profile.Provider)
+	}
+	if err := validateBucketExists(profile, bucket); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	rec := fileRecord{Path: "-", Profile: profile, Bucket: bucket, Key: key, Operation: "stream"}
+	s := &streamUpload{
+		client:      newS3Client(profile),
+		profile:     profile,
+		bucket:      bucket,
+		key:         key,
+		contentType: optionalString(*contentType),
+		rec:         &rec,
+	}
+	release := acquireUploadSlot(profile)
+	start := time.Now()
+	rec.Checksum, err = s.upload(os.Stdin)
+	release()
+	rec.Retries = s.retries
+	rec.Bytes, rec.Duration, rec.Parts = s.size, time.Since(start), max(s.parts, 1)
+	if err != nil {
+		log.Printf("Error uploading standard input to %s: %v", fs.Arg(1), err)
+		if errors.Is(err, errConflict) {
+			logRetry(rec, "conflict")
+		} else {
+			logRetry(rec, "failure")
+		}
+		os.Exit(1)
+	}
+	logRetry(rec, "success")
+	log.Printf("Uploaded %d bytes from standard input to %s", s.size, fs.Arg(1))
+}
+
+// streamUpload uploads a stream of unknown length.
+type streamUpload struct {
+	client      *s3.Client
+	profile     Profile
+	bucket      string
+	key         string
+	contentType *string
+
+	size int64 // bytes read so far
+
+	mu      sync.Mutex
+	retries int
+	rec     *fileRecord // collects the failed attempts
+	parts   int
+}
+
+// withRetry runs fn with the retry policy of uploads.
+func (s *streamUpload) withRetry(what string, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		transient := !errors.Is(err, errConflict) && isTransientError(err)
+		if !transient || attempt >= retryLimit() {
+			s.mu.Lock()
+			s.rec.failedAttempt(err, transient, 0)
+			s.mu.Unlock()
+			return err
+		}
+		log.Printf("Error uploading %s of %s, retrying: %v", what, s.key, err)
+		delay := retryDelay(attempt)
+		s.mu.Lock()
+		s.retries++
+		s.rec.failedAttempt(err, true, delay)
+		s.mu.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+// upload reads r to the end and returns the checksum of the object, if the
+// profile uses a checksum algorithm.
+func (s *streamUpload) upload(r io.Reader) (string, error) {
+	partSize := s.profile.PartSize
+	reserved := globalMemory.reserve(partSize)
+	first := make([]byte, partSize)
+	n, err := io.ReadFull(r, first)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		defer globalMemory.release(reserved)
+		s.size = int64(n)
+		return s.putObject(first[:n])
+	}
+	globalMemory.release(reserved)
+	if err != nil {
+		return "", fmt.Errorf("failed to read standard input: %w", err)
+	}
+	return s.uploadMultipart(first, r)
+}
+
+func (s *streamUpload) putObject(data []byte) (string, error) {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.key),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   s.contentType,
+	}
+	applyPutObjectOptions(input, s.profile, nil)
+
+	var out *s3.PutObjectOutput
+	err := s.withRetry("object", func() error {
+		input.Body = throttle(bytes.NewReader(data), s.profile)
+		var err error
+		out, err = s.client.PutObject(context.TODO(), input)
+		if err != nil && httpStatusCode(err) == http.StatusPreconditionFailed {
+			return fmt.Errorf("%w: %s", errConflict, s.key)
+		}
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload object: %w", err)
+	}
+	return checksumValue(input.ChecksumAlgorithm, out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256), nil
+}
+
+// uploadMultipart uploads first and the rest of r as parts of the profile's
+// part size. The reader stays at most PartConcurrency parts ahead of the
+// uploads.
+func (s *streamUpload) uploadMultipart(first []byte, r io.Reader) (string, error) {
+	partSize := s.profile.PartSize
+	concurrency := s.profile.PartConcurrency
+	reserved := globalMemory.reserve(int64(concurrency+1) * partSize)
+	defer globalMemory.release(reserved)
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		ContentType: s.contentType,
+	}
+	applyMultipartUploadOptions(input, s.profile, nil)
+	var created *s3.CreateMultipartUploadOutput
+	err := s.withRetry("multipart upload", func() error {
+		var err error
+		created, err = s.client.CreateMultipartUpload(context.TODO(), input)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	algorithm := input.ChecksumAlgorithm
+	log.Printf("Starting multipart upload of standard input to %s (parts of %d bytes, concurrency %d)", s.key, partSize, concurrency)
+
+	type part struct {
+		number int32
+		data   []byte
+	}
+	parts := make(chan part)
+	var (
+		completed []types.CompletedPart
+		firstErr  error
+		wg        sync.WaitGroup
+	)
+	failed := func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return firstErr != nil
+	}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range parts {
+				if failed() {
+					continue
+				}
+				var out *s3.UploadPartOutput
+				err := s.withRetry(fmt.Sprintf("part %d", p.number), func() error {
+					var err error
+					out, err = s.client.UploadPart(context.TODO(), &s3.UploadPartInput{
+						Bucket:            aws.String(s.bucket),
+						Key:               aws.String(s.key),
+						UploadId:          created.UploadId,
+						PartNumber:        aws.Int32(p.number),
+						Body:              throttle(bytes.NewReader(p.data), s.profile),
+						ContentLength:     aws.Int64(int64(len(p.data))),
+						ChecksumAlgorithm: algorithm,
+					})
+					return err
+				})
+
+				s.mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to upload part %d: %w", p.number, err)
+					}
+				} else {
+					checksum := checksumValue(algorithm, out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256)
+					completed = append(completed, completedPart(p.number, aws.ToString(out.ETag), algorithm, checksum))
+					uploaderLog.Debug(fmt.Sprintf("Uploaded part %d of %s", p.number, s.key))
+				}
+				s.mu.Unlock()
+			}
+		}()
+	}
+
+	data := first
+	for number := int32(1); ; number++ {
+		if number > maxParts {
+			s.mu.Lock()
+			firstErr = fmt.Errorf("standard input exceeds %d parts of %d bytes; increase part_size", maxParts, partSize)
+			s.mu.Unlock()
+			break
+		}
+		s.size += int64(len(data))
+		parts <- part{number: number, data: data}
+		if int64(len(data)) < partSize || failed() {
+			break
+		}
+
+		data = make([]byte, partSize)
+		n, err := io.ReadFull(r, data)
+		data = data[:n]
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			s.mu.Lock()
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to read standard input: %w", err)
+			}
+			s.mu.Unlock()
+			break
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if firstErr != nil {
+		abortMultipart(s.client, s.bucket, s.key, created.UploadId)
+		return "", firstErr
+	}
+	s.parts = len(completed)
+
+	sort.Slice(completed, func(i, j int) bool {
+		return *completed[i].PartNumber < *completed[j].PartNumber
+	})
+	complete := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(s.key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}
+	if s.profile.IfNoneMatch {
+		complete.IfNoneMatch = aws.String("*")
+	}
+	var out *s3.CompleteMultipartUploadOutput
+	err = s.withRetry("completion", func() error {
+		var err error
+		out, err = s.client.CompleteMultipartUpload(context.TODO(), complete)
+		if err != nil && httpStatusCode(err) == http.StatusPreconditionFailed {
+			return fmt.Errorf("%w: %s", errConflict, s.key)
+		}
+		return err
+	})
+	if err != nil {
+		abortMultipart(s.client, s.bucket, s.key, created.UploadId)
+		if errors.Is(err, errConflict) {
+			return "", err
+		}
+		return "", fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	log.Printf("Completed multipart upload of %s with %d part(s)", s.key, len(completed))
+	return checksumValue(algorithm, out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256), nil
+}
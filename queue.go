@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queueDepth bounds the number of files handed to a profile's workers ahead
+// of time. Files beyond that simply wait in the processing directory.
+const queueDepth = 64
+
+// uploadJob is a unit of work for an upload worker: either a single file or
+// a bundle of small files that are archived and uploaded together.
+type uploadJob struct {
+	bucket  string
+	path    string   // single file
+	dir     string   // bundle: directory relative to the bucket directory
+	members []string // bundle: files to archive
+}
+
+// paths returns the files of the processing directory the job covers.
+func (j uploadJob) paths() []string {
+	if j.members != nil {
+		return j.members
+	}
+	return []string{j.path}
+}
+
+// profileQueue feeds the files waiting in a profile's processing directory
+// to that profile's pool of upload workers. Every profile has its own queue
+// and scanner, so a slow profile only ever blocks itself.
+type profileQueue struct {
+	jobs chan uploadJob
+	kick chan struct{}
+
+	mu         sync.Mutex
+	profile    Profile         // replaced on reload, see currentProfile
+	pending    map[string]bool // files queued or being uploaded
+	opening    *time.Timer     // wakes the scanner when the upload window opens
+	quotaReset *time.Timer     // wakes the scanner when the daily quotas reset
+	paused     bool            // paused through the admin API
+	workers    int             // workers running, less those retiring
+	retire     int             // workers to stop after their current job
+
+	cancelled map[string]bool // queued files cancelled before their upload started
+}
+
+// queues is replaced rather than changed when profiles are added on
+// reload, so it can be read without a lock.
+var queues = make(map[string]*profileQueue)
+
+func startQueues() {
+	for name, profile := range profiles {
+		queues[name] = newProfileQueue(profile)
+	}
+	loadPausedProfiles()
+	for _, q := range queues {
+		q.start()
+	}
+}
+
+func newProfileQueue(profile Profile) *profileQueue {
+	return &profileQueue{
+		profile: profile,
+		jobs:    make(chan uploadJob, queueDepth),
+		kick:    make(chan struct{}, 1),
+		pending: make(map[string]bool),
+	}
+}
+
+// start starts the workers and scanner of the queue.
+func (q *profileQueue) start() {
+	profile := q.profile
+	log.Printf("Starting %d upload worker(s) for profile %s", profile.Workers, profile.Name)
+	q.setWorkers(profile.Workers)
+	go q.scanner()
+	if profile.RedriveInterval > 0 {
+		go q.redriver()
+	}
+
+	if profile.BundleSize > 0 {
+		// Partial bundles are flushed once their oldest file has
+		// waited long enough, which needs a rescan even when no new
+		// files arrive.
+		go func() {
+			for range time.Tick(profile.BundleMaxWait / 2) {
+				q.notify()
+			}
+		}()
+	}
+}
+
+// notify asks the scanner to look for new files. Requests made while a scan
+// is already pending are coalesced, so notify never blocks the caller.
+func (q *profileQueue) notify() {
+	select {
+	case q.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (q *profileQueue) scanner() {
+	for range q.kick {
+		q.scan()
+	}
+}
+
+// scan walks the processing directory and queues every file that is not
+// already queued or being uploaded. Outside the profile's upload windows
+// nothing is queued until the next window opens.
+func (q *profileQueue) scan() {
+	if q.isPaused() || !q.windowOpen() || !q.quotaOpen() {
+		return
+	}
+	jobs := collectJobs(q.currentProfile(), false, func(path string) bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return q.pending[path]
+	})
+	for _, job := range jobs {
+		q.enqueue(job)
+	}
+}
+
+// collectJobs returns the jobs for the files in the profile's processing
+// directory for which skip returns false, in priority order. With bundling
+// enabled the files are grouped into bundles first; flush bundles partial
+// groups regardless of their age.
+func collectJobs(profile Profile, flush bool, skip func(path string) bool) []uploadJob {
+	root := filepath.Join(serverDir, "processing", profile.Name)
+	var (
+		candidates []string
+		jobs       []uploadJob
+	)
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Error scanning %s: %v", path, err)
+			return nil
+		}
+		if info.IsDir() || isSidecar(path) || filepath.Base(path) == priorityFile || skip(path) {
+			return nil
+		}
+
+		if profile.BundleSize > 0 && !isTombstone(profile, path) {
+			candidates = append(candidates, path)
+			return nil
+		}
+
+		bucket, _, ok := splitProcessingPath(profile, path)
+		if !ok {
+			log.Printf("Ignoring %s: files must be placed in a bucket directory", path)
+			return nil
+		}
+		jobs = append(jobs, uploadJob{bucket: bucket, path: path})
+		return nil
+	})
+
+	if profile.BundleSize > 0 {
+		jobs = planBundles(profile, candidates, flush)
+	}
+	return prioritize(profile, jobs)
+}
+
+// currentProfile returns the profile as of the last reload.
+func (q *profileQueue) currentProfile() Profile {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.profile
+}
+
+// setPaused pauses or resumes the uploads of the profile. Files keep
+// arriving in processing while it is paused. The state is kept in
+// paused_profiles, so the profile is still paused after a restart.
+func (q *profileQueue) setPaused(paused bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.paused == paused {
+		return nil
+	}
+	var err error
+	if paused {
+		_, err = db.Exec(`INSERT INTO paused_profiles(profile, paused) VALUES (?, ?)
+			ON CONFLICT(profile) DO UPDATE SET paused = excluded.paused`, q.profile.Name, time.Now())
+	} else {
+		_, err = db.Exec("DELETE FROM paused_profiles WHERE profile = ?", q.profile.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record the paused state: %w", err)
+	}
+	q.paused = paused
+
+	if paused {
+		log.Printf("Paused profile %s: no new uploads are started until resumed", q.profile.Name)
+		return nil
+	}
+	log.Printf("Resumed profile %s", q.profile.Name)
+	q.notify()
+	return nil
+}
+
+// loadPausedProfiles restores the paused state of the profiles at startup.
+func loadPausedProfiles() {
+	rows, err := db.Query("SELECT profile, paused FROM paused_profiles")
+	if err != nil {
+		log.Fatalf("Failed to read paused profiles: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name  string
+			since time.Time
+		)
+		if err := rows.Scan(&name, &since); err != nil {
+			log.Fatalf("Failed to read paused profiles: %v", err)
+		}
+		if q, ok := queues[name]; ok {
+			q.paused = true
+			log.Printf("Profile %s is paused since %s; resume it with flood ctl resume %s", name, since.Local().Format("2006-01-02 15:04"), name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("Failed to read paused profiles: %v", err)
+	}
+}
+
+func (q *profileQueue) isPaused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+func (q *profileQueue) enqueue(job uploadJob) {
+	q.mu.Lock()
+	for _, path := range job.paths() {
+		q.pending[path] = true
+	}
+	q.mu.Unlock()
+	q.jobs <- job
+}
+
+// windowOpen reports whether the profile is in an upload window. If not, it
+// makes sure the scanner runs again when the next window opens.
+func (q *profileQueue) windowOpen() bool {
+	now := time.Now()
+	profile := q.currentProfile()
+	if inUploadWindow(profile, now) {
+		return true
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.opening == nil {
+		next := nextUploadWindow(profile, now)
+		log.Printf("Profile %s is outside its upload windows until %s", profile.Name, next.Format("2006-01-02 15:04"))
+		q.opening = time.AfterFunc(time.Until(next), func() {
+			q.mu.Lock()
+			q.opening = nil
+			q.mu.Unlock()
+			q.notify()
+		})
+	}
+	return false
+}
+
+// setWorkers starts or stops workers until n are running. A worker that is
+// stopped finishes its current upload first.
+func (q *profileQueue) setWorkers(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for ; q.workers < n; q.workers++ {
+		if q.retire > 0 {
+			q.retire--
+		} else {
+			go q.worker()
+		}
+	}
+	for ; q.workers > n; q.workers-- {
+		q.retire++
+	}
+}
+
+func (q *profileQueue) worker() {
+	for job := range q.jobs {
+		waitWhilePaused()
+		run, ok := q.withoutCancelled(job)
+		// Jobs queued before the window closed, the profile was paused or
+		// its quota was used up wait until they are scanned again.
+		if ok && !q.isPaused() && q.windowOpen() && q.quotaOpen() && startJob(run) {
+			if runJob(q.currentProfile(), run) == "leased" {
+				// Look again once a lease of a dead instance expired
+				time.AfterFunc(leaseTTL, q.notify)
+			}
+			finishJob(run)
+		}
+
+		q.mu.Lock()
+		for _, path := range job.paths() {
+			delete(q.pending, path)
+			delete(q.cancelled, path)
+		}
+		retire := q.retire > 0
+		if retire {
+			q.retire--
+		}
+		q.mu.Unlock()
+		if retire {
+			return
+		}
+	}
+}
+
+// runJob uploads a single file or bundle and returns the outcome, which is
+// "leased" if another instance holds one of its files.
+func runJob(profile Profile, job uploadJob) string {
+	release, ok := claimJob(job)
+	if !ok {
+		return "leased"
+	}
+	defer release()
+
+	if job.members != nil {
+		return processBundle(profile, job.bucket, job.dir, job.members)
+	}
+	if isTombstone(profile, job.path) {
+		return processTombstone(job.path, profile, job.bucket)
+	}
+	return processFile(job.path, profile, job.bucket)
+}
+
+// splitProcessingPath splits a path in the profile's processing directory
+// into the bucket and the path relative to the bucket directory.
+func splitProcessingPath(profile Profile, path string) (bucket, relativePath string, ok bool) {
+	rel, err := filepath.Rel(filepath.Join(serverDir, "processing", profile.Name), path)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", "", false
+	}
+	parts := strings.SplitN(rel, string(os.PathSeparator), 2)
+	if len(parts) < 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A profile can cap what it uploads per day, to keep egress bills in check:
+//
+//	quota_bytes_per_day = 200GB
+//	quota_objects_per_day = 100000
+//
+// Days are UTC, as in daily_stats, which the usage is read from so that
+// every instance sharing the database counts towards the same quota. A
+// tenant can have quotas of its own across its profiles (see tenant.go).
+// Only transferred files count; files already present in the bucket do not.
+// Once a quota is used up, the profile starts no new uploads and its files wait
+// in processing until the quota resets at midnight UTC; uploads that
+// already started are finished, so a quota can be overshot by the files in
+// flight. Reaching a quota is logged and alerted through -alert-webhook and
+// -alert-email, once per day and profile or tenant. -once ignores quotas.
+
+// parseQuotas returns the daily quotas of the settings of a profile or
+// tenant, zero where there is none.
+func parseQuotas(settings map[string]string) (bytes, objects int64, err error) {
+	if value := settings["quota_bytes_per_day"]; value != "" {
+		bytes, err = parseByteSize(value)
+		if err != nil || bytes == 0 {
+			return 0, 0, fmt.Errorf("invalid quota_bytes_per_day %q", value)
+		}
+	}
+	if value := settings["quota_objects_per_day"]; value != "" {
+		objects, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || objects < 1 {
+			return 0, 0, fmt.Errorf("invalid quota_objects_per_day %q: must be a positive integer", value)
+		}
+	}
+	return bytes, objects, nil
+}
+
+// quotaAlerts holds the day of the last alert of every profile and tenant
+// over quota, so that a tenant with many profiles alerts once.
+var quotaAlerts struct {
+	mu   sync.Mutex
+	days map[string]string // keyed by "profile x" or "tenant x"
+}
+
+// quotaOpen reports whether the profile and its tenant are within their
+// daily quotas. If not, it makes sure the scanner runs again when the
+// quotas reset.
+func (q *profileQueue) quotaOpen() bool {
+	profile := q.currentProfile()
+	now := time.Now().UTC()
+	day := now.Format(time.DateOnly)
+	owner, used := quotaExceeded(profile, day)
+	if owner == "" {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.quotaReset == nil {
+		reset := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		message := fmt.Sprintf("The %s used its daily quota (%s); uploads of profile %s wait until %s", owner, used, profile.Name, reset.Local().Format("2006-01-02 15:04"))
+		log.Print(message)
+		quotaAlerts.mu.Lock()
+		if quotaAlerts.days == nil {
+			quotaAlerts.days = make(map[string]string)
+		}
+		if quotaAlerts.days[owner] != day {
+			quotaAlerts.days[owner] = day
+			go alertQuota(owner, message)
+		}
+		quotaAlerts.mu.Unlock()
+		q.quotaReset = time.AfterFunc(time.Until(reset), func() {
+			q.mu.Lock()
+			q.quotaReset = nil
+			q.mu.Unlock()
+			q.notify()
+		})
+	}
+	return false
+}
+
+// quotaExceeded returns the owner ("profile x" or "tenant x") of a daily
+// quota of the profile that is used up on day and how much was used, or ""
+// if there is none.
+func quotaExceeded(profile Profile, day string) (owner, used string) {
+	type quota struct {
+		column, name   string
+		bytes, objects int64
+	}
+	quotas := []quota{{"profile", profile.Name, profile.QuotaBytes, profile.QuotaObjects}}
+	if t := profile.Tenant; t != nil {
+		quotas = append(quotas, quota{"tenant", t.Name, t.QuotaBytes, t.QuotaObjects})
+	}
+	for _, quota := range quotas {
+		if quota.bytes == 0 && quota.objects == 0 {
+			continue
+		}
+		owner := quota.column + " " + quota.name
+		// The column is one of two constants, never input
+		var objects, bytes int64
+		err := db.QueryRow("SELECT COALESCE(SUM(uploaded), 0), COALESCE(SUM(uploaded_bytes), 0) FROM daily_stats WHERE day = ? AND "+quota.column+" = ?", day, quota.name).Scan(&objects, &bytes)
+		if err != nil {
+			log.Printf("Failed to read the quota usage of %s, uploading anyway: %v", owner, err)
+			continue
+		}
+		switch {
+		case quota.objects > 0 && objects >= quota.objects:
+			return owner, fmt.Sprintf("%d of %d objects", objects, quota.objects)
+		case quota.bytes > 0 && bytes >= quota.bytes:
+			return owner, fmt.Sprintf("%s of %s", formatBytes(bytes), formatBytes(quota.bytes))
+		}
+	}
+	return "", ""
+}
+
+func alertQuota(owner, message string) {
+	if alertWebhook != "" {
+		if err := postAlert(fmt.Sprintf("flood on %s: %s.", hostname, message)); err != nil {
+			log.Printf("Failed to post alert to %s: %v", alertWebhook, err)
+		}
+	}
+	if alertEmail != "" {
+		subject := fmt.Sprintf("flood on %s: %s reached its daily quota", hostname, owner)
+		if err := sendEmail(subject, message+".\r\n"); err != nil {
+			log.Printf("Failed to send alert email: %v", err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "testing"
+
+func TestParseQuotas(t *testing.T) {
+	tests := []struct {
+		settings       map[string]string
+		bytes, objects int64
+		wantErr        bool
+	}{
+		{map[string]string{}, 0, 0, false},
+		{map[string]string{"quota_bytes_per_day": "200GB"}, 200 << 30, 0, false},
+		{map[string]string{"quota_objects_per_day": "100000"}, 0, 100000, false},
+		{map[string]string{"quota_bytes_per_day": "1MB", "quota_objects_per_day": "5"}, 1 << 20, 5, false},
+		{map[string]string{"quota_bytes_per_day": "0"}, 0, 0, true},
+		{map[string]string{"quota_bytes_per_day": "lots"}, 0, 0, true},
+		{map[string]string{"quota_objects_per_day": "0"}, 0, 0, true},
+		{map[string]string{"quota_objects_per_day": "-1"}, 0, 0, true},
+		{map[string]string{"quota_objects_per_day": "1.5"}, 0, 0, true},
+	}
+	for _, tt := range tests {
+		bytes, objects, err := parseQuotas(tt.settings)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseQuotas(%v) error = %v, want error %t", tt.settings, err, tt.wantErr)
+			continue
+		}
+		if bytes != tt.bytes || objects != tt.objects {
+			t.Errorf("parseQuotas(%v) = %d, %d, want %d, %d", tt.settings, bytes, objects, tt.bytes, tt.objects)
+		}
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	openTestDB(t)
+	const day = "2026-10-16"
+	acme := &tenant{Name: "acme", QuotaObjects: 3}
+	record := func(profile, bucket, tenant string, outcome string, bytes int64) {
+		t.Helper()
+		if err := addDailyStats(day, profile, bucket, tenant, countOutcome(outcome, 0, bytes, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	record("a", "b1", "acme", "success", 600)
+	record("a", "b2", "acme", "success", 500)
+	record("a", "b1", "acme", "already_present", 5000)
+	record("c", "b1", "acme", "already_present", 5000)
+	record("d", "b1", "", "success", 100)
+	// Another day does not count
+	if err := addDailyStats("2026-10-15", "a", "b1", "acme", countOutcome("success", 0, 5000, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		profile Profile
+		owner   string
+	}{
+		{"no quota", Profile{Name: "a"}, ""},
+		{"bytes within", Profile{Name: "a", QuotaBytes: 1101}, ""},
+		{"bytes used up", Profile{Name: "a", QuotaBytes: 1100}, "profile a"},
+		{"objects within", Profile{Name: "a", QuotaObjects: 3}, ""},
+		{"objects used up", Profile{Name: "a", QuotaObjects: 2}, "profile a"},
+		{"already present is free", Profile{Name: "c", QuotaBytes: 1, QuotaObjects: 1}, ""},
+		{"tenant within", Profile{Name: "c", Tenant: acme}, ""},
+		{"tenant used up", Profile{Name: "c", Tenant: &tenant{Name: "acme", QuotaBytes: 1000}}, "tenant acme"},
+		{"other profile", Profile{Name: "d", QuotaObjects: 2}, ""},
+	}
+	for _, tt := range tests {
+		if owner, used := quotaExceeded(tt.profile, day); owner != tt.owner {
+			t.Errorf("%s: quotaExceeded = %q (%s), want %q", tt.name, owner, used, tt.owner)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Files that failed can be moved back to processing automatically:
+//
+//	failed_retry_interval = 1h   ; look for failed files this often
+//	failed_retry_max = 3         ; re-drive a file at most this many times
+//
+// Every re-drive is logged in file_records with the outcome "redriven",
+// which starts a new chain of upload attempts. The number of re-drives of
+// a file is kept in failed_redrives; a file whose modification time has
+// changed counts as a new file.
+//
+// Failed files can also be re-driven by hand with flood ctl retry or the
+// admin API. A manual re-drive ignores the limit and resets the count, so
+// the file gets failed_retry_max automatic re-drives again, and it is
+// recorded as an attempt of class "manual" of the "redriven" record.
+const defaultRedriveMax = 3
+
+// redriver periodically moves the profile's failed files back to
+// processing.
+func (q *profileQueue) redriver() {
+	for range time.Tick(q.currentProfile().RedriveInterval) {
+		if n := redriveFailed(q.currentProfile()); n > 0 {
+			log.Printf("Re-drove %d failed file(s) of profile %s", n, q.currentProfile().Name)
+			q.notify()
+		}
+	}
+}
+
+// redriveFailed moves the failed files of a profile that have not used up
+// their re-drives back to processing and returns their number.
+func redriveFailed(profile Profile) int {
+	failedRoot := filepath.Join(serverDir, "failed", profile.Name)
+	var moved int
+	filepath.Walk(failedRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || isSidecar(path) {
+			return nil
+		}
+		if redriveFile(profile, path, info, "") == nil {
+			moved++
+		}
+		return nil
+	})
+	return moved
+}
+
+// errRedrivesUsedUp is returned by redriveFile for a file that was re-driven
+// failed_retry_max times.
+var errRedrivesUsedUp = errors.New("re-drives used up")
+
+// redriveFile moves a failed file of the profile back to processing. A
+// manual re-drive names its origin in by, and is empty otherwise.
+func redriveFile(profile Profile, path string, info os.FileInfo, by string) error {
+	failedRoot := filepath.Join(serverDir, "failed", profile.Name)
+	rel, err := filepath.Rel(failedRoot, path)
+	if err != nil {
+		return err
+	}
+	bucket, relativePath, ok := strings.Cut(rel, string(os.PathSeparator))
+	if !ok || !filepath.IsLocal(rel) {
+		return fmt.Errorf("%s is not in a bucket directory of %s", path, failedRoot)
+	}
+	processingPath := filepath.Join(serverDir, "processing", profile.Name, rel)
+
+	cycles, err := redriveCount(profile.Name, processingPath, info.ModTime())
+	if err != nil {
+		log.Printf("Failed to look up re-drives of %s: %v", path, err)
+		return err
+	}
+	if cycles >= profile.RedriveMax && by == "" {
+		return errRedrivesUsedUp
+	}
+	count := cycles + 1
+	if by != "" {
+		count = 0
+	}
+
+	if err := moveFile(path, processingPath); err != nil {
+		log.Printf("Failed to re-drive %s: %v", path, err)
+		return err
+	}
+
+	_, err = db.Exec(`INSERT INTO failed_redrives(profile, filepath, mtime, cycles, last_redrive) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(profile, filepath) DO UPDATE SET mtime = excluded.mtime, cycles = excluded.cycles, last_redrive = excluded.last_redrive`,
+		profile.Name, processingPath, info.ModTime().UnixNano(), count, time.Now())
+	if err != nil {
+		log.Printf("Failed to record re-drive of %s: %v", path, err)
+	}
+	rec := fileRecord{Path: processingPath, Profile: profile.forBucket(bucket), Bucket: bucket, OriginalPath: filepath.ToSlash(relativePath)}
+	if by != "" {
+		log.Printf("Re-driving %s by hand through %s; re-drives start over", processingPath, by)
+		rec.Attempts = []attempt{{At: time.Now(), Class: "manual", Message: "re-driven by hand through " + by}}
+	} else {
+		log.Printf("Re-driving %s (%d of %d)", processingPath, count, profile.RedriveMax)
+	}
+	logRetry(rec, "redriven")
+	return nil
+}
+
+// redriveCount returns how often the file was re-driven so far.
+func redriveCount(profileName, path string, modTime time.Time) (int, error) {
+	var (
+		mtime  int64
+		cycles int
+	)
+	err := db.QueryRow("SELECT mtime, cycles FROM failed_redrives WHERE profile = ? AND filepath = ?", profileName, path).Scan(&mtime, &cycles)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if mtime != modTime.UnixNano() {
+		return 0, nil
+	}
+	return cycles, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// archiveStorageClasses lists the storage classes whose objects have to be
+// restored before they can be downloaded.
+var archiveStorageClasses = []string{"GLACIER", "DEEP_ARCHIVE"}
+
+// Restore statuses recorded in the restores table.
+const (
+	restoreRequested = "requested"
+	restoreRestored  = "restored"
+)
+
+// runRestoreMode requests temporary copies of archived objects and waits
+// until they can be downloaded:
+//
+//	flood restore [-days 7] [-tier Standard] s3://profile/bucket/key
+//	flood restore s3://profile/bucket/prefix/
+//
+// A key that is empty or ends in a slash restores every GLACIER and
+// DEEP_ARCHIVE object below it. Requests and their completion are tracked in
+// the restores table; running the command again does not request objects
+// that are already being restored but keeps waiting for them. Restores take
+// hours, so the status is checked every -poll interval; with -no-wait the
+// command exits after requesting.
+func runRestoreMode(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	days := fs.Int("days", 7, "Number of days the restored copy is kept")
+	tier := fs.String("tier", string(types.TierStandard), "Retrieval tier: Standard, Bulk or Expedited")
+	poll := fs.Duration("poll", 15*time.Minute, "Interval between checks of pending restores")
+	noWait := fs.Bool("no-wait", false, "Exit after requesting the restores")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] restore [restore flags] s3://profile/bucket/key")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *days < 1 || *poll <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if !slices.Contains([]types.Tier{types.TierStandard, types.TierBulk, types.TierExpedited}, types.Tier(*tier)) {
+		log.Fatalf("Invalid -tier %q: must be Standard, Bulk or Expedited", *tier)
+	}
+
+	profile, bucket, key, err := parseS3URI(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if profile.Destination != nil {
+		log.Fatalf("restore is not supported by provider %s", profile.Provider)
+	}
+	client := newS3Client(profile)
+
+	keys, err := archivedKeys(client, bucket, key)
+	if err != nil {
+		log.Fatalf("Failed to list %s: %v", fs.Arg(0), err)
+	}
+	log.Printf("Found %d archived object(s)", len(keys))
+
+	var pending []string
+	failed := 0
+	for _, k := range keys {
+		status, err := restoreStatus(profile.Name, bucket, k)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if status == restoreRequested {
+			pending = append(pending, k)
+			continue
+		}
+		_, err = client.RestoreObject(context.TODO(), &s3.RestoreObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(k),
+			RestoreRequest: &types.RestoreRequest{
+				Days:                 aws.Int32(int32(*days)),
+				GlacierJobParameters: &types.GlacierJobParameters{Tier: types.Tier(*tier)},
+			},
+		})
+		if err != nil && httpStatusCode(err) != http.StatusConflict {
+			// 409 means a restore is already in progress.
+			log.Printf("Failed to request restore of %s: %v", k, err)
+			failed++
+			continue
+		}
+		log.Printf("Requested %s restore of %s for %d day(s)", *tier, k, *days)
+		recordRestore(profile.Name, bucket, k, *tier, *days, restoreRequested)
+		pending = append(pending, k)
+	}
+
+	for !*noWait && len(pending) > 0 {
+		var still []string
+		for _, k := range pending {
+			head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(k),
+			})
+			if err != nil {
+				log.Printf("Failed to check restore of %s: %v", k, err)
+				still = append(still, k)
+				continue
+			}
+			restore := aws.ToString(head.Restore)
+			if strings.Contains(restore, `ongoing-request="false"`) {
+				log.Printf("Restored %s (%s)", k, restore)
+				markRestored(profile.Name, bucket, k)
+				continue
+			}
+			still = append(still, k)
+		}
+		pending = still
+		if len(pending) > 0 {
+			log.Printf("Waiting for %d restore(s), checking again in %s", len(pending), *poll)
+			time.Sleep(*poll)
+		}
+	}
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// archivedKeys returns the key itself or, for a prefix, the archived
+// objects below it.
+func archivedKeys(client *s3.Client, bucket, key string) ([]string, error) {
+	if key != "" && !strings.HasSuffix(key, "/") {
+		head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(archiveStorageClasses, string(head.StorageClass)) {
+			return nil, fmt.Errorf("%s has storage class %s and does not need to be restored", key, head.StorageClass)
+		}
+		return []string{key}, nil
+	}
+
+	var keys []string
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: optionalString(key),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			if slices.Contains(archiveStorageClasses, string(object.StorageClass)) {
+				keys = append(keys, aws.ToString(object.Key))
+			}
+		}
+	}
+	return keys, nil
+}
+
+// restoreStatus returns the tracked status of a restore, or "" if none was
+// requested.
+func restoreStatus(profileName, bucket, key string) (string, error) {
+	var status string
+	err := db.QueryRow("SELECT status FROM restores WHERE profile = ? AND bucket = ? AND object_key = ?", profileName, bucket, key).Scan(&status)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	return status, nil
+}
+
+func recordRestore(profileName, bucket, key, tier string, days int, status string) {
+	_, err := db.Exec(`INSERT INTO restores(profile, bucket, object_key, tier, days, status, requested) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(profile, bucket, object_key) DO UPDATE SET tier = excluded.tier, days = excluded.days, status = excluded.status, requested = excluded.requested, restored = NULL`,
+		profileName, bucket, key, tier, days, status, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func markRestored(profileName, bucket, key string) {
+	_, err := db.Exec("UPDATE restores SET status = ?, restored = ? WHERE profile = ? AND bucket = ? AND object_key = ?",
+		restoreRestored, time.Now(), profileName, bucket, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpDestination delivers files to an SFTP server. Buckets are directories
+// below sftp_root that must exist on the server:
+//
+//	provider = sftp
+//	sftp_host = sftp.partner.example
+//	sftp_port = 22                  ; optional
+//	sftp_user = flood
+//	sftp_key_file = /etc/flood/id_ed25519
+//	sftp_known_hosts = /etc/flood/known_hosts   ; default ~/.ssh/known_hosts
+//	sftp_root = /upload             ; optional, default is the login directory
+//
+// sftp_password may be used instead of a key. Files are written under a
+// temporary name and renamed into place once complete.
+type sftpDestination struct {
+	addr   string
+	config *ssh.ClientConfig
+	root   string
+}
+
+// errSizeMismatch is returned when the size of an uploaded file differs from
+// the local file. Like an ETag mismatch it is retried.
+var errSizeMismatch = errors.New("size mismatch")
+
+func newSFTPDestination(name string, settings map[string]string) (destination, error) {
+	port := 22
+	if value := settings["sftp_port"]; value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 65535 {
+			return nil, fmt.Errorf("invalid sftp_port %q", value)
+		}
+		port = n
+	}
+
+	var auth []ssh.AuthMethod
+	if keyFile := settings["sftp_key_file"]; keyFile != "" {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sftp_key_file: %w", err)
+		}
+		var signer ssh.Signer
+		if passphrase := settings["sftp_key_passphrase"]; passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp_key_file %s: %w", keyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password := settings["sftp_password"]; password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("sftp requires sftp_key_file or sftp_password")
+	}
+
+	hostKeyCallback, err := sftpHostKeyCallback(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	root := settings["sftp_root"]
+	if root == "" {
+		root = "."
+	}
+	return &sftpDestination{
+		addr: net.JoinHostPort(settings["sftp_host"], strconv.Itoa(port)),
+		config: &ssh.ClientConfig{
+			User:            settings["sftp_user"],
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+		root: root,
+	}, nil
+}
+
+func sftpHostKeyCallback(settings map[string]string) (ssh.HostKeyCallback, error) {
+	if value := settings["sftp_insecure_ignore_host_key"]; value != "" {
+		insecure, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sftp_insecure_ignore_host_key %q: must be true or false", value)
+		}
+		if insecure {
+			return ssh.InsecureIgnoreHostKey(), nil
+		}
+	}
+
+	knownHosts := settings["sftp_known_hosts"]
+	if knownHosts == "" {
+		homeDir, _ := os.UserHomeDir()
+		knownHosts = filepath.Join(homeDir, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sftp_known_hosts %s: %w", knownHosts, err)
+	}
+	return callback, nil
+}
+
+// connect opens an SSH connection with an SFTP session and returns the
+// function that closes both.
+func (d *sftpDestination) connect() (*sftp.Client, func(), error) {
+	conn, err := ssh.Dial("tcp", d.addr, d.config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to %s: %w", d.addr, err)
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to start SFTP session on %s: %w", d.addr, err)
+	}
+	return client, func() {
+		client.Close()
+		conn.Close()
+	}, nil
+}
+
+func (d *sftpDestination) validate(bucket string) error {
+	client, closeClient, err := d.connect()
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+
+	dir := path.Join(d.root, bucket)
+	info, err := client.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return fmt.Errorf("directory %s does not exist on SFTP server %s", dir, d.addr)
+	}
+	return nil
+}
+
+func (d *sftpDestination) upload(rec fileRecord) (uploadResult, error) {
+	f, err := os.Open(rec.Path)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to open file %s: %w", rec.Path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to stat file %s: %w", rec.Path, err)
+	}
+	if rec.Meta != nil {
+		log.Printf("Sidecar metadata of %s is not supported by SFTP and is ignored", rec.Path)
+	}
+
+	client, closeClient, err := d.connect()
+	if err != nil {
+		return uploadResult{}, err
+	}
+	defer closeClient()
+	defer reserveUploadMemory(rec.Path, 1)()
+
+	remotePath := path.Join(d.root, rec.Bucket, rec.Key)
+	if err := client.MkdirAll(path.Dir(remotePath)); err != nil {
+		return uploadResult{}, fmt.Errorf("failed to create directory for %s: %w", remotePath, err)
+	}
+
+	tmpPath := path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".flood-tmp")
+	w, err := client.Create(tmpPath)
+	if err != nil {
+		return uploadResult{}, fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	_, err = io.CopyBuffer(w, uploadBody(f, rec.Profile), newCopyBuffer())
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = checkRemoteSize(client, tmpPath, info.Size())
+	}
+	if err != nil {
+		client.Remove(tmpPath)
+		return uploadResult{}, fmt.Errorf("failed to upload %s: %w", remotePath, err)
+	}
+
+	if err := client.PosixRename(tmpPath, remotePath); err != nil {
+		// Servers without the posix-rename extension refuse to replace an
+		// existing file with a plain rename.
+		client.Remove(remotePath)
+		if err := client.Rename(tmpPath, remotePath); err != nil {
+			client.Remove(tmpPath)
+			return uploadResult{}, fmt.Errorf("failed to rename %s to %s: %w", tmpPath, remotePath, err)
+		}
+	}
+	log.Printf("Uploaded %s to sftp://%s/%s", rec.Path, d.addr, remotePath)
+	return uploadResult{}, nil
+}
+
+func checkRemoteSize(client *sftp.Client, remotePath string, size int64) error {
+	info, err := client.Stat(remotePath)
+	if err != nil {
+		return err
+	}
+	if info.Size() != size {
+		return fmt.Errorf("%w: remote %d bytes, local %d bytes", errSizeMismatch, info.Size(), size)
+	}
+	return nil
+}
+
+// matches compares sizes only; SFTP has no standard way to get a checksum.
+func (d *sftpDestination) matches(bucket, key, localPath string) (bool, error) {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return false, err
+	}
+
+	client, closeClient, err := d.connect()
+	if err != nil {
+		return false, err
+	}
+	defer closeClient()
+
+	remote, err := client.Stat(path.Join(d.root, bucket, key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return remote.Size() == info.Size(), nil
+}
+
+func (d *sftpDestination) transient(err error) bool {
+	var netErr net.Error
+	return isTransientError(err) ||
+		errors.Is(err, errSizeMismatch) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, sftp.ErrSSHFxNoConnection) ||
+		errors.As(err, &netErr)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// sidecarSuffix marks optional metadata files that accompany an upload. A
+// sidecar for "report.csv" is "report.csv.floodmeta" and must be placed in
+// incoming before the file it describes. Sidecars are never uploaded.
+const sidecarSuffix = ".floodmeta"
+
+// sidecarMetadata is the content of a sidecar file.
+type sidecarMetadata struct {
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	CacheControl       string            `json:"cache_control,omitempty"`
+	ContentDisposition string            `json:"content_disposition,omitempty"`
+	ContentEncoding    string            `json:"content_encoding,omitempty"`
+	Tags               map[string]string `json:"tags,omitempty"`
+}
+
+func isSidecar(path string) bool {
+	return strings.HasSuffix(path, sidecarSuffix)
+}
+
+// loadSidecar reads the sidecar of the given file. It returns nil without an
+// error if the file has no sidecar.
+func loadSidecar(path string) (*sidecarMetadata, error) {
+	data, err := os.ReadFile(path + sidecarSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta sidecarMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid sidecar %s: %w", path+sidecarSuffix, err)
+	}
+	if err := validateTags(meta.Tags); err != nil {
+		return nil, fmt.Errorf("invalid sidecar %s: %w", path+sidecarSuffix, err)
+	}
+	return &meta, nil
+}
+
+// moveSidecar moves the sidecar of a file along with the file itself.
+func moveSidecar(path, newPath string) {
+	if _, err := os.Stat(path + sidecarSuffix); err != nil {
+		return
+	}
+	if err := os.Rename(path+sidecarSuffix, newPath+sidecarSuffix); err != nil {
+		log.Printf("Failed to move sidecar of %s: %v", path, err)
+	}
+}
+
+// String returns the sidecar as JSON for the database.
+func (m *sidecarMetadata) String() string {
+	if m == nil {
+		return ""
+	}
+	data, _ := json.Marshal(m)
+	return string(data)
+}
+
+func (m *sidecarMetadata) applyToPutObject(in *s3.PutObjectInput) {
+	if m == nil {
+		return
+	}
+	in.Metadata = m.Metadata
+	in.CacheControl = optionalString(m.CacheControl)
+	in.ContentDisposition = optionalString(m.ContentDisposition)
+	in.ContentEncoding = optionalString(m.ContentEncoding)
+}
+
+func (m *sidecarMetadata) applyToMultipartUpload(in *s3.CreateMultipartUploadInput) {
+	if m == nil {
+		return
+	}
+	in.Metadata = m.Metadata
+	in.CacheControl = optionalString(m.CacheControl)
+	in.ContentDisposition = optionalString(m.ContentDisposition)
+	in.ContentEncoding = optionalString(m.ContentEncoding)
+}
+
+// applyToRequest sends the metadata as HTTP headers, user metadata with an
+// X-Meta- prefix.
+func (m *sidecarMetadata) applyToRequest(req *http.Request) {
+	if m == nil {
+		return
+	}
+	for k, v := range m.Metadata {
+		req.Header.Set("X-Meta-"+k, v)
+	}
+	if m.CacheControl != "" {
+		req.Header.Set("Cache-Control", m.CacheControl)
+	}
+	if m.ContentDisposition != "" {
+		req.Header.Set("Content-Disposition", m.ContentDisposition)
+	}
+	if m.ContentEncoding != "" {
+		req.Header.Set("Content-Encoding", m.ContentEncoding)
+	}
+}
+
+// objectTagging merges the profile's default tags with the tags of the
+// sidecar, the latter taking precedence, and encodes them for the Tagging
+// header. It returns an empty string when there are no tags.
+func objectTagging(profile Profile, meta *sidecarMetadata) string {
+	tags := url.Values{}
+	for k, v := range profile.Tags {
+		tags.Set(k, v)
+	}
+	if meta != nil {
+		for k, v := range meta.Tags {
+			tags.Set(k, v)
+		}
+	}
+	return tags.Encode()
+}
+
+// validateTags checks the limits S3 places on object tags.
+func validateTags(tags map[string]string) error {
+	if len(tags) > 10 {
+		return fmt.Errorf("at most 10 tags are allowed, got %d", len(tags))
+	}
+	for k, v := range tags {
+		if k == "" || len(k) > 128 {
+			return fmt.Errorf("tag key %q must be 1 to 128 characters", k)
+		}
+		if len(v) > 256 {
+			return fmt.Errorf("value of tag %q must be at most 256 characters", k)
+		}
+	}
+	return nil
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
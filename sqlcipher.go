@@ -0,0 +1,51 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// An encrypted SQLite database needs flood built against SQLCipher instead
+// of the bundled SQLite:
+//
+//	CGO_LDFLAGS=-lsqlcipher go build -tags libsqlite3,sqlite_omit_load_extension
+//
+// The key is read from -db-key-file or FLOOD_DB_KEY. Every connection is
+// keyed before anything else touches the file, so all queries run on the
+// encrypted database unchanged. A database created without a key cannot
+// be opened with one and vice versa.
+
+const encryptedSQLiteDriver = "sqlite3-encrypted"
+
+// databaseKey returns the key of the SQLite database, or "" if it is not
+// encrypted.
+func databaseKey() (string, error) {
+	if databaseKeyFile != "" {
+		data, err := os.ReadFile(databaseKeyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read database key: %w", err)
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return "", fmt.Errorf("database key file %s is empty", databaseKeyFile)
+		}
+		return key, nil
+	}
+	return os.Getenv("FLOOD_DB_KEY"), nil
+}
+
+// checkEncryption fails unless the database is SQLCipher's and the key
+// opens it.
+func checkEncryption(db *sql.DB) error {
+	var version string
+	if err := db.QueryRow("PRAGMA cipher_version").Scan(&version); err != nil || version == "" {
+		return fmt.Errorf("a database key is set but flood was not built with SQLCipher")
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master").Scan(&n); err != nil {
+		return fmt.Errorf("failed to open encrypted database, wrong key? %w", err)
+	}
+	return nil
+}
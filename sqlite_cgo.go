@@ -0,0 +1,44 @@
+//go:build cgo
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// cgoSQLite reports whether the cgo driver is built in.
+const cgoSQLite = true
+
+// registerEncryptedSQLite registers a driver that keys every connection
+// and then applies the pragmas that would otherwise be given in the DSN,
+// which SQLCipher only accepts after the key.
+func registerEncryptedSQLite(key string, pragmas []string) {
+	quoted := "'" + strings.ReplaceAll(key, "'", "''") + "'"
+	sql.Register(encryptedSQLiteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if _, err := conn.Exec("PRAGMA key = "+quoted, nil); err != nil {
+				return err
+			}
+			for _, pragma := range pragmas {
+				if _, err := conn.Exec("PRAGMA "+pragma, nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// isCgoSQLiteBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED of
+// the cgo driver.
+func isCgoSQLiteBusy(err error) bool {
+	var cgoErr sqlite3.Error
+	if errors.As(err, &cgoErr) {
+		return cgoErr.Code == sqlite3.ErrBusy || cgoErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package main
+
+// Without cgo only -db-driver purego and bolt are available; see
+// sqlite_cgo.go.
+const cgoSQLite = false
+
+// registerEncryptedSQLite is never called, since encrypted databases
+// need the cgo driver.
+func registerEncryptedSQLite(key string, pragmas []string) {
+	panic("encrypted databases require cgo")
+}
+
+func isCgoSQLiteBusy(err error) bool {
+	return false
+}
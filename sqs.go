@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// ingestMessage is the body of an SQS message asking the server to pick up
+// a file, either from a path the server can read or from a URL:
+//
+//	{"profile": "backup", "bucket": "logs", "key": "2024/app.log", "path": "/shared/app.log"}
+//	{"profile": "backup", "bucket": "logs", "url": "https://host/export.csv", "sidecar": {"tags": {"team": "data"}}}
+//
+// The key is the path below the bucket directory and defaults to the base
+// name of the path or URL. The optional sidecar has the format of a
+// .floodmeta file.
+type ingestMessage struct {
+	Profile string           `json:"profile"`
+	Bucket  string           `json:"bucket"`
+	Key     string           `json:"key"`
+	Path    string           `json:"path"`
+	URL     string           `json:"url"`
+	Sidecar *sidecarMetadata `json:"sidecar"`
+
+	tenant string // of the client that submitted the file over HTTP or gRPC, see tenant.go
+}
+
+// consumeSQS long-polls the -sqs-queue queue and places the file of every
+// message in the incoming directory, from where it runs through the usual
+// pipeline. A message is deleted once its file is in incoming; if fetching
+// the file fails the message becomes visible again after the queue's
+// visibility timeout and is retried, so a redrive policy on the queue bounds
+// the attempts. Messages that can never succeed, such as malformed ones, are
+// deleted right away.
+func consumeSQS() {
+	profile, ok := profiles[sqsProfile]
+	if !ok {
+		log.Fatalf("Unknown -sqs-profile: %s", sqsProfile)
+	}
+	client := sqs.NewFromConfig(getAWSConfig(profile))
+	log.Printf("Consuming ingest messages from %s", sqsQueueURL)
+
+	for !isDraining() {
+		waitWhilePaused()
+		out, err := client.ReceiveMessage(context.TODO(), &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(sqsQueueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			log.Printf("Failed to receive messages from %s: %v", sqsQueueURL, err)
+			time.Sleep(retryDelay(0))
+			continue
+		}
+
+		var wg sync.WaitGroup
+		for _, msg := range out.Messages {
+			wg.Add(1)
+			go func(msg types.Message) {
+				defer wg.Done()
+				if !ingest(msg) {
+					return
+				}
+				_, err := client.DeleteMessage(context.TODO(), &sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(sqsQueueURL),
+					ReceiptHandle: msg.ReceiptHandle,
+				})
+				if err != nil {
+					log.Printf("Failed to delete message %s: %v", aws.ToString(msg.MessageId), err)
+				}
+			}(msg)
+		}
+		wg.Wait()
+	}
+}
+
+// ingest handles a single message and reports whether it can be deleted.
+func ingest(msg types.Message) bool {
+	id := aws.ToString(msg.MessageId)
+	var m ingestMessage
+	if err := json.Unmarshal([]byte(aws.ToString(msg.Body)), &m); err != nil {
+		log.Printf("Dropping message %s: invalid body: %v", id, err)
+		return true
+	}
+	if err := m.validate(); err != nil {
+		log.Printf("Dropping message %s: %v", id, err)
+		return true
+	}
+
+	tmpPath := filepath.Join(serverDir, "incoming_tmp", "sqs-"+id)
+	defer os.Remove(tmpPath)
+	source := m.Path
+	if m.URL != "" {
+		source = m.URL
+		if err := download(m.URL, tmpPath); err != nil {
+			log.Printf("Failed to fetch %s for message %s: %v", m.URL, id, err)
+			return false
+		}
+	} else if err := streamFile(m.Path, tmpPath, nil); err != nil {
+		log.Printf("Failed to copy %s for message %s: %v", m.Path, id, err)
+		return false
+	}
+
+	dst, err := placeIncoming(m, tmpPath)
+	if err != nil {
+		log.Printf("Failed to ingest %s for message %s: %v", source, id, err)
+		return false
+	}
+	log.Printf("Ingested %s as %s for message %s", source, dst, id)
+	return true
+}
+
+// placeIncoming moves the file of m, fetched to tmpPath, to the incoming
+// directory together with its sidecar and returns its path there.
+func placeIncoming(m ingestMessage, tmpPath string) (string, error) {
+	dst := filepath.Join(serverDir, "incoming", m.Profile, m.Bucket, filepath.FromSlash(m.Key))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return "", err
+	}
+	if m.Sidecar != nil {
+		// The sidecar must be in place before the file is picked up.
+		data, _ := json.Marshal(m.Sidecar)
+		if err := os.WriteFile(dst+sidecarSuffix, data, 0644); err != nil {
+			return "", fmt.Errorf("failed to write sidecar: %w", err)
+		}
+	}
+	if err := os.Rename(tmpPath, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
+
+func (m *ingestMessage) validate() error {
+	if (m.Path == "") == (m.URL == "") {
+		return fmt.Errorf("exactly one of path and url is required")
+	}
+	if m.Key == "" {
+		if m.Path != "" {
+			m.Key = filepath.Base(m.Path)
+		} else {
+			m.Key = path.Base(m.URL)
+		}
+	}
+	return m.validateTarget()
+}
+
+// validateTarget checks where the file of m goes: profile, bucket, key and
+// sidecar.
+func (m *ingestMessage) validateTarget() error {
+	if _, ok := profiles[m.Profile]; !ok || !visible(m.tenant, m.Profile) {
+		return fmt.Errorf("unknown profile %q", m.Profile)
+	}
+	if m.Bucket == "" || !filepath.IsLocal(m.Bucket) || filepath.Base(m.Bucket) != m.Bucket {
+		return fmt.Errorf("invalid bucket %q", m.Bucket)
+	}
+	if !filepath.IsLocal(filepath.FromSlash(m.Key)) || isSidecar(m.Key) || path.Base(m.Key) == priorityFile {
+		return fmt.Errorf("invalid key %q", m.Key)
+	}
+	if m.Sidecar != nil {
+		if err := validateTags(m.Sidecar.Tags); err != nil {
+			return fmt.Errorf("invalid sidecar: %w", err)
+		}
+	}
+	return nil
+}
+
+// download saves the content of url to dst.
+func download(url, dst string) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyBuffer(f, resp.Body, newCopyBuffer()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// The admin listener and the control socket stream the state transitions
+// of files as server-sent events, for dashboards and scripts that react to
+// them rather than poll /status:
+//
+//	GET /events[?profile=name...][&after=seq]
+//
+//	id: 1234
+//	event: file.success
+//	data: {"schema_version": 1, "event": "file.success", ...}
+//
+// The data is the lifecycleEvent of events.go. id is its sequence number
+// with -event-journal, and events after a sequence number (after=, or the
+// Last-Event-ID header a reconnecting EventSource sends) are replayed from
+// the journal first. A client that falls behind by eventQueueSize events
+// gets an "overflow" event and is disconnected. The stream needs the read
+// scope; a token bound to a tenant only gets the events of the tenant.
+const sseKeepAlive = 15 * time.Second
+
+func handleEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	after := r.URL.Query().Get("after")
+	if after == "" {
+		after = r.Header.Get("Last-Event-ID")
+	}
+	var last int64
+	if after != "" {
+		var err error
+		if last, err = strconv.ParseInt(after, 10, 64); err != nil {
+			http.Error(w, "invalid sequence number "+after, http.StatusBadRequest)
+			return
+		}
+		if !journalEvents {
+			http.Error(w, "replaying events needs -event-journal", http.StatusBadRequest)
+			return
+		}
+	}
+	tenant := contextTenant(r.Context())
+	wanted := func(e lifecycleEvent) bool {
+		names := r.URL.Query()["profile"]
+		return (tenant == "" || e.Tenant == tenant) && (len(names) == 0 || slices.Contains(names, e.Profile))
+	}
+
+	// Subscribe before replaying, so that no event falls in between.
+	live, cancel := subscribeEvents()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Events that are replayed may also arrive live; they are sent once.
+	replayed := make(map[int64]bool)
+	for last > 0 {
+		events, err := readJournal(last, 1000)
+		if err != nil {
+			fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+			return
+		}
+		if len(events) == 0 {
+			break
+		}
+		for _, e := range events {
+			if wanted(e) && !writeEvent(w, e) {
+				return
+			}
+			replayed[e.Seq] = true
+			last = e.Seq
+		}
+		flusher.Flush()
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-live:
+			if !ok {
+				fmt.Fprint(w, "event: overflow\ndata: {}\n\n")
+				return
+			}
+			if replayed[e.Seq] || !wanted(e) {
+				continue
+			}
+			if !writeEvent(w, e) {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes e in the event stream format and reports whether the
+// client is still there.
+func writeEvent(w http.ResponseWriter, e lifecycleEvent) bool {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return true
+	}
+	if e.Seq > 0 {
+		fmt.Fprintf(w, "id: %d\n", e.Seq)
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Event, data)
+	return err == nil
+}
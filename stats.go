@@ -0,0 +1,164 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Every file record is also counted in daily_stats, keyed by its UTC day,
+// profile and bucket, and the daily_delivery_health view derives failure
+// rates, mean retries and throughput from it. Dashboards can chart these
+// without aggregating file_records, which prune may also have thinned out.
+// Dry runs and imported inventories are not counted.
+
+// dailyCounts is the contribution of one or more records to a row of
+// daily_stats.
+type dailyCounts struct {
+	files, succeeded, failed, conflicts, failedOver, retries, bytes, durationMS int64
+	// uploaded and uploadedBytes leave out files that were already present
+	uploaded, uploadedBytes int64
+}
+
+func countOutcome(outcome string, retries, bytes, durationMS int64) dailyCounts {
+	c := dailyCounts{files: 1, retries: retries}
+	switch outcome {
+	case "success":
+		c.succeeded, c.bytes, c.durationMS = 1, bytes, durationMS
+		c.uploaded, c.uploadedBytes = 1, bytes
+	case "already_present":
+		c.succeeded, c.bytes, c.durationMS = 1, bytes, durationMS
+	case "failure":
+		c.failed = 1
+	case "conflict":
+		c.conflicts = 1
+	case "failed_over":
+		c.failedOver = 1
+	}
+	return c
+}
+
+func (c *dailyCounts) add(o dailyCounts) {
+	c.files += o.files
+	c.succeeded += o.succeeded
+	c.failed += o.failed
+	c.conflicts += o.conflicts
+	c.failedOver += o.failedOver
+	c.retries += o.retries
+	c.bytes += o.bytes
+	c.durationMS += o.durationMS
+	c.uploaded += o.uploaded
+	c.uploadedBytes += o.uploadedBytes
+}
+
+// recordDailyStats counts a record written at t.
+func recordDailyStats(rec fileRecord, outcome string, t time.Time) {
+	if outcome == "dry_run" {
+		return
+	}
+	c := countOutcome(outcome, int64(rec.Retries), rec.Bytes, rec.Duration.Milliseconds())
+	if err := addDailyStats(t.UTC().Format(time.DateOnly), rec.Profile.Name, rec.Bucket, rec.Profile.tenantName(), c); err != nil {
+		log.Printf("Failed to update daily statistics: %v", err)
+	}
+}
+
+// addDailyStatsSet adds the counts of excluded to a row of daily_stats, in
+// the columns that existed at migration 8.
+const addDailyStatsSet = `files = daily_stats.files + excluded.files, succeeded = daily_stats.succeeded + excluded.succeeded,
+	failed = daily_stats.failed + excluded.failed, conflicts = daily_stats.conflicts + excluded.conflicts, failed_over = daily_stats.failed_over + excluded.failed_over,
+	retries = daily_stats.retries + excluded.retries, bytes = daily_stats.bytes + excluded.bytes, duration_ms = daily_stats.duration_ms + excluded.duration_ms`
+
+func addDailyStats(day, profile, bucket, tenant string, c dailyCounts) error {
+	_, err := db.Exec(
+		`INSERT INTO daily_stats(day, profile, bucket, tenant, files, succeeded, failed, conflicts, failed_over, retries, bytes, duration_ms, uploaded, uploaded_bytes) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(day, profile, bucket) DO UPDATE SET `+addDailyStatsSet+`, tenant = excluded.tenant,
+			uploaded = daily_stats.uploaded + excluded.uploaded, uploaded_bytes = daily_stats.uploaded_bytes + excluded.uploaded_bytes`,
+		day, profile, bucket, optionalString(tenant), c.files, c.succeeded, c.failed, c.conflicts, c.failedOver, c.retries, c.bytes, c.durationMS, c.uploaded, c.uploadedBytes,
+	)
+	return err
+}
+
+// backfillDailyStats counts the records written before daily_stats existed.
+func backfillDailyStats(tx storeTx) {
+	rows, err := tx.Query(`SELECT profile, bucket, upload_outcome, retries, last_retry, bytes, duration_ms FROM file_records
+		WHERE upload_outcome != 'dry_run' AND COALESCE(operation, 'upload') != 'import'`)
+	if err != nil {
+		log.Fatalf("Failed to read file records: %v", err)
+	}
+	type key struct{ day, profile, bucket string }
+	totals := make(map[key]*dailyCounts)
+	for rows.Next() {
+		var (
+			profile, bucket, outcome   string
+			retries, bytes, durationMS sql.NullInt64
+			at                         sql.NullTime
+		)
+		if err := rows.Scan(&profile, &bucket, &outcome, &retries, &at, &bytes, &durationMS); err != nil {
+			log.Fatalf("Failed to read file records: %v", err)
+		}
+		k := key{at.Time.UTC().Format(time.DateOnly), profile, bucket}
+		if totals[k] == nil {
+			totals[k] = &dailyCounts{}
+		}
+		totals[k].add(countOutcome(outcome, retries.Int64, bytes.Int64, durationMS.Int64))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Fatalf("Failed to read file records: %v", err)
+	}
+
+	// The backfill runs at migration 8, before daily_stats has a tenant
+	// column; backfillTenants sets it at migration 13.
+	for k, c := range totals {
+		_, err := tx.Exec(
+			`INSERT INTO daily_stats(day, profile, bucket, files, succeeded, failed, conflicts, failed_over, retries, bytes, duration_ms) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(day, profile, bucket) DO UPDATE SET `+addDailyStatsSet,
+			k.day, k.profile, k.bucket, c.files, c.succeeded, c.failed, c.conflicts, c.failedOver, c.retries, c.bytes, c.durationMS,
+		)
+		if err != nil {
+			log.Fatalf("Failed to backfill daily statistics: %v", err)
+		}
+	}
+	if len(totals) > 0 {
+		log.Printf("Backfilled daily statistics for %d day(s), profile(s) and bucket(s)", len(totals))
+	}
+}
+
+// backfillUploads counts the uploaded files and bytes of the records
+// written before daily_stats had the columns.
+func backfillUploads(tx storeTx) {
+	rows, err := tx.Query(`SELECT profile, bucket, last_retry, bytes FROM file_records
+		WHERE upload_outcome = 'success' AND COALESCE(operation, 'upload') != 'import'`)
+	if err != nil {
+		log.Fatalf("Failed to read file records: %v", err)
+	}
+	type key struct{ day, profile, bucket string }
+	totals := make(map[key]*dailyCounts)
+	for rows.Next() {
+		var (
+			profile, bucket string
+			bytes           sql.NullInt64
+			at              sql.NullTime
+		)
+		if err := rows.Scan(&profile, &bucket, &at, &bytes); err != nil {
+			log.Fatalf("Failed to read file records: %v", err)
+		}
+		k := key{at.Time.UTC().Format(time.DateOnly), profile, bucket}
+		if totals[k] == nil {
+			totals[k] = &dailyCounts{}
+		}
+		totals[k].uploaded++
+		totals[k].uploadedBytes += bytes.Int64
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Fatalf("Failed to read file records: %v", err)
+	}
+
+	for k, c := range totals {
+		if _, err := tx.Exec("UPDATE daily_stats SET uploaded = ?, uploaded_bytes = ? WHERE day = ? AND profile = ? AND bucket = ?",
+			c.uploaded, c.uploadedBytes, k.day, k.profile, k.bucket); err != nil {
+			log.Fatalf("Failed to backfill daily statistics: %v", err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+)
+
+// openTestDB replaces db with a migrated SQLite database in a temporary
+// directory for the duration of the test.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	if dbLog == nil {
+		dbLog = slog.Default()
+	}
+	if uploaderLog == nil {
+		uploaderLog = slog.Default()
+	}
+	previousDriver := databaseDriver
+	if !cgoSQLite {
+		databaseDriver = sqliteDriverPureGo
+	}
+	s, err := openSQLite(filepath.Join(t.TempDir(), "flood.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = s
+	t.Cleanup(func() {
+		db.Close()
+		db, databaseDriver = previous, previousDriver
+	})
+	if err := migrate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCountOutcome(t *testing.T) {
+	tests := []struct {
+		outcome string
+		want    dailyCounts
+	}{
+		{"success", dailyCounts{files: 1, succeeded: 1, retries: 2, bytes: 100, durationMS: 10, uploaded: 1, uploadedBytes: 100}},
+		{"already_present", dailyCounts{files: 1, succeeded: 1, retries: 2, bytes: 100, durationMS: 10}},
+		{"failure", dailyCounts{files: 1, failed: 1, retries: 2}},
+		{"conflict", dailyCounts{files: 1, conflicts: 1, retries: 2}},
+		{"failed_over", dailyCounts{files: 1, failedOver: 1, retries: 2}},
+		{"interrupted", dailyCounts{files: 1, retries: 2}},
+	}
+	for _, tt := range tests {
+		if got := countOutcome(tt.outcome, 2, 100, 10); got != tt.want {
+			t.Errorf("countOutcome(%q) = %+v, want %+v", tt.outcome, got, tt.want)
+		}
+	}
+}
+
+func TestDailyCountsAdd(t *testing.T) {
+	var c dailyCounts
+	c.add(countOutcome("success", 1, 100, 10))
+	c.add(countOutcome("already_present", 0, 50, 5))
+	c.add(countOutcome("failure", 3, 0, 0))
+	want := dailyCounts{files: 3, succeeded: 2, failed: 1, retries: 4, bytes: 150, durationMS: 15, uploaded: 1, uploadedBytes: 100}
+	if c != want {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+}
+
+func TestBackfillUploads(t *testing.T) {
+	openTestDB(t)
+	for _, outcome := range []string{"success", "success", "already_present", "failure"} {
+		_, err := db.Exec("INSERT INTO file_records(profile, bucket, filepath, retries, last_retry, upload_outcome, bytes) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			"p", "b", "/f", 0, "2026-10-01 12:00:00", outcome, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := addDailyStats("2026-10-01", "p", "b", "", dailyCounts{files: 4, succeeded: 3, failed: 1, bytes: 300}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	backfillUploads(tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploaded, uploadedBytes int64
+	if err := db.QueryRow("SELECT uploaded, uploaded_bytes FROM daily_stats WHERE day = '2026-10-01' AND profile = 'p' AND bucket = 'b'").Scan(&uploaded, &uploadedBytes); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded != 2 || uploadedBytes != 200 {
+		t.Errorf("got %d uploaded, %d bytes; want 2, 200", uploaded, uploadedBytes)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// With -statsd-addr, flood sends metrics over UDP to a StatsD server or the
+// Datadog agent:
+//
+//	<prefix>files              counter, per file settled
+//	<prefix>bytes              counter, bytes of the files transferred
+//	<prefix>upload.duration    timer, of the successful attempt
+//	<prefix>retries            counter, per attempt that is retried
+//	<prefix>queue.depth        gauge, files waiting per profile (server mode)
+//
+// The metrics are tagged with profile, tenant (if any, see tenant.go),
+// bucket, and for files also operation and outcome, followed by -statsd-tags, in the DogStatsD format. Plain
+// StatsD servers don't understand tags; -statsd-tags=none leaves them out.
+// Metrics are sent best effort: when the sender falls behind they are
+// dropped rather than slow down uploads.
+var statsd chan string
+
+const (
+	statsdQueueSize = 4096
+	statsdMaxPacket = 1432 // stays within a single Ethernet frame
+)
+
+func startStatsD() {
+	if statsdAddr == "" {
+		return
+	}
+	conn, err := net.Dial("udp", statsdAddr)
+	if err != nil {
+		log.Fatalf("Invalid -statsd-addr: %v", err)
+	}
+	statsd = make(chan string, statsdQueueSize)
+	log.Printf("Sending metrics to StatsD at %s", statsdAddr)
+	go sendStatsD(conn)
+}
+
+// sendStatsD batches the queued metrics into packets.
+func sendStatsD(conn net.Conn) {
+	var packet bytes.Buffer
+	flush := time.NewTicker(time.Second)
+	for {
+		select {
+		case line := <-statsd:
+			if packet.Len() > 0 && packet.Len()+1+len(line) > statsdMaxPacket {
+				conn.Write(packet.Bytes())
+				packet.Reset()
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		case <-flush.C:
+			if packet.Len() > 0 {
+				// Errors are those of a missing listener; keep trying
+				conn.Write(packet.Bytes())
+				packet.Reset()
+			}
+		}
+	}
+}
+
+// statsdMetric queues a metric of the given type (c, ms or g).
+func statsdMetric(name string, value int64, kind string, tags ...string) {
+	if statsd == nil {
+		return
+	}
+	line := fmt.Sprintf("%s%s:%d|%s", statsdPrefix, name, value, kind)
+	if statsdTags != "none" {
+		if statsdTags != "" {
+			tags = append(tags, statsdTags)
+		}
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+	}
+	select {
+	case statsd <- line:
+	default:
+	}
+}
+
+// statsdTag formats a tag, replacing the characters DogStatsD reserves.
+func statsdTag(name, value string) string {
+	return name + ":" + strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(value)
+}
+
+// countStatsDOutcome sends the metrics of a settled file.
+func countStatsDOutcome(rec fileRecord, operation, outcome string) {
+	if statsd == nil {
+		return
+	}
+	tags := append(profileTags(rec.Profile),
+		statsdTag("bucket", rec.Bucket),
+		statsdTag("operation", operation),
+		statsdTag("outcome", outcome),
+	)
+	statsdMetric("files", 1, "c", tags...)
+	if outcome == "success" {
+		statsdMetric("bytes", rec.Bytes, "c", tags...)
+		statsdMetric("upload.duration", rec.Duration.Milliseconds(), "ms", tags...)
+	}
+}
+
+// countStatsDRetry counts an attempt of rec that is retried.
+func countStatsDRetry(rec fileRecord) {
+	statsdMetric("retries", 1, "c", append(profileTags(rec.Profile), statsdTag("bucket", rec.Bucket))...)
+}
+
+// profileTags returns the tags of the profile and its tenant.
+func profileTags(profile Profile) []string {
+	tags := []string{statsdTag("profile", profile.Name)}
+	if tenant := profile.tenantName(); tenant != "" {
+		tags = append(tags, statsdTag("tenant", tenant))
+	}
+	return tags
+}
+
+// reportQueueDepth sends the number of files waiting per profile every
+// 10 seconds.
+func reportQueueDepth() {
+	for range time.Tick(10 * time.Second) {
+		for _, q := range queues {
+			q.mu.Lock()
+			depth := len(q.pending)
+			q.mu.Unlock()
+			statsdMetric("queue.depth", int64(depth), "g", profileTags(q.currentProfile())...)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// statusRecord is a line of status output in JSON form.
+type statusRecord struct {
+	ID        int64      `json:"id"`
+	Profile   string     `json:"profile"`
+	Bucket    string     `json:"bucket"`
+	Path      string     `json:"path"`
+	Key       string     `json:"key,omitempty"`
+	Operation string     `json:"operation"`
+	Outcome   string     `json:"outcome"`
+	Retries   int        `json:"retries"`
+	Time      *time.Time `json:"time,omitempty"`
+}
+
+// runStatusMode prints the file records of the database:
+//
+//	flood status [-profile name] [-state failed] [-since 24h] [-all] [-json]
+//
+// By default only the latest record of every file is shown, which is its
+// current state; -all shows every attempt. -state matches the upload outcome,
+// with "failed" accepted for "failure".
+func runStatusMode(args []string) {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	profile := fs.String("profile", "", "Only show records of this profile")
+	state := fs.String("state", "", "Only show records with this outcome (success, failure, conflict, ...)")
+	since := fs.String("since", "", "Only show records of this period (e.g. 24h or 7d)")
+	all := fs.Bool("all", false, "Show every attempt instead of the latest record of each file")
+	limit := fs.Int("limit", 0, "Show at most this many records, the most recent ones (0 for no limit)")
+	asJSON := fs.Bool("json", false, "Print one JSON object per record")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] status [status flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *limit < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var (
+		where  []string
+		params []any
+	)
+	if !*all {
+		where = append(where, "id IN (SELECT MAX(id) FROM file_records GROUP BY profile, bucket, filepath)")
+	}
+	if *profile != "" {
+		where = append(where, "profile = ?")
+		params = append(params, *profile)
+	}
+	if *state != "" {
+		if *state == "failed" {
+			*state = "failure"
+		}
+		where = append(where, "upload_outcome = ?")
+		params = append(params, *state)
+	}
+	if *since != "" {
+		period, err := parseDuration(*since)
+		if err != nil || period <= 0 {
+			log.Fatalf("Invalid -since %q: must be a positive duration such as 24h", *since)
+		}
+		where = append(where, "last_retry >= ?")
+		params = append(params, time.Now().Add(-period))
+	}
+
+	query := "SELECT id, profile, bucket, filepath, COALESCE(object_key, ''), COALESCE(operation, 'upload'), upload_outcome, retries, last_retry FROM file_records"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if *limit > 0 {
+		query = fmt.Sprintf("SELECT * FROM (%s ORDER BY id DESC LIMIT %d) latest", query, *limit)
+	}
+	rows, err := db.Query(query+" ORDER BY id", params...)
+	if err != nil {
+		log.Fatalf("Failed to query file records: %v", err)
+	}
+	defer rows.Close()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !*asJSON {
+		fmt.Fprintln(w, "TIME\tPROFILE\tBUCKET\tOPERATION\tOUTCOME\tRETRIES\tPATH")
+	}
+	for rows.Next() {
+		var (
+			r       statusRecord
+			retries sql.NullInt64
+			at      sql.NullTime
+		)
+		if err := rows.Scan(&r.ID, &r.Profile, &r.Bucket, &r.Path, &r.Key, &r.Operation, &r.Outcome, &retries, &at); err != nil {
+			log.Fatalf("Failed to read file records: %v", err)
+		}
+		r.Retries = int(retries.Int64)
+		if at.Valid {
+			r.Time = &at.Time
+		}
+
+		if *asJSON {
+			data, _ := json.Marshal(r)
+			fmt.Println(string(data))
+			continue
+		}
+		when := "-"
+		if r.Time != nil {
+			when = r.Time.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", when, r.Profile, r.Bucket, r.Operation, r.Outcome, r.Retries, r.Path)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("Failed to read file records: %v", err)
+	}
+	w.Flush()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPostgresRewrite(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{
+			"SELECT id FROM file_records WHERE profile = ? AND outcome = ?",
+			"SELECT id FROM file_records WHERE profile = $1 AND outcome = $2",
+		},
+		{
+			"CREATE TABLE IF NOT EXISTS t (id INTEGER PRIMARY KEY AUTOINCREMENT, bytes INTEGER)",
+			"CREATE TABLE IF NOT EXISTS t (id BIGSERIAL PRIMARY KEY, bytes BIGINT)",
+		},
+		{
+			"INSERT INTO daily_stats (day, uploaded) VALUES (?, ?) ON CONFLICT (day) DO UPDATE SET uploaded = uploaded + excluded.uploaded",
+			"INSERT INTO daily_stats (day, uploaded) VALUES ($1, $2) ON CONFLICT (day) DO UPDATE SET uploaded = uploaded + excluded.uploaded",
+		},
+	}
+	s := &postgresStore{}
+	for _, tt := range tests {
+		if got := s.rewrite(tt.query); got != tt.want {
+			t.Errorf("rewrite(%q)\n got %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMySQLRewrite(t *testing.T) {
+	tests := []struct {
+		query, want string
+	}{
+		{
+			"SELECT id FROM file_records WHERE profile = ?",
+			"SELECT id FROM file_records WHERE profile = ?",
+		},
+		{
+			"CREATE TABLE IF NOT EXISTS t (\n    id INTEGER PRIMARY KEY AUTOINCREMENT,\n    name TEXT UNIQUE,\n    created TIMESTAMP\n);",
+			"CREATE TABLE IF NOT EXISTS t (\n    id BIGINT AUTO_INCREMENT PRIMARY KEY,\n    name VARCHAR(255) UNIQUE,\n    created DATETIME(6)\n);",
+		},
+		{
+			"CREATE TABLE IF NOT EXISTS t (\n    day TEXT,\n    profile TEXT,\n    n INTEGER,\n    PRIMARY KEY (day, profile, n)\n);",
+			"CREATE TABLE IF NOT EXISTS t (\n    day TEXT,\n    profile TEXT,\n    n BIGINT,\n    PRIMARY KEY (day(255), profile(255), n)\n);",
+		},
+		{
+			"INSERT INTO daily_stats (day, uploaded) VALUES (?, ?) ON CONFLICT (day) DO UPDATE SET uploaded = uploaded + excluded.uploaded",
+			"INSERT INTO daily_stats (day, uploaded) VALUES (?, ?) ON DUPLICATE KEY UPDATE uploaded = uploaded + VALUES(uploaded)",
+		},
+	}
+	s := &mysqlStore{}
+	for _, tt := range tests {
+		if got := s.rewrite(tt.query); got != tt.want {
+			t.Errorf("rewrite(%q)\n got %q\nwant %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	if dbLog == nil {
+		dbLog = slog.Default()
+	}
+	previousDriver, previousDB := databaseDriver, db
+	databaseDriver = sqliteDriverBolt
+	t.Cleanup(func() { databaseDriver, db = previousDriver, previousDB })
+	path := filepath.Join(t.TempDir(), "flood.bolt")
+
+	s, err := openSQLite(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = s
+	if err := migrate(); err != nil {
+		t.Fatal(err)
+	}
+	lastRetry := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	tx, err := db.begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		_, err := tx.Exec("INSERT INTO file_records(profile, bucket, filepath, retries, last_retry, upload_outcome, bytes) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			"p", "b", "/f", 0, lastRetry, "success", 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("DELETE FROM file_records WHERE id > 10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.maintain(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, err = openSQLite(path); err != nil {
+		t.Fatal(err)
+	}
+	db = s
+	defer db.Close()
+	if err := migrate(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	var got time.Time
+	if err := db.QueryRow("SELECT COUNT(*) FROM file_records").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT last_retry FROM file_records LIMIT 1").Scan(&got); err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 || !got.Equal(lastRetry) {
+		t.Errorf("got %d records, last_retry %v; want 10, %v", n, got, lastRetry)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Files are never read into memory as a whole. Every upload streams its body
+// from disk through a buffer of at most maxBufferPerUpload bytes per stream
+// (one for a single-part upload, one per part in flight for a multipart
+// upload), and copies and checksums use a buffer of the same size.
+const minUploadBuffer = 4 << 10
+
+var maxBufferPerUpload int64 = 1 << 20
+
+func validateUploadBuffer(size int64) error {
+	if size < minUploadBuffer {
+		return fmt.Errorf("buffer size %d must be at least %d bytes", size, int64(minUploadBuffer))
+	}
+	return nil
+}
+
+// newCopyBuffer returns a buffer for io.CopyBuffer.
+func newCopyBuffer() []byte {
+	return make([]byte, maxBufferPerUpload)
+}
+
+// bufferedBody reads an upload body from disk in chunks of the configured
+// buffer size. Unlike bufio.Reader it is seekable, so the SDK can still
+// rewind it to retry a request or compute a checksum.
+type bufferedBody struct {
+	r          io.ReadSeeker
+	buf        []byte
+	start, end int
+	progress   *inflightUpload // nil if the upload is not tracked
+	pos        int64           // bytes returned since the start of the body
+}
+
+func newBufferedBody(r io.ReadSeeker, progress *inflightUpload) *bufferedBody {
+	return &bufferedBody{r: r, buf: make([]byte, maxBufferPerUpload), progress: progress}
+}
+
+func (b *bufferedBody) Read(p []byte) (int, error) {
+	if b.progress != nil && b.progress.cancelled() {
+		return 0, errCancelled
+	}
+	if b.start == b.end {
+		n, err := b.r.Read(b.buf)
+		markProgress()
+		b.start, b.end = 0, n
+		if n == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, b.buf[b.start:b.end])
+	b.start += n
+	b.pos += int64(n)
+	if b.progress != nil {
+		b.progress.sent.Add(int64(n))
+	}
+	return n, nil
+}
+
+func (b *bufferedBody) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekCurrent {
+		// The underlying reader is ahead by the unread part of the buffer
+		offset -= int64(b.end - b.start)
+	}
+	b.start, b.end = 0, 0
+	pos, err := b.r.Seek(offset, whence)
+	if err == nil {
+		if b.progress != nil {
+			b.progress.sent.Add(pos - b.pos)
+		}
+		b.pos = pos
+	}
+	return pos, err
+}
+
+// uploadBody prepares a body read from disk for an upload request.
+func uploadBody(r io.ReadSeeker, profile Profile) io.ReadSeeker {
+	return throttle(newBufferedBody(r, uploadProgress(r, profile)), profile)
+}
+
+// streamFile copies src to dst without holding more than one buffer of the
+// file in memory. progress, if not nil, is called with the bytes of every
+// write.
+func streamFile(src, dst string, progress func(n int64)) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	if progress != nil {
+		w = &progressWriter{w: out, progress: progress}
+	}
+	if _, err := io.CopyBuffer(w, in, newCopyBuffer()); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+type progressWriter struct {
+	w        io.Writer
+	progress func(n int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.progress(int64(n))
+	return n, err
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In server mode a summary is logged every -summary-interval: one line for
+// all profiles, one per profile and one per bucket of a profile, all with
+// the message "summary", so grep summary shows whether the pipeline keeps
+// up and which destination lags. Completed and failed files are counted
+// since the previous summary; the backlog is the files in processing, and
+// oldest_pending the age of the oldest of them.
+//
+// To bound the lines of installations with thousands of buckets, only the
+// -metrics-max-buckets busiest buckets of a profile get lines of their
+// own; the others are added up in a line with the bucket otherBucketLabel.
+type summaryCounts struct {
+	completed    int
+	bytes        int64
+	failed       int
+	backlog      int
+	backlogBytes int64
+	oldest       time.Time // of the files in processing, zero if none
+}
+
+func (c *summaryCounts) add(o *summaryCounts) {
+	c.completed += o.completed
+	c.bytes += o.bytes
+	c.failed += o.failed
+	c.backlog += o.backlog
+	c.backlogBytes += o.backlogBytes
+	if !o.oldest.IsZero() && (c.oldest.IsZero() || o.oldest.Before(c.oldest)) {
+		c.oldest = o.oldest
+	}
+}
+
+// activity ranks buckets for -metrics-max-buckets.
+func (c *summaryCounts) activity() int {
+	return c.completed + c.failed + c.backlog
+}
+
+// otherBucketLabel is the bucket of the line that adds up the buckets beyond
+// -metrics-max-buckets.
+const otherBucketLabel = "_other"
+
+var summary struct {
+	mu     sync.Mutex
+	counts map[string]map[string]*summaryCounts // by profile and bucket
+}
+
+// countSummaryOutcome counts the outcome of a file for the next summary.
+func countSummaryOutcome(rec fileRecord, outcome string) {
+	if summaryInterval <= 0 {
+		return
+	}
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	if summary.counts == nil {
+		summary.counts = make(map[string]map[string]*summaryCounts)
+	}
+	c := bucketCounts(summary.counts, rec.Profile.Name, rec.Bucket)
+	switch outcome {
+	case "success", "already_present":
+		c.completed++
+		c.bytes += rec.Bytes
+	case "failure", "conflict":
+		c.failed++
+	}
+}
+
+// bucketCounts returns the counts of a bucket in counts, adding them if
+// needed.
+func bucketCounts(counts map[string]map[string]*summaryCounts, profile, bucket string) *summaryCounts {
+	buckets, ok := counts[profile]
+	if !ok {
+		buckets = make(map[string]*summaryCounts)
+		counts[profile] = buckets
+	}
+	c, ok := buckets[bucket]
+	if !ok {
+		c = &summaryCounts{}
+		buckets[bucket] = c
+	}
+	return c
+}
+
+func logSummaries() {
+	for range time.Tick(summaryInterval) {
+		summary.mu.Lock()
+		counts := summary.counts
+		summary.counts = nil
+		summary.mu.Unlock()
+		if counts == nil {
+			counts = make(map[string]map[string]*summaryCounts)
+		}
+
+		var names []string
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		var total summaryCounts
+		for _, name := range names {
+			buckets := counts[name]
+			if buckets == nil {
+				buckets = make(map[string]*summaryCounts)
+			}
+			addProcessingBacklog(name, buckets)
+
+			var profileTotal summaryCounts
+			for _, c := range buckets {
+				profileTotal.add(c)
+			}
+			logSummary(&profileTotal, "profile", name)
+			total.add(&profileTotal)
+
+			for _, b := range limitBuckets(buckets) {
+				args := []any{"profile", name, "bucket", b.name}
+				if b.merged > 0 {
+					args = append(args, "buckets", b.merged)
+				}
+				logSummary(b.counts, args...)
+			}
+		}
+		logSummary(&total,
+			"interval", summaryInterval,
+			"throughput", formatBytes(int64(float64(total.bytes)/summaryInterval.Seconds()))+"/s",
+		)
+	}
+}
+
+func logSummary(c *summaryCounts, args ...any) {
+	slog.Info("summary", append(args,
+		"completed", c.completed,
+		"bytes", c.bytes,
+		"failed", c.failed,
+		"backlog", c.backlog,
+		"backlog_bytes", c.backlogBytes,
+		"oldest_pending", pendingAge(c.oldest),
+	)...)
+}
+
+type bucketSummary struct {
+	name   string
+	counts *summaryCounts
+	merged int // buckets added up in the otherBucketLabel line
+}
+
+// limitBuckets returns the buckets with activity, at most
+// -metrics-max-buckets of them plus a line for the rest.
+func limitBuckets(buckets map[string]*summaryCounts) []bucketSummary {
+	var active []bucketSummary
+	for name, c := range buckets {
+		if c.activity() > 0 {
+			active = append(active, bucketSummary{name: name, counts: c})
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if active[i].counts.activity() != active[j].counts.activity() {
+			return active[i].counts.activity() > active[j].counts.activity()
+		}
+		return active[i].name < active[j].name
+	})
+	if len(active) <= metricsMaxBuckets {
+		return active
+	}
+	other := bucketSummary{name: otherBucketLabel, counts: &summaryCounts{}}
+	for _, b := range active[metricsMaxBuckets:] {
+		other.counts.add(b.counts)
+		other.merged++
+	}
+	return append(active[:metricsMaxBuckets], other)
+}
+
+// addProcessingBacklog adds the files in the processing directory of a
+// profile to the counts of their buckets.
+func addProcessingBacklog(profile string, buckets map[string]*summaryCounts) {
+	root := filepath.Join(serverDir, "processing", profile)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || isSidecar(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		bucket, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+		c, ok := buckets[bucket]
+		if !ok {
+			c = &summaryCounts{}
+			buckets[bucket] = c
+		}
+		c.add(&summaryCounts{backlog: 1, backlogBytes: info.Size(), oldest: info.ModTime()})
+		return nil
+	})
+}
+
+func pendingAge(oldest time.Time) time.Duration {
+	if oldest.IsZero() {
+		return 0
+	}
+	return time.Since(oldest).Round(time.Second)
+}
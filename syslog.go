@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// syslogFacilities are the facilities -syslog-facility accepts.
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverity maps a level to a syslog severity, which journald uses
+// as PRIORITY as well.
+func syslogSeverity(level slog.Level) int {
+	switch {
+	case level < slog.LevelInfo:
+		return 7 // debug
+	case level < slog.LevelWarn:
+		return 6 // info
+	case level < slog.LevelError:
+		return 4 // warning
+	default:
+		return 3 // err
+	}
+}
+
+// syslogSink sends RFC 5424 messages to the local syslog daemon, or with
+// -syslog-addr udp://host:514 or tcp://host:601 to a remote one. Stream
+// connections use octet counting framing (RFC 6587). The subsystem of a
+// line becomes its MSGID; the other attributes follow the message as
+// key=value pairs. If a message cannot be sent even after reconnecting, it
+// is written to stderr.
+type syslogSink struct {
+	mu       sync.Mutex
+	network  string
+	addr     string
+	conn     net.Conn
+	facility int
+	hostname string
+	pid      int
+}
+
+func newSyslogSink(addr, facility string) (*syslogSink, error) {
+	f, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("unknown facility %q", facility)
+	}
+	s := &syslogSink{facility: f, pid: os.Getpid()}
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+	if addr != "" {
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("invalid address %q: must be udp://host:port or tcp://host:port", addr)
+		}
+		s.network, s.addr = u.Scheme, u.Host
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// connect dials the configured address, or else the first local syslog
+// socket that accepts a connection.
+func (s *syslogSink) connect() error {
+	if s.addr != "" {
+		conn, err := net.DialTimeout(s.network, s.addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		return nil
+	}
+	for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				s.conn = conn
+				return nil
+			}
+		}
+	}
+	return errors.New("no local syslog socket found")
+}
+
+func (s *syslogSink) write(r slog.Record, attrs []slog.Attr) error {
+	msgID := "-"
+	rest := attrs[:0:0]
+	for _, a := range attrs {
+		if a.Key == "subsystem" {
+			msgID = a.Value.String()
+			continue
+		}
+		rest = append(rest, a)
+	}
+	msg := fmt.Appendf(nil, "<%d>1 %s %s flood %d %s - ",
+		s.facility*8+syslogSeverity(r.Level), r.Time.Format("2006-01-02T15:04:05.000000Z07:00"), s.hostname, s.pid, msgID)
+	msg = appendMessage(msg, r.Message, rest)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.send(msg)
+	if err != nil && s.conn != nil {
+		// The daemon restarted or the connection broke: try once more
+		s.conn.Close()
+		s.conn = nil
+		if err = s.connect(); err == nil {
+			err = s.send(msg)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", msg)
+	}
+	return err
+}
+
+func (s *syslogSink) send(msg []byte) error {
+	if s.conn == nil {
+		return errors.New("not connected")
+	}
+	switch s.conn.LocalAddr().Network() {
+	case "tcp", "unix":
+		_, err := fmt.Fprintf(s.conn, "%d %s", len(msg), msg)
+		return err
+	}
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+// journaldSocket is where journald receives entries in its native protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldSink sends entries to journald with one field per attribute:
+// keys become upper case field names, so file_id is FILE_ID and can be
+// matched with journalctl FILE_ID=... Entries larger than a datagram lose
+// the end of their message.
+type journaldSink struct {
+	conn *net.UnixConn
+}
+
+func newJournaldSink() (*journaldSink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &journaldSink{conn: conn}, nil
+}
+
+func (s *journaldSink) write(r slog.Record, attrs []slog.Attr) error {
+	var fields []byte
+	fields = appendJournalField(fields, "PRIORITY", fmt.Sprint(syslogSeverity(r.Level)))
+	fields = appendJournalField(fields, "SYSLOG_IDENTIFIER", "flood")
+	for _, a := range attrs {
+		fields = appendJournalField(fields, journalFieldName(a.Key), a.Value.String())
+	}
+	entry := appendJournalField(fields, "MESSAGE", r.Message)
+
+	_, err := s.conn.Write(entry)
+	if errors.Is(err, syscall.EMSGSIZE) {
+		// Leave room for the other fields and the length prefix
+		limit := max(0, 200*1024-len(fields))
+		entry = appendJournalField(fields, "MESSAGE", r.Message[:min(len(r.Message), limit)])
+		_, err = s.conn.Write(entry)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, string(appendMessage(nil, r.Message, attrs)))
+	}
+	return err
+}
+
+// appendJournalField appends a field in the native protocol: NAME=value,
+// or for values with line breaks the name, the length as 64 bit little
+// endian integer and the value.
+func appendJournalField(buf []byte, name, value string) []byte {
+	if !strings.Contains(value, "\n") {
+		return append(append(append(append(buf, name...), '='), value...), '\n')
+	}
+	buf = append(append(buf, name...), '\n')
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(value)))
+	return append(append(buf, value...), '\n')
+}
+
+// journalFieldName turns an attribute key into a field name, which may only
+// hold upper case letters, digits and underscores and must not start with
+// an underscore or a digit.
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] == '_' || (name[0] >= '0' && name[0] <= '9') {
+		return "F" + string(name)
+	}
+	return string(name)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// flood can run as a service shared by tenants. A tenant is declared in
+// the credentials file and its profiles name it:
+//
+//	[tenant acme]
+//	quota_bytes_per_day = 1TB     ; across all profiles of the tenant
+//	quota_objects_per_day = 500000
+//
+//	[acme-logs]
+//	tenant = acme
+//	...
+//
+// The files of a tenant stay in the directories of its profiles: failover
+// and fan-out only go to profiles of the same tenant. A token of
+// -auth-tokens-file can be bound to a tenant (see auth.go); it then only
+// sees and acts on the profiles of that tenant, and is refused the
+// endpoints that affect the whole server. File records, daily statistics,
+// events, webhooks, log lines and StatsD metrics carry the tenant.
+type tenant struct {
+	Name         string
+	QuotaBytes   int64 // zero for no quota, see quota.go
+	QuotaObjects int64
+}
+
+const tenantSectionPrefix = "tenant "
+
+// parseTenants returns the tenants declared in the sections of a
+// credentials file, keyed by name.
+func parseTenants(sections map[string]map[string]string) (map[string]*tenant, error) {
+	tenants := make(map[string]*tenant)
+	for section, settings := range sections {
+		name, ok := strings.CutPrefix(section, tenantSectionPrefix)
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, "/ ") {
+			return nil, fmt.Errorf("invalid tenant section [%s]", section)
+		}
+		t := &tenant{Name: name}
+		var err error
+		if t.QuotaBytes, t.QuotaObjects, err = parseQuotas(settings); err != nil {
+			return nil, fmt.Errorf("invalid tenant %s: %w", name, err)
+		}
+		tenants[name] = t
+	}
+	return tenants, nil
+}
+
+// tenantName returns the name of the tenant of the profile, "" if it has
+// none.
+func (p Profile) tenantName() string {
+	if p.Tenant == nil {
+		return ""
+	}
+	return p.Tenant.Name
+}
+
+// backfillTenants sets the tenant of the file records and daily statistics
+// written before migration 13 to that of their profile.
+func backfillTenants(tx storeTx) {
+	for name, p := range profiles {
+		if p.Tenant == nil {
+			continue
+		}
+		for _, table := range []string{"file_records", "daily_stats"} {
+			if _, err := tx.Exec("UPDATE "+table+" SET tenant = ? WHERE profile = ? AND tenant IS NULL", p.Tenant.Name, name); err != nil {
+				log.Fatalf("Failed to backfill tenants: %v", err)
+			}
+		}
+	}
+}
+
+// tenantExists reports whether a profile belongs to the tenant.
+func tenantExists(name string) bool {
+	for _, p := range profiles {
+		if p.tenantName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTenancy checks that the profiles a profile hands files to
+// belong to the same tenant.
+func validateTenancy(profile Profile, profiles map[string]Profile) error {
+	targets := []string{profile.FailoverProfile}
+	if fanout, ok := profile.Destination.(*fanoutDestination); ok {
+		targets = append(targets, fanout.names...)
+	}
+	for _, name := range targets {
+		if target, ok := profiles[name]; ok && target.tenantName() != profile.tenantName() {
+			return fmt.Errorf("profile %s belongs to another tenant", name)
+		}
+	}
+	return nil
+}
+
+type tenantKey struct{}
+
+// withTenant returns ctx for a client bound to the tenant.
+func withTenant(ctx context.Context, tenant string) context.Context {
+	if tenant == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// contextTenant returns the tenant the client of ctx is bound to, "" if it
+// may see every profile.
+func contextTenant(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// visible reports whether a client bound to tenant may see the profile.
+func visible(tenant, profile string) bool {
+	return tenant == "" || profiles[profile].tenantName() == tenant
+}
+
+// serverWide refuses requests of clients bound to a tenant, for the
+// endpoints that affect every tenant.
+func serverWide(handler func(w http.ResponseWriter, r *http.Request) (any, error)) func(w http.ResponseWriter, r *http.Request) (any, error) {
+	return func(w http.ResponseWriter, r *http.Request) (any, error) {
+		if tenant := contextTenant(r.Context()); tenant != "" {
+			return nil, apiError{http.StatusForbidden, "a token of tenant " + tenant + " cannot change the whole server"}
+		}
+		return handler(w, r)
+	}
+}
+
+// forTenant leaves only what the client bound to tenant may see in s.
+func (s *serverStatus) forTenant(tenant string) {
+	if tenant == "" {
+		return
+	}
+	s.PausedProfiles = slices.DeleteFunc(s.PausedProfiles, func(name string) bool { return !visible(tenant, name) })
+	s.Queues = slices.DeleteFunc(s.Queues, func(q queueStatus) bool { return !visible(tenant, q.Profile) })
+	s.Uploads = slices.DeleteFunc(s.Uploads, func(u inflightStatus) bool { return !visible(tenant, u.Profile) })
+	s.Failures = slices.DeleteFunc(s.Failures, func(f failureStatus) bool { return !visible(tenant, f.Profile) })
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// throttleChunk is the most a throttled reader hands out per Read call, so
+// that the limiter can pace uploads smoothly.
+const throttleChunk = 32 << 10
+
+// rateLimiter is a token bucket refilled at a fixed number of bytes per
+// second and holding at most one second worth of tokens.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// globalBandwidth is the -bandwidth-limit, nil without one. It can be
+// changed at runtime, see tuning.go.
+var globalBandwidth atomic.Pointer[rateLimiter]
+
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes may be sent.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+
+	// Take the tokens now, going into debt if needed, and sleep until the
+	// debt would have been paid back. Later callers queue up behind it.
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// throttledReader paces reads through one or more rate limiters. Seek is
+// passed through so that the SDK can still rewind bodies on retry.
+type throttledReader struct {
+	r        io.Reader
+	limiters []*rateLimiter
+}
+
+// throttle wraps r with the global and the profile's bandwidth limits, or
+// returns it unchanged when no limit applies. The limiters are independent:
+// a profile capped at 10MB/s leaves the rest of the global budget to others.
+func throttle(r io.ReadSeeker, profile Profile) io.ReadSeeker {
+	limiters := bandwidthLimiters(profile)
+	if len(limiters) == 0 {
+		return r
+	}
+	return &throttledReader{r: r, limiters: limiters}
+}
+
+// throttleReader is throttle for readers that cannot seek, such as download
+// bodies.
+func throttleReader(r io.Reader, profile Profile) io.Reader {
+	limiters := bandwidthLimiters(profile)
+	if len(limiters) == 0 {
+		return r
+	}
+	return &throttledReader{r: r, limiters: limiters}
+}
+
+func bandwidthLimiters(profile Profile) []*rateLimiter {
+	var limiters []*rateLimiter
+	if profile.Bandwidth != nil {
+		limiters = append(limiters, profile.Bandwidth)
+	}
+	if l := globalBandwidth.Load(); l != nil {
+		limiters = append(limiters, l)
+	}
+	return limiters
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limiters {
+		l.wait(n)
+	}
+	return n, err
+}
+
+func (t *throttledReader) Seek(offset int64, whence int) (int64, error) {
+	seeker, ok := t.r.(io.Seeker)
+	if !ok {
+		return 0, errors.New("throttled reader cannot seek")
+	}
+	return seeker.Seek(offset, whence)
+}
+
+// parseBandwidth parses rates such as "50MB/s" or "512KB" into bytes per
+// second.
+func parseBandwidth(value string) (int64, error) {
+	rate, err := parseByteSize(strings.TrimSuffix(strings.TrimSpace(value), "/s"))
+	if err != nil {
+		return 0, err
+	}
+	if rate == 0 {
+		return 0, fmt.Errorf("bandwidth limit must be greater than zero")
+	}
+	return rate, nil
+}
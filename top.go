@@ -0,0 +1,269 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// flood top shows the state of a server started with -admin-addr, which
+// serves it as JSON on /status:
+//
+//	flood top [-addr localhost:8081] [-interval 2s] [-token-file path]
+//
+// -addr may be a URL such as https://flood.example.com:8081 for a listener
+// with TLS. If the server requires a token, it is read from -token-file or
+// FLOOD_ADMIN_TOKEN and needs the read scope.
+//
+// The view lists the files waiting per profile and bucket, the uploads in
+// flight with their progress, throughput and retry timers, and the most
+// recent failures.
+type serverStatus struct {
+	Time           time.Time        `json:"time"`
+	Hostname       string           `json:"hostname"`
+	Paused         bool             `json:"paused"`
+	PausedProfiles []string         `json:"paused_profiles,omitempty"`
+	Queues         []queueStatus    `json:"queues"`
+	Uploads        []inflightStatus `json:"uploads"`
+	Failures       []failureStatus  `json:"failures"`
+}
+
+type queueStatus struct {
+	Profile string `json:"profile"`
+	Bucket  string `json:"bucket"`
+	Waiting int    `json:"waiting"` // files in processing
+	Queued  int    `json:"queued"`  // handed to the upload workers
+}
+
+type failureStatus struct {
+	statusRecord
+	Error string `json:"error,omitempty"`
+}
+
+// topFailures is the number of recent failures in /status.
+const topFailures = 10
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status := serverStatus{
+		Time:           time.Now(),
+		Hostname:       hostname,
+		Paused:         isPaused(),
+		PausedProfiles: pausedProfiles(),
+		Queues:         queueStatuses(),
+		Uploads:        inflightUploads(),
+	}
+	failures, err := recentFailures()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status.Failures = failures
+	status.forTenant(contextTenant(r.Context()))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func queueStatuses() []queueStatus {
+	counts := make(map[[2]string]*queueStatus)
+	entry := func(profile, bucket string) *queueStatus {
+		s, ok := counts[[2]string{profile, bucket}]
+		if !ok {
+			s = &queueStatus{Profile: profile, Bucket: bucket}
+			counts[[2]string{profile, bucket}] = s
+		}
+		return s
+	}
+	bucketOf := func(profile, path string) string {
+		rel, err := filepath.Rel(filepath.Join(serverDir, "processing", profile), path)
+		if err != nil {
+			return ""
+		}
+		bucket, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+		return bucket
+	}
+
+	for name := range profiles {
+		root := filepath.Join(serverDir, "processing", name)
+		filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() && !isSidecar(path) {
+				if bucket := bucketOf(name, path); bucket != "" {
+					entry(name, bucket).Waiting++
+				}
+			}
+			return nil
+		})
+		if q, ok := queues[name]; ok {
+			q.mu.Lock()
+			for path := range q.pending {
+				if bucket := bucketOf(name, path); bucket != "" {
+					entry(name, bucket).Queued++
+				}
+			}
+			q.mu.Unlock()
+		}
+	}
+
+	var statuses []queueStatus
+	for _, s := range counts {
+		statuses = append(statuses, *s)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Profile != statuses[j].Profile {
+			return statuses[i].Profile < statuses[j].Profile
+		}
+		return statuses[i].Bucket < statuses[j].Bucket
+	})
+	return statuses
+}
+
+func recentFailures() ([]failureStatus, error) {
+	rows, err := db.Query(fmt.Sprintf(`SELECT id, profile, bucket, filepath, COALESCE(object_key, ''), COALESCE(operation, 'upload'), upload_outcome, retries, last_retry,
+		(SELECT error_message FROM retry_attempts a WHERE a.record_id = r.id ORDER BY attempt DESC LIMIT 1)
+		FROM file_records r WHERE upload_outcome IN ('failure', 'conflict') ORDER BY id DESC LIMIT %d`, topFailures))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var failures []failureStatus
+	for rows.Next() {
+		var (
+			f       failureStatus
+			retries sql.NullInt64
+			at      sql.NullTime
+			message sql.NullString
+		)
+		if err := rows.Scan(&f.ID, &f.Profile, &f.Bucket, &f.Path, &f.Key, &f.Operation, &f.Outcome, &retries, &at, &message); err != nil {
+			return nil, err
+		}
+		f.Retries = int(retries.Int64)
+		if at.Valid {
+			f.Time = &at.Time
+		}
+		f.Error = message.String
+		failures = append(failures, f)
+	}
+	return failures, rows.Err()
+}
+
+func runTopMode(args []string) {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8081", "-admin-addr of the server to show")
+	interval := fs.Duration("interval", 2*time.Second, "Refresh interval")
+	tokenFile := fs.String("token-file", "", "File holding a token with the read scope (default $FLOOD_ADMIN_TOKEN)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] top [top flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 || *interval <= 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	token, err := readSecret(*tokenFile, "FLOOD_ADMIN_TOKEN")
+	if err != nil {
+		log.Fatal(err)
+	}
+	url := *addr + "/status"
+	if !strings.Contains(*addr, "://") {
+		url = "http://" + url
+	}
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	for ; ; time.Sleep(*interval) {
+		var status serverStatus
+		resp, err := client.Do(req)
+		if err == nil {
+			if resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("unexpected response %s", resp.Status)
+			} else {
+				err = json.NewDecoder(resp.Body).Decode(&status)
+			}
+			resp.Body.Close()
+		}
+		// Move to the top left and clear the screen
+		fmt.Print("\x1b[H\x1b[2J")
+		if err != nil {
+			fmt.Printf("flood top: %s: %v\n", url, err)
+			continue
+		}
+		printTop(status)
+	}
+}
+
+func printTop(s serverStatus) {
+	state := "running"
+	if s.Paused {
+		state = "paused"
+	}
+	if len(s.PausedProfiles) > 0 && !s.Paused {
+		state += ", paused: " + strings.Join(s.PausedProfiles, ", ")
+	}
+	fmt.Printf("flood on %s (%s) at %s\n\n", s.Hostname, state, s.Time.Local().Format("15:04:05"))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tBUCKET\tWAITING\tQUEUED")
+	for _, q := range s.Queues {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", q.Profile, q.Bucket, q.Waiting, q.Queued)
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROFILE\tBUCKET\tKEY\tPROGRESS\tRATE\tATTEMPT\tSTATE")
+	for _, u := range s.Uploads {
+		progress := formatBytes(u.Sent)
+		if u.Size > 0 {
+			progress = fmt.Sprintf("%3.0f%% of %s", 100*float64(u.Sent)/float64(u.Size), formatBytes(u.Size))
+		}
+		state := "uploading"
+		if u.RetryAt != nil {
+			state = fmt.Sprintf("retry in %s", max(0, time.Until(*u.RetryAt)).Round(time.Second))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s/s\t%d\t%s\n", u.Profile, u.Bucket, u.Key, progress, formatBytes(u.Throughput), u.Attempt+1, state)
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RECORD\tTIME\tPROFILE\tBUCKET\tOUTCOME\tPATH\tERROR")
+	for _, f := range s.Failures {
+		when := "-"
+		if f.Time != nil {
+			when = f.Time.Local().Format("2006-01-02 15:04:05")
+		}
+		message := f.Error
+		if len(message) > 80 {
+			message = message[:77] + "..."
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", f.ID, when, f.Profile, f.Bucket, f.Outcome, f.Path, message)
+	}
+	w.Flush()
+}
+
+// formatBytes formats a byte count with a binary unit, as in 12.3MiB.
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// transfer copies objects from one profile to another, e.g. to migrate from
+// Amazon S3 to R2:
+//
+//	flood transfer s3://amazon/bucket/key s3://cloudflare/bucket/key
+//	flood transfer s3://amazon/bucket/prefix/ s3://cloudflare/bucket/prefix/
+//
+// A source key that is empty or ends in a slash copies every object below
+// it. Data is streamed through memory without touching the local disk:
+// objects up to the destination's part size in one piece, larger objects
+// part by part with ranged reads. Each object is retried as a whole and
+// recorded in file_records with operation "transfer".
+type transfer struct {
+	src, dst             Profile
+	srcBucket, dstBucket string
+	srcClient, dstClient *s3.Client
+}
+
+type transferJob struct {
+	srcKey string
+	dstKey string
+}
+
+func runTransferMode(args []string) {
+	fs := flag.NewFlagSet("transfer", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: flood [flags] transfer s3://profile/bucket/key s3://profile/bucket/key")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	src, srcBucket, srcKey, err := parseS3URI(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	dst, dstBucket, dstKey, err := parseS3URI(fs.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range []Profile{src, dst} {
+		if p.Destination != nil {
+			log.Fatalf("transfer is not supported by provider %s", p.Provider)
+		}
+	}
+	if err := validateBucketExists(dst, dstBucket); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	t := &transfer{
+		src: src, dst: dst,
+		srcBucket: srcBucket, dstBucket: dstBucket,
+		srcClient: newS3Client(src), dstClient: newS3Client(dst),
+	}
+
+	if srcKey != "" && !strings.HasSuffix(srcKey, "/") {
+		if dstKey == "" || strings.HasSuffix(dstKey, "/") {
+			dstKey += path.Base(srcKey)
+		}
+		if t.transferWithRetry(transferJob{srcKey: srcKey, dstKey: dstKey}) != "success" {
+			os.Exit(1)
+		}
+		return
+	}
+
+	jobs := make(chan transferJob)
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		counts = make(map[string]int)
+	)
+	for i := 0; i < dst.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				outcome := t.transferWithRetry(job)
+				mu.Lock()
+				counts[outcome]++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(t.srcClient, &s3.ListObjectsV2Input{
+		Bucket: aws.String(srcBucket),
+		Prefix: optionalString(srcKey),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Printf("Failed to list %s: %v", fs.Arg(0), err)
+			mu.Lock()
+			counts["failure"]++
+			mu.Unlock()
+			break
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if strings.HasSuffix(key, "/") {
+				continue // directory marker
+			}
+			jobs <- transferJob{srcKey: key, dstKey: dstKey + strings.TrimPrefix(key, srcKey)}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	log.Printf("Transfer finished: %d copied, %d conflicts, %d failed", counts["success"], counts["conflict"], counts["failure"])
+	if counts["failure"] > 0 || counts["conflict"] > 0 {
+		os.Exit(1)
+	}
+}
+
+// transferWithRetry copies one object with the retry policy of uploads and
+// returns the outcome recorded for it.
+func (t *transfer) transferWithRetry(job transferJob) string {
+	rec := fileRecord{
+		Path:         fmt.Sprintf("s3://%s/%s/%s", t.src.Name, t.srcBucket, job.srcKey),
+		Profile:      t.dst,
+		Bucket:       t.dstBucket,
+		OriginalPath: job.srcKey,
+		Key:          job.dstKey,
+		Operation:    "transfer",
+	}
+	for {
+		log.Printf("Transferring %s to s3://%s/%s/%s. Retry attempt: %d", rec.Path, t.dst.Name, t.dstBucket, job.dstKey, rec.Retries)
+		release := acquireUploadSlot(t.dst)
+		var err error
+		rec.Checksum, err = t.copyObject(job)
+		release()
+		if err == nil {
+			logRetry(rec, "success")
+			return "success"
+		}
+
+		log.Printf("Error transferring %s: %v", rec.Path, err)
+		if errors.Is(err, errConflict) {
+			rec.failedAttempt(err, false, 0)
+			logRetry(rec, "conflict")
+			return "conflict"
+		}
+		if !isTransientError(err) || rec.Retries >= retryLimit() {
+			rec.failedAttempt(err, isTransientError(err), 0)
+			logRetry(rec, "failure")
+			return "failure"
+		}
+		delay := retryDelay(rec.Retries)
+		rec.failedAttempt(err, true, delay)
+		time.Sleep(delay)
+		rec.Retries++
+	}
+}
+
+// copyObject copies a single object and returns its checksum, if the
+// destination profile uses a checksum algorithm.
+func (t *transfer) copyObject(job transferJob) (string, error) {
+	head, err := t.srcClient.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(t.srcBucket),
+		Key:    aws.String(job.srcKey),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get source object: %w", err)
+	}
+	size := aws.ToInt64(head.ContentLength)
+	meta := &sidecarMetadata{
+		Metadata:           head.Metadata,
+		CacheControl:       aws.ToString(head.CacheControl),
+		ContentDisposition: aws.ToString(head.ContentDisposition),
+		ContentEncoding:    aws.ToString(head.ContentEncoding),
+	}
+
+	partSize := effectivePartSize(t.dst.PartSize, size)
+	if size > partSize {
+		return t.copyMultipart(job, head, meta, size, partSize)
+	}
+
+	reserved := globalMemory.reserve(size)
+	defer globalMemory.release(reserved)
+	data, err := t.readRange(job.srcKey, 0, size)
+	if err != nil {
+		return "", err
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(t.dstBucket),
+		Key:           aws.String(job.dstKey),
+		Body:          throttle(bytes.NewReader(data), t.dst),
+		ContentLength: aws.Int64(size),
+		ContentType:   head.ContentType,
+	}
+	applyPutObjectOptions(input, t.dst, meta)
+	out, err := t.dstClient.PutObject(context.TODO(), input)
+	if err != nil {
+		if httpStatusCode(err) == http.StatusPreconditionFailed {
+			return "", fmt.Errorf("%w: %s", errConflict, job.dstKey)
+		}
+		return "", fmt.Errorf("failed to upload object: %w", err)
+	}
+
+	srcETag := strings.Trim(aws.ToString(head.ETag), `"`)
+	dstETag := strings.Trim(aws.ToString(out.ETag), `"`)
+	if etagIsContentHash(t.src, false) && etagIsContentHash(t.dst, false) && !strings.Contains(srcETag, "-") && !strings.EqualFold(srcETag, dstETag) {
+		return "", fmt.Errorf("%w for %s: source %s, destination %s", errETagMismatch, job.srcKey, srcETag, dstETag)
+	}
+	return checksumValue(input.ChecksumAlgorithm, out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256), nil
+}
+
+// readRange reads length bytes of a source object starting at offset.
+func (t *transfer) readRange(key string, offset, length int64) ([]byte, error) {
+	data := make([]byte, length)
+	if length == 0 {
+		return data, nil
+	}
+	out, err := t.srcClient.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(t.srcBucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get source object: %w", err)
+	}
+	defer out.Body.Close()
+	if _, err := io.ReadFull(throttleReader(out.Body, t.src), data); err != nil {
+		return nil, fmt.Errorf("failed to read source object: %w", err)
+	}
+	return data, nil
+}
+
+// copyMultipart copies a large object part by part. Every part is read from
+// the source with a ranged GET, so at most PartConcurrency parts are held in
+// memory.
+func (t *transfer) copyMultipart(job transferJob, head *s3.HeadObjectOutput, meta *sidecarMetadata, size, partSize int64) (string, error) {
+	partCount := int((size + partSize - 1) / partSize)
+	concurrency := min(t.dst.PartConcurrency, partCount)
+	reserved := globalMemory.reserve(int64(concurrency) * partSize)
+	defer globalMemory.release(reserved)
+
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(t.dstBucket),
+		Key:         aws.String(job.dstKey),
+		ContentType: head.ContentType,
+	}
+	applyMultipartUploadOptions(input, t.dst, meta)
+	created, err := t.dstClient.CreateMultipartUpload(context.TODO(), input)
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	algorithm := input.ChecksumAlgorithm
+	log.Printf("Starting multipart transfer of %s (%d parts of %d bytes, concurrency %d)", job.dstKey, partCount, partSize, concurrency)
+
+	partNumbers := make(chan int32)
+	var (
+		mu        sync.Mutex
+		completed []types.CompletedPart
+		firstErr  error
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range partNumbers {
+				offset := int64(partNumber-1) * partSize
+				length := min(partSize, size-offset)
+				data, err := t.readRange(job.srcKey, offset, length)
+				var out *s3.UploadPartOutput
+				if err == nil {
+					out, err = t.dstClient.UploadPart(context.TODO(), &s3.UploadPartInput{
+						Bucket:            aws.String(t.dstBucket),
+						Key:               aws.String(job.dstKey),
+						UploadId:          created.UploadId,
+						PartNumber:        aws.Int32(partNumber),
+						Body:              throttle(bytes.NewReader(data), t.dst),
+						ContentLength:     aws.Int64(length),
+						ChecksumAlgorithm: algorithm,
+					})
+				}
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to transfer part %d: %w", partNumber, err)
+					}
+				} else {
+					checksum := checksumValue(algorithm, out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256)
+					completed = append(completed, completedPart(partNumber, aws.ToString(out.ETag), algorithm, checksum))
+					uploaderLog.Debug(fmt.Sprintf("Transferred part %d of %d of %s", partNumber, partCount, job.dstKey))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for partNumber := int32(1); int(partNumber) <= partCount; partNumber++ {
+		mu.Lock()
+		failed := firstErr != nil
+		mu.Unlock()
+		if failed {
+			break
+		}
+		partNumbers <- partNumber
+	}
+	close(partNumbers)
+	wg.Wait()
+
+	if firstErr != nil {
+		abortMultipart(t.dstClient, t.dstBucket, job.dstKey, created.UploadId)
+		return "", firstErr
+	}
+
+	sort.Slice(completed, func(i, j int) bool {
+		return *completed[i].PartNumber < *completed[j].PartNumber
+	})
+	complete := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(t.dstBucket),
+		Key:             aws.String(job.dstKey),
+		UploadId:        created.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	}
+	if t.dst.IfNoneMatch {
+		complete.IfNoneMatch = aws.String("*")
+	}
+	out, err := t.dstClient.CompleteMultipartUpload(context.TODO(), complete)
+	if err != nil {
+		abortMultipart(t.dstClient, t.dstBucket, job.dstKey, created.UploadId)
+		if httpStatusCode(err) == http.StatusPreconditionFailed {
+			return "", fmt.Errorf("%w: %s", errConflict, job.dstKey)
+		}
+		return "", fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	return checksumValue(algorithm, out.ChecksumCRC32, out.ChecksumCRC32C, out.ChecksumSHA1, out.ChecksumSHA256), nil
+}
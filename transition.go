@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Moving a file out of processing and recording the outcome are two steps
+// that a crash can separate. settleFile therefore first writes the intended
+// move with the record to file_transitions, then moves the file, then
+// writes the record, which refers to the transition, and finally deletes
+// the transition. On startup reconcileTransitions completes or discards
+// the transitions left behind:
+//
+//   - a record refers to it: only the deletion was lost
+//   - the file is at the target: the record is written from the saved one
+//   - the file is still at the source: it is processed again
+//
+// Moves that record no outcome of their own, such as those of bundle
+// members, tombstones, re-drives and drains, go through moveFile, whose
+// transitions have no record; reconcileTransitions completes them.
+type fileTransition struct {
+	id      int64
+	profile string
+	source  string
+	target  string
+	outcome string
+	record  string
+}
+
+// completeFile moves the file of rec to completed and records the outcome.
+func completeFile(rec fileRecord, outcome string) {
+	if settleFile(rec, outcome, strings.Replace(rec.Path, "processing", "completed", 1)) {
+		notifyFile("completed", rec, outcome, nil)
+	}
+}
+
+// failFile moves the file of rec to failed and records the outcome.
+func failFile(rec fileRecord, outcome string) {
+	if !settleFile(rec, outcome, strings.Replace(rec.Path, "processing", "failed", 1)) {
+		return
+	}
+	var err error
+	if n := len(rec.Attempts); n > 0 {
+		err = errors.New(rec.Attempts[n-1].Message)
+	}
+	notifyFile("failed", rec, outcome, err)
+}
+
+// settleFile moves the file of rec, and its sidecar, to target and records
+// the outcome, in a way that reconcileTransitions can complete. If the file
+// cannot be moved, nothing is recorded: it stays in processing and is
+// processed again.
+func settleFile(rec fileRecord, outcome, target string) bool {
+	data, err := json.Marshal(rec)
+	if err == nil {
+		rec.Transition, err = db.insert(
+			"INSERT INTO file_transitions(profile, source, target, outcome, record, created) VALUES (?, ?, ?, ?, ?, ?)",
+			rec.Profile.Name, rec.Path, target, outcome, string(data), time.Now(),
+		)
+	}
+	if err != nil {
+		log.Printf("Failed to record transition of %s to %s: %v", rec.Path, target, err)
+	}
+
+	os.MkdirAll(filepath.Dir(target), 0755)
+	if err := os.Rename(rec.Path, target); err != nil {
+		log.Printf("Failed to move %s to %s, leaving its outcome %s unrecorded: %v", rec.Path, target, outcome, err)
+		// The transition stays for reconcileTransitions, which finds the
+		// file at its source.
+		return false
+	}
+	moveSidecar(rec.Path, target)
+
+	rec.logger().Info("Moved to "+target, "state", outcome)
+	logRetry(rec, outcome)
+	publishEvent(outcome, rec)
+	if rec.Transition != 0 {
+		if _, err := db.Exec("DELETE FROM file_transitions WHERE id = ?", rec.Transition); err != nil {
+			log.Printf("Failed to confirm transition of %s: %v", rec.Path, err)
+		}
+	}
+	return true
+}
+
+// moveFile moves a file, and its sidecar first, to target in a way that
+// reconcileTransitions can complete. The sidecar goes first so that a file
+// moved to incoming never arrives without it.
+func moveFile(source, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	id, err := db.insert(
+		"INSERT INTO file_transitions(profile, source, target, outcome, record, created) VALUES (?, ?, ?, ?, ?, ?)",
+		"", source, target, "", "", time.Now(),
+	)
+	if err != nil {
+		log.Printf("Failed to record transition of %s to %s: %v", source, target, err)
+	}
+	moveSidecar(source, target)
+	err = os.Rename(source, target)
+	if err != nil {
+		// Nothing to complete
+		moveSidecar(target, source)
+	}
+	if id != 0 {
+		if _, err := db.Exec("DELETE FROM file_transitions WHERE id = ?", id); err != nil {
+			log.Printf("Failed to confirm transition of %s: %v", source, err)
+		}
+	}
+	return err
+}
+
+// reconcileTransitions repairs the transitions that a crash interrupted. The
+// transitions of files that another live instance holds are its own to
+// finish.
+func reconcileTransitions() {
+	rows, err := db.Query("SELECT id, profile, source, target, outcome, record FROM file_transitions ORDER BY id")
+	if err != nil {
+		log.Fatalf("Failed to read file transitions: %v", err)
+	}
+	var transitions []fileTransition
+	for rows.Next() {
+		var t fileTransition
+		if err := rows.Scan(&t.id, &t.profile, &t.source, &t.target, &t.outcome, &t.record); err != nil {
+			log.Fatalf("Failed to read file transitions: %v", err)
+		}
+		transitions = append(transitions, t)
+	}
+	rows.Close()
+
+	for _, t := range transitions {
+		if leasedElsewhere(t.source) {
+			continue
+		}
+		reconcileTransition(t)
+		if _, err := db.Exec("DELETE FROM file_transitions WHERE id = ?", t.id); err != nil {
+			log.Fatalf("Failed to delete file transition: %v", err)
+		}
+	}
+}
+
+func reconcileTransition(t fileTransition) {
+	if t.record == "" {
+		reconcileMove(t)
+		return
+	}
+	var recorded int
+	if err := db.QueryRow("SELECT COUNT(*) FROM file_records WHERE transition_id = ?", t.id).Scan(&recorded); err != nil {
+		log.Fatalf("Failed to look up record of transition %d: %v", t.id, err)
+	}
+	if recorded > 0 {
+		return
+	}
+
+	_, sourceErr := os.Stat(t.source)
+	_, targetErr := os.Stat(t.target)
+	switch {
+	case targetErr == nil:
+		var rec fileRecord
+		if err := json.Unmarshal([]byte(t.record), &rec); err != nil {
+			log.Printf("Cannot recover the record of %s: %v", t.target, err)
+			return
+		}
+		rec.Profile = Profile{Name: t.profile}
+		if profile, ok := profiles[t.profile]; ok {
+			rec.Profile = profile.forBucket(rec.Bucket)
+		}
+		moveSidecar(t.source, t.target)
+		rec.Meta, _ = loadSidecar(t.target)
+		rec.Transition = t.id
+		logRetry(rec, t.outcome)
+		log.Printf("Recorded outcome %s of %s, which was moved before an interruption", t.outcome, t.target)
+	case sourceErr == nil:
+		log.Printf("Move of %s to %s was interrupted; it will be processed again", t.source, t.target)
+	case errors.Is(sourceErr, os.ErrNotExist) && errors.Is(targetErr, os.ErrNotExist):
+		log.Printf("Neither %s nor %s exists; discarding its interrupted transition", t.source, t.target)
+	default:
+		log.Printf("Cannot reconcile the move of %s to %s: %v", t.source, t.target, targetErr)
+	}
+}
+
+// reconcileMove completes an interrupted moveFile.
+func reconcileMove(t fileTransition) {
+	if _, err := os.Stat(t.source); err == nil {
+		if err := os.Rename(t.source, t.target); err != nil {
+			log.Printf("Cannot complete the move of %s to %s: %v", t.source, t.target, err)
+			return
+		}
+		log.Printf("Completed the move of %s to %s, which was interrupted", t.source, t.target)
+	}
+	moveSidecar(t.source, t.target)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The global tuning parameters can be changed while the server runs,
+// through the admin API or flood ctl:
+//
+//	flood ctl tune                                   show the current values
+//	flood ctl tune max-retries=5 bandwidth-limit=20MB/s
+//
+// The parameters are max-concurrent-uploads, bandwidth-limit (off for no
+// limit), max-retries and retry-backoff, as the flags of the same names.
+// Those of a profile, such as workers, max_concurrency and bandwidth, are
+// changed in the credentials file followed by a reload (see control.go).
+// New values apply to uploads and retries that start afterwards. They last
+// until the server stops; the flags set them at the next start.
+var (
+	maxRetries     atomic.Int64
+	initialBackoff atomic.Int64 // nanoseconds
+
+	tuneMu sync.Mutex // serializes changes
+)
+
+// retryLimit is the number of retries before a file is given up on.
+func retryLimit() int {
+	return int(maxRetries.Load())
+}
+
+// retryBackoff is the delay before the first retry.
+func retryBackoff() time.Duration {
+	return time.Duration(initialBackoff.Load())
+}
+
+// tuning holds the current values in the format of the flags.
+func tuning() map[string]string {
+	bandwidth := "off"
+	if l := globalBandwidth.Load(); l != nil {
+		bandwidth = formatBytes(int64(l.rate)) + "/s"
+	}
+	globalUploads.mu.Lock()
+	uploads := globalUploads.limit
+	globalUploads.mu.Unlock()
+	return map[string]string{
+		"max-concurrent-uploads": fmt.Sprint(uploads),
+		"bandwidth-limit":        bandwidth,
+		"max-retries":            fmt.Sprint(retryLimit()),
+		"retry-backoff":          retryBackoff().String(),
+	}
+}
+
+// tune validates all changes and then applies them.
+func tune(changes map[string]string) error {
+	tuneMu.Lock()
+	defer tuneMu.Unlock()
+	var apply []func()
+	for name, value := range changes {
+		value = strings.TrimSpace(value)
+		switch name {
+		case "max-concurrent-uploads":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s %q: must be a number, 0 for unlimited", name, value)
+			}
+			apply = append(apply, func() { globalUploads.setLimit(n) })
+		case "bandwidth-limit":
+			if value == "off" || value == "" {
+				apply = append(apply, func() { globalBandwidth.Store(nil) })
+				continue
+			}
+			rate, err := parseBandwidth(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q: %v", name, value, err)
+			}
+			apply = append(apply, func() { globalBandwidth.Store(newRateLimiter(rate)) })
+		case "max-retries":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s %q: must be a number", name, value)
+			}
+			apply = append(apply, func() { maxRetries.Store(n) })
+		case "retry-backoff":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid %s %q: must be a positive duration such as 30s", name, value)
+			}
+			apply = append(apply, func() { initialBackoff.Store(int64(d)) })
+		default:
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	for _, f := range apply {
+		f()
+	}
+	for name, value := range changes {
+		log.Printf("Changed %s to %s", name, value)
+	}
+	return nil
+}
+
+func apiTuning(w http.ResponseWriter, r *http.Request) (any, error) {
+	if r.Method == http.MethodPatch {
+		var changes map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&changes); err != nil {
+			return nil, badRequest("invalid body: %v", err)
+		}
+		if err := tune(changes); err != nil {
+			return nil, badRequest("%v", err)
+		}
+	}
+	return tuning(), nil
+}
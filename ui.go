@@ -0,0 +1,19 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+// The admin listener serves a web UI on /ui/ with the backlog, the uploads
+// in flight, and the recent failures with their history and a retry button.
+// The page reads /status; the history and retries go through the admin API.
+// It asks for a token when the server requires one (see auth.go).
+//
+//go:embed ui
+var uiFiles embed.FS
+
+func registerUI(mux *http.ServeMux) {
+	mux.Handle("/ui/", http.FileServerFS(uiFiles))
+	mux.Handle("/{$}", http.RedirectHandler("/ui/", http.StatusFound))
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// errETagMismatch is returned when the ETag of an uploaded object does not
+// match the local content. It is treated as transient so that the file is
+// uploaded again.
+var errETagMismatch = errors.New("ETag mismatch")
+
+// etagIsContentHash reports whether the ETag returned by the profile's
+// provider is derived from the MD5 of the content and can thus be compared
+// with the local file. SSE-KMS objects and Cloudflare R2 multipart uploads
+// get opaque ETags.
+func etagIsContentHash(profile Profile, multipart bool) bool {
+	if profile.SSE == types.ServerSideEncryptionAwsKms {
+		return false
+	}
+	if multipart && profile.Provider == "cloudflare" {
+		return false
+	}
+	return true
+}
+
+// expectedETag computes the ETag S3 assigns to the content of f: the MD5 of
+// the data for a single-part upload, and for a multipart upload the MD5 of
+// the concatenated part MD5s followed by "-" and the number of parts.
+func expectedETag(f *os.File, size, partSize int64, multipart bool) (string, error) {
+	buf := newCopyBuffer()
+	if !multipart {
+		h := md5.New()
+		if _, err := io.CopyBuffer(h, io.NewSectionReader(f, 0, size), buf); err != nil {
+			return "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	var (
+		sums  []byte
+		parts int
+	)
+	for offset := int64(0); offset < size; offset += partSize {
+		length := min(partSize, size-offset)
+		h := md5.New()
+		if _, err := io.CopyBuffer(h, io.NewSectionReader(f, offset, length), buf); err != nil {
+			return "", err
+		}
+		sums = append(sums, h.Sum(nil)...)
+		parts++
+	}
+	sum := md5.Sum(sums)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), parts), nil
+}
+
+// verifyETag compares the ETag returned for an upload with the local file.
+// Mismatches are errors when the provider's ETags are content hashes and
+// informational otherwise.
+func verifyETag(f *os.File, size, partSize int64, multipart bool, etag string, profile Profile) error {
+	etag = strings.Trim(etag, `"`)
+	if etag == "" {
+		log.Printf("No ETag returned for %s, skipping verification", f.Name())
+		return nil
+	}
+
+	expected, err := expectedETag(f, size, partSize, multipart)
+	if err != nil {
+		return fmt.Errorf("failed to compute ETag of %s: %w", f.Name(), err)
+	}
+	if strings.EqualFold(etag, expected) {
+		log.Printf("Verified ETag %s of %s", etag, f.Name())
+		return nil
+	}
+
+	if !etagIsContentHash(profile, multipart) {
+		log.Printf("ETag %s of %s differs from local %s (informational only for provider %s)", etag, f.Name(), expected, profile.Provider)
+		return nil
+	}
+	return fmt.Errorf("%w for %s: remote %s, local %s", errETagMismatch, f.Name(), etag, expected)
+}
+
+// remoteMatches checks whether the object at key already exists with the
+// same size and, where the ETag is a content hash, the same checksum as the
+// local file. It returns errNotImplemented if the provider does not support
+// HEAD requests.
+func remoteMatches(profile Profile, bucket, key, path string) (bool, error) {
+	client := newS3Client(profile)
+	head, err := client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		switch {
+		case errors.As(err, &notFound) || httpStatusCode(err) == http.StatusNotFound:
+			return false, nil
+		case httpStatusCode(err) == http.StatusNotImplemented:
+			return false, errNotImplemented
+		}
+		return false, err
+	}
+	return contentMatches(profile, key, path, aws.ToInt64(head.ContentLength), aws.ToString(head.ETag))
+}
+
+// contentMatches compares the local file with the size and ETag of the
+// object at key, as reported by HEAD or an inventory.
+func contentMatches(profile Profile, key, path string, remoteSize int64, etag string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	size := info.Size()
+	if remoteSize != size {
+		log.Printf("Remote %s exists with size %d, local size is %d", key, remoteSize, size)
+		return false, nil
+	}
+
+	etag = strings.Trim(etag, `"`)
+	hash, parts, multipart := strings.Cut(etag, "-")
+	if !etagIsContentHash(profile, multipart) || len(hash) != 32 {
+		log.Printf("Remote %s exists with matching size; its ETag cannot be compared", key)
+		return true, nil
+	}
+
+	partSize := effectivePartSize(profile.PartSize, size)
+	if multipart {
+		// The part size used for the remote object is unknown; it can only
+		// be checked if it produced the same number of parts as ours.
+		n, _ := strconv.ParseInt(parts, 10, 64)
+		if n != (size+partSize-1)/partSize {
+			log.Printf("Remote %s exists with matching size; its part layout differs, ETag not compared", key)
+			return true, nil
+		}
+	}
+
+	expected, err := expectedETag(f, size, partSize, multipart)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(etag, expected), nil
+}
+
+// httpStatusCode returns the HTTP status code of an SDK error, or zero if
+// the error did not come with an HTTP response.
+func httpStatusCode(err error) int {
+	var re interface{ HTTPStatusCode() int }
+	if errors.As(err, &re) {
+		return re.HTTPStatusCode()
+	}
+	return 0
+}
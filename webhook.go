@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// A profile can notify an HTTP endpoint of terminal events of its files:
+//
+//	webhook_url = https://hooks.example/flood
+//	webhook_events = completed, failed        ; default: all events
+//	webhook_template = {"text": "{{.Event}}: {{.Key}} {{json .Error}}"}
+//	webhook_header_authorization = Bearer ...
+//
+// Events are completed (uploaded or already present), failed (moved to the
+// failed directory), cancelled (see cancel.go) and bucket_invalid (the
+// bucket failed validation before an upload). Without a template the body is the webhookEvent as JSON; the
+// template has the fields of webhookEvent and a json function for quoting.
+// Notifications are POSTed from a queue of their own and retried a few
+// times, so an unreachable endpoint never holds up uploads; when the queue
+// is full, notifications are dropped.
+type webhook struct {
+	url      string
+	events   []string
+	template *template.Template // nil for the JSON of the event
+	headers  http.Header
+}
+
+// webhookEvent is the payload of a notification.
+type webhookEvent struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Profile  string    `json:"profile"`
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key,omitempty"`
+	Path     string    `json:"path,omitempty"`
+	FileID   string    `json:"file_id,omitempty"`
+	Outcome  string    `json:"outcome,omitempty"`
+	Retries  int       `json:"retries"`
+	Error    string    `json:"error,omitempty"`
+	Tenant   string    `json:"tenant,omitempty"`
+}
+
+var webhookEvents = []string{"completed", "failed", "cancelled", "bucket_invalid"}
+
+const (
+	webhookQueueSize   = 256
+	webhookMaxAttempts = 5
+)
+
+type webhookDelivery struct {
+	hook  *webhook
+	event webhookEvent
+}
+
+var webhookQueue = make(chan webhookDelivery, webhookQueueSize)
+
+func init() {
+	go deliverWebhooks()
+}
+
+// parseWebhook returns the webhook of a profile, or nil if it has none.
+func parseWebhook(settings map[string]string) (*webhook, error) {
+	if settings["webhook_url"] == "" {
+		for key := range settings {
+			if strings.HasPrefix(key, "webhook_") {
+				return nil, fmt.Errorf("%s requires webhook_url", key)
+			}
+		}
+		return nil, nil
+	}
+	u, err := url.Parse(settings["webhook_url"])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid webhook_url %q: must be an http or https URL", settings["webhook_url"])
+	}
+	hook := &webhook{url: u.String(), events: webhookEvents, headers: make(http.Header)}
+
+	if value := settings["webhook_events"]; value != "" {
+		hook.events = nil
+		for _, event := range strings.Split(value, ",") {
+			event = strings.TrimSpace(event)
+			if !slices.Contains(webhookEvents, event) {
+				return nil, fmt.Errorf("invalid webhook_events: unknown event %q, must be one of %s", event, strings.Join(webhookEvents, ", "))
+			}
+			hook.events = append(hook.events, event)
+		}
+	}
+	if value := settings["webhook_template"]; value != "" {
+		tmpl, err := template.New("webhook_template").Option("missingkey=error").Funcs(template.FuncMap{
+			"json": func(v any) (string, error) {
+				data, err := json.Marshal(v)
+				return string(data), err
+			},
+		}).Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook_template: %w", err)
+		}
+		if err := tmpl.Execute(io.Discard, webhookEvent{}); err != nil {
+			return nil, fmt.Errorf("invalid webhook_template: %w", err)
+		}
+		hook.template = tmpl
+	}
+	for key, value := range settings {
+		if name, ok := strings.CutPrefix(key, "webhook_header_"); ok {
+			hook.headers.Set(name, value)
+		}
+	}
+	return hook, nil
+}
+
+// notifyFile queues the notification of an event of a file, if its profile
+// has a webhook for the event. err may be nil.
+func notifyFile(event string, rec fileRecord, outcome string, err error) {
+	hook := rec.Profile.Webhook
+	if hook == nil || !slices.Contains(hook.events, event) {
+		return
+	}
+	e := webhookEvent{
+		Event:    event,
+		Time:     time.Now(),
+		Hostname: hostname,
+		Profile:  rec.Profile.Name,
+		Bucket:   rec.Bucket,
+		Key:      rec.Key,
+		Path:     rec.Path,
+		FileID:   rec.ID,
+		Outcome:  outcome,
+		Retries:  rec.Retries,
+		Tenant:   rec.Profile.tenantName(),
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	select {
+	case webhookQueue <- webhookDelivery{hook: hook, event: e}:
+	default:
+		log.Printf("Webhook queue is full, dropping %s notification for %s", event, rec.Path)
+	}
+}
+
+func deliverWebhooks() {
+	for d := range webhookQueue {
+		for attempt := 0; ; attempt++ {
+			err := d.hook.post(d.event)
+			if err == nil {
+				break
+			}
+			if attempt+1 >= webhookMaxAttempts {
+				log.Printf("Failed to send %s notification for %s to %s, giving up: %v", d.event.Event, d.event.Path, d.hook.url, err)
+				break
+			}
+			log.Printf("Failed to send %s notification for %s to %s, retrying: %v", d.event.Event, d.event.Path, d.hook.url, err)
+			time.Sleep(retryDelay(attempt))
+		}
+	}
+}
+
+func (h *webhook) post(e webhookEvent) error {
+	var body bytes.Buffer
+	if h.template != nil {
+		if err := h.template.Execute(&body, e); err != nil {
+			return err
+		}
+	} else if err := json.NewEncoder(&body).Encode(e); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, h.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range h.headers {
+		req.Header[name] = values
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// uploadWindow is a daily period in local time during which a profile
+// uploads, configured as
+//
+//	upload_window = 22:00-06:00, 12:00-13:00
+//
+// A window whose end is before its start runs past midnight. Outside its
+// windows a profile keeps files in processing and the scanner is woken up
+// when the next window opens. Uploads that already started are finished.
+// -once ignores upload windows.
+type uploadWindow struct {
+	start, end int // minutes since midnight
+}
+
+func parseUploadWindows(value string) ([]uploadWindow, error) {
+	var windows []uploadWindow
+	for _, part := range strings.Split(value, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(part), "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid upload_window %q: expected HH:MM-HH:MM", part)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload_window %q: %w", part, err)
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload_window %q: %w", part, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("invalid upload_window %q: start and end are equal", part)
+		}
+		windows = append(windows, uploadWindow{start: start, end: end})
+	}
+	return windows, nil
+}
+
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day such as 22:00", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w uploadWindow) contains(minute int) bool {
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// inUploadWindow reports whether the profile may start uploads at t. A
+// profile without windows always may.
+func inUploadWindow(profile Profile, t time.Time) bool {
+	if len(profile.Windows) == 0 {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range profile.Windows {
+		if w.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextUploadWindow returns when the next of the profile's windows opens
+// after t.
+func nextUploadWindow(profile Profile, t time.Time) time.Time {
+	var next time.Time
+	for _, w := range profile.Windows {
+		for day := 0; day <= 1; day++ {
+			open := time.Date(t.Year(), t.Month(), t.Day()+day, w.start/60, w.start%60, 0, 0, time.Local)
+			if open.After(t) && (next.IsZero() || open.Before(next)) {
+				next = open
+			}
+		}
+	}
+	return next
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseUploadWindows(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []uploadWindow
+		wantErr bool
+	}{
+		{"22:00-06:00", []uploadWindow{{22 * 60, 6 * 60}}, false},
+		{"22:00-06:00, 12:00-13:30", []uploadWindow{{22 * 60, 6 * 60}, {12 * 60, 13*60 + 30}}, false},
+		{" 00:00 - 23:59 ", []uploadWindow{{0, 23*60 + 59}}, false},
+		{"22:00", nil, true},
+		{"22:00-22:00", nil, true},
+		{"25:00-06:00", nil, true},
+		{"22:00-6pm", nil, true},
+		{"22:00-06:00,", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUploadWindows(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseUploadWindows(%q) error = %v, want error %t", tt.value, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseUploadWindows(%q) = %v, want %v", tt.value, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseUploadWindows(%q) = %v, want %v", tt.value, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestInUploadWindow(t *testing.T) {
+	windows, err := parseUploadWindows("22:00-06:00, 12:00-13:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := Profile{Windows: windows}
+	tests := []struct {
+		clock string
+		want  bool
+	}{
+		{"21:59", false},
+		{"22:00", true},
+		{"23:30", true},
+		{"00:00", true},
+		{"05:59", true},
+		{"06:00", false},
+		{"12:00", true},
+		{"12:59", true},
+		{"13:00", false},
+	}
+	for _, tt := range tests {
+		clock, _ := time.Parse("15:04", tt.clock)
+		at := time.Date(2026, 10, 16, clock.Hour(), clock.Minute(), 0, 0, time.Local)
+		if got := inUploadWindow(profile, at); got != tt.want {
+			t.Errorf("inUploadWindow(%s) = %t, want %t", tt.clock, got, tt.want)
+		}
+	}
+	if !inUploadWindow(Profile{}, time.Now()) {
+		t.Error("a profile without windows must always upload")
+	}
+}
+
+func TestNextUploadWindow(t *testing.T) {
+	windows, err := parseUploadWindows("22:00-06:00, 12:00-13:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile := Profile{Windows: windows}
+	tests := []struct {
+		at, want time.Time
+	}{
+		{time.Date(2026, 10, 16, 7, 0, 0, 0, time.Local), time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local)},
+		{time.Date(2026, 10, 16, 14, 0, 0, 0, time.Local), time.Date(2026, 10, 16, 22, 0, 0, 0, time.Local)},
+		{time.Date(2026, 10, 16, 22, 0, 0, 0, time.Local), time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		if got := nextUploadWindow(profile, tt.at); !got.Equal(tt.want) {
+			t.Errorf("nextUploadWindow(%s) = %s, want %s", tt.at, got, tt.want)
+		}
+	}
+}